@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/chicogong/stream-relay-go/internal"
+)
+
+// runKeys 处理 relay keys 子命令，返回进程退出码
+func runKeys(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] != "hash" {
+		fmt.Fprintln(stderr, "usage: relay keys hash -id <id> -tenant <tenant_id> [-algo sha256|argon2id]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("keys hash", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	id := fs.String("id", "", "Key ID (embedded in the generated key)")
+	tenant := fs.String("tenant", "", "Tenant ID the key belongs to")
+	algo := fs.String("algo", internal.HashSHA256, "Hash algorithm: sha256 | argon2id")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *id == "" || *tenant == "" {
+		fmt.Fprintln(stderr, "-id and -tenant are required")
+		return 2
+	}
+
+	key, err := internal.GenerateAPIKey(*id)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	hash, err := internal.HashAPIKey(key, *algo)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	// Key 只在这里输出一次，配置文件里只保存哈希
+	fmt.Fprintf(stdout, "# API key (shown once, give it to the client): %s\n", key)
+	fmt.Fprintf(stdout, "- id: %s\n  tenant_id: %s\n  key_hash: %q\n", *id, *tenant, hash)
+	return 0
+}
@@ -14,6 +14,11 @@ import (
 )
 
 func main() {
+	// 子命令：relay keys hash ...
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		os.Exit(runKeys(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// 解析命令行参数
 	configPath := flag.String("config", "configs/config.yaml", "Path to config file")
 	flag.Parse()
@@ -51,18 +56,68 @@ func main() {
 	slog.Info("Metrics initialized")
 
 	// 初始化限流器
-	limiter := internal.NewRateLimiter(&config.RateLimit)
+	limiter := internal.NewRateLimiter(&config.RateLimit, metrics)
+	defer limiter.Stop()
 	slog.Info("Rate limiter initialized",
 		"enabled", config.RateLimit.Enabled,
 		"default_rpm", config.RateLimit.Default,
-		"burst", config.RateLimit.Burst)
+		"burst", config.RateLimit.Burst,
+		"tokens_per_minute", config.RateLimit.TokensPerMinute,
+		"max_concurrent_streams", config.RateLimit.MaxConcurrentStreams)
+
+	// 初始化 API Key 管理（有 Redis 时多实例共享）
+	keys := internal.NewKeyManager(config, internal.NewKeyStore(storage))
+	defer keys.Stop()
+	if err := keys.Refresh(context.Background()); err != nil {
+		slog.Warn("Failed to load api keys from store, using config keys only", "error", err)
+	}
+	slog.Info("API keys initialized", "admin_api", config.Auth.Admin.Enabled)
+
+	// 初始化租户自带的上游凭证（BYOK）
+	var credentials *internal.CredentialVault
+	if config.Credentials.Enabled {
+		store, err := internal.NewCredentialStore(storage, config.Credentials.AllowMemoryStore)
+		if err == nil {
+			credentials, err = internal.NewCredentialVault(config, store)
+		}
+		if err != nil {
+			slog.Error("Failed to initialize credentials", "error", err)
+			os.Exit(1)
+		}
+		defer credentials.Stop()
+		if err := credentials.Refresh(context.Background()); err != nil {
+			slog.Warn("Failed to load credentials from store", "error", err)
+		}
+		slog.Info("Tenant credentials initialized")
+	}
+
+	// 加载路由的上游凭证，缺失时直接退出，避免静默发出不带凭证的请求
+	secrets := internal.NewSecretManager(config)
+	if err := secrets.Load(context.Background()); err != nil {
+		slog.Error("Failed to load upstream secrets", "error", err)
+		os.Exit(1)
+	}
+	secrets.Start()
+	defer secrets.Stop()
+	slog.Info("Upstream secrets loaded")
+
+	// 初始化 TTS 音频缓存
+	var ttsCache *internal.TTSCache
+	if config.TTSCache.Enabled {
+		ttsCache, err = internal.NewTTSCache(&config.TTSCache, metrics)
+		if err != nil {
+			slog.Error("Failed to initialize tts cache", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("TTS cache initialized", "dir", config.TTSCache.Dir)
+	}
 
 	// 初始化代理
-	proxy := internal.NewProxy(config, storage, metrics)
+	proxy := internal.NewProxy(config, storage, metrics, credentials, secrets, ttsCache)
 	slog.Info("Proxy initialized")
 
 	// 初始化服务器
-	server := internal.NewServer(config, proxy, limiter)
+	server := internal.NewServer(config, proxy, limiter, keys)
 	slog.Info("Server initialized", "port", config.Server.Port)
 
 	// 启动服务器（在 goroutine 中）
@@ -74,6 +129,21 @@ func main() {
 		}
 	}()
 
+	// SIGHUP 热更新限流配置和路由的并发上限
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			newConfig, err := internal.LoadConfig(*configPath)
+			if err != nil {
+				slog.Error("Failed to reload config, keeping current", "error", err, "path", *configPath)
+				continue
+			}
+			limiter.Reload(&newConfig.RateLimit, newConfig.Routes)
+			slog.Info("Rate limit config reloaded", "path", *configPath)
+		}
+	}()
+
 	// 等待信号
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
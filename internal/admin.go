@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyResponse 管理 API 返回的 Key 信息，不包含哈希
+type keyResponse struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	TenantID  string     `json:"tenant_id"`
+	Routes    []string   `json:"routes,omitempty"`
+	Models    []string   `json:"models,omitempty"`
+	Tier      string     `json:"tier,omitempty"`
+	CIDRs     []string   `json:"allowed_cidrs,omitempty"`
+	CORS      CORSPolicy `json:"cors,omitzero"`
+	ExpiresAt time.Time  `json:"expires_at,omitzero"`
+	CreatedAt time.Time  `json:"created_at,omitzero"`
+	RotatedAt time.Time  `json:"rotated_at,omitzero"`
+	Source    string     `json:"source"`        // config | admin
+	Key       string     `json:"key,omitempty"` // 只在创建和轮换时返回
+}
+
+// credentialResponse 租户凭证的管理 API 响应，不返回明文和密文
+type credentialResponse struct {
+	TenantID  string    `json:"tenant_id"`
+	Route     string    `json:"route"`
+	Hint      string    `json:"hint,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// putCredentialRequest 保存凭证请求
+type putCredentialRequest struct {
+	Value string `json:"value"`
+}
+
+// rotateKeyRequest 轮换请求
+type rotateKeyRequest struct {
+	Overlap string `json:"overlap"` // 旧 Key 继续有效的时长，例如 "1h"，默认 24h，"0s" 表示立即失效
+}
+
+// setupAdminRoutes 注册管理 API
+func (s *Server) setupAdminRoutes() {
+	admin := s.engine.Group("/admin", s.requireAdmin)
+	admin.GET("/keys", s.handleListKeys)
+	admin.POST("/keys", s.handleCreateKey)
+	admin.POST("/keys/:id/rotate", s.handleRotateKey)
+	admin.DELETE("/keys/:id", s.handleRevokeKey)
+
+	if s.proxy.credentials != nil {
+		admin.GET("/credentials", s.handleListCredentials)
+		admin.PUT("/credentials/:tenant/:route", s.handlePutCredential)
+		admin.DELETE("/credentials/:tenant/:route", s.handleDeleteCredential)
+	}
+}
+
+// requireAdmin 管理员鉴权
+func (s *Server) requireAdmin(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !s.keys.IsAdmin(token) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "invalid admin token",
+		})
+		return
+	}
+	c.Next()
+}
+
+// handleListKeys 列出全部 Key
+func (s *Server) handleListKeys(c *gin.Context) {
+	keys, err := s.keys.List(c.Request.Context())
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+
+	resp := make([]keyResponse, 0, len(keys))
+	for i := range keys {
+		resp = append(resp, newKeyResponse(&keys[i], ""))
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": resp})
+}
+
+// handleCreateKey 创建 Key
+func (s *Server) handleCreateKey(c *gin.Context) {
+	var req APIKeyConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	raw, key, err := s.keys.Create(c.Request.Context(), req)
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, newKeyResponse(key, raw))
+}
+
+// handleRotateKey 轮换 Key
+func (s *Server) handleRotateKey(c *gin.Context) {
+	var req rotateKeyRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	overlap := defaultRotateOverlap
+	if req.Overlap != "" {
+		d, err := time.ParseDuration(req.Overlap)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid overlap: " + req.Overlap})
+			return
+		}
+		overlap = d
+	}
+
+	raw, key, err := s.keys.Rotate(c.Request.Context(), c.Param("id"), overlap)
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newKeyResponse(key, raw))
+}
+
+// handleRevokeKey 吊销 Key
+func (s *Server) handleRevokeKey(c *gin.Context) {
+	if err := s.keys.Revoke(c.Request.Context(), c.Param("id")); err != nil {
+		adminError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// handleListCredentials 列出全部租户凭证
+func (s *Server) handleListCredentials(c *gin.Context) {
+	creds, err := s.proxy.credentials.List(c.Request.Context())
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+
+	resp := make([]credentialResponse, 0, len(creds))
+	for i := range creds {
+		resp = append(resp, newCredentialResponse(&creds[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{"credentials": resp})
+}
+
+// handlePutCredential 保存（或替换）租户在某个路由上的凭证
+func (s *Server) handlePutCredential(c *gin.Context) {
+	var req putCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cred, err := s.proxy.credentials.Put(c.Request.Context(), c.Param("tenant"), c.Param("route"), req.Value)
+	if err != nil {
+		adminError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newCredentialResponse(cred))
+}
+
+// handleDeleteCredential 删除租户凭证
+func (s *Server) handleDeleteCredential(c *gin.Context) {
+	if err := s.proxy.credentials.Delete(c.Request.Context(), c.Param("tenant"), c.Param("route")); err != nil {
+		adminError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// newCredentialResponse 转换为响应
+func newCredentialResponse(cred *StoredCredential) credentialResponse {
+	return credentialResponse{
+		TenantID:  cred.TenantID,
+		Route:     cred.Route,
+		Hint:      cred.Hint,
+		CreatedAt: cred.CreatedAt,
+		UpdatedAt: cred.UpdatedAt,
+	}
+}
+
+// adminError 把 KeyManager 和 CredentialVault 的错误映射为 HTTP 状态码
+func adminError(c *gin.Context, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrInvalidKeyRequest), errors.Is(err, ErrInvalidCredentialRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrKeyNotFound), errors.Is(err, ErrCredentialNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrKeyExists), errors.Is(err, ErrKeyReadOnly):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+// newKeyResponse 转换为响应，raw 为空时不返回原始 Key
+func newKeyResponse(key *StoredKey, raw string) keyResponse {
+	source := "admin"
+	if key.CreatedAt.IsZero() {
+		source = "config"
+	}
+	return keyResponse{
+		ID:        key.ID,
+		Name:      key.Name,
+		TenantID:  key.TenantID,
+		Routes:    key.Routes,
+		Models:    key.Models,
+		Tier:      key.Tier,
+		CIDRs:     key.AllowedCIDRs,
+		CORS:      key.CORS,
+		ExpiresAt: key.ExpiresAt,
+		CreatedAt: key.CreatedAt,
+		RotatedAt: key.RotatedAt,
+		Source:    source,
+		Key:       raw,
+	}
+}
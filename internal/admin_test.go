@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServer_AdminKeys(t *testing.T) {
+	adminHash, _ := HashAPIKey("admin-secret", HashSHA256)
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.Auth.Admin = AdminConfig{Enabled: true, KeyHashes: []string{adminHash}}
+	})
+
+	if rec := doRequest(s, http.MethodGet, "/admin/keys", "sk-test", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for non-admin token, got %d", rec.Code)
+	}
+
+	// Create
+	rec := doRequest(s, http.MethodPost, "/admin/keys", "admin-secret", `{"id":"acme-1","tenant_id":"acme","routes":["chat"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created keyResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.Key == "" || created.Source != "admin" {
+		t.Fatalf("unexpected create response: %s", rec.Body.String())
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", created.Key, `{}`); rec.Code != http.StatusOK {
+		t.Errorf("expected new key to work immediately, got %d", rec.Code)
+	}
+
+	if rec := doRequest(s, http.MethodPost, "/admin/keys", "admin-secret", `{"id":"acme-1","tenant_id":"acme"}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for duplicate id, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodPost, "/admin/keys", "admin-secret", `{"id":"bad"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without tenant_id, got %d", rec.Code)
+	}
+
+	// List never exposes hashes or raw keys
+	rec = doRequest(s, http.MethodGet, "/admin/keys", "admin-secret", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var list struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(list.Keys))
+	}
+	for _, field := range []string{"key", "key_hash"} {
+		if _, ok := list.Keys[0][field]; ok {
+			t.Errorf("list must not expose %s", field)
+		}
+	}
+
+	// Rotate without overlap, then revoke
+	rec = doRequest(s, http.MethodPost, "/admin/keys/acme-1/rotate", "admin-secret", `{"overlap":"0s"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var rotated keyResponse
+	json.Unmarshal(rec.Body.Bytes(), &rotated)
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", created.Key, `{}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected old key to be rejected, got %d", rec.Code)
+	}
+
+	if rec := doRequest(s, http.MethodDelete, "/admin/keys/acme-1", "admin-secret", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", rotated.Key, `{}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected revoked key to be rejected, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodDelete, "/admin/keys/acme-1", "admin-secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestServer_AdminCredentials(t *testing.T) {
+	adminHash, _ := HashAPIKey("admin-secret", HashSHA256)
+	var upstreamAuth string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth = r.Header.Get("Authorization")
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	})
+	t.Setenv("TEST_RELAY_UPSTREAM_KEY", "sk-shared")
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Routes[0].AuthHeader = "Authorization"
+		cfg.Routes[0].AuthEnv = "TEST_RELAY_UPSTREAM_KEY"
+		cfg.Auth.Admin = AdminConfig{Enabled: true, KeyHashes: []string{adminHash}}
+		cfg.Auth.Keys = []APIKeyConfig{{ID: "acme-1", Key: "sk-acme", TenantID: "acme"}}
+		cfg.Credentials = CredentialsConfig{Enabled: true, MasterKey: testMasterKey}
+	})
+
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-acme", `{}`); rec.Code != http.StatusOK || upstreamAuth != "Bearer sk-shared" {
+		t.Fatalf("expected route credential before BYOK, got %d %q", rec.Code, upstreamAuth)
+	}
+
+	rec := doRequest(s, http.MethodPut, "/admin/credentials/acme/chat", "admin-secret", `{"value":"sk-acme-own-key-9876"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "sk-acme-own") || strings.Contains(rec.Body.String(), "ciphertext") {
+		t.Errorf("response must not expose the credential: %s", rec.Body.String())
+	}
+
+	// Tenant credential takes precedence; other tenants keep the shared one
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-acme", `{}`); rec.Code != http.StatusOK || upstreamAuth != "Bearer sk-acme-own-key-9876" {
+		t.Errorf("expected tenant credential, got %d %q", rec.Code, upstreamAuth)
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`); rec.Code != http.StatusOK || upstreamAuth != "Bearer sk-shared" {
+		t.Errorf("expected route credential for other tenant, got %d %q", rec.Code, upstreamAuth)
+	}
+
+	rec = doRequest(s, http.MethodGet, "/admin/credentials", "admin-secret", "")
+	var list struct {
+		Credentials []credentialResponse `json:"credentials"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &list)
+	if len(list.Credentials) != 1 || list.Credentials[0].Hint != "9876" {
+		t.Errorf("unexpected list response: %s", rec.Body.String())
+	}
+
+	if rec := doRequest(s, http.MethodPut, "/admin/credentials/acme/nope", "admin-secret", `{"value":"sk-1"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown route, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodDelete, "/admin/credentials/acme/chat", "admin-secret", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodDelete, "/admin/credentials/acme/chat", "admin-secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestServer_TenantCredentialFailClosed(t *testing.T) {
+	var upstreamAuth string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth = r.Header.Get("Authorization")
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	})
+	t.Setenv("TEST_RELAY_UPSTREAM_KEY", "sk-shared")
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Routes[0].AuthHeader = "Authorization"
+		cfg.Routes[0].AuthEnv = "TEST_RELAY_UPSTREAM_KEY"
+		cfg.Auth.Keys = []APIKeyConfig{{ID: "acme-1", Key: "sk-acme", TenantID: "acme"}}
+		cfg.Credentials = CredentialsConfig{Enabled: true, MasterKey: testMasterKey}
+	})
+	ctx := context.Background()
+
+	// An undecryptable credential fails the request instead of using the shared key
+	s.proxy.credentials.store.Put(ctx, &StoredCredential{TenantID: "acme", Route: "chat", Ciphertext: "bm90LWEtdmFsaWQtY2lwaGVydGV4dA=="})
+	if err := s.proxy.credentials.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	upstreamAuth = ""
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-acme", `{}`); rec.Code != http.StatusBadGateway || upstreamAuth != "" {
+		t.Errorf("expected 502 without upstream call, got %d %q", rec.Code, upstreamAuth)
+	}
+
+	// A route requiring tenant credentials rejects tenants without one
+	s.config.Routes[0].RequireTenantCredential = true
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`); rec.Code != http.StatusForbidden || upstreamAuth != "" {
+		t.Errorf("expected 403 without upstream call, got %d %q", rec.Code, upstreamAuth)
+	}
+	if _, err := s.proxy.credentials.Put(ctx, "acme", "chat", "sk-acme-own-key-9876"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-acme", `{}`); rec.Code != http.StatusOK || upstreamAuth != "Bearer sk-acme-own-key-9876" {
+		t.Errorf("expected tenant credential, got %d %q", rec.Code, upstreamAuth)
+	}
+}
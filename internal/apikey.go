@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// apiKeyPrefix relay 生成的 Key 前缀，格式为 sk-relay-<id>.<secret>
+	apiKeyPrefix = "sk-relay-"
+	// apiKeySecretBytes 随机部分的字节数
+	apiKeySecretBytes = 32
+	// keySaltBytes 哈希盐的字节数
+	keySaltBytes = 16
+
+	// HashSHA256 加盐 SHA-256，Key 本身是高熵随机串，默认使用
+	HashSHA256 = "sha256"
+	// HashArgon2id argon2id，更慢但可以抵抗弱 Key 的离线暴力破解
+	HashArgon2id = "argon2id"
+)
+
+// argon2id 参数（OWASP 推荐的最低配置）
+const (
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024 // KiB
+	argon2Threads = 1
+	argon2KeyLen  = 32
+
+	// 解析哈希时允许的参数上限，防止一个 key_hash 在每次校验时占用过多内存或 CPU
+	argon2MaxTime    = 10
+	argon2MaxMemory  = 256 * 1024 // KiB
+	argon2MaxThreads = 16
+)
+
+var b64 = base64.RawStdEncoding
+
+// argon2Slots 限制同时进行的 argon2id 计算，每次计算占用约 19MB 内存和一个 CPU，不能随请求数无限增长
+var argon2Slots = make(chan struct{}, runtime.GOMAXPROCS(0))
+
+// maxRejectedDigests 每个哈希版本缓存的校验失败摘要数
+const maxRejectedDigests = 1024
+
+// GenerateAPIKey 生成新的 API Key，ID 编码在 Key 中，鉴权时按 ID 找到对应的哈希
+func GenerateAPIKey(id string) (string, error) {
+	if id == "" || strings.Contains(id, ".") {
+		return "", fmt.Errorf("invalid key id %q", id)
+	}
+	secret := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generate key: %w", err)
+	}
+	return apiKeyPrefix + id + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// parseAPIKeyID 从 Key 中取出 ID，不是 relay 生成的格式时返回 false
+func parseAPIKeyID(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	id, secret, ok := strings.Cut(rest, ".")
+	if !ok || id == "" || secret == "" {
+		return "", false
+	}
+	return id, true
+}
+
+// HashAPIKey 计算 Key 的加盐哈希
+// 格式：sha256$<salt>$<digest> 或 argon2id$v=19$m=<KiB>,t=<n>,p=<n>$<salt>$<digest>
+func HashAPIKey(key, algo string) (string, error) {
+	salt := make([]byte, keySaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+
+	switch algo {
+	case HashSHA256, "":
+		digest := sha256Salted(salt, key)
+		return fmt.Sprintf("%s$%s$%s", HashSHA256, b64.EncodeToString(salt), b64.EncodeToString(digest)), nil
+	case HashArgon2id:
+		digest := argon2.IDKey([]byte(key), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("%s$v=%d$m=%d,t=%d,p=%d$%s$%s", HashArgon2id, argon2.Version,
+			argon2Memory, argon2Time, argon2Threads, b64.EncodeToString(salt), b64.EncodeToString(digest)), nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm: %s", algo)
+	}
+}
+
+// keyHash 解析后的哈希
+type keyHash struct {
+	encoded string
+	algo    string
+	salt    []byte
+	digest  []byte
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// parseKeyHash 解析 HashAPIKey 的输出
+func parseKeyHash(encoded string) (*keyHash, error) {
+	parts := strings.Split(encoded, "$")
+	h := &keyHash{encoded: encoded, algo: parts[0]}
+
+	var salt, digest string
+	switch {
+	case h.algo == HashSHA256 && len(parts) == 3:
+		salt, digest = parts[1], parts[2]
+	case h.algo == HashArgon2id && len(parts) == 5:
+		var version int
+		if _, err := fmt.Sscanf(parts[1], "v=%d", &version); err != nil || version != argon2.Version {
+			return nil, fmt.Errorf("unsupported argon2 version: %s", parts[1])
+		}
+		if _, err := fmt.Sscanf(parts[2], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+			return nil, fmt.Errorf("invalid argon2 params: %w", err)
+		}
+		// t 或 p 为 0 时 argon2.IDKey 会 panic，m 至少为 8*p
+		if h.time < 1 || h.time > argon2MaxTime || h.threads < 1 || h.threads > argon2MaxThreads ||
+			h.memory < 8*uint32(h.threads) || h.memory > argon2MaxMemory {
+			return nil, fmt.Errorf("invalid argon2 params: %s (need 1<=t<=%d, 1<=p<=%d, 8*p<=m<=%d)",
+				parts[2], argon2MaxTime, argon2MaxThreads, argon2MaxMemory)
+		}
+		salt, digest = parts[3], parts[4]
+	default:
+		return nil, errors.New("malformed key hash")
+	}
+
+	var err error
+	if h.salt, err = b64.DecodeString(salt); err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	if h.digest, err = b64.DecodeString(digest); err != nil {
+		return nil, fmt.Errorf("invalid digest: %w", err)
+	}
+	if len(h.digest) == 0 {
+		return nil, errors.New("empty digest")
+	}
+	return h, nil
+}
+
+// verify 常数时间比较 Key 和哈希
+func (h *keyHash) verify(key string) bool {
+	var digest []byte
+	switch h.algo {
+	case HashSHA256:
+		digest = sha256Salted(h.salt, key)
+	case HashArgon2id:
+		argon2Slots <- struct{}{}
+		digest = argon2.IDKey([]byte(key), h.salt, h.time, h.memory, h.threads, uint32(len(h.digest)))
+		<-argon2Slots
+	}
+	return subtle.ConstantTimeCompare(digest, h.digest) == 1
+}
+
+// digestSet 有界的摘要集合，满了之后覆盖最早加入的
+type digestSet struct {
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]struct{}
+	order   [][sha256.Size]byte
+	next    int
+}
+
+func newDigestSet() *digestSet {
+	return &digestSet{entries: make(map[[sha256.Size]byte]struct{})}
+}
+
+func (s *digestSet) contains(digest [sha256.Size]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[digest]
+	return ok
+}
+
+func (s *digestSet) add(digest [sha256.Size]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[digest]; ok {
+		return
+	}
+	if len(s.order) < maxRejectedDigests {
+		s.order = append(s.order, digest)
+	} else {
+		delete(s.entries, s.order[s.next])
+		s.order[s.next] = digest
+		s.next = (s.next + 1) % maxRejectedDigests
+	}
+	s.entries[digest] = struct{}{}
+}
+
+// sha256Salted 计算 SHA-256(salt || key)
+func sha256Salted(salt []byte, key string) []byte {
+	sum := sha256.New()
+	sum.Write(salt)
+	sum.Write([]byte(key))
+	return sum.Sum(nil)
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, err := GenerateAPIKey("acme-prod")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if id, ok := parseAPIKeyID(key); !ok || id != "acme-prod" {
+		t.Errorf("expected id acme-prod in %q, got %q", key, id)
+	}
+
+	other, _ := GenerateAPIKey("acme-prod")
+	if other == key {
+		t.Error("generated keys must be random")
+	}
+
+	if _, err := GenerateAPIKey("a.b"); err == nil {
+		t.Error("expected error for id containing '.'")
+	}
+}
+
+func TestHashAPIKey(t *testing.T) {
+	for _, algo := range []string{HashSHA256, HashArgon2id} {
+		t.Run(algo, func(t *testing.T) {
+			encoded, err := HashAPIKey("sk-relay-k1.secret", algo)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(encoded, algo+"$") {
+				t.Errorf("expected %s prefix, got %q", algo, encoded)
+			}
+
+			h, err := parseKeyHash(encoded)
+			if err != nil {
+				t.Fatalf("parse failed: %v", err)
+			}
+			if !h.verify("sk-relay-k1.secret") {
+				t.Error("expected key to verify")
+			}
+			if h.verify("sk-relay-k1.wrong") {
+				t.Error("expected wrong key to fail")
+			}
+
+			// Salted: hashing the same key twice gives different output
+			again, _ := HashAPIKey("sk-relay-k1.secret", algo)
+			if again == encoded {
+				t.Error("expected different salt per hash")
+			}
+		})
+	}
+
+	if _, err := HashAPIKey("k", "md5"); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+}
+
+func TestParseKeyHash_Invalid(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"sha256$abc",
+		"md5$abc$def",
+		"sha256$!!!$def",
+		"argon2id$v=1$m=1,t=1,p=1$abc$def",
+		"argon2id$v=19$bad$abc$def",
+		"argon2id$v=19$m=19456,t=0,p=1$abc$def",
+		"argon2id$v=19$m=19456,t=2,p=0$abc$def",
+		"argon2id$v=19$m=7,t=2,p=1$abc$def",
+		"argon2id$v=19$m=64,t=2,p=16$abc$def",
+		"argon2id$v=19$m=4194304,t=2,p=1$abc$def",
+		"argon2id$v=19$m=19456,t=1000,p=1$abc$def",
+		"argon2id$v=19$m=19456,t=2,p=64$abc$def",
+	} {
+		if _, err := parseKeyHash(encoded); err == nil {
+			t.Errorf("expected error for %q", encoded)
+		}
+	}
+}
+
+func TestKeyRegistry_HashedKey(t *testing.T) {
+	key, _ := GenerateAPIKey("k1")
+	hash, _ := HashAPIKey(key, HashArgon2id)
+	registry := NewKeyRegistry(&AuthConfig{
+		Keys: []APIKeyConfig{{ID: "k1", KeyHash: hash, TenantID: "acme"}},
+	})
+
+	for i := 0; i < 2; i++ { // second lookup hits the verified cache
+		p, err := registry.Lookup(key, time.Now())
+		if err != nil {
+			t.Fatalf("lookup %d failed: %v", i, err)
+		}
+		if p.TenantID != "acme" {
+			t.Errorf("expected tenant acme, got %q", p.TenantID)
+		}
+	}
+
+	wrong := key[:len(key)-1] + "x"
+	if key[len(key)-1] == 'x' {
+		wrong = key[:len(key)-1] + "y"
+	}
+	if _, err := registry.Lookup(wrong, time.Now()); err == nil {
+		t.Error("expected wrong secret to be rejected")
+	}
+	if _, err := registry.Lookup("sk-relay-k2.secret", time.Now()); err == nil {
+		t.Error("expected unknown key id to be rejected")
+	}
+}
+
+func TestKeyRegistry_RejectedCache(t *testing.T) {
+	key, _ := GenerateAPIKey("k1")
+	hash, _ := HashAPIKey(key, HashArgon2id)
+	config := &AuthConfig{Keys: []APIKeyConfig{{ID: "k1", KeyHash: hash, TenantID: "acme"}}}
+	registry := NewKeyRegistry(config)
+
+	// 还没有验证通过的 Key 时，错误的 Key 会触发 argon2id，结果按摘要缓存
+	wrong := "sk-relay-k1.guess"
+	if _, err := registry.Lookup(wrong, time.Now()); err == nil {
+		t.Fatal("expected wrong secret to be rejected")
+	}
+	version := registry.set.Load().hashed["k1"].versions[0]
+	if !version.rejected.contains(sha256.Sum256([]byte(wrong))) {
+		t.Fatal("expected the failed digest to be cached")
+	}
+
+	// 哈希不变时缓存在 Update 后保留
+	registry.Update(nil)
+	if !registry.set.Load().hashed["k1"].versions[0].rejected.contains(sha256.Sum256([]byte(wrong))) {
+		t.Error("expected the rejected cache to survive Update")
+	}
+	if _, err := registry.Lookup(key, time.Now()); err != nil {
+		t.Errorf("expected the real key to still verify: %v", err)
+	}
+}
+
+func TestDigestSet_Bounded(t *testing.T) {
+	s := newDigestSet()
+	for i := 0; i < maxRejectedDigests+10; i++ {
+		s.add(sha256.Sum256([]byte(fmt.Sprint(i))))
+	}
+	if len(s.entries) != maxRejectedDigests {
+		t.Errorf("expected %d entries, got %d", maxRejectedDigests, len(s.entries))
+	}
+	if s.contains(sha256.Sum256([]byte("0"))) || !s.contains(sha256.Sum256([]byte(fmt.Sprint(maxRejectedDigests+9)))) {
+		t.Error("expected the oldest digests to be replaced first")
+	}
+}
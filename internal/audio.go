@@ -0,0 +1,343 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"time"
+)
+
+const (
+	AudioFormatWAV = "wav"
+	AudioFormatMP3 = "mp3"
+	AudioFormatOgg = "ogg"
+	AudioFormatPCM = "pcm" // 无容器的 PCM / mu-law / A-law，字节率由调用方给出
+)
+
+// audioMeterMaxHeader 单个头部的缓冲上限，超过仍无法解析则放弃计量（不影响转发）
+const audioMeterMaxHeader = 64 * 1024
+
+// audioMeter 边写入边解析音频容器并计算时长，只缓冲正在解析的头部，音频数据直接跳过
+// WAV 读 fmt / data chunk，MP3 逐帧累计（兼容 VBR），Ogg Vorbis / Opus 取最后一页的 granule position
+type audioMeter struct {
+	format string
+	buf    []byte // 流的末尾、尚未解析的字节
+	skip   int64  // 接下来直接跳过的字节数
+	total  int64
+	failed bool
+
+	// WAV
+	byteRate  int64
+	dataStart int64 // data chunk 的起始偏移，-1 表示还没读到
+	dataSize  int64
+
+	// MP3
+	mp3Seconds float64
+
+	// Ogg
+	oggSerial  uint32
+	oggRate    int64
+	oggPreSkip int64
+	oggGranule int64
+}
+
+func newAudioMeter() *audioMeter {
+	return &audioMeter{dataStart: -1}
+}
+
+// newPCMMeter 无容器音频的计量，时长为字节数 / 字节率
+func newPCMMeter(byteRate int64) *audioMeter {
+	return &audioMeter{format: AudioFormatPCM, byteRate: byteRate, dataStart: 0, skip: math.MaxInt64}
+}
+
+// Write 实现 io.Writer，解析失败时继续接收数据但不再计量
+func (m *audioMeter) Write(p []byte) (int, error) {
+	n := len(p)
+	m.total += int64(n)
+	if m.skip > 0 {
+		k := min(m.skip, int64(len(p)))
+		m.skip -= k
+		p = p[k:]
+	}
+	if len(p) == 0 || m.failed {
+		return n, nil
+	}
+
+	m.buf = append(m.buf, p...)
+	m.parse()
+	if len(m.buf) > audioMeterMaxHeader {
+		m.failed = true
+	}
+	if m.failed {
+		m.buf = nil
+	}
+	return n, nil
+}
+
+// Format 识别出的容器格式，未识别时为空
+func (m *audioMeter) Format() string {
+	return m.format
+}
+
+// Duration 音频时长，格式无法识别或头部不完整时返回 false
+func (m *audioMeter) Duration() (time.Duration, bool) {
+	if m.failed {
+		return 0, false
+	}
+	var seconds float64
+	switch m.format {
+	case AudioFormatWAV, AudioFormatPCM:
+		if m.byteRate == 0 || m.dataStart < 0 {
+			return 0, false
+		}
+		// 流式生成的 WAV 常把 data 长度写成 0 或 0xFFFFFFFF，以实际收到的字节为准
+		size := m.total - m.dataStart
+		if m.dataSize > 0 && m.dataSize != math.MaxUint32 && m.dataSize < size {
+			size = m.dataSize
+		}
+		seconds = float64(size) / float64(m.byteRate)
+	case AudioFormatMP3:
+		seconds = m.mp3Seconds
+	case AudioFormatOgg:
+		if m.oggRate == 0 || m.oggGranule <= m.oggPreSkip {
+			return 0, false
+		}
+		seconds = float64(m.oggGranule-m.oggPreSkip) / float64(m.oggRate)
+	default:
+		return 0, false
+	}
+	if seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// parse 尽可能多地解析缓冲中的头部
+func (m *audioMeter) parse() {
+	for !m.failed {
+		if m.skip > 0 {
+			k := min(m.skip, int64(len(m.buf)))
+			m.skip -= k
+			m.buf = m.buf[k:]
+			if m.skip > 0 {
+				return
+			}
+		}
+
+		var progressed bool
+		switch m.format {
+		case "":
+			progressed = m.detect()
+		case AudioFormatWAV:
+			progressed = m.parseWAV()
+		case AudioFormatMP3:
+			progressed = m.parseMP3()
+		case AudioFormatOgg:
+			progressed = m.parseOgg()
+		}
+		if !progressed {
+			return
+		}
+	}
+}
+
+// detect 根据开头的魔数识别格式
+func (m *audioMeter) detect() bool {
+	if len(m.buf) < 12 {
+		return false
+	}
+	switch {
+	case bytes.HasPrefix(m.buf, []byte("RIFF")) && bytes.Equal(m.buf[8:12], []byte("WAVE")):
+		m.format = AudioFormatWAV
+		m.buf = m.buf[12:]
+	case bytes.HasPrefix(m.buf, []byte("OggS")):
+		m.format = AudioFormatOgg
+	case bytes.HasPrefix(m.buf, []byte("ID3")):
+		m.format = AudioFormatMP3
+	default:
+		if _, ok := parseMP3Frame(m.buf); !ok {
+			m.failed = true
+			return false
+		}
+		m.format = AudioFormatMP3
+	}
+	return true
+}
+
+// parseWAV 解析一个 RIFF chunk 头：fmt 读取字节率，data 之后全部是音频数据
+func (m *audioMeter) parseWAV() bool {
+	if len(m.buf) < 8 {
+		return false
+	}
+	id := string(m.buf[:4])
+	size := int64(binary.LittleEndian.Uint32(m.buf[4:8]))
+	switch id {
+	case "fmt ":
+		if len(m.buf) < 8+16 {
+			return false
+		}
+		m.byteRate = int64(binary.LittleEndian.Uint32(m.buf[16:20]))
+	case "data":
+		m.dataStart = m.total - int64(len(m.buf)) + 8
+		m.dataSize = size
+		m.buf = m.buf[8:]
+		m.skip = math.MaxInt64
+		return true
+	}
+	// chunk 按 2 字节对齐
+	m.buf = m.buf[8:]
+	m.skip = size + size&1
+	return true
+}
+
+// parseMP3 跳过 ID3v2 标签，逐帧累计时长；帧头无效时向后寻找下一个帧同步字
+func (m *audioMeter) parseMP3() bool {
+	if bytes.HasPrefix(m.buf, []byte("ID3")) {
+		if len(m.buf) < 10 {
+			return false
+		}
+		m.skip = id3TagSize(m.buf)
+		return true
+	}
+	if len(m.buf) < 4 {
+		return false
+	}
+
+	frame, ok := parseMP3Frame(m.buf)
+	if !ok {
+		i := bytes.IndexByte(m.buf[1:], 0xff)
+		if i < 0 {
+			m.buf = m.buf[:0]
+			return false
+		}
+		m.buf = m.buf[1+i:]
+		return true
+	}
+	m.mp3Seconds += float64(frame.samples) / float64(frame.sampleRate)
+	m.skip = int64(frame.length)
+	return true
+}
+
+// parseOgg 解析一个 Ogg 页头：第一页的 identification header 给出采样率，之后只记录 granule position
+func (m *audioMeter) parseOgg() bool {
+	if len(m.buf) < 27 {
+		return false
+	}
+	if !bytes.HasPrefix(m.buf, []byte("OggS")) {
+		m.failed = true
+		return false
+	}
+	segments := int(m.buf[26])
+	header := 27 + segments
+	if len(m.buf) < header {
+		return false
+	}
+	bodySize := 0
+	for _, lacing := range m.buf[27:header] {
+		bodySize += int(lacing)
+	}
+	granule := int64(binary.LittleEndian.Uint64(m.buf[6:14]))
+	serial := binary.LittleEndian.Uint32(m.buf[14:18])
+
+	if m.oggRate == 0 {
+		if len(m.buf) < header+bodySize {
+			return false
+		}
+		body := m.buf[header : header+bodySize]
+		switch {
+		case bytes.HasPrefix(body, []byte("OpusHead")) && len(body) >= 12:
+			// Opus 的 granule position 固定以 48kHz 计
+			m.oggRate = 48000
+			m.oggPreSkip = int64(binary.LittleEndian.Uint16(body[10:12]))
+		case bytes.HasPrefix(body, []byte("\x01vorbis")) && len(body) >= 16:
+			m.oggRate = int64(binary.LittleEndian.Uint32(body[12:16]))
+		}
+		if m.oggRate == 0 {
+			m.failed = true
+			return false
+		}
+		m.oggSerial = serial
+	} else if serial == m.oggSerial && granule >= 0 {
+		// -1 表示这一页没有结束的包
+		m.oggGranule = granule
+	}
+
+	m.skip = int64(header + bodySize)
+	return true
+}
+
+// id3TagSize ID3v2 标签的总长度（含 10 字节的头和可选的 footer），h 至少 10 字节
+func id3TagSize(h []byte) int64 {
+	// 标签长度是 synchsafe 整数（每字节 7 位）
+	size := int64(h[6]&0x7f)<<21 | int64(h[7]&0x7f)<<14 | int64(h[8]&0x7f)<<7 | int64(h[9]&0x7f)
+	if h[5]&0x10 != 0 {
+		size += 10 // footer
+	}
+	return 10 + size
+}
+
+// mp3Frame MPEG 音频帧头中计算时长需要的字段
+type mp3Frame struct {
+	length     int // 整帧字节数（含帧头）
+	samples    int
+	sampleRate int
+}
+
+// mp3Bitrates 码率表（kbps），按 MPEG1 Layer I/II/III、MPEG2/2.5 Layer I、MPEG2/2.5 Layer II/III 排列
+var mp3Bitrates = [5][15]int{
+	{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// mp3SampleRates 采样率表，按版本位索引（0: MPEG2.5，2: MPEG2，3: MPEG1）
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},
+	{},
+	{22050, 24000, 16000},
+	{44100, 48000, 32000},
+}
+
+// parseMP3Frame 解析 4 字节的 MPEG 音频帧头，不支持 free format
+func parseMP3Frame(h []byte) (mp3Frame, bool) {
+	if len(h) < 4 || h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return mp3Frame{}, false
+	}
+	version := (h[1] >> 3) & 3
+	layer := 4 - int((h[1]>>1)&3) // 1: Layer I, 2: Layer II, 3: Layer III
+	bitrateIndex := h[2] >> 4
+	rateIndex := (h[2] >> 2) & 3
+	padding := int(h[2]>>1) & 1
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	mpeg1 := version == 3
+	var table int
+	switch {
+	case mpeg1:
+		table = layer - 1
+	case layer == 1:
+		table = 3
+	default:
+		table = 4
+	}
+	bitrate := mp3Bitrates[table][bitrateIndex] * 1000
+	sampleRate := mp3SampleRates[version][rateIndex]
+
+	frame := mp3Frame{sampleRate: sampleRate}
+	switch {
+	case layer == 1:
+		frame.samples = 384
+		frame.length = (12*bitrate/sampleRate + padding) * 4
+	case layer == 3 && !mpeg1:
+		frame.samples = 576
+		frame.length = 72*bitrate/sampleRate + padding
+	default:
+		frame.samples = 1152
+		frame.length = 144*bitrate/sampleRate + padding
+	}
+	return frame, true
+}
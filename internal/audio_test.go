@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// testWAV 16kHz 16bit 单声道 WAV，dataSize 为 data chunk 头中写入的长度
+func testWAV(seconds int, dataSize uint32) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	b.WriteString("WAVE")
+	b.WriteString("LIST")
+	binary.Write(&b, binary.LittleEndian, uint32(3))
+	b.Write([]byte{'a', 'b', 'c', 0}) // 奇数长度的 chunk 带 1 字节填充
+	b.WriteString("fmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1))     // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(1))     // channels
+	binary.Write(&b, binary.LittleEndian, uint32(16000)) // sample rate
+	binary.Write(&b, binary.LittleEndian, uint32(32000)) // byte rate
+	binary.Write(&b, binary.LittleEndian, uint16(2))     // block align
+	binary.Write(&b, binary.LittleEndian, uint16(16))    // bits per sample
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, dataSize)
+	b.Write(make([]byte, seconds*32000))
+	return b.Bytes()
+}
+
+// testMP3 MPEG1 Layer III 128kbps 44.1kHz 的 CBR 帧，可选 ID3v2 标签
+func testMP3(frames int, id3 bool) []byte {
+	var b bytes.Buffer
+	if id3 {
+		b.Write([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 1, 72}) // 200 字节
+		b.Write(make([]byte, 200))
+	}
+	for range frames {
+		b.Write([]byte{0xff, 0xfb, 0x90, 0x00})
+		b.Write(make([]byte, 417-4))
+	}
+	return b.Bytes()
+}
+
+// oggPage 编码一个 Ogg 页（不计算 CRC）
+func oggPage(granule int64, body []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("OggS")
+	b.Write([]byte{0, 0})
+	binary.Write(&b, binary.LittleEndian, granule)
+	binary.Write(&b, binary.LittleEndian, uint32(1)) // serial
+	binary.Write(&b, binary.LittleEndian, uint32(0)) // sequence
+	binary.Write(&b, binary.LittleEndian, uint32(0)) // crc
+	segments := []byte{}
+	for n := len(body); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	b.WriteByte(byte(len(segments)))
+	b.Write(segments)
+	b.Write(body)
+	return b.Bytes()
+}
+
+// testOpus Ogg Opus，pre-skip 312，时长 seconds 秒
+func testOpus(seconds int64) []byte {
+	head := []byte("OpusHead")
+	head = append(head, 1, 1)
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = binary.LittleEndian.AppendUint32(head, 16000)
+	head = append(head, 0, 0, 0)
+
+	var b bytes.Buffer
+	b.Write(oggPage(0, head))
+	b.Write(oggPage(0, []byte("OpusTags")))
+	b.Write(oggPage(48000+312, make([]byte, 600)))
+	b.Write(oggPage(-1, make([]byte, 300)))
+	b.Write(oggPage(seconds*48000+312, make([]byte, 100)))
+	return b.Bytes()
+}
+
+// meter 分成小块写入，模拟流式读取
+func meter(data []byte, chunk int) *audioMeter {
+	m := newAudioMeter()
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		m.Write(data[:n])
+		data = data[n:]
+	}
+	return m
+}
+
+func TestAudioMeter(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		duration time.Duration
+	}{
+		{"wav", testWAV(2, 64000), AudioFormatWAV, 2 * time.Second},
+		{"wav streaming size", testWAV(3, 0xffffffff), AudioFormatWAV, 3 * time.Second},
+		{"mp3", testMP3(100, false), AudioFormatMP3, 100 * 1152 * time.Second / 44100},
+		{"mp3 with id3", testMP3(10, true), AudioFormatMP3, 10 * 1152 * time.Second / 44100},
+		{"ogg opus", testOpus(3), AudioFormatOgg, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		for _, chunk := range []int{7, 4096, len(tt.data)} {
+			m := meter(tt.data, chunk)
+			d, ok := m.Duration()
+			if m.Format() != tt.format || !ok || (d-tt.duration).Abs() > time.Millisecond {
+				t.Errorf("%s (chunk %d): expected %s %v, got %q %v %v", tt.name, chunk, tt.format, tt.duration, m.Format(), d, ok)
+			}
+			if len(m.buf) > audioMeterMaxHeader {
+				t.Errorf("%s (chunk %d): buffered %d bytes", tt.name, chunk, len(m.buf))
+			}
+		}
+	}
+}
+
+func TestAudioMeter_Unknown(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"text":      []byte("this is not an audio file at all"),
+		"truncated": testWAV(1, 32000)[:20],
+		"webm":      append([]byte{0x1a, 0x45, 0xdf, 0xa3}, make([]byte, 100)...),
+	} {
+		m := meter(data, 5)
+		if _, ok := m.Duration(); ok {
+			t.Errorf("%s: expected no duration", name)
+		}
+	}
+}
+
+func TestParseMP3Frame(t *testing.T) {
+	tests := []struct {
+		header []byte
+		want   mp3Frame
+		ok     bool
+	}{
+		{[]byte{0xff, 0xfb, 0x90, 0x00}, mp3Frame{length: 417, samples: 1152, sampleRate: 44100}, true},
+		{[]byte{0xff, 0xfb, 0x92, 0x00}, mp3Frame{length: 418, samples: 1152, sampleRate: 44100}, true},
+		{[]byte{0xff, 0xf3, 0x48, 0x00}, mp3Frame{length: 144, samples: 576, sampleRate: 16000}, true}, // MPEG2 Layer III 32kbps
+		{[]byte{0xff, 0xfb, 0x00, 0x00}, mp3Frame{}, false},                                            // free format
+		{[]byte{0xff, 0xfb, 0x9c, 0x00}, mp3Frame{}, false},                                            // reserved sample rate
+		{[]byte{0xff, 0xf9, 0x90, 0x00}, mp3Frame{}, false},                                            // reserved layer
+		{[]byte{0x49, 0x44, 0x33, 0x03}, mp3Frame{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseMP3Frame(tt.header)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseMP3Frame(% x): expected %+v %v, got %+v %v", tt.header, tt.want, tt.ok, got, ok)
+		}
+	}
+}
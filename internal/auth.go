@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultTenantID 旧格式 api_keys 归属的租户
+const defaultTenantID = "default"
+
+// 每个客户端 IP 的 Key 校验失败限制：连续失败 10 次后每 6 秒允许再试一次
+const (
+	authFailureBurst      = 10
+	authFailureInterval   = 6 * time.Second
+	maxAuthFailureClients = 10000
+)
+
+var (
+	// ErrInvalidKey API Key 不存在
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrKeyExpired API Key 已过期
+	ErrKeyExpired = errors.New("api key expired")
+	// ErrTooManyAuthFailures 客户端 Key 校验失败次数过多，暂时拒绝
+	ErrTooManyAuthFailures = errors.New("too many failed authentication attempts")
+	// ErrModelNotAllowed Key 不允许使用请求的模型
+	ErrModelNotAllowed = errors.New("api key is not allowed to use model")
+)
+
+// Principal 鉴权后的调用方身份 - 租户、限流档位和访问范围都由凭证决定，不信任客户端传入的 header
+type Principal struct {
+	KeyID    string
+	Name     string
+	TenantID string
+	Tier     string
+	Routes   []string // 允许的路由名，空表示全部
+	Models   []string // 允许的模型，空表示全部
+
+	AllowedCIDRs []netip.Prefix // 允许的来源网段，空表示不限制
+	CORS         *CORSPolicy    // Key 级跨域策略，为空时使用租户或全局策略
+
+	// ExpiresAt 凭证过期时间：Key 的 expires_at、JWT 的 exp、客户端证书的 NotAfter 或客户端 Token 的 exp，零值表示不过期
+	// 签发客户端 Token 时有效期不能超过它
+	ExpiresAt time.Time
+
+	// apiKey 由 API Key 鉴权，客户端 Token 校验时需要确认签发它的 Key 仍然有效
+	apiKey bool
+
+	// 以下只对客户端 Token 有效
+	TokenID   string // Token 的 jti
+	MaxTokens int64  // 整个有效期内的 token 预算，0 表示不限制
+}
+
+// AllowsRoute 是否允许访问路由
+func (p *Principal) AllowsRoute(route string) bool {
+	return len(p.Routes) == 0 || slices.Contains(p.Routes, route)
+}
+
+// AllowsModel 是否允许使用模型，支持 "gpt-4o*" 形式的前缀匹配
+// 限定了模型的 Key 在无法确定请求模型时（model 为空）一律拒绝，避免绕过模型范围
+func (p *Principal) AllowsModel(model string) bool {
+	if len(p.Models) == 0 {
+		return true
+	}
+	if model == "" {
+		return false
+	}
+	for _, pattern := range p.Models {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(model, prefix) {
+				return true
+			}
+		} else if pattern == model {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsModelPattern 模型或 "gpt-4o*" 形式的前缀是否被允许（签发客户端 Token 时收窄范围用）
+func (p *Principal) allowsModelPattern(pattern string) bool {
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	if !wildcard {
+		return p.AllowsModel(pattern)
+	}
+	if len(p.Models) == 0 {
+		return true
+	}
+	for _, allowed := range p.Models {
+		if base, ok := strings.CutSuffix(allowed, "*"); ok && strings.HasPrefix(prefix, base) {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyRegistry API Key 注册表 - 把 Key 映射到租户和访问范围
+// 明文 Key 按 SHA-256 摘要索引，哈希 Key 按 Key 中编码的 ID 索引，都不直接用 == 比较原文
+// 配置文件中的 Key 固定不变，管理 API 创建的 Key 通过 Update 整体替换
+type KeyRegistry struct {
+	config *AuthConfig
+	set    atomic.Pointer[keySet]
+}
+
+// keySet 某一时刻的全部 Key，构建后只读
+type keySet struct {
+	plain   map[[sha256.Size]byte]*APIKeyConfig
+	plainID map[string]*APIKeyConfig // 明文 Key 按 ID 索引，校验客户端 Token 的签发 Key 用
+	hashed  map[string]*hashedKey
+	cors    []*CORSPolicy // 配置了跨域策略的 Key，预检请求用
+}
+
+// hashedKey 以哈希形式保存的 Key，轮换后的重叠期内旧哈希仍然有效
+type hashedKey struct {
+	config   *APIKeyConfig
+	versions []*keyVersion
+}
+
+// keyVersion Key 的一个哈希版本
+type keyVersion struct {
+	hash      *keyHash
+	expiresAt time.Time // 轮换后旧版本的失效时间，零值表示不过期
+	// verified 验证通过的 Key 的 SHA-256 摘要，避免每个请求都跑一遍 argon2id
+	verified atomic.Pointer[[sha256.Size]byte]
+	// rejected 验证失败的 Key 的摘要，同一个错误 Key 重复请求时不再计算哈希
+	rejected *digestSet
+}
+
+// NewKeyRegistry 从配置创建注册表
+// 旧格式的 api_keys 不绑定租户，统一归属 default 租户
+func NewKeyRegistry(config *AuthConfig) *KeyRegistry {
+	r := &KeyRegistry{config: config}
+	r.Update(nil)
+	return r
+}
+
+// Update 用管理 API 保存的 Key 替换运行时 Key，配置文件中的 Key 优先
+// key_hash 格式错误的条目会被跳过（配置和管理 API 写入前都已校验）
+func (r *KeyRegistry) Update(stored []StoredKey) {
+	set := &keySet{
+		plain:   make(map[[sha256.Size]byte]*APIKeyConfig, len(r.config.Keys)+len(r.config.APIKeys)),
+		plainID: make(map[string]*APIKeyConfig, len(r.config.Keys)+len(r.config.APIKeys)),
+		hashed:  make(map[string]*hashedKey, len(stored)),
+	}
+
+	for i, key := range r.config.APIKeys {
+		set.plain[sha256.Sum256([]byte(key))] = &APIKeyConfig{
+			ID:       fmt.Sprintf("legacy-%d", i),
+			Name:     "legacy",
+			Key:      key,
+			TenantID: defaultTenantID,
+		}
+	}
+	for i := range r.config.Keys {
+		key := &r.config.Keys[i]
+		if key.KeyHash == "" {
+			set.plain[sha256.Sum256([]byte(key.Key))] = key
+		} else {
+			set.addHashed(key, nil, r.previous(key.ID))
+		}
+	}
+	for i := range stored {
+		if _, ok := set.hashed[stored[i].ID]; !ok {
+			set.addHashed(&stored[i].APIKeyConfig, stored[i].Previous, r.previous(stored[i].ID))
+		}
+	}
+
+	for _, key := range set.plain {
+		set.plainID[key.ID] = key
+		if !key.CORS.empty() {
+			set.cors = append(set.cors, &key.CORS)
+		}
+	}
+	for _, key := range set.hashed {
+		if !key.config.CORS.empty() {
+			set.cors = append(set.cors, &key.config.CORS)
+		}
+	}
+
+	r.set.Store(set)
+}
+
+// CORSPolicies 返回所有 Key 级跨域策略
+func (r *KeyRegistry) CORSPolicies() []*CORSPolicy {
+	return r.set.Load().cors
+}
+
+// previous 返回当前注册表中的 Key，用于在 Update 时保留验证缓存
+func (r *KeyRegistry) previous(id string) *hashedKey {
+	if set := r.set.Load(); set != nil {
+		return set.hashed[id]
+	}
+	return nil
+}
+
+// addHashed 添加哈希 Key，哈希未变化的版本沿用 old 中的验证缓存
+func (s *keySet) addHashed(key *APIKeyConfig, previous []PreviousKey, old *hashedKey) {
+	entry := &hashedKey{config: key}
+	add := func(encoded string, expiresAt time.Time) {
+		hash, err := parseKeyHash(encoded)
+		if err != nil {
+			return
+		}
+		version := &keyVersion{hash: hash, expiresAt: expiresAt, rejected: newDigestSet()}
+		if old != nil {
+			for _, v := range old.versions {
+				if v.hash.encoded == encoded {
+					version.verified.Store(v.verified.Load())
+					version.rejected = v.rejected
+				}
+			}
+		}
+		entry.versions = append(entry.versions, version)
+	}
+
+	add(key.KeyHash, time.Time{})
+	for _, p := range previous {
+		add(p.KeyHash, p.ExpiresAt)
+	}
+	if len(entry.versions) > 0 {
+		s.hashed[key.ID] = entry
+	}
+}
+
+// Lookup 校验 Key 并返回对应的身份
+func (r *KeyRegistry) Lookup(token string, now time.Time) (*Principal, error) {
+	if token == "" {
+		return nil, ErrInvalidKey
+	}
+
+	set := r.set.Load()
+	key := set.plain[sha256.Sum256([]byte(token))]
+	if key == nil {
+		key = set.lookupHashed(token, now)
+	}
+	if key == nil {
+		return nil, ErrInvalidKey
+	}
+	if !key.ExpiresAt.IsZero() && now.After(key.ExpiresAt) {
+		return nil, ErrKeyExpired
+	}
+	return key.principal(), nil
+}
+
+// ActiveKey 返回存在且未过期的 Key，否则返回 nil（吊销的运行时 Key 在刷新后即不存在）
+func (r *KeyRegistry) ActiveKey(id string, now time.Time) *APIKeyConfig {
+	set := r.set.Load()
+	key := set.plainID[id]
+	if entry := set.hashed[id]; entry != nil {
+		key = entry.config
+	}
+	if key == nil || (!key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt)) {
+		return nil
+	}
+	return key
+}
+
+// lookupHashed 按 Key 中的 ID 找到哈希并做常数时间比较
+func (s *keySet) lookupHashed(token string, now time.Time) *APIKeyConfig {
+	id, ok := parseAPIKeyID(token)
+	if !ok {
+		return nil
+	}
+	entry := s.hashed[id]
+	if entry == nil {
+		return nil
+	}
+
+	digest := sha256.Sum256([]byte(token))
+	for _, v := range entry.versions {
+		if !v.expiresAt.IsZero() && now.After(v.expiresAt) {
+			continue
+		}
+		if verified := v.verified.Load(); verified != nil {
+			if subtle.ConstantTimeCompare(digest[:], verified[:]) == 1 {
+				return entry.config
+			}
+			continue
+		}
+		if v.rejected.contains(digest) {
+			continue
+		}
+		if v.hash.verify(token) {
+			v.verified.Store(&digest)
+			return entry.config
+		}
+		v.rejected.add(digest)
+	}
+	return nil
+}
+
+// authFailures 按客户端限制 Key 校验失败的频率，超过后直接拒绝，不再查找和计算哈希
+type authFailures struct {
+	mu      sync.Mutex
+	clients map[string]*rate.Limiter
+}
+
+func newAuthFailures() *authFailures {
+	return &authFailures{clients: make(map[string]*rate.Limiter)}
+}
+
+// blocked 客户端的失败次数是否已用完
+func (f *authFailures) blocked(client string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	limiter := f.clients[client]
+	return limiter != nil && limiter.TokensAt(now) < 1
+}
+
+// record 记录一次失败
+func (f *authFailures) record(client string, now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	limiter := f.clients[client]
+	if limiter == nil {
+		if len(f.clients) >= maxAuthFailureClients {
+			f.pruneLocked(now)
+		}
+		limiter = rate.NewLimiter(rate.Every(authFailureInterval), authFailureBurst)
+		f.clients[client] = limiter
+	}
+	limiter.AllowN(now, 1)
+}
+
+// pruneLocked 删除已经恢复满额的客户端；仍然超过上限时全部清空，只影响限制的精度
+func (f *authFailures) pruneLocked(now time.Time) {
+	for client, limiter := range f.clients {
+		if limiter.TokensAt(now) >= authFailureBurst {
+			delete(f.clients, client)
+		}
+	}
+	if len(f.clients) >= maxAuthFailureClients {
+		clear(f.clients)
+	}
+}
+
+// principal 转换为调用方身份
+func (k *APIKeyConfig) principal() *Principal {
+	return &Principal{
+		KeyID:    k.ID,
+		Name:     k.Name,
+		TenantID: k.TenantID,
+		Tier:     k.Tier,
+		Routes:   k.Routes,
+		Models:   k.Models,
+
+		AllowedCIDRs: mustParseCIDRs(k.AllowedCIDRs),
+		CORS:         &k.CORS,
+		ExpiresAt:    k.ExpiresAt,
+		apiKey:       true,
+	}
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestKeyRegistry_Lookup(t *testing.T) {
+	now := time.Now()
+	registry := NewKeyRegistry(&AuthConfig{
+		APIKeys: []string{"sk-legacy"},
+		Keys: []APIKeyConfig{
+			{ID: "k1", Name: "acme prod", Key: "sk-acme", TenantID: "acme", Tier: "pro"},
+			{ID: "k2", Key: "sk-old", TenantID: "acme", ExpiresAt: now.Add(-time.Hour)},
+		},
+	})
+
+	p, err := registry.Lookup("sk-acme", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.KeyID != "k1" || p.TenantID != "acme" || p.Tier != "pro" {
+		t.Errorf("unexpected principal: %+v", p)
+	}
+
+	p, err = registry.Lookup("sk-legacy", now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.TenantID != defaultTenantID {
+		t.Errorf("expected legacy key in tenant %q, got %q", defaultTenantID, p.TenantID)
+	}
+
+	if _, err := registry.Lookup("sk-old", now); !errors.Is(err, ErrKeyExpired) {
+		t.Errorf("expected ErrKeyExpired, got %v", err)
+	}
+	if _, err := registry.Lookup("sk-unknown", now); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey, got %v", err)
+	}
+	if _, err := registry.Lookup("", now); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected ErrInvalidKey for empty token, got %v", err)
+	}
+}
+
+func TestPrincipal_Allows(t *testing.T) {
+	p := &Principal{
+		Routes: []string{"openai"},
+		Models: []string{"gpt-4o*", "o1"},
+	}
+
+	if !p.AllowsRoute("openai") || p.AllowsRoute("anthropic") {
+		t.Error("route scope not enforced")
+	}
+
+	tests := []struct {
+		model string
+		want  bool
+	}{
+		{"gpt-4o", true},
+		{"gpt-4o-mini", true},
+		{"o1", true},
+		{"o1-mini", false},
+		{"gpt-3.5-turbo", false},
+		{"", false}, // 无法确定模型时不能绕过模型范围
+	}
+	for _, tt := range tests {
+		if got := p.AllowsModel(tt.model); got != tt.want {
+			t.Errorf("AllowsModel(%q) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+
+	unrestricted := &Principal{}
+	if !unrestricted.AllowsRoute("any") || !unrestricted.AllowsModel("any") {
+		t.Error("empty scope should allow everything")
+	}
+}
+
+func TestKeyRegistry_Active(t *testing.T) {
+	now := time.Now()
+	hash, _ := HashAPIKey("sk-relay-k2.secret", HashSHA256)
+	registry := NewKeyRegistry(&AuthConfig{Keys: []APIKeyConfig{
+		{ID: "k1", Key: "sk-1", TenantID: "acme"},
+		{ID: "k2", KeyHash: hash, TenantID: "acme"},
+		{ID: "old", Key: "sk-old", TenantID: "acme", ExpiresAt: now.Add(-time.Minute)},
+	}})
+
+	for id, want := range map[string]bool{"k1": true, "k2": true, "old": false, "missing": false} {
+		if got := registry.ActiveKey(id, now) != nil; got != want {
+			t.Errorf("ActiveKey(%s) = %v, want %v", id, got, want)
+		}
+	}
+}
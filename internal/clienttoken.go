@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// clientTokenPrefix 客户端 Token 前缀，格式为 rct_<payload>.<signature>
+	clientTokenPrefix = "rct_"
+	// defaultClientTokenTTL 未指定 ttl 时的有效期
+	defaultClientTokenTTL = 15 * time.Minute
+	// defaultClientTokenMaxTTL 未配置 max_ttl 时允许的最长有效期
+	defaultClientTokenMaxTTL = 1 * time.Hour
+	// budgetSweepInterval 清理过期预算记录的最小间隔
+	budgetSweepInterval = 1 * time.Minute
+)
+
+var (
+	// ErrClientTokenScope 申请的范围超出了签发方自己的范围
+	ErrClientTokenScope = errors.New("requested scope exceeds the issuing key")
+	// ErrTokenBudgetExhausted 客户端 Token 的 token 预算已用完
+	ErrTokenBudgetExhausted = errors.New("client token budget exhausted")
+)
+
+// isClientToken 是否是 relay 签发的客户端 Token
+func isClientToken(token string) bool {
+	return strings.HasPrefix(token, clientTokenPrefix)
+}
+
+// ClientTokenRequest 签发客户端 Token 的请求，范围为空时继承签发方的范围
+type ClientTokenRequest struct {
+	Routes    []string `json:"routes"`
+	Models    []string `json:"models"`
+	MaxTokens int64    `json:"max_tokens"` // 整个有效期内的 token 预算，0 表示不限制
+	TTL       string   `json:"ttl"`        // 例如 "10m"
+}
+
+// clientTokenClaims 签名覆盖的内容
+type clientTokenClaims struct {
+	ID        string   `json:"jti"`
+	TenantID  string   `json:"tid"`
+	KeyID     string   `json:"kid"`            // 签发方的 Key ID
+	APIKey    bool     `json:"akey,omitempty"` // 签发方是 API Key，校验时确认它仍然有效
+	Tier      string   `json:"tier,omitempty"`
+	Routes    []string `json:"routes,omitempty"`
+	Models    []string `json:"models,omitempty"`
+	MaxTokens int64    `json:"max_tokens,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// ClientTokenIssuer 签发和校验短期客户端 Token
+// 后端用真实 Key 换取限定路由、模型、token 预算和有效期的 Token，交给浏览器或移动端直接访问 relay
+// Token 用 HMAC-SHA256 签名，无需存储；有效期不超过签发凭证的过期时间，签发 Key 被吊销或过期后 Token 随之失效
+// 预算用量只记录在本实例内存中，多实例部署时每个实例各自计数，最坏情况下总用量可达实例数 × max_tokens
+type ClientTokenIssuer struct {
+	config    *ClientTokenConfig
+	secret    []byte
+	parentKey func(id string, now time.Time) *APIKeyConfig // 仍然有效的签发 Key，nil 时不检查
+
+	mu        sync.Mutex
+	used      map[string]*tokenBudget
+	lastSweep time.Time
+}
+
+// tokenBudget 单个客户端 Token 的预算用量
+type tokenBudget struct {
+	used      int64
+	expiresAt time.Time
+}
+
+// NewClientTokenIssuer 创建签发器，parentKey 用于确认签发 Key 没有被吊销或过期，并取得它的跨域策略
+func NewClientTokenIssuer(config *ClientTokenConfig, parentKey func(id string, now time.Time) *APIKeyConfig) *ClientTokenIssuer {
+	return &ClientTokenIssuer{
+		config:    config,
+		secret:    []byte(config.Secret),
+		parentKey: parentKey,
+		used:      make(map[string]*tokenBudget),
+	}
+}
+
+// Issue 为 parent 签发客户端 Token，范围不能超出 parent 自己的范围
+func (i *ClientTokenIssuer) Issue(parent *Principal, req *ClientTokenRequest, now time.Time) (string, time.Time, error) {
+	if parent.TokenID != "" {
+		return "", time.Time{}, fmt.Errorf("%w: client tokens cannot issue tokens", ErrClientTokenScope)
+	}
+
+	ttl := defaultClientTokenTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			return "", time.Time{}, fmt.Errorf("invalid ttl %q", req.TTL)
+		}
+		ttl = d
+	}
+	maxTTL := i.config.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = defaultClientTokenMaxTTL
+	}
+	if ttl > maxTTL {
+		return "", time.Time{}, fmt.Errorf("ttl exceeds max %s", maxTTL)
+	}
+	if req.MaxTokens < 0 {
+		return "", time.Time{}, errors.New("max_tokens must not be negative")
+	}
+
+	routes, err := narrowScope(parent.Routes, req.Routes, slices.Contains[[]string])
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	models, err := narrowScope(parent.Models, req.Models, func(_ []string, model string) bool {
+		return parent.allowsModelPattern(model)
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	// 有效期不超过签发凭证本身
+	expiresAt := now.Add(ttl)
+	if !parent.ExpiresAt.IsZero() && parent.ExpiresAt.Before(expiresAt) {
+		if !parent.ExpiresAt.After(now) {
+			return "", time.Time{}, fmt.Errorf("%w: issuing credential has expired", ErrClientTokenScope)
+		}
+		expiresAt = parent.ExpiresAt
+	}
+	claims := &clientTokenClaims{
+		ID:        uuid.NewString(),
+		TenantID:  parent.TenantID,
+		KeyID:     parent.KeyID,
+		APIKey:    parent.apiKey,
+		Tier:      parent.Tier,
+		Routes:    routes,
+		Models:    models,
+		MaxTokens: req.MaxTokens,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("encode client token: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return clientTokenPrefix + encoded + "." + i.sign(encoded), expiresAt, nil
+}
+
+// narrowScope 申请的范围必须是 parent 范围的子集，为空时继承 parent
+func narrowScope(parent, requested []string, allowed func([]string, string) bool) ([]string, error) {
+	if len(requested) == 0 {
+		return parent, nil
+	}
+	if len(parent) == 0 {
+		return requested, nil
+	}
+	for _, v := range requested {
+		if !allowed(parent, v) {
+			return nil, fmt.Errorf("%w: %s", ErrClientTokenScope, v)
+		}
+	}
+	return requested, nil
+}
+
+// Verify 校验签名和有效期，返回 Token 对应的身份
+func (i *ClientTokenIssuer) Verify(token string, now time.Time) (*Principal, error) {
+	encoded, signature, ok := strings.Cut(strings.TrimPrefix(token, clientTokenPrefix), ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(i.sign(encoded))) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims clientTokenClaims
+	if err := decodeSegment(encoded, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	// 浏览器中使用的 Token 沿用签发 Key 的跨域策略
+	var cors *CORSPolicy
+	if claims.APIKey && i.parentKey != nil {
+		parent := i.parentKey(claims.KeyID, now)
+		if parent == nil {
+			return nil, fmt.Errorf("%w: issuing key is revoked or expired", ErrInvalidToken)
+		}
+		cors = &parent.CORS
+	}
+
+	return &Principal{
+		KeyID:     claims.KeyID,
+		Name:      "client-token",
+		TenantID:  claims.TenantID,
+		Tier:      claims.Tier,
+		Routes:    claims.Routes,
+		Models:    claims.Models,
+		TokenID:   claims.ID,
+		MaxTokens: claims.MaxTokens,
+		CORS:      cors,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+	}, nil
+}
+
+// sign 计算签名
+func (i *ClientTokenIssuer) sign(encoded string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Reserve 从客户端 Token 的预算中预扣估算的 token 数，没有预算的身份直接放行
+func (i *ClientTokenIssuer) Reserve(p *Principal, estimated int64, now time.Time) error {
+	if p.TokenID == "" || p.MaxTokens <= 0 {
+		return nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.sweepLocked(now)
+	budget, ok := i.used[p.TokenID]
+	if !ok {
+		budget = &tokenBudget{expiresAt: p.ExpiresAt}
+		i.used[p.TokenID] = budget
+	}
+	if budget.used+estimated > p.MaxTokens {
+		return ErrTokenBudgetExhausted
+	}
+	budget.used += estimated
+	return nil
+}
+
+// Reconcile 按实际用量修正预扣值
+func (i *ClientTokenIssuer) Reconcile(p *Principal, reserved, actual int64) {
+	if p.TokenID == "" || p.MaxTokens <= 0 {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if budget, ok := i.used[p.TokenID]; ok {
+		budget.used = max(budget.used+actual-reserved, 0)
+	}
+}
+
+// sweepLocked 清理已过期 Token 的预算记录（调用方持有锁）
+func (i *ClientTokenIssuer) sweepLocked(now time.Time) {
+	if now.Sub(i.lastSweep) < budgetSweepInterval {
+		return
+	}
+	i.lastSweep = now
+	for id, budget := range i.used {
+		if now.After(budget.expiresAt) {
+			delete(i.used, id)
+		}
+	}
+}
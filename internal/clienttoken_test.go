@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestIssuer 创建测试用签发器
+func newTestIssuer() *ClientTokenIssuer {
+	return NewClientTokenIssuer(&ClientTokenConfig{
+		Enabled: true,
+		Secret:  strings.Repeat("s", 32),
+		MaxTTL:  time.Hour,
+	}, nil)
+}
+
+func TestClientTokenIssuer_IssueVerify(t *testing.T) {
+	issuer := newTestIssuer()
+	parent := &Principal{KeyID: "k1", TenantID: "acme", Tier: "pro", Routes: []string{"chat", "tts"}}
+	now := time.Now()
+
+	token, expiresAt, err := issuer.Issue(parent, &ClientTokenRequest{Routes: []string{"chat"}, Models: []string{"gpt-4o"}, MaxTokens: 1000, TTL: "10m"}, now)
+	if err != nil {
+		t.Fatalf("issue failed: %v", err)
+	}
+	if !isClientToken(token) {
+		t.Errorf("unexpected token format: %s", token)
+	}
+	if got := expiresAt.Sub(now); got != 10*time.Minute {
+		t.Errorf("expected 10m ttl, got %s", got)
+	}
+
+	p, err := issuer.Verify(token, now)
+	if err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	if p.TenantID != "acme" || p.Tier != "pro" || p.KeyID != "k1" || p.MaxTokens != 1000 || p.TokenID == "" {
+		t.Errorf("unexpected principal: %+v", p)
+	}
+	if !p.AllowsRoute("chat") || p.AllowsRoute("tts") || p.AllowsModel("gpt-4o-mini") {
+		t.Error("token scope not applied")
+	}
+
+	if _, err := issuer.Verify(token, now.Add(11*time.Minute)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected expired token to be rejected, got %v", err)
+	}
+
+	tampered := strings.Replace(token, token[len(clientTokenPrefix):len(clientTokenPrefix)+4], "AAAA", 1)
+	if _, err := issuer.Verify(tampered, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected tampered token to be rejected, got %v", err)
+	}
+
+	other := NewClientTokenIssuer(&ClientTokenConfig{Secret: strings.Repeat("x", 32)}, nil)
+	if _, err := other.Verify(token, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected token signed with another secret to be rejected, got %v", err)
+	}
+}
+
+func TestClientTokenIssuer_Scope(t *testing.T) {
+	issuer := newTestIssuer()
+	parent := &Principal{KeyID: "k1", TenantID: "acme", Routes: []string{"chat"}, Models: []string{"gpt-4o*"}}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		req     ClientTokenRequest
+		wantErr error
+	}{
+		{"inherit", ClientTokenRequest{}, nil},
+		{"narrower model", ClientTokenRequest{Models: []string{"gpt-4o-mini"}}, nil},
+		{"narrower prefix", ClientTokenRequest{Models: []string{"gpt-4o-mini*"}}, nil},
+		{"wider model", ClientTokenRequest{Models: []string{"gpt-*"}}, ErrClientTokenScope},
+		{"other model", ClientTokenRequest{Models: []string{"claude-3"}}, ErrClientTokenScope},
+		{"other route", ClientTokenRequest{Routes: []string{"tts"}}, ErrClientTokenScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := issuer.Issue(parent, &tt.req, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	if _, _, err := issuer.Issue(parent, &ClientTokenRequest{TTL: "2h"}, now); err == nil {
+		t.Error("expected ttl above max to be rejected")
+	}
+
+	token, _, _ := issuer.Issue(parent, &ClientTokenRequest{}, now)
+	child, _ := issuer.Verify(token, now)
+	if _, _, err := issuer.Issue(child, &ClientTokenRequest{}, now); !errors.Is(err, ErrClientTokenScope) {
+		t.Errorf("expected client token to be unable to issue tokens, got %v", err)
+	}
+}
+
+func TestClientTokenIssuer_ParentKey(t *testing.T) {
+	active := map[string]*APIKeyConfig{"k1": {ID: "k1", TenantID: "acme"}}
+	issuer := NewClientTokenIssuer(&ClientTokenConfig{Enabled: true, Secret: strings.Repeat("s", 32), MaxTTL: time.Hour},
+		func(id string, now time.Time) *APIKeyConfig { return active[id] })
+	now := time.Now()
+
+	// 有效期不超过签发 Key 的过期时间
+	parent := &Principal{KeyID: "k1", TenantID: "acme", ExpiresAt: now.Add(5 * time.Minute), apiKey: true}
+	token, expiresAt, err := issuer.Issue(parent, &ClientTokenRequest{TTL: "30m"}, now)
+	if err != nil {
+		t.Fatalf("issue failed: %v", err)
+	}
+	if !expiresAt.Equal(parent.ExpiresAt) {
+		t.Errorf("expected expiry clamped to the parent key, got %s", expiresAt.Sub(now))
+	}
+	if _, err := issuer.Verify(token, now.Add(6*time.Minute)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected token to expire with the parent key, got %v", err)
+	}
+
+	// 签发 Key 吊销后 Token 失效
+	if _, err := issuer.Verify(token, now); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+	delete(active, "k1")
+	if _, err := issuer.Verify(token, now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected token of a revoked key to be rejected, got %v", err)
+	}
+
+	// JWT 等无状态凭证只按过期时间约束
+	jwtParent := &Principal{KeyID: "jwt:svc", TenantID: "acme", ExpiresAt: now.Add(time.Minute)}
+	token, expiresAt, _ = issuer.Issue(jwtParent, &ClientTokenRequest{}, now)
+	if !expiresAt.Equal(jwtParent.ExpiresAt) {
+		t.Errorf("expected expiry clamped to the JWT, got %s", expiresAt.Sub(now))
+	}
+	if _, err := issuer.Verify(token, now); err != nil {
+		t.Errorf("expected token issued from a JWT to verify: %v", err)
+	}
+}
+
+func TestClientTokenIssuer_Budget(t *testing.T) {
+	issuer := newTestIssuer()
+	now := time.Now()
+	token, _, _ := issuer.Issue(&Principal{TenantID: "acme"}, &ClientTokenRequest{MaxTokens: 100}, now)
+	p, _ := issuer.Verify(token, now)
+
+	if err := issuer.Reserve(p, 80, now); err != nil {
+		t.Fatalf("reserve failed: %v", err)
+	}
+	if err := issuer.Reserve(p, 30, now); !errors.Is(err, ErrTokenBudgetExhausted) {
+		t.Errorf("expected ErrTokenBudgetExhausted, got %v", err)
+	}
+
+	// Actual usage lower than estimate frees budget
+	issuer.Reconcile(p, 80, 50)
+	if err := issuer.Reserve(p, 30, now); err != nil {
+		t.Errorf("expected budget after reconcile, got %v", err)
+	}
+
+	// Principals without a budget are never limited
+	if err := issuer.Reserve(&Principal{TenantID: "acme"}, 1_000_000, now); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
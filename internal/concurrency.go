@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrQueueFull 并发已满且等待队列已满
+	ErrQueueFull = errors.New("too many concurrent streams")
+	// ErrQueueTimeout 在队列中等待超时
+	ErrQueueTimeout = errors.New("timed out waiting for a stream slot")
+)
+
+// streamLimiter 并发流限制 - 超过上限的请求进入有界 FIFO 队列等待
+// 名额释放时直接交给队首，避免新请求插队
+type streamLimiter struct {
+	mu        sync.Mutex
+	limit     int // 0 表示不限制
+	queueSize int
+	active    int
+	waiters   list.List      // chan struct{}，close 表示获得名额
+	movedTo   *streamLimiter // 配置热更新后作用域合并到的 limiter，之后的获取和归还都转给它
+}
+
+// newStreamLimiter 创建并发限制器
+func newStreamLimiter(limit, queueSize int) *streamLimiter {
+	return &streamLimiter{
+		limit:     limit,
+		queueSize: queueSize,
+	}
+}
+
+// acquire 获取一个名额，必要时排队等待直到 ctx 结束
+// 等到 ctx 的截止时间返回 ErrQueueTimeout，ctx 被取消（客户端断开）时返回 ctx.Err()
+// onQueued 在进入队列后调用（不持有锁），返回值表示是否排过队
+func (l *streamLimiter) acquire(ctx context.Context, onQueued func()) (bool, error) {
+	l.mu.Lock()
+	if next := l.movedTo; next != nil {
+		l.mu.Unlock()
+		return next.acquire(ctx, onQueued)
+	}
+	if l.hasCapacity() && l.waiters.Len() == 0 {
+		l.active++
+		l.mu.Unlock()
+		return false, nil
+	}
+	if l.waiters.Len() >= l.queueSize {
+		l.mu.Unlock()
+		return false, ErrQueueFull
+	}
+	ready := make(chan struct{})
+	l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	if onQueued != nil {
+		onQueued()
+	}
+
+	select {
+	case <-ready:
+		return true, nil
+	case <-ctx.Done():
+		l.abandon(ready)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return true, ErrQueueTimeout
+		}
+		return true, ctx.Err()
+	}
+}
+
+// abandon 等待超时后离开队列；等待者可能已经随热更新转到了 movedTo 的队列中
+func (l *streamLimiter) abandon(ready chan struct{}) {
+	l.mu.Lock()
+	if next := l.movedTo; next != nil {
+		l.mu.Unlock()
+		next.abandon(ready)
+		return
+	}
+	for elem := l.waiters.Front(); elem != nil; elem = elem.Next() {
+		if elem.Value.(chan struct{}) == ready {
+			l.waiters.Remove(elem)
+			l.mu.Unlock()
+			return
+		}
+	}
+	l.mu.Unlock()
+
+	// 超时的同时拿到了名额，归还给下一个等待者
+	l.release()
+}
+
+// release 归还名额
+func (l *streamLimiter) release() {
+	l.mu.Lock()
+	if next := l.movedTo; next != nil {
+		l.mu.Unlock()
+		next.release()
+		return
+	}
+	defer l.mu.Unlock()
+
+	l.active--
+	l.dispatch()
+}
+
+// migrateTo 把进行中的流和排队的请求转移到 dst（配置热更新时两个作用域合并为一个）
+// 转移后 l 只做转发：之前获得的名额在 dst 上归还，排队的请求在 dst 上等待
+func (l *streamLimiter) migrateTo(dst *streamLimiter) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	l.movedTo = dst
+	dst.active += l.active
+	l.active = 0
+	for elem := l.waiters.Front(); elem != nil; elem = elem.Next() {
+		dst.waiters.PushBack(elem.Value)
+	}
+	l.waiters.Init()
+	dst.dispatch()
+}
+
+// setLimit 调整上限和队列长度（配置热更新），上限提高时立即唤醒等待者
+// 上限降低时不会中断进行中的流，只是在它们结束前不再放行新请求
+func (l *streamLimiter) setLimit(limit, queueSize int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.queueSize = queueSize
+	l.dispatch()
+}
+
+// inUse 是否有进行中或排队中的请求
+func (l *streamLimiter) inUse() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.active > 0 || l.waiters.Len() > 0
+}
+
+// dispatch 按 FIFO 顺序把空闲名额交给等待者（调用方持有锁）
+func (l *streamLimiter) dispatch() {
+	for l.hasCapacity() {
+		front := l.waiters.Front()
+		if front == nil {
+			return
+		}
+		l.waiters.Remove(front)
+		l.active++
+		close(front.Value.(chan struct{}))
+	}
+}
+
+// hasCapacity 是否还有空闲名额（调用方持有锁）
+func (l *streamLimiter) hasCapacity() bool {
+	return l.limit <= 0 || l.active < l.limit
+}
@@ -0,0 +1,247 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStreamLimiter_Unlimited(t *testing.T) {
+	l := newStreamLimiter(0, 0)
+
+	for i := 0; i < 100; i++ {
+		if _, err := l.acquire(context.Background(), nil); err != nil {
+			t.Fatalf("unlimited limiter should never block: %v", err)
+		}
+	}
+}
+
+func TestStreamLimiter_QueueFull(t *testing.T) {
+	l := newStreamLimiter(1, 0)
+
+	if _, err := l.acquire(context.Background(), nil); err != nil {
+		t.Fatalf("first acquire failed: %v", err)
+	}
+
+	// No queue configured - reject immediately
+	if _, err := l.acquire(context.Background(), nil); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestStreamLimiter_Timeout(t *testing.T) {
+	l := newStreamLimiter(1, 1)
+	l.acquire(context.Background(), nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	queued, err := l.acquire(ctx, nil)
+	if !errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("expected ErrQueueTimeout, got %v", err)
+	}
+	if !queued {
+		t.Error("expected request to have been queued")
+	}
+
+	// Timed-out waiter must be removed from the queue
+	l.mu.Lock()
+	waiting := l.waiters.Len()
+	l.mu.Unlock()
+	if waiting != 0 {
+		t.Errorf("expected empty queue after timeout, got %d", waiting)
+	}
+}
+
+func TestStreamLimiter_Canceled(t *testing.T) {
+	l := newStreamLimiter(1, 1)
+	l.acquire(context.Background(), nil)
+
+	// 客户端断开不是排队超时
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := l.acquire(ctx, nil); !errors.Is(err, context.Canceled) || errors.Is(err, ErrQueueTimeout) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	l.mu.Lock()
+	waiting := l.waiters.Len()
+	l.mu.Unlock()
+	if waiting != 0 {
+		t.Errorf("expected empty queue after cancel, got %d", waiting)
+	}
+}
+
+func TestStreamLimiter_FIFO(t *testing.T) {
+	l := newStreamLimiter(1, 3)
+	l.acquire(context.Background(), nil)
+
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		queued := make(chan struct{})
+		go func(id int) {
+			l.acquire(context.Background(), func() { close(queued) })
+			order <- id
+			l.release()
+		}(i)
+		<-queued // enqueue strictly in order
+	}
+
+	l.release()
+
+	for want := 0; want < 3; want++ {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("expected waiter %d to run next, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter was not woken")
+		}
+	}
+}
+
+func TestStreamLimiter_SetLimitWakesWaiters(t *testing.T) {
+	l := newStreamLimiter(1, 1)
+	l.acquire(context.Background(), nil)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.acquire(context.Background(), nil)
+		done <- err
+	}()
+
+	// Wait until the request is queued, then raise the limit
+	for {
+		l.mu.Lock()
+		waiting := l.waiters.Len()
+		l.mu.Unlock()
+		if waiting == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.setLimit(2, 1)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit should wake the waiter")
+	}
+}
+
+func TestRateLimiter_AcquireStream(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled:              true,
+		Default:              600,
+		Burst:                100,
+		MaxConcurrentStreams: 2,
+		QueueSize:            0,
+	}
+	route := &RouteConfig{Name: "chat", MaxConcurrentStreams: 3}
+
+	rl := NewRateLimiter(config, getTestMetrics())
+
+	// Tenant limit: 2 concurrent streams
+	r1, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant1", Route: "chat"}, route)
+	if err != nil {
+		t.Fatalf("acquire 1 failed: %v", err)
+	}
+	if _, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant1", Route: "chat"}, route); err != nil {
+		t.Fatalf("acquire 2 failed: %v", err)
+	}
+	if _, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant1", Route: "chat"}, route); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected tenant limit to reject third stream, got %v", err)
+	}
+
+	// Route limit: 3 concurrent streams shared by all tenants
+	if _, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant2", Route: "chat"}, route); err != nil {
+		t.Fatalf("acquire for tenant2 failed: %v", err)
+	}
+	if _, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant3", Route: "chat"}, route); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected route limit to reject fourth stream, got %v", err)
+	}
+
+	// A rejected route acquisition must not leak the tenant slot
+	tenant3 := rl.getLimiter(LimitScope{TenantID: "tenant3", Route: "chat"})
+	tenant3.streams.mu.Lock()
+	active := tenant3.streams.active
+	tenant3.streams.mu.Unlock()
+	if active != 0 {
+		t.Errorf("expected tenant slot to be released, got %d active", active)
+	}
+
+	r1()
+	if _, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant3", Route: "chat"}, route); err != nil {
+		t.Errorf("expected slot after release, got %v", err)
+	}
+}
+
+func TestRateLimiter_ReloadWhileStreaming(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:              true,
+		Default:              600,
+		Burst:                100,
+		MaxConcurrentStreams: 1,
+		Routes: map[string]RateLimitRule{
+			"chat": {MaxConcurrentStreams: 1},
+		},
+	}, nil)
+	defer rl.Stop()
+	route := &RouteConfig{Name: "chat", MaxConcurrentStreams: 1}
+	chat := LimitScope{TenantID: "tenant1", Route: "chat"}
+	other := LimitScope{TenantID: "tenant1", Route: "other"}
+
+	// tenant1@chat 和 tenant1 各有一个进行中的流
+	releaseChat, err := rl.AcquireStream(context.Background(), chat, route)
+	if err != nil {
+		t.Fatalf("acquire chat failed: %v", err)
+	}
+	releaseOther, err := rl.AcquireStream(context.Background(), other, &RouteConfig{Name: "other"})
+	if err != nil {
+		t.Fatalf("acquire other failed: %v", err)
+	}
+
+	// 删除路由级规则后两个作用域合并，路由上限提高到 3
+	routes := []RouteConfig{{Name: "chat", MaxConcurrentStreams: 3}}
+	rl.Reload(&RateLimitConfig{Enabled: true, Default: 600, Burst: 100, MaxConcurrentStreams: 3}, routes)
+
+	tenant := rl.getLimiter(chat)
+	tenant.streams.mu.Lock()
+	active := tenant.streams.active
+	tenant.streams.mu.Unlock()
+	if active != 2 {
+		t.Fatalf("expected in-flight streams to be migrated, got %d active", active)
+	}
+
+	release, err := rl.AcquireStream(context.Background(), chat, route)
+	if err != nil {
+		t.Fatalf("expected the raised route limit to admit a stream, got %v", err)
+	}
+	if _, err := rl.AcquireStream(context.Background(), chat, route); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected the tenant limit of 3 to count migrated streams, got %v", err)
+	}
+
+	// 热更新前获得的名额在新的 limiter 上归还
+	releaseChat()
+	releaseOther()
+	release()
+	tenant.streams.mu.Lock()
+	active = tenant.streams.active
+	tenant.streams.mu.Unlock()
+	if active != 0 {
+		t.Errorf("expected all slots to be returned, got %d active", active)
+	}
+
+	// 路由上限降低时原地生效
+	rl.Reload(&RateLimitConfig{Enabled: true, Default: 600, Burst: 100}, []RouteConfig{{Name: "chat", MaxConcurrentStreams: 1}})
+	if _, err := rl.AcquireStream(context.Background(), chat, route); err != nil {
+		t.Fatalf("acquire after lowering failed: %v", err)
+	}
+	if _, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "tenant2", Route: "chat"}, route); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected the lowered route limit to apply, got %v", err)
+	}
+}
@@ -17,12 +17,91 @@ type Config struct {
 	RateLimit     RateLimitConfig     `yaml:"rate_limit"`
 	Observability ObservabilityConfig `yaml:"observability"`
 	Auth          AuthConfig          `yaml:"auth"`
+	Credentials   CredentialsConfig   `yaml:"credentials"`
+	Secrets       SecretsConfig       `yaml:"secrets"`
+	TTSCache      TTSCacheConfig      `yaml:"tts_cache"`
+}
+
+// TTSCacheConfig TTS 音频的磁盘缓存，路由通过 tts.cache 开启
+type TTSCacheConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Dir     string `yaml:"dir"`      // 缓存目录，启动时自动创建
+	MaxSize int64  `yaml:"max_size"` // 缓存总大小上限（字节），超过时淘汰最久未使用的条目，默认 1GB
+	// ExposeHeader 在响应中返回 X-TTS-Cache: hit | miss，默认关闭，避免客户端借此探测其他请求的内容
+	ExposeHeader bool `yaml:"expose_header"`
+}
+
+// SecretsConfig 路由上游凭证的读取方式（auth_secret），启动时缺失即退出
+type SecretsConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // 重新读取文件和 Vault 的间隔，默认 30s
+	Vault           VaultConfig   `yaml:"vault"`
+}
+
+// VaultConfig Vault 兼容的 KV 存储，供 auth_secret: vault:<path>#<field> 使用
+type VaultConfig struct {
+	Addr      string `yaml:"addr"`
+	Token     string `yaml:"token"` // 建议用 ${VAULT_TOKEN} 注入
+	Namespace string `yaml:"namespace"`
+}
+
+// CredentialsConfig 租户自带上游凭证（BYOK），通过管理 API 写入，加密保存
+type CredentialsConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	MasterKey string `yaml:"master_key"` // base64 编码的 32 字节 AES-256 密钥
+
+	// AllowMemoryStore 没有 Redis 时允许把凭证保存在内存中（只在本实例生效，重启后丢失），否则启动失败
+	AllowMemoryStore bool `yaml:"allow_memory_store"`
 }
 
 type ServerConfig struct {
-	Port        int           `yaml:"port"`
-	Timeout     time.Duration `yaml:"timeout"`
-	MaxBodySize int64         `yaml:"max_body_size"`
+	Port        int            `yaml:"port"`
+	Timeout     time.Duration  `yaml:"timeout"`
+	MaxBodySize int64          `yaml:"max_body_size"`
+	TLS         TLSConfig      `yaml:"tls"`
+	ClientIP    ClientIPConfig `yaml:"client_ip"`
+	CORS        CORSConfig     `yaml:"cors"`
+}
+
+// CORSConfig 跨域配置，租户和 Key 可以覆盖 allowed_origins / allowed_methods / allowed_headers
+type CORSConfig struct {
+	Enabled    bool `yaml:"enabled"`
+	CORSPolicy `yaml:",inline"`
+	MaxAge     time.Duration `yaml:"max_age"` // 预检结果缓存时间
+}
+
+// CORSPolicy 跨域策略
+type CORSPolicy struct {
+	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins,omitempty"` // "*"、https://app.example.com 或 https://*.example.com
+	AllowedMethods []string `yaml:"allowed_methods" json:"allowed_methods,omitempty"` // 默认 GET、POST
+	AllowedHeaders []string `yaml:"allowed_headers" json:"allowed_headers,omitempty"` // 默认 Authorization、Content-Type、X-Request-ID
+}
+
+// ClientIPConfig 客户端 IP 解析 - 只采信可信代理追加的 X-Forwarded-For
+type ClientIPConfig struct {
+	TrustedProxies []string `yaml:"trusted_proxies"` // 可信代理的 CIDR
+	TrustedHops    int      `yaml:"trusted_hops"`    // 无论来源都信任的 X-Forwarded-For 跳数（例如前面固定有一层负载均衡时为 1）
+}
+
+// TLSConfig TLS 终止和客户端证书（mTLS）鉴权，证书文件变化后自动重新加载
+type TLSConfig struct {
+	Enabled      bool                 `yaml:"enabled"`
+	CertFile     string               `yaml:"cert_file"`
+	KeyFile      string               `yaml:"key_file"`
+	ClientCAFile string               `yaml:"client_ca_file"`    // 校验客户端证书的 CA
+	ClientAuth   string               `yaml:"client_auth"`       // none | request | require
+	Identities   []ClientCertIdentity `yaml:"client_identities"` // 客户端证书到租户的映射
+}
+
+// ClientCertIdentity 客户端证书身份，common_name / dns_name / uri 任一匹配即可
+type ClientCertIdentity struct {
+	ID         string   `yaml:"id"`
+	CommonName string   `yaml:"common_name"`
+	DNSName    string   `yaml:"dns_name"`
+	URI        string   `yaml:"uri"` // 例如 SPIFFE ID
+	TenantID   string   `yaml:"tenant_id"`
+	Tier       string   `yaml:"tier"`
+	Routes     []string `yaml:"routes"`
+	Models     []string `yaml:"models"`
 }
 
 type RouteConfig struct {
@@ -30,8 +109,66 @@ type RouteConfig struct {
 	Path       string `yaml:"path"`
 	Upstream   string `yaml:"upstream"`
 	AuthHeader string `yaml:"auth_header"`
-	AuthEnv    string `yaml:"auth_env"` // 从环境变量读取
-	Kind       string `yaml:"kind"`     // sse | raw
+	AuthEnv    string `yaml:"auth_env"`    // 从环境变量读取，等价于 auth_secret: env:<name>
+	AuthSecret string `yaml:"auth_secret"` // env:<name> | file:<path> | vault:<path>#<field>
+	Kind       string `yaml:"kind"`        // sse | raw | eventstream（AWS event-stream，转换为 SSE）| multipart（语音转写上传）| voice（LLM + TTS 组合路由）
+
+	UpstreamAuth UpstreamAuthConfig `yaml:"upstream_auth"` // 凭证的使用方式，默认放在 auth_header 中
+
+	// RequireTenantCredential 只使用租户自带的凭证（credentials），租户没有配置时拒绝请求，不回退到路由凭证
+	RequireTenantCredential bool `yaml:"require_tenant_credential"`
+
+	Provider       string `yaml:"provider"`        // gemini：补全 alt=sse、从路径解析模型、提取 usageMetadata
+	ResponseFormat string `yaml:"response_format"` // openai：把 Gemini 的流转换为 OpenAI chat.completion.chunk
+
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"` // 路由级并发流上限（所有租户共享），0 表示不限制
+
+	TTS   TTSConfig   `yaml:"tts"`   // raw 路由按 TTS 处理（tts.enabled）及其可选功能
+	Voice VoiceConfig `yaml:"voice"` // voice 路由使用的 LLM 和 TTS 路由
+}
+
+// VoiceConfig 语音管线：流式生成回复，按句送入 TTS，输出一个音频流
+type VoiceConfig struct {
+	LLMRoute   string            `yaml:"llm_route"`   // 生成回复的 sse 路由
+	TTSRoute   string            `yaml:"tts_route"`   // 合成语音的 raw 路由，并行数和分段长度沿用其 tts 配置
+	TTSBody    string            `yaml:"tts_body"`    // TTS 请求体模板，{{text}} 替换为句子（SSML 按 XML 转义，JSON 按字符串转义）
+	TTSHeaders map[string]string `yaml:"tts_headers"` // TTS 请求头，例如 X-Microsoft-OutputFormat
+}
+
+// TTSConfig 语音合成路由的配置
+type TTSConfig struct {
+	Enabled bool `yaml:"enabled"` // 按 TTS 路由处理：计量输出音频的格式、时长、实时率和输入字符数，以下功能都需要开启
+
+	Segment         bool `yaml:"segment"`           // 按句切分：第一句立即合成，其余并行合成后按顺序拼接
+	MaxParallel     int  `yaml:"max_parallel"`      // 同时合成的段数，默认 3
+	MaxSegmentChars int  `yaml:"max_segment_chars"` // 第一段之后每段的字符数上限，默认 300
+	MaxSegments     int  `yaml:"max_segments"`      // 每个请求最多分成的段数（即上游请求数），超过时不分段，默认 20
+
+	// Azure TTS：配置 voices 后接受 {text, voice, rate, pitch, style, format} 的 JSON 请求，由 relay 生成 SSML
+	Voices        []string `yaml:"voices"`         // 允许的 voice，JSON 请求未指定时使用第一个
+	DefaultFormat string   `yaml:"default_format"` // JSON 请求未指定 format 时的输出格式，默认 audio-24khz-48kbitrate-mono-mp3
+
+	Cache bool `yaml:"cache"` // 使用 tts_cache：相同的文本、voice 和输出格式直接返回缓存的音频
+}
+
+// UpstreamAuthConfig 上游鉴权方式，凭证本身仍来自 auth_env / auth_secret 或租户自带凭证
+type UpstreamAuthConfig struct {
+	Type string `yaml:"type"` // header（默认）| query | sigv4 | azure_ad | google_service_account
+
+	// query
+	Param string `yaml:"param"` // 查询参数名，默认 key
+
+	// sigv4：凭证为 secret access key
+	Region       string `yaml:"region"`
+	Service      string `yaml:"service"` // 例如 bedrock
+	AccessKeyID  string `yaml:"access_key_id"`
+	SessionToken string `yaml:"session_token"`
+
+	// azure_ad：凭证为 client secret；google_service_account：凭证为服务账号 JSON key
+	TenantID string   `yaml:"tenant_id"`
+	ClientID string   `yaml:"client_id"`
+	Scopes   []string `yaml:"scopes"`
+	TokenURL string   `yaml:"token_url"` // 覆盖默认的 token 端点
 }
 
 type StorageConfig struct {
@@ -55,9 +192,36 @@ type ClickHouseConfig struct {
 }
 
 type RateLimitConfig struct {
-	Enabled bool `yaml:"enabled"`
-	Default int  `yaml:"default"` // requests per minute
-	Burst   int  `yaml:"burst"`
+	Enabled         bool                       `yaml:"enabled"`
+	Default         int                        `yaml:"default"` // requests per minute
+	Burst           int                        `yaml:"burst"`
+	TokensPerMinute int                        `yaml:"tokens_per_minute"` // 0 表示不限制 TPM
+	Tenants         map[string]TenantRateLimit `yaml:"tenants"`           // 按租户覆盖
+	Routes          map[string]RateLimitRule   `yaml:"routes"`            // 按路由名覆盖
+	Tiers           map[string]RateLimitRule   `yaml:"tiers"`             // 限流档位，由 API Key 的 tier 引用
+
+	// 并发流限制
+	MaxConcurrentStreams int           `yaml:"max_concurrent_streams"` // 每租户并发流上限，0 表示不限制
+	QueueSize            int           `yaml:"queue_size"`             // 超过上限时的排队长度，0 表示直接拒绝
+	QueueTimeout         time.Duration `yaml:"queue_timeout"`          // 排队超时，默认 30s
+
+	// limiter 淘汰
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // 空闲多久后淘汰，默认 1h
+	MaxTenants  int           `yaml:"max_tenants"`  // 最多保留的 limiter 数，默认 10000
+}
+
+// RateLimitRule 限流覆盖规则，为 0 的字段继承上一级
+type RateLimitRule struct {
+	Default              int `yaml:"default"`
+	Burst                int `yaml:"burst"`
+	TokensPerMinute      int `yaml:"tokens_per_minute"`
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+}
+
+// TenantRateLimit 租户级限流规则，可以再按路由细分
+type TenantRateLimit struct {
+	RateLimitRule `yaml:",inline"`
+	Routes        map[string]RateLimitRule `yaml:"routes"`
 }
 
 type ObservabilityConfig struct {
@@ -77,7 +241,91 @@ type LoggingConfig struct {
 }
 
 type AuthConfig struct {
-	APIKeys []string `yaml:"api_keys"`
+	APIKeys            []string                    `yaml:"api_keys"`             // 旧格式：不绑定租户，统一归属 default 租户
+	AllowPlaintextKeys bool                        `yaml:"allow_plaintext_keys"` // 允许 api_keys 和明文 key，默认只接受 key_hash
+	Keys               []APIKeyConfig              `yaml:"keys"`
+	Tenants            map[string]TenantAuthConfig `yaml:"tenants"` // 租户级访问限制
+	Admin              AdminConfig                 `yaml:"admin"`
+	JWT                JWTConfig                   `yaml:"jwt"`
+	ClientTokens       ClientTokenConfig           `yaml:"client_tokens"`
+}
+
+// TenantAuthConfig 租户级访问限制，对该租户的所有 Key、JWT 和证书生效
+type TenantAuthConfig struct {
+	AllowedCIDRs []string   `yaml:"allowed_cidrs"`
+	CORS         CORSPolicy `yaml:"cors"`
+}
+
+// ClientTokenConfig 短期客户端 Token（给浏览器、移动端使用）
+type ClientTokenConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Secret  string        `yaml:"secret"`  // HMAC 签名密钥，至少 32 字节，建议用 ${ENV} 注入
+	MaxTTL  time.Duration `yaml:"max_ttl"` // 允许的最长有效期，默认 1h
+}
+
+// JWTConfig IdP 签发的 JWT 鉴权，与 API Key 同时生效
+type JWTConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	JWKSURL  string        `yaml:"jwks_url"` // 与 jwks_file 二选一
+	JWKSFile string        `yaml:"jwks_file"`
+	CacheTTL time.Duration `yaml:"cache_ttl"` // JWKS 缓存时间，默认 10m
+	Issuer   string        `yaml:"issuer"`
+	Audience string        `yaml:"audience"`
+	Leeway   time.Duration `yaml:"leeway"` // exp / nbf 允许的时钟偏差
+	Claims   JWTClaims     `yaml:"claims"`
+}
+
+// JWTClaims claim 名到调用方身份的映射
+type JWTClaims struct {
+	Tenant string `yaml:"tenant"` // 默认 tenant_id
+	Tier   string `yaml:"tier"`   // 默认 tier
+	Scopes string `yaml:"scopes"` // 默认 scope，值为 "route:<name>" / "model:<pattern>"
+}
+
+// tenantClaim 租户 claim 名
+func (c JWTClaims) tenantClaim() string {
+	if c.Tenant == "" {
+		return "tenant_id"
+	}
+	return c.Tenant
+}
+
+// tierClaim 限流档位 claim 名
+func (c JWTClaims) tierClaim() string {
+	if c.Tier == "" {
+		return "tier"
+	}
+	return c.Tier
+}
+
+// scopesClaim 访问范围 claim 名
+func (c JWTClaims) scopesClaim() string {
+	if c.Scopes == "" {
+		return "scope"
+	}
+	return c.Scopes
+}
+
+// AdminConfig 管理 API 配置
+type AdminConfig struct {
+	Enabled   bool     `yaml:"enabled"`
+	KeyHashes []string `yaml:"key_hashes"` // 管理员 Token 的哈希，由 relay keys hash 生成
+}
+
+// APIKeyConfig 绑定到租户的 API Key，管理 API 创建的 Key 以 JSON 形式保存在 Redis 中
+type APIKeyConfig struct {
+	ID        string    `yaml:"id" json:"id"`
+	Name      string    `yaml:"name" json:"name,omitempty"`
+	Key       string    `yaml:"key" json:"-"`             // 明文 Key（不推荐），与 key_hash 二选一
+	KeyHash   string    `yaml:"key_hash" json:"key_hash"` // 加盐哈希，由 relay keys hash 生成
+	TenantID  string    `yaml:"tenant_id" json:"tenant_id"`
+	Routes    []string  `yaml:"routes" json:"routes,omitempty"` // 允许的路由名，空表示全部
+	Models    []string  `yaml:"models" json:"models,omitempty"` // 允许的模型，空表示全部，支持 "gpt-4o*" 前缀匹配
+	Tier      string    `yaml:"tier" json:"tier,omitempty"`     // 限流档位，对应 rate_limit.tiers
+	ExpiresAt time.Time `yaml:"expires_at" json:"expires_at"`   // RFC 3339，不填表示不过期
+
+	AllowedCIDRs []string   `yaml:"allowed_cidrs" json:"allowed_cidrs,omitempty"` // 允许的来源网段，空表示不限制
+	CORS         CORSPolicy `yaml:"cors" json:"cors,omitzero"`                    // 浏览器跨域策略，覆盖租户和全局配置
 }
 
 // LoadConfig 加载配置文件
@@ -120,14 +368,454 @@ func (c *Config) Validate() error {
 		if route.Path == "" {
 			return fmt.Errorf("route path is required for %s", route.Name)
 		}
-		if route.Upstream == "" {
+		// voice 路由没有自己的上游，转发到 llm_route 和 tts_route
+		if route.Upstream == "" && route.Kind != "voice" {
 			return fmt.Errorf("route upstream is required for %s", route.Name)
 		}
-		if route.Kind != "sse" && route.Kind != "raw" {
-			return fmt.Errorf("invalid route kind: %s (must be 'sse' or 'raw')", route.Kind)
+		if route.Kind != "sse" && route.Kind != "raw" && route.Kind != "eventstream" && route.Kind != "multipart" && route.Kind != "voice" {
+			return fmt.Errorf("invalid route kind: %s (must be 'sse', 'raw', 'eventstream', 'multipart' or 'voice')", route.Kind)
+		}
+		if route.Kind == "multipart" && route.UpstreamAuth.Type == UpstreamAuthSigV4 {
+			// 上传不缓存，无法计算 SigV4 需要的请求体哈希
+			return fmt.Errorf("route %s: kind multipart does not support sigv4 upstream auth", route.Name)
+		}
+		if route.MaxConcurrentStreams < 0 {
+			return fmt.Errorf("invalid max_concurrent_streams for %s: %d", route.Name, route.MaxConcurrentStreams)
+		}
+		if err := c.validateSecretRef(&route); err != nil {
+			return err
+		}
+		if err := route.UpstreamAuth.validate(); err != nil {
+			return fmt.Errorf("route %s: upstream_auth: %w", route.Name, err)
+		}
+		if route.RequireTenantCredential && !c.Credentials.Enabled {
+			return fmt.Errorf("route %s: require_tenant_credential requires credentials to be enabled", route.Name)
+		}
+		if err := route.TTS.validate(route.Kind); err != nil {
+			return fmt.Errorf("route %s: tts: %w", route.Name, err)
+		}
+		if route.TTS.Cache && !c.TTSCache.Enabled {
+			return fmt.Errorf("route %s: tts: cache requires tts_cache to be enabled", route.Name)
+		}
+		if err := c.validateVoice(&route); err != nil {
+			return fmt.Errorf("route %s: voice: %w", route.Name, err)
+		}
+		if route.Provider != "" && route.Provider != ProviderGemini {
+			return fmt.Errorf("route %s: invalid provider %q (must be gemini)", route.Name, route.Provider)
+		}
+		if route.ResponseFormat != "" && (route.ResponseFormat != ResponseFormatOpenAI || route.Provider != ProviderGemini || route.Kind != "sse") {
+			return fmt.Errorf("route %s: response_format openai requires provider gemini and kind sse", route.Name)
+		}
+	}
+
+	if err := c.RateLimit.Validate(); err != nil {
+		return err
+	}
+
+	if err := c.validateTLS(); err != nil {
+		return err
+	}
+
+	if _, err := parseCIDRs(c.Server.ClientIP.TrustedProxies); err != nil {
+		return fmt.Errorf("client_ip trusted_proxies: %w", err)
+	}
+	if c.Server.ClientIP.TrustedHops < 0 {
+		return fmt.Errorf("client_ip trusted_hops must not be negative")
+	}
+	if err := validateCORSPolicy(&c.Server.CORS.CORSPolicy); err != nil {
+		return fmt.Errorf("cors: %w", err)
+	}
+
+	if c.TTSCache.Enabled && c.TTSCache.Dir == "" {
+		return fmt.Errorf("tts_cache: dir is required")
+	}
+	if c.TTSCache.MaxSize < 0 {
+		return fmt.Errorf("tts_cache: max_size must not be negative")
+	}
+
+	if c.Credentials.Enabled {
+		if !c.Auth.Admin.Enabled {
+			return fmt.Errorf("credentials: requires auth.admin to be enabled")
+		}
+		if _, err := parseMasterKey(c.Credentials.MasterKey); err != nil {
+			return fmt.Errorf("credentials: %w", err)
+		}
+	}
+
+	if err := c.validateKeys(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateKeys 验证 API Key 配置
+func (c *Config) validateKeys() error {
+	ids := make(map[string]bool)
+	keys := make(map[string]bool)
+	if len(c.Auth.APIKeys) > 0 && !c.Auth.AllowPlaintextKeys {
+		return fmt.Errorf("auth api_keys stores keys in plaintext; use keys with key_hash or set auth.allow_plaintext_keys")
+	}
+	for _, key := range c.Auth.APIKeys {
+		keys[key] = true
+	}
+
+	for i := range c.Auth.Keys {
+		key := &c.Auth.Keys[i]
+		if err := c.ValidateKey(key); err != nil {
+			return err
+		}
+		if ids[key.ID] {
+			return fmt.Errorf("duplicate auth key id: %s", key.ID)
+		}
+		ids[key.ID] = true
+
+		switch {
+		case key.Key != "" && key.KeyHash != "":
+			return fmt.Errorf("auth key %s: key and key_hash are mutually exclusive", key.ID)
+		case key.KeyHash != "":
+			if _, err := parseKeyHash(key.KeyHash); err != nil {
+				return fmt.Errorf("auth key %s: invalid key_hash: %w", key.ID, err)
+			}
+		case key.Key == "":
+			return fmt.Errorf("auth key %s: key or key_hash is required", key.ID)
+		case !c.Auth.AllowPlaintextKeys:
+			return fmt.Errorf("auth key %s: plaintext key is not allowed; use key_hash or set auth.allow_plaintext_keys", key.ID)
+		case keys[key.Key]:
+			return fmt.Errorf("auth key %s: duplicate key value", key.ID)
+		default:
+			keys[key.Key] = true
+		}
+	}
+
+	for _, hash := range c.Auth.Admin.KeyHashes {
+		if _, err := parseKeyHash(hash); err != nil {
+			return fmt.Errorf("invalid admin key hash: %w", err)
+		}
+	}
+	if c.Auth.Admin.Enabled && len(c.Auth.Admin.KeyHashes) == 0 {
+		return fmt.Errorf("auth admin enabled but no key_hashes configured")
+	}
+
+	for tenant, t := range c.Auth.Tenants {
+		if _, err := parseCIDRs(t.AllowedCIDRs); err != nil {
+			return fmt.Errorf("auth tenant %s: %w", tenant, err)
+		}
+		if err := validateCORSPolicy(&t.CORS); err != nil {
+			return fmt.Errorf("auth tenant %s: %w", tenant, err)
+		}
+	}
+
+	if c.Auth.ClientTokens.Enabled && len(c.Auth.ClientTokens.Secret) < 32 {
+		return fmt.Errorf("auth client_tokens: secret must be at least 32 bytes")
+	}
+
+	if jwt := c.Auth.JWT; jwt.Enabled {
+		if (jwt.JWKSURL == "") == (jwt.JWKSFile == "") {
+			return fmt.Errorf("auth jwt: exactly one of jwks_url and jwks_file is required")
+		}
+		if jwt.Issuer == "" || jwt.Audience == "" {
+			return fmt.Errorf("auth jwt: issuer and audience are required")
+		}
+	}
+
+	return nil
+}
+
+// ValidateKey 验证单个 Key 的 ID、租户和访问范围（不含 Key 本身）
+func (c *Config) ValidateKey(key *APIKeyConfig) error {
+	if key.ID == "" {
+		return fmt.Errorf("auth key id is required")
+	}
+	if strings.Contains(key.ID, ".") {
+		return fmt.Errorf("auth key %s: id must not contain '.'", key.ID)
+	}
+	if key.TenantID == "" {
+		return fmt.Errorf("auth key %s: tenant_id is required", key.ID)
+	}
+	if _, ok := c.RateLimit.Tiers[key.Tier]; key.Tier != "" && !ok {
+		return fmt.Errorf("auth key %s: unknown rate limit tier %s", key.ID, key.Tier)
+	}
+	for _, route := range key.Routes {
+		if !c.hasRoute(route) {
+			return fmt.Errorf("auth key %s: unknown route %s", key.ID, route)
+		}
+	}
+	if _, err := parseCIDRs(key.AllowedCIDRs); err != nil {
+		return fmt.Errorf("auth key %s: %w", key.ID, err)
+	}
+	if err := validateCORSPolicy(&key.CORS); err != nil {
+		return fmt.Errorf("auth key %s: %w", key.ID, err)
+	}
+	return nil
+}
+
+// validateTLS 验证 TLS 配置
+func (c *Config) validateTLS() error {
+	t := &c.Server.TLS
+	if !t.Enabled {
+		return nil
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return fmt.Errorf("tls: cert_file and key_file are required")
+	}
+
+	switch t.ClientAuth {
+	case "", "none":
+	case "request", "require":
+		if t.ClientCAFile == "" {
+			return fmt.Errorf("tls: client_ca_file is required for client_auth %s", t.ClientAuth)
 		}
+	default:
+		return fmt.Errorf("tls: invalid client_auth %q (must be none, request or require)", t.ClientAuth)
 	}
 
+	for _, id := range t.Identities {
+		if id.ID == "" || id.TenantID == "" {
+			return fmt.Errorf("tls: client identity requires id and tenant_id")
+		}
+		if id.CommonName == "" && id.DNSName == "" && id.URI == "" {
+			return fmt.Errorf("tls: client identity %s needs common_name, dns_name or uri", id.ID)
+		}
+		if _, ok := c.RateLimit.Tiers[id.Tier]; id.Tier != "" && !ok {
+			return fmt.Errorf("tls: client identity %s: unknown rate limit tier %s", id.ID, id.Tier)
+		}
+		for _, route := range id.Routes {
+			if !c.hasRoute(route) {
+				return fmt.Errorf("tls: client identity %s: unknown route %s", id.ID, route)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSecretRef 验证路由的上游凭证引用
+func (c *Config) validateSecretRef(route *RouteConfig) error {
+	if route.AuthEnv != "" && route.AuthSecret != "" {
+		return fmt.Errorf("route %s: auth_env and auth_secret are mutually exclusive", route.Name)
+	}
+	if route.AuthSecret == "" {
+		return nil
+	}
+	scheme, _, err := parseSecretRef(route.AuthSecret)
+	if err != nil {
+		return fmt.Errorf("route %s: %w", route.Name, err)
+	}
+	if scheme == "vault" && c.Secrets.Vault.Addr == "" {
+		return fmt.Errorf("route %s: secrets.vault.addr is required for vault references", route.Name)
+	}
+	return nil
+}
+
+// validate 验证上游鉴权方式需要的字段
+func (a *UpstreamAuthConfig) validate() error {
+	switch a.Type {
+	case "", UpstreamAuthHeader, UpstreamAuthQuery:
+	case UpstreamAuthSigV4:
+		if a.Region == "" || a.Service == "" {
+			return fmt.Errorf("sigv4 requires region and service")
+		}
+	case UpstreamAuthAzureAD:
+		if a.ClientID == "" || len(a.Scopes) == 0 {
+			return fmt.Errorf("azure_ad requires client_id and scopes")
+		}
+		if a.TenantID == "" && a.TokenURL == "" {
+			return fmt.Errorf("azure_ad requires tenant_id or token_url")
+		}
+	case UpstreamAuthGoogleServiceAccount:
+		if len(a.Scopes) == 0 {
+			return fmt.Errorf("google_service_account requires scopes")
+		}
+	default:
+		return fmt.Errorf("invalid type %q (must be header, query, sigv4, azure_ad or google_service_account)", a.Type)
+	}
+	return nil
+}
+
+// validate 校验 TTS 配置，只有 raw 路由可以开启
+func (t *TTSConfig) validate(kind string) error {
+	if t.MaxParallel < 0 || t.MaxSegmentChars < 0 || t.MaxSegments < 0 {
+		return fmt.Errorf("max_parallel, max_segment_chars and max_segments must not be negative")
+	}
+	if t.Enabled && kind != "raw" {
+		return fmt.Errorf("enabled requires kind raw")
+	}
+	if t.Segment && !t.Enabled {
+		return fmt.Errorf("segment requires enabled")
+	}
+	if len(t.Voices) > 0 && !t.Enabled {
+		return fmt.Errorf("voices requires enabled")
+	}
+	for _, voice := range t.Voices {
+		if strings.TrimSpace(voice) == "" || strings.ContainsAny(voice, `<>&"`) {
+			return fmt.Errorf("invalid voice %q", voice)
+		}
+	}
+	if t.DefaultFormat != "" && !azureFormatPattern.MatchString(t.DefaultFormat) {
+		return fmt.Errorf("invalid default_format %q", t.DefaultFormat)
+	}
+	if t.Cache && !t.Enabled {
+		return fmt.Errorf("cache requires enabled")
+	}
+	return nil
+}
+
+// validateVoice 校验 voice 路由引用的 LLM 和 TTS 路由
+func (c *Config) validateVoice(route *RouteConfig) error {
+	voice := &route.Voice
+	if route.Kind != "voice" {
+		if voice.LLMRoute != "" || voice.TTSRoute != "" {
+			return fmt.Errorf("requires kind voice")
+		}
+		return nil
+	}
+
+	llm := c.GetRouteByName(voice.LLMRoute)
+	if llm == nil || llm.Kind != "sse" || llm.Provider != "" {
+		return fmt.Errorf("llm_route %q must name an sse route without provider", voice.LLMRoute)
+	}
+	tts := c.GetRouteByName(voice.TTSRoute)
+	if tts == nil || !tts.TTS.Enabled {
+		return fmt.Errorf("tts_route %q must name a raw route with tts enabled", voice.TTSRoute)
+	}
+	if !strings.Contains(voice.TTSBody, "{{text}}") {
+		return fmt.Errorf("tts_body must contain {{text}}")
+	}
+	return nil
+}
+
+// hasRoute 是否存在指定名称的路由
+func (c *Config) hasRoute(name string) bool {
+	for _, route := range c.Routes {
+		if route.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 验证限流配置
+func (c *RateLimitConfig) Validate() error {
+	if err := c.rule().validate("rate_limit"); err != nil {
+		return err
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("invalid rate_limit.queue_size: %d", c.QueueSize)
+	}
+	if c.MaxTenants < 0 {
+		return fmt.Errorf("invalid rate_limit.max_tenants: %d", c.MaxTenants)
+	}
+	for name, rule := range c.Routes {
+		if err := rule.validate("rate_limit.routes." + name); err != nil {
+			return err
+		}
+	}
+	for name, rule := range c.Tiers {
+		if err := rule.validate("rate_limit.tiers." + name); err != nil {
+			return err
+		}
+	}
+	for tenantID, tenant := range c.Tenants {
+		if err := tenant.validate("rate_limit.tenants." + tenantID); err != nil {
+			return err
+		}
+		for name, rule := range tenant.Routes {
+			if err := rule.validate("rate_limit.tenants." + tenantID + ".routes." + name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LimitScope 限流作用域：租户、API Key 的限流档位、路由
+type LimitScope struct {
+	TenantID string
+	Tier     string
+	Route    string
+}
+
+// key limiter 的 key：tenant[@route]
+// 档位只用于选择规则，不参与 key：同一租户使用不同档位的 Key 共用租户的桶，租户级额度不会按档位数成倍放大
+func (s LimitScope) key() string {
+	key := s.TenantID
+	if s.Route != "" {
+		key += "@" + s.Route
+	}
+	return key
+}
+
+// Resolve 计算生效规则，以及规范化后的作用域（决定 limiter 的 key）
+// 优先级（从高到低）：租户 + 路由 > 租户 > 路由 > 档位 > 全局默认
+// 命中路由级规则时该路由单独计数，否则清空 Route 由整个租户共用一个桶；未配置的档位视为无档位
+// 共用的桶按创建它的请求所在档位取额度，热更新时按同一档位重新计算
+func (c *RateLimitConfig) Resolve(scope LimitScope) (RateLimitRule, LimitScope) {
+	rule := c.rule()
+	routeScoped := false
+
+	if r, ok := c.Tiers[scope.Tier]; ok && scope.Tier != "" {
+		rule = rule.merge(r)
+	} else {
+		scope.Tier = ""
+	}
+	if r, ok := c.Routes[scope.Route]; ok {
+		rule = rule.merge(r)
+		routeScoped = true
+	}
+	if tenant, ok := c.Tenants[scope.TenantID]; ok {
+		rule = rule.merge(tenant.RateLimitRule)
+		if r, ok := tenant.Routes[scope.Route]; ok {
+			rule = rule.merge(r)
+			routeScoped = true
+		}
+	}
+
+	if !routeScoped {
+		scope.Route = ""
+	}
+	return rule, scope
+}
+
+// rule 全局默认规则
+func (c *RateLimitConfig) rule() RateLimitRule {
+	return RateLimitRule{
+		Default:              c.Default,
+		Burst:                c.Burst,
+		TokensPerMinute:      c.TokensPerMinute,
+		MaxConcurrentStreams: c.MaxConcurrentStreams,
+	}
+}
+
+// merge 用 override 中非零的字段覆盖当前规则
+func (r RateLimitRule) merge(override RateLimitRule) RateLimitRule {
+	if override.Default > 0 {
+		r.Default = override.Default
+	}
+	if override.Burst > 0 {
+		r.Burst = override.Burst
+	}
+	if override.TokensPerMinute > 0 {
+		r.TokensPerMinute = override.TokensPerMinute
+	}
+	if override.MaxConcurrentStreams > 0 {
+		r.MaxConcurrentStreams = override.MaxConcurrentStreams
+	}
+	return r
+}
+
+func (r RateLimitRule) validate(path string) error {
+	if r.Default < 0 {
+		return fmt.Errorf("invalid %s.default: %d", path, r.Default)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("invalid %s.burst: %d", path, r.Burst)
+	}
+	if r.TokensPerMinute < 0 {
+		return fmt.Errorf("invalid %s.tokens_per_minute: %d", path, r.TokensPerMinute)
+	}
+	if r.MaxConcurrentStreams < 0 {
+		return fmt.Errorf("invalid %s.max_concurrent_streams: %d", path, r.MaxConcurrentStreams)
+	}
 	return nil
 }
 
@@ -141,10 +829,25 @@ func (c *Config) GetRouteByPath(path string) *RouteConfig {
 	return nil
 }
 
-// GetAuthValue 获取认证值（从环境变量）
-func (r *RouteConfig) GetAuthValue() string {
+// GetRouteByName 根据路由名获取路由
+func (c *Config) GetRouteByName(name string) *RouteConfig {
+	for i := range c.Routes {
+		if c.Routes[i].Name == name {
+			return &c.Routes[i]
+		}
+	}
+	return nil
+}
+
+// SecretRef 上游凭证引用，auth_env 转换为 env:<name>，未配置时为空
+func (r *RouteConfig) SecretRef() string {
 	if r.AuthEnv != "" {
-		return os.Getenv(r.AuthEnv)
+		return "env:" + r.AuthEnv
 	}
-	return ""
+	return r.AuthSecret
+}
+
+// bedrock 是否为 AWS Bedrock 路由（event-stream 响应或 sigv4 service 为 bedrock），模型在路径中
+func (r *RouteConfig) bedrock() bool {
+	return r.Kind == "eventstream" || (r.UpstreamAuth.Type == UpstreamAuthSigV4 && r.UpstreamAuth.Service == "bedrock")
 }
@@ -3,6 +3,7 @@ package internal
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -29,6 +30,7 @@ rate_limit:
   burst: 20
 
 auth:
+  allow_plaintext_keys: true
   api_keys:
     - sk-test-key-123
 `
@@ -180,6 +182,324 @@ func TestConfig_Validate(t *testing.T) {
 			wantErr: true,
 			errMsg:  "invalid route kind",
 		},
+		{
+			name: "auth key without tenant",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{AllowPlaintextKeys: true, Keys: []APIKeyConfig{{ID: "k1", Key: "sk-1"}}},
+			},
+			wantErr: true,
+			errMsg:  "tenant_id is required",
+		},
+		{
+			name: "auth key duplicate value",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{
+					APIKeys:            []string{"sk-1"},
+					AllowPlaintextKeys: true,
+					Keys:               []APIKeyConfig{{ID: "k1", Key: "sk-1", TenantID: "acme"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "duplicate key value",
+		},
+		{
+			name: "plaintext auth key without opt-in",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{Keys: []APIKeyConfig{{ID: "k1", Key: "sk-1", TenantID: "acme"}}},
+			},
+			wantErr: true,
+			errMsg:  "plaintext key is not allowed",
+		},
+		{
+			name: "legacy api_keys without opt-in",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{APIKeys: []string{"sk-1"}},
+			},
+			wantErr: true,
+			errMsg:  "api_keys stores keys in plaintext",
+		},
+		{
+			name: "auth key with unknown route",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{AllowPlaintextKeys: true, Keys: []APIKeyConfig{{ID: "k1", Key: "sk-1", TenantID: "acme", Routes: []string{"missing"}}}},
+			},
+			wantErr: true,
+			errMsg:  "unknown route",
+		},
+		{
+			name: "auth key with unknown tier",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{AllowPlaintextKeys: true, Keys: []APIKeyConfig{{ID: "k1", Key: "sk-1", TenantID: "acme", Tier: "gold"}}},
+			},
+			wantErr: true,
+			errMsg:  "unknown rate limit tier",
+		},
+		{
+			name: "client identity with unknown tier",
+			config: Config{
+				Server: ServerConfig{Port: 8080, TLS: TLSConfig{
+					Enabled: true, CertFile: "server.pem", KeyFile: "server.key",
+					Identities: []ClientCertIdentity{{ID: "billing", TenantID: "acme", CommonName: "billing", Tier: "gold"}},
+				}},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "client identity billing: unknown rate limit tier gold",
+		},
+		{
+			name: "auth key with key and key_hash",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{AllowPlaintextKeys: true, Keys: []APIKeyConfig{{ID: "k1", Key: "sk-1", KeyHash: "sha256$c2FsdA$ZGlnZXN0", TenantID: "acme"}}},
+			},
+			wantErr: true,
+			errMsg:  "mutually exclusive",
+		},
+		{
+			name: "auth key with malformed key_hash",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{Keys: []APIKeyConfig{{ID: "k1", KeyHash: "plaintext", TenantID: "acme"}}},
+			},
+			wantErr: true,
+			errMsg:  "invalid key_hash",
+		},
+		{
+			name: "auth key with zero argon2 threads",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{Keys: []APIKeyConfig{{ID: "k1", KeyHash: "argon2id$v=19$m=19456,t=2,p=0$c2FsdA$ZGlnZXN0", TenantID: "acme"}}},
+			},
+			wantErr: true,
+			errMsg:  "invalid argon2 params",
+		},
+		{
+			name: "jwt without jwks",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{JWT: JWTConfig{Enabled: true, Issuer: "https://idp", Audience: "relay"}},
+			},
+			wantErr: true,
+			errMsg:  "jwks_url",
+		},
+		{
+			name: "auth key with invalid cidr",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth: AuthConfig{AllowPlaintextKeys: true, Keys: []APIKeyConfig{{ID: "k1", Key: "sk-1", TenantID: "acme", AllowedCIDRs: []string{"10.0.0.0/33"}}}},
+			},
+			wantErr: true,
+			errMsg:  "invalid cidr",
+		},
+		{
+			name: "invalid trusted proxy",
+			config: Config{
+				Server: ServerConfig{Port: 8080, ClientIP: ClientIPConfig{TrustedProxies: []string{"lb.internal"}}},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "trusted_proxies",
+		},
+		{
+			name: "credentials with short master key",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse"},
+				},
+				Auth:        AuthConfig{Admin: AdminConfig{Enabled: true}},
+				Credentials: CredentialsConfig{Enabled: true, MasterKey: "c2hvcnQ="},
+			},
+			wantErr: true,
+			errMsg:  "master_key",
+		},
+		{
+			name: "auth_env and auth_secret both set",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", AuthEnv: "KEY", AuthSecret: "file:/run/secrets/key"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "mutually exclusive",
+		},
+		{
+			name: "unknown secret provider",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", AuthSecret: "aws:openai"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "unknown secret provider",
+		},
+		{
+			name: "vault reference without vault addr",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", AuthSecret: "vault:secret/data/openai#api_key"},
+				},
+			},
+			wantErr: true,
+			errMsg:  "secrets.vault.addr",
+		},
+		{
+			name: "invalid upstream auth type",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", UpstreamAuth: UpstreamAuthConfig{Type: "basic"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "upstream_auth",
+		},
+		{
+			name: "sigv4 without region",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", UpstreamAuth: UpstreamAuthConfig{Type: UpstreamAuthSigV4, Service: "bedrock"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "region",
+		},
+		{
+			name: "openai response format without gemini provider",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", ResponseFormat: ResponseFormatOpenAI},
+				},
+			},
+			wantErr: true,
+			errMsg:  "response_format",
+		},
+		{
+			name: "multipart with sigv4",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "multipart", UpstreamAuth: UpstreamAuthConfig{Type: UpstreamAuthSigV4, Region: "us-east-1", Service: "transcribe"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "does not support sigv4",
+		},
+		{
+			name: "voice with unknown tts route",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "llm", Path: "/v1/chat/completions", Upstream: "https://example.com", Kind: "sse"},
+					{Name: "voice", Path: "/v1/voice", Kind: "voice", Voice: VoiceConfig{LLMRoute: "llm", TTSRoute: "tts", TTSBody: "{{text}}"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "tts_route",
+		},
+		{
+			name: "tts invalid default format",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "raw", TTS: TTSConfig{Enabled: true, Voices: []string{"en-US-JennyNeural"}, DefaultFormat: "mp3"}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "default_format",
+		},
+		{
+			name: "tts segment on sse route",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", TTS: TTSConfig{Segment: true}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "tts",
+		},
+		{
+			name: "require_tenant_credential without credentials",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "sse", AuthHeader: "Authorization", RequireTenantCredential: true},
+				},
+			},
+			wantErr: true,
+			errMsg:  "require_tenant_credential",
+		},
+		{
+			name: "tts segment without enabled",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "raw", TTS: TTSConfig{Segment: true}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "segment requires enabled",
+		},
+		{
+			name: "tts cache without tts_cache",
+			config: Config{
+				Server: ServerConfig{Port: 8080},
+				Routes: []RouteConfig{
+					{Name: "test", Path: "/test", Upstream: "https://example.com", Kind: "raw", TTS: TTSConfig{Enabled: true, Cache: true}},
+				},
+			},
+			wantErr: true,
+			errMsg:  "tts_cache",
+		},
 	}
 
 	for _, tt := range tests {
@@ -188,6 +508,8 @@ func TestConfig_Validate(t *testing.T) {
 			if tt.wantErr {
 				if err == nil {
 					t.Error("expected error but got nil")
+				} else if tt.errMsg != "" && !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("expected error containing %q, got %v", tt.errMsg, err)
 				}
 			} else {
 				if err != nil {
@@ -234,35 +556,84 @@ func TestConfig_GetRouteByPath(t *testing.T) {
 	}
 }
 
-func TestRouteConfig_GetAuthValue(t *testing.T) {
-	// Set up test env var
-	os.Setenv("TEST_AUTH_KEY", "sk-test-12345")
-	defer os.Unsetenv("TEST_AUTH_KEY")
-
-	route := &RouteConfig{
-		AuthEnv: "TEST_AUTH_KEY",
+func TestRouteConfig_SecretRef(t *testing.T) {
+	tests := []struct {
+		route    RouteConfig
+		expected string
+	}{
+		{RouteConfig{AuthEnv: "TEST_AUTH_KEY"}, "env:TEST_AUTH_KEY"},
+		{RouteConfig{AuthSecret: "file:/run/secrets/openai"}, "file:/run/secrets/openai"},
+		{RouteConfig{}, ""},
+	}
+	for _, tt := range tests {
+		if ref := tt.route.SecretRef(); ref != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, ref)
+		}
 	}
+}
 
-	value := route.GetAuthValue()
-	if value != "sk-test-12345" {
-		t.Errorf("expected 'sk-test-12345', got '%s'", value)
+func TestRateLimitConfig_Resolve(t *testing.T) {
+	cfg := &RateLimitConfig{
+		Enabled:         true,
+		Default:         100,
+		Burst:           20,
+		TokensPerMinute: 10000,
+		Routes: map[string]RateLimitRule{
+			"openai": {Default: 50},
+		},
+		Tiers: map[string]RateLimitRule{
+			"pro": {Default: 300},
+		},
+		Tenants: map[string]TenantRateLimit{
+			"batch": {
+				RateLimitRule: RateLimitRule{Default: 1000, Burst: 200},
+				Routes: map[string]RateLimitRule{
+					"anthropic": {TokensPerMinute: 500000},
+				},
+			},
+			"free": {
+				RateLimitRule: RateLimitRule{Default: 10, Burst: 2},
+			},
+		},
 	}
 
-	// Test with empty AuthEnv
-	route2 := &RouteConfig{
-		AuthEnv: "",
+	tests := []struct {
+		name    string
+		scope   LimitScope
+		want    RateLimitRule
+		wantKey string
+	}{
+		{"global default", LimitScope{TenantID: "acme", Route: "siliconflow"}, RateLimitRule{100, 20, 10000, 0}, "acme"},
+		{"route override", LimitScope{TenantID: "acme", Route: "openai"}, RateLimitRule{50, 20, 10000, 0}, "acme@openai"},
+		{"tenant override", LimitScope{TenantID: "batch", Route: "siliconflow"}, RateLimitRule{1000, 200, 10000, 0}, "batch"},
+		{"tenant beats route", LimitScope{TenantID: "free", Route: "openai"}, RateLimitRule{10, 2, 10000, 0}, "free@openai"},
+		{"tenant route override", LimitScope{TenantID: "batch", Route: "anthropic"}, RateLimitRule{1000, 200, 500000, 0}, "batch@anthropic"},
+		{"tier", LimitScope{TenantID: "acme", Tier: "pro", Route: "siliconflow"}, RateLimitRule{300, 20, 10000, 0}, "acme"},
+		{"route beats tier", LimitScope{TenantID: "acme", Tier: "pro", Route: "openai"}, RateLimitRule{50, 20, 10000, 0}, "acme@openai"},
+		{"unknown tier ignored", LimitScope{TenantID: "acme", Tier: "gold"}, RateLimitRule{100, 20, 10000, 0}, "acme"},
 	}
-	value2 := route2.GetAuthValue()
-	if value2 != "" {
-		t.Errorf("expected empty string, got '%s'", value2)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, scope := cfg.Resolve(tt.scope)
+			if rule != tt.want {
+				t.Errorf("expected rule %+v, got %+v", tt.want, rule)
+			}
+			if key := scope.key(); key != tt.wantKey {
+				t.Errorf("expected key %q, got %q", tt.wantKey, key)
+			}
+		})
 	}
+}
 
-	// Test with nonexistent env var
-	route3 := &RouteConfig{
-		AuthEnv: "NONEXISTENT_VAR",
+func TestRateLimitConfig_Validate(t *testing.T) {
+	cfg := &RateLimitConfig{
+		Default: 100,
+		Tenants: map[string]TenantRateLimit{
+			"bad": {RateLimitRule: RateLimitRule{Burst: -1}},
+		},
 	}
-	value3 := route3.GetAuthValue()
-	if value3 != "" {
-		t.Errorf("expected empty string for nonexistent var, got '%s'", value3)
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative tenant burst")
 	}
 }
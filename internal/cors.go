@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// defaultCORSMethods 未配置 allowed_methods 时允许的方法
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost}
+	// corsMethods allowed_methods 可以配置的方法
+	corsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	// defaultCORSHeaders 未配置 allowed_headers 时允许的请求头
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-Request-ID"}
+	// corsExposedHeaders 允许浏览器 JS 读取的响应头：请求 ID 和限流状态
+	corsExposedHeaders = strings.Join([]string{
+		"X-Request-ID",
+		"Retry-After",
+		"x-ratelimit-limit-requests", "x-ratelimit-remaining-requests", "x-ratelimit-reset-requests",
+		"x-ratelimit-limit-tokens", "x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy",
+	}, ", ")
+)
+
+// empty 是否没有配置任何来源
+func (p *CORSPolicy) empty() bool {
+	return p == nil || len(p.AllowedOrigins) == 0
+}
+
+// allowsOrigin 来源是否被允许，支持 "*" 和 "https://*.example.com" 形式的子域名通配
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	if p.empty() {
+		return false
+	}
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			rest, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(rest, "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// allowsMethod 方法是否被允许，不区分大小写
+func (p *CORSPolicy) allowsMethod(method string) bool {
+	method = strings.ToUpper(method)
+	return slices.ContainsFunc(p.AllowedMethods, func(m string) bool {
+		return strings.ToUpper(m) == method
+	})
+}
+
+// validateCORSPolicy 校验来源格式（必须是 "*" 或 scheme://host[:port]，不带路径）和方法
+func validateCORSPolicy(p *CORSPolicy) error {
+	for _, method := range p.AllowedMethods {
+		if !slices.Contains(corsMethods, strings.ToUpper(method)) {
+			return fmt.Errorf("invalid cors method %q", method)
+		}
+	}
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("invalid cors origin %q", origin)
+		}
+	}
+	return nil
+}
+
+// corsPolicies 跨域策略 - 优先级：Key > 租户 > 全局，方法和请求头未配置时继承全局
+type corsPolicies struct {
+	config  *CORSConfig
+	tenants map[string]*CORSPolicy
+	keys    *KeyManager
+}
+
+// newCORSPolicies 从配置创建
+func newCORSPolicies(config *Config, keys *KeyManager) *corsPolicies {
+	p := &corsPolicies{
+		config:  &config.Server.CORS,
+		tenants: make(map[string]*CORSPolicy),
+		keys:    keys,
+	}
+	for tenant, t := range config.Auth.Tenants {
+		if !t.CORS.empty() {
+			p.tenants[tenant] = &t.CORS
+		}
+	}
+	return p
+}
+
+// resolve 返回身份对应的策略
+func (p *corsPolicies) resolve(principal *Principal) *CORSPolicy {
+	policy := &p.config.CORSPolicy
+	if tenant := p.tenants[principal.TenantID]; tenant != nil {
+		policy = tenant
+	}
+	if !principal.CORS.empty() {
+		policy = principal.CORS
+	}
+	return &CORSPolicy{
+		AllowedOrigins: policy.AllowedOrigins,
+		AllowedMethods: firstNonEmpty(policy.AllowedMethods, p.config.AllowedMethods, defaultCORSMethods),
+		AllowedHeaders: firstNonEmpty(policy.AllowedHeaders, p.config.AllowedHeaders, defaultCORSHeaders),
+	}
+}
+
+// preflight 预检请求不带凭证，无法确定租户，按所有策略的并集应答
+// 返回 nil 表示没有任何策略允许该来源；真正的请求鉴权后再按具体策略检查
+func (p *corsPolicies) preflight(origin string) *CORSPolicy {
+	candidates := []*CORSPolicy{&p.config.CORSPolicy}
+	for _, t := range p.tenants {
+		candidates = append(candidates, t)
+	}
+	candidates = append(candidates, p.keys.CORSPolicies()...)
+
+	var union *CORSPolicy
+	for _, c := range candidates {
+		if !c.allowsOrigin(origin) {
+			continue
+		}
+		if union == nil {
+			union = &CORSPolicy{}
+		}
+		for _, m := range firstNonEmpty(c.AllowedMethods, p.config.AllowedMethods, defaultCORSMethods) {
+			if !union.allowsMethod(m) {
+				union.AllowedMethods = append(union.AllowedMethods, m)
+			}
+		}
+		for _, h := range firstNonEmpty(c.AllowedHeaders, p.config.AllowedHeaders, defaultCORSHeaders) {
+			if !slices.ContainsFunc(union.AllowedHeaders, func(v string) bool { return strings.EqualFold(v, h) }) {
+				union.AllowedHeaders = append(union.AllowedHeaders, h)
+			}
+		}
+	}
+	return union
+}
+
+// allowsHeaders 预检请求的 Access-Control-Request-Headers 是否都被允许
+func (p *CORSPolicy) allowsHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if !slices.ContainsFunc(p.AllowedHeaders, func(v string) bool { return v == "*" || strings.EqualFold(v, h) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// writePreflight 写入预检响应头
+func (p *CORSPolicy) writePreflight(h http.Header, origin string, maxAgeSeconds int) {
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Allow-Methods", strings.ToUpper(strings.Join(p.AllowedMethods, ", ")))
+	h.Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+	if maxAgeSeconds > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(maxAgeSeconds))
+	}
+}
+
+// writeCORSHeaders 写入实际请求的跨域响应头
+func writeCORSHeaders(h http.Header, origin string) {
+	h.Set("Access-Control-Allow-Origin", origin)
+	h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+}
+
+// firstNonEmpty 返回第一个非空列表
+func firstNonEmpty(lists ...[]string) []string {
+	for _, l := range lists {
+		if len(l) > 0 {
+			return l
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestCORSPolicy_AllowsOrigin(t *testing.T) {
+	p := &CORSPolicy{AllowedOrigins: []string{"https://app.example.com", "https://*.acme.io"}}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://evil.example.com", false},
+		{"https://web.acme.io", true},
+		{"https://a.b.acme.io", true},
+		{"https://acme.io", false},
+		{"https://evilacme.io", false},
+		{"http://web.acme.io", false},
+	}
+	for _, tt := range tests {
+		if got := p.allowsOrigin(tt.origin); got != tt.want {
+			t.Errorf("allowsOrigin(%s) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	if !(&CORSPolicy{AllowedOrigins: []string{"*"}}).allowsOrigin("https://anything.dev") {
+		t.Error("expected * to allow any origin")
+	}
+	if (&CORSPolicy{}).allowsOrigin("https://app.example.com") {
+		t.Error("expected empty policy to allow nothing")
+	}
+}
+
+func TestValidateCORSPolicy(t *testing.T) {
+	valid := []string{"*", "https://app.example.com", "http://localhost:3000", "https://*.example.com"}
+	if err := validateCORSPolicy(&CORSPolicy{AllowedOrigins: valid}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, origin := range []string{"app.example.com", "https://app.example.com/path", "https://app.example.com?x=1"} {
+		if err := validateCORSPolicy(&CORSPolicy{AllowedOrigins: []string{origin}}); err == nil {
+			t.Errorf("expected error for %q", origin)
+		}
+	}
+
+	if err := validateCORSPolicy(&CORSPolicy{AllowedMethods: []string{"GET", "post", "Delete"}}); err != nil {
+		t.Errorf("unexpected error for methods: %v", err)
+	}
+	for _, method := range []string{"", "POST ", "CONNECT", "FETCH"} {
+		if err := validateCORSPolicy(&CORSPolicy{AllowedMethods: []string{method}}); err == nil {
+			t.Errorf("expected error for method %q", method)
+		}
+	}
+}
+
+func TestCORSPolicies_Resolve(t *testing.T) {
+	cfg := &Config{
+		Server: ServerConfig{CORS: CORSConfig{
+			Enabled:    true,
+			CORSPolicy: CORSPolicy{AllowedOrigins: []string{"https://global.example.com"}, AllowedHeaders: []string{"Authorization"}},
+		}},
+		Auth: AuthConfig{Tenants: map[string]TenantAuthConfig{
+			"acme": {CORS: CORSPolicy{AllowedOrigins: []string{"https://acme.example.com"}, AllowedMethods: []string{"POST"}}},
+		}},
+	}
+	keys := NewKeyManager(cfg, newMemoryKeyStore())
+	t.Cleanup(keys.Stop)
+	policies := newCORSPolicies(cfg, keys)
+
+	// Tenant policy overrides origins and methods, headers inherit from global
+	p := policies.resolve(&Principal{TenantID: "acme"})
+	if !p.allowsOrigin("https://acme.example.com") || p.allowsOrigin("https://global.example.com") {
+		t.Errorf("expected tenant origins, got %v", p.AllowedOrigins)
+	}
+	if len(p.AllowedMethods) != 1 || p.AllowedMethods[0] != "POST" {
+		t.Errorf("expected tenant methods, got %v", p.AllowedMethods)
+	}
+	if len(p.AllowedHeaders) != 1 || p.AllowedHeaders[0] != "Authorization" {
+		t.Errorf("expected global headers, got %v", p.AllowedHeaders)
+	}
+
+	// Key policy overrides tenant
+	key := &CORSPolicy{AllowedOrigins: []string{"https://key.example.com"}}
+	if p := policies.resolve(&Principal{TenantID: "acme", CORS: key}); !p.allowsOrigin("https://key.example.com") {
+		t.Errorf("expected key origins, got %v", p.AllowedOrigins)
+	}
+
+	// Other tenants fall back to global with default methods
+	p = policies.resolve(&Principal{TenantID: "globex", CORS: &CORSPolicy{}})
+	if !p.allowsOrigin("https://global.example.com") || len(p.AllowedMethods) != len(defaultCORSMethods) {
+		t.Errorf("expected global policy, got %+v", p)
+	}
+
+	// Preflight answers from the union of all policies
+	if policies.preflight("https://acme.example.com") == nil || policies.preflight("https://global.example.com") == nil {
+		t.Error("expected preflight to allow configured origins")
+	}
+	if policies.preflight("https://evil.example.com") != nil {
+		t.Error("expected preflight to reject unknown origin")
+	}
+}
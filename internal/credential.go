@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// CredentialSourceTenant 使用租户自带的凭证
+	CredentialSourceTenant = "tenant"
+	// CredentialSourceRoute 使用路由配置的凭证（auth_env）
+	CredentialSourceRoute = "route"
+	// CredentialSourceNone 没有注入凭证
+	CredentialSourceNone = "none"
+)
+
+var (
+	// ErrInvalidCredentialRequest 保存凭证的参数不合法
+	ErrInvalidCredentialRequest = errors.New("invalid credential request")
+	// ErrTenantCredentialRequired 路由要求租户自带凭证（require_tenant_credential），但租户没有配置
+	ErrTenantCredentialRequired = errors.New("tenant credential required")
+	// ErrTenantCredentialUnavailable 租户配置了凭证但无法解密，不回退到路由凭证
+	ErrTenantCredentialUnavailable = errors.New("tenant credential unavailable")
+)
+
+// vaultEntry 解密后的凭证，解密失败时 err 不为空
+type vaultEntry struct {
+	secret string
+	err    error
+}
+
+// parseMasterKey 解析 base64 编码的 32 字节主密钥（AES-256）
+func parseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("master_key must be base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master_key must be 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// CredentialVault 租户自带的上游凭证（BYOK）
+// 凭证用主密钥 AES-GCM 加密后保存在 CredentialStore 中，"<tenant>/<route>" 作为附加数据，密文不能挪给其他租户或路由
+// 转发时只查内存中解密后的副本，收到变更通知或定期从存储刷新
+type CredentialVault struct {
+	store    CredentialStore
+	config   *Config
+	aead     cipher.AEAD
+	entries  atomic.Pointer[map[string]vaultEntry]
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+}
+
+// NewCredentialVault 创建凭证库并开始监听存储的变更通知
+func NewCredentialVault(config *Config, store CredentialStore) (*CredentialVault, error) {
+	key, err := parseMasterKey(config.Credentials.MasterKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+
+	v := &CredentialVault{store: store, config: config, aead: aead}
+	v.entries.Store(&map[string]vaultEntry{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+	go v.sync(ctx, store.Watch(ctx))
+	return v, nil
+}
+
+// Stop 停止监听
+func (v *CredentialVault) Stop() {
+	v.stopOnce.Do(v.cancel)
+}
+
+// Lookup 返回租户在该路由上的凭证
+// 没有配置时返回 ErrCredentialNotFound，配置了但无法解密时返回 ErrTenantCredentialUnavailable
+func (v *CredentialVault) Lookup(tenant, route string) (string, error) {
+	entry, ok := (*v.entries.Load())[credentialID(tenant, route)]
+	if !ok {
+		return "", ErrCredentialNotFound
+	}
+	if entry.err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTenantCredentialUnavailable, entry.err)
+	}
+	return entry.secret, nil
+}
+
+// Refresh 从存储加载并解密全部凭证
+// 解密失败的条目（例如主密钥已更换、密文被挪到其他租户）仍然保留，转发时报错而不是回退到路由凭证
+func (v *CredentialVault) Refresh(ctx context.Context) error {
+	creds, err := v.store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string]vaultEntry, len(creds))
+	for i := range creds {
+		secret, err := v.decrypt(&creds[i])
+		if err != nil {
+			slog.Warn("Failed to decrypt credential", "tenant", creds[i].TenantID, "route", creds[i].Route, "error", err)
+		}
+		entries[creds[i].id()] = vaultEntry{secret: secret, err: err}
+	}
+	v.entries.Store(&entries)
+	return nil
+}
+
+// sync 收到变更通知或定时从存储刷新
+func (v *CredentialVault) sync(ctx context.Context, changes <-chan struct{}) {
+	ticker := time.NewTicker(keyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				// 订阅失败，下次定时刷新时重新订阅
+				changes = nil
+			}
+		case <-ticker.C:
+			if changes == nil {
+				changes = v.store.Watch(ctx)
+			}
+		}
+
+		if err := v.Refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to refresh credentials", "error", err)
+		}
+	}
+}
+
+// Put 加密保存租户凭证，已存在时覆盖
+func (v *CredentialVault) Put(ctx context.Context, tenant, route, secret string) (*StoredCredential, error) {
+	if tenant == "" || secret == "" {
+		return nil, fmt.Errorf("%w: tenant and value are required", ErrInvalidCredentialRequest)
+	}
+	if strings.Contains(tenant, "/") {
+		return nil, fmt.Errorf("%w: tenant must not contain '/'", ErrInvalidCredentialRequest)
+	}
+	if !v.config.hasRoute(route) {
+		return nil, fmt.Errorf("%w: unknown route %s", ErrInvalidCredentialRequest, route)
+	}
+
+	now := time.Now().UTC()
+	cred := &StoredCredential{TenantID: tenant, Route: route, CreatedAt: now, UpdatedAt: now}
+	if existing, ok := v.existing(ctx, cred.id()); ok {
+		cred.CreatedAt = existing.CreatedAt
+	}
+
+	nonce := make([]byte, v.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := v.aead.Seal(nonce, nonce, []byte(secret), []byte(cred.id()))
+	cred.Ciphertext = base64.StdEncoding.EncodeToString(sealed)
+	if len(secret) >= 12 {
+		cred.Hint = secret[len(secret)-4:]
+	}
+
+	if err := v.store.Put(ctx, cred); err != nil {
+		return nil, err
+	}
+	return cred, v.Refresh(ctx)
+}
+
+// existing 返回已保存的凭证
+func (v *CredentialVault) existing(ctx context.Context, id string) (*StoredCredential, bool) {
+	creds, err := v.store.List(ctx)
+	if err != nil {
+		return nil, false
+	}
+	for i := range creds {
+		if creds[i].id() == id {
+			return &creds[i], true
+		}
+	}
+	return nil, false
+}
+
+// List 返回全部凭证
+func (v *CredentialVault) List(ctx context.Context) ([]StoredCredential, error) {
+	creds, err := v.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].id() < creds[j].id() })
+	return creds, nil
+}
+
+// Delete 删除租户凭证，之后该租户回退到路由配置的凭证
+func (v *CredentialVault) Delete(ctx context.Context, tenant, route string) error {
+	if err := v.store.Delete(ctx, tenant, route); err != nil {
+		return err
+	}
+	return v.Refresh(ctx)
+}
+
+// decrypt 解密凭证
+func (v *CredentialVault) decrypt(cred *StoredCredential) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(cred.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("decode ciphertext: %w", err)
+	}
+	if len(sealed) < v.aead.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:v.aead.NonceSize()], sealed[v.aead.NonceSize():]
+	plain, err := v.aead.Open(nil, nonce, ciphertext, []byte(cred.id()))
+	if err != nil {
+		return "", fmt.Errorf("decrypt: %w", err)
+	}
+	return string(plain), nil
+}
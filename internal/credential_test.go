@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testMasterKey 测试用主密钥
+var testMasterKey = base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+
+// newTestVault 创建测试用凭证库
+func newTestVault(t *testing.T, store CredentialStore, masterKey string) *CredentialVault {
+	t.Helper()
+
+	cfg := &Config{
+		Routes:      []RouteConfig{{Name: "openai"}, {Name: "anthropic"}},
+		Credentials: CredentialsConfig{Enabled: true, MasterKey: masterKey},
+	}
+	v, err := NewCredentialVault(cfg, store)
+	if err != nil {
+		t.Fatalf("NewCredentialVault: %v", err)
+	}
+	t.Cleanup(v.Stop)
+	return v
+}
+
+func TestCredentialVault_PutLookup(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryCredentialStore()
+	v := newTestVault(t, store, testMasterKey)
+
+	cred, err := v.Put(ctx, "acme", "openai", "sk-acme-openai-1234")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if cred.Hint != "1234" {
+		t.Errorf("expected hint 1234, got %q", cred.Hint)
+	}
+	if strings.Contains(cred.Ciphertext, "sk-acme") {
+		t.Error("ciphertext must not contain the plaintext")
+	}
+
+	if secret, err := v.Lookup("acme", "openai"); err != nil || secret != "sk-acme-openai-1234" {
+		t.Errorf("expected stored secret, got %q %v", secret, err)
+	}
+	if _, err := v.Lookup("acme", "anthropic"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected no credential for other route, got %v", err)
+	}
+	if _, err := v.Lookup("globex", "openai"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected no credential for other tenant, got %v", err)
+	}
+
+	// Replacing keeps CreatedAt
+	updated, err := v.Put(ctx, "acme", "openai", "sk-acme-openai-5678")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !updated.CreatedAt.Equal(cred.CreatedAt) {
+		t.Error("expected CreatedAt to be preserved on replace")
+	}
+
+	if err := v.Delete(ctx, "acme", "openai"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := v.Lookup("acme", "openai"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected credential to be gone after delete, got %v", err)
+	}
+	if err := v.Delete(ctx, "acme", "openai"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Errorf("expected ErrCredentialNotFound, got %v", err)
+	}
+}
+
+func TestCredentialVault_Validation(t *testing.T) {
+	v := newTestVault(t, newMemoryCredentialStore(), testMasterKey)
+
+	tests := []struct {
+		name   string
+		tenant string
+		route  string
+		value  string
+	}{
+		{"unknown route", "acme", "gemini", "sk-1"},
+		{"empty value", "acme", "openai", ""},
+		{"empty tenant", "", "openai", "sk-1"},
+		{"slash in tenant", "acme/x", "openai", "sk-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := v.Put(context.Background(), tt.tenant, tt.route, tt.value); !errors.Is(err, ErrInvalidCredentialRequest) {
+				t.Errorf("expected ErrInvalidCredentialRequest, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCredentialVault_CiphertextBinding(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryCredentialStore()
+	v := newTestVault(t, store, testMasterKey)
+
+	cred, err := v.Put(ctx, "acme", "openai", "sk-acme-openai-1234")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A ciphertext copied to another tenant does not decrypt
+	stolen := *cred
+	stolen.TenantID = "globex"
+	store.Put(ctx, &stolen)
+	if err := v.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	// 解密失败不当作没有配置，避免回退到路由凭证
+	if _, err := v.Lookup("globex", "openai"); !errors.Is(err, ErrTenantCredentialUnavailable) {
+		t.Errorf("expected moved ciphertext to be rejected, got %v", err)
+	}
+	if _, err := v.Lookup("acme", "openai"); err != nil {
+		t.Errorf("expected original credential to remain, got %v", err)
+	}
+
+	// A different master key cannot read existing credentials
+	other := newTestVault(t, store, base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32))))
+	if err := other.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, err := other.Lookup("acme", "openai"); !errors.Is(err, ErrTenantCredentialUnavailable) {
+		t.Errorf("expected credential to be unreadable with another master key, got %v", err)
+	}
+}
+
+func TestNewCredentialStore_RequiresRedis(t *testing.T) {
+	if _, err := NewCredentialStore(nil, false); !errors.Is(err, ErrCredentialStoreUnavailable) {
+		t.Errorf("expected ErrCredentialStoreUnavailable, got %v", err)
+	}
+	if store, err := NewCredentialStore(nil, true); err != nil || store == nil {
+		t.Errorf("expected memory store when allowed, got %v", err)
+	}
+}
+
+func TestParseMasterKey(t *testing.T) {
+	if _, err := parseMasterKey(testMasterKey); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, bad := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		if _, err := parseMasterKey(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
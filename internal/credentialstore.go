@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisCredentialsHash 保存租户上游凭证的 Redis hash，field 为 "<tenant>/<route>"，value 为 StoredCredential 的 JSON
+	redisCredentialsHash = "relay:credentials"
+	// redisCredentialsChannel 凭证变更通知的 pub/sub 频道
+	redisCredentialsChannel = "relay:credentials:changed"
+)
+
+var (
+	// ErrCredentialNotFound 凭证不存在
+	ErrCredentialNotFound = errors.New("credential not found")
+	// ErrCredentialStoreUnavailable 没有 Redis，且未允许使用内存存储
+	ErrCredentialStoreUnavailable = errors.New("credentials require redis (or credentials.allow_memory_store)")
+)
+
+// StoredCredential 租户自带的上游凭证，只保存密文
+type StoredCredential struct {
+	TenantID   string    `json:"tenant_id"`
+	Route      string    `json:"route"`
+	Ciphertext string    `json:"ciphertext"` // base64(nonce || AES-GCM 密文)
+	Hint       string    `json:"hint"`       // 明文末尾 4 位，便于核对（明文太短时为空）
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// id 存储中的 field
+func (c *StoredCredential) id() string {
+	return credentialID(c.TenantID, c.Route)
+}
+
+// credentialID 凭证按租户和路由索引
+func credentialID(tenant, route string) string {
+	return tenant + "/" + route
+}
+
+// CredentialStore 租户凭证的持久化存储
+// Put 和 Delete 成功后通知所有实例（包括自己）的 Watch
+type CredentialStore interface {
+	List(ctx context.Context) ([]StoredCredential, error)
+	Put(ctx context.Context, cred *StoredCredential) error
+	Delete(ctx context.Context, tenant, route string) error
+	// Watch 返回变更通知，ctx 结束后关闭
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// NewCredentialStore 有 Redis 时使用 Redis（多实例共享）
+// 没有 Redis 时只有 allowMemory 才退化为只在本实例生效、重启后丢失的内存存储
+func NewCredentialStore(storage *Storage, allowMemory bool) (CredentialStore, error) {
+	if storage != nil && storage.redis != nil {
+		return &redisCredentialStore{client: storage.redis}, nil
+	}
+	if !allowMemory {
+		return nil, ErrCredentialStoreUnavailable
+	}
+	return newMemoryCredentialStore(), nil
+}
+
+// redisCredentialStore 基于 Redis hash + pub/sub 的 CredentialStore
+type redisCredentialStore struct {
+	client *redis.Client
+}
+
+// List 返回全部凭证，无法解析的条目记录日志后跳过
+func (s *redisCredentialStore) List(ctx context.Context) ([]StoredCredential, error) {
+	values, err := s.client.HGetAll(ctx, redisCredentialsHash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list credentials: %w", err)
+	}
+
+	creds := make([]StoredCredential, 0, len(values))
+	for id, value := range values {
+		var cred StoredCredential
+		if err := json.Unmarshal([]byte(value), &cred); err != nil {
+			slog.Warn("Skipping undecodable credential", "id", id, "error", err)
+			continue
+		}
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+// Put 保存凭证并通知其他实例
+func (s *redisCredentialStore) Put(ctx context.Context, cred *StoredCredential) error {
+	value, err := json.Marshal(cred)
+	if err != nil {
+		return fmt.Errorf("encode credential %s: %w", cred.id(), err)
+	}
+	if err := s.client.HSet(ctx, redisCredentialsHash, cred.id(), value).Err(); err != nil {
+		return fmt.Errorf("save credential %s: %w", cred.id(), err)
+	}
+	return s.publish(ctx, cred.id())
+}
+
+// Delete 删除凭证并通知其他实例
+func (s *redisCredentialStore) Delete(ctx context.Context, tenant, route string) error {
+	id := credentialID(tenant, route)
+	n, err := s.client.HDel(ctx, redisCredentialsHash, id).Result()
+	if err != nil {
+		return fmt.Errorf("delete credential %s: %w", id, err)
+	}
+	if n == 0 {
+		return ErrCredentialNotFound
+	}
+	return s.publish(ctx, id)
+}
+
+// publish 发送变更通知
+func (s *redisCredentialStore) publish(ctx context.Context, id string) error {
+	if err := s.client.Publish(ctx, redisCredentialsChannel, id).Err(); err != nil {
+		return fmt.Errorf("publish credential change: %w", err)
+	}
+	return nil
+}
+
+// Watch 订阅变更通知
+func (s *redisCredentialStore) Watch(ctx context.Context) <-chan struct{} {
+	return watchRedis(ctx, s.client, redisCredentialsChannel)
+}
+
+// memoryCredentialStore 没有 Redis 时使用的内存存储，重启后丢失
+type memoryCredentialStore struct {
+	mu       sync.Mutex
+	creds    map[string]StoredCredential
+	watchers memoryWatchers
+}
+
+// newMemoryCredentialStore 创建内存存储
+func newMemoryCredentialStore() *memoryCredentialStore {
+	return &memoryCredentialStore{creds: make(map[string]StoredCredential)}
+}
+
+// List 返回全部凭证
+func (s *memoryCredentialStore) List(ctx context.Context) ([]StoredCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds := make([]StoredCredential, 0, len(s.creds))
+	for _, cred := range s.creds {
+		creds = append(creds, cred)
+	}
+	return creds, nil
+}
+
+// Put 保存凭证
+func (s *memoryCredentialStore) Put(ctx context.Context, cred *StoredCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.creds[cred.id()] = *cred
+	s.watchers.notify()
+	return nil
+}
+
+// Delete 删除凭证
+func (s *memoryCredentialStore) Delete(ctx context.Context, tenant, route string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := credentialID(tenant, route)
+	if _, ok := s.creds[id]; !ok {
+		return ErrCredentialNotFound
+	}
+	delete(s.creds, id)
+	s.watchers.notify()
+	return nil
+}
+
+// Watch 返回变更通知
+func (s *memoryCredentialStore) Watch(ctx context.Context) <-chan struct{} {
+	return s.watchers.add(ctx)
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	// eventStreamPreludeSize total_length + headers_length + prelude_crc
+	eventStreamPreludeSize = 12
+	// eventStreamMinMessageSize 没有头和负载的消息：prelude + message_crc
+	eventStreamMinMessageSize = eventStreamPreludeSize + 4
+	// maxEventStreamMessageSize 单条消息的最大字节数
+	maxEventStreamMessageSize = 16 << 20
+)
+
+// ErrInvalidEventStream event-stream 帧格式错误或 CRC 校验失败
+var ErrInvalidEventStream = errors.New("invalid event stream")
+
+// bedrockModel 从 Bedrock 路径中解析模型，例如 /model/anthropic.claude-3-haiku-20240307-v1:0/invoke -> anthropic.claude-3-haiku-20240307-v1:0
+// path 是转义后的路径，模型 ARN 中的 "/" 以 %2F 出现在同一段内
+func bedrockModel(path string) string {
+	_, rest, ok := strings.Cut(path, "/model/")
+	if !ok {
+		return ""
+	}
+	segment, _, _ := strings.Cut(rest, "/")
+	model, err := url.PathUnescape(segment)
+	if err != nil {
+		return ""
+	}
+	return model
+}
+
+// eventStreamMessage application/vnd.amazon.eventstream 的一条消息，只保留字符串类型的头
+type eventStreamMessage struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// eventStreamDecoder 解码 AWS event-stream 二进制帧
+// 帧格式：total_length(4) headers_length(4) prelude_crc(4) headers payload message_crc(4)，整数均为大端
+type eventStreamDecoder struct {
+	r io.Reader
+}
+
+// newEventStreamDecoder 创建解码器
+func newEventStreamDecoder(r io.Reader) *eventStreamDecoder {
+	return &eventStreamDecoder{r: r}
+}
+
+// Next 读取下一条消息，流正常结束时返回 io.EOF
+func (d *eventStreamDecoder) Next() (*eventStreamMessage, error) {
+	prelude := make([]byte, eventStreamPreludeSize)
+	if _, err := io.ReadFull(d.r, prelude); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("%w: truncated prelude", ErrInvalidEventStream)
+		}
+		return nil, err
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, fmt.Errorf("%w: prelude crc mismatch", ErrInvalidEventStream)
+	}
+	if totalLength < eventStreamMinMessageSize || totalLength > maxEventStreamMessageSize ||
+		headersLength > totalLength-eventStreamMinMessageSize {
+		return nil, fmt.Errorf("%w: invalid lengths %d/%d", ErrInvalidEventStream, totalLength, headersLength)
+	}
+
+	message := make([]byte, totalLength)
+	copy(message, prelude)
+	if _, err := io.ReadFull(d.r, message[eventStreamPreludeSize:]); err != nil {
+		return nil, fmt.Errorf("%w: truncated message: %w", ErrInvalidEventStream, err)
+	}
+	crcOffset := totalLength - 4
+	if crc32.ChecksumIEEE(message[:crcOffset]) != binary.BigEndian.Uint32(message[crcOffset:]) {
+		return nil, fmt.Errorf("%w: message crc mismatch", ErrInvalidEventStream)
+	}
+
+	headersEnd := eventStreamPreludeSize + headersLength
+	headers, err := parseEventStreamHeaders(message[eventStreamPreludeSize:headersEnd])
+	if err != nil {
+		return nil, err
+	}
+	return &eventStreamMessage{Headers: headers, Payload: message[headersEnd:crcOffset]}, nil
+}
+
+// eventStreamValueSizes 定长头值的字节数，按类型编号索引
+// 0 true, 1 false, 2 byte, 3 int16, 4 int32, 5 int64, 8 timestamp, 9 uuid；6 bytes 和 7 string 为变长
+var eventStreamValueSizes = map[byte]int{0: 0, 1: 0, 2: 1, 3: 2, 4: 4, 5: 8, 8: 8, 9: 16}
+
+// parseEventStreamHeaders 解析头：name_length(1) name value_type(1) value
+func parseEventStreamHeaders(b []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	for len(b) > 0 {
+		nameLength := int(b[0])
+		if len(b) < 1+nameLength+1 {
+			return nil, fmt.Errorf("%w: truncated header", ErrInvalidEventStream)
+		}
+		name := string(b[1 : 1+nameLength])
+		valueType := b[1+nameLength]
+		b = b[2+nameLength:]
+
+		switch valueType {
+		case 6, 7:
+			if len(b) < 2 {
+				return nil, fmt.Errorf("%w: truncated header %s", ErrInvalidEventStream, name)
+			}
+			n := int(binary.BigEndian.Uint16(b))
+			if len(b) < 2+n {
+				return nil, fmt.Errorf("%w: truncated header %s", ErrInvalidEventStream, name)
+			}
+			if valueType == 7 {
+				headers[name] = string(b[2 : 2+n])
+			}
+			b = b[2+n:]
+		default:
+			n, ok := eventStreamValueSizes[valueType]
+			if !ok || len(b) < n {
+				return nil, fmt.Errorf("%w: invalid header %s", ErrInvalidEventStream, name)
+			}
+			b = b[n:]
+		}
+	}
+	return headers, nil
+}
+
+// bedrockSSEData 把 event-stream 消息转换为 SSE 的 data 内容，不需要转发的消息返回 nil
+// chunk 事件的负载为 {"bytes":"<base64>"}，解码后就是模型原本的 JSON 事件；
+// exception 转换为 {"type":"<异常类型>","message":"..."}，同时作为错误返回
+func bedrockSSEData(msg *eventStreamMessage) ([]byte, error) {
+	switch msg.Headers[":message-type"] {
+	case "event":
+		if msg.Headers[":event-type"] != "chunk" {
+			return nil, nil
+		}
+		var chunk struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+			return nil, fmt.Errorf("%w: decode chunk: %w", ErrInvalidEventStream, err)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decode chunk bytes: %w", ErrInvalidEventStream, err)
+		}
+		// 保证 JSON 在一行内，SSE 的 data 不能跨行
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, decoded); err != nil {
+			return nil, fmt.Errorf("%w: chunk is not json: %w", ErrInvalidEventStream, err)
+		}
+		return compact.Bytes(), nil
+
+	case "exception":
+		var payload struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(msg.Payload, &payload) //nolint:errcheck // 负载不是 JSON 时只返回异常类型
+		exceptionType := msg.Headers[":exception-type"]
+		data, _ := json.Marshal(map[string]string{"type": exceptionType, "message": payload.Message})
+		return data, fmt.Errorf("%s: %s", exceptionType, payload.Message)
+
+	case "error":
+		code, message := msg.Headers[":error-code"], msg.Headers[":error-message"]
+		data, _ := json.Marshal(map[string]string{"type": code, "message": message})
+		return data, fmt.Errorf("%s: %s", code, message)
+
+	default:
+		return nil, nil
+	}
+}
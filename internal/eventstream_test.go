@@ -0,0 +1,239 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// encodeEventStream 按 AWS event-stream 格式编码一条消息，头均为字符串类型
+func encodeEventStream(headers map[string]string, payload []byte) []byte {
+	var h bytes.Buffer
+	for name, value := range headers {
+		h.WriteByte(byte(len(name)))
+		h.WriteString(name)
+		h.WriteByte(7)
+		binary.Write(&h, binary.BigEndian, uint16(len(value)))
+		h.WriteString(value)
+	}
+
+	total := uint32(eventStreamMinMessageSize + h.Len() + len(payload))
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.BigEndian, total)
+	binary.Write(&msg, binary.BigEndian, uint32(h.Len()))
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	msg.Write(h.Bytes())
+	msg.Write(payload)
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	return msg.Bytes()
+}
+
+// bedrockChunk 编码一个 Bedrock chunk 事件
+func bedrockChunk(event string) []byte {
+	payload := `{"bytes":"` + base64.StdEncoding.EncodeToString([]byte(event)) + `","p":"abcd"}`
+	return encodeEventStream(map[string]string{
+		":message-type": "event",
+		":event-type":   "chunk",
+		":content-type": "application/json",
+	}, []byte(payload))
+}
+
+func TestEventStreamDecoder(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(bedrockChunk(`{"type":"message_start"}`))
+	stream.Write(encodeEventStream(nil, nil))
+
+	d := newEventStreamDecoder(&stream)
+	msg, err := d.Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if msg.Headers[":event-type"] != "chunk" || !strings.Contains(string(msg.Payload), `"bytes"`) {
+		t.Errorf("unexpected message: %+v", msg)
+	}
+	if msg, err = d.Next(); err != nil || len(msg.Headers) != 0 || len(msg.Payload) != 0 {
+		t.Errorf("expected empty message, got %+v %v", msg, err)
+	}
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestEventStreamDecoder_NonStringHeaders(t *testing.T) {
+	headers := []byte{}
+	headers = append(headers, 4, 'f', 'l', 'a', 'g', 0)        // bool true
+	headers = append(headers, 3, 'n', 'u', 'm', 4, 0, 0, 0, 7) // int32
+	headers = append(headers, 2, 'i', 'd', 9)                  // uuid
+	headers = append(headers, make([]byte, 16)...)
+	headers = append(headers, 1, 'k', 7, 0, 2, 'o', 'k') // string
+
+	var msg bytes.Buffer
+	binary.Write(&msg, binary.BigEndian, uint32(eventStreamMinMessageSize+len(headers)))
+	binary.Write(&msg, binary.BigEndian, uint32(len(headers)))
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+	msg.Write(headers)
+	binary.Write(&msg, binary.BigEndian, crc32.ChecksumIEEE(msg.Bytes()))
+
+	got, err := newEventStreamDecoder(&msg).Next()
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if len(got.Headers) != 1 || got.Headers["k"] != "ok" {
+		t.Errorf("expected only the string header, got %v", got.Headers)
+	}
+}
+
+func TestEventStreamDecoder_Invalid(t *testing.T) {
+	valid := bedrockChunk(`{"type":"message_start"}`)
+
+	corrupt := func(offset int) []byte {
+		b := bytes.Clone(valid)
+		b[offset] ^= 0xff
+		return b
+	}
+
+	tests := []struct {
+		name   string
+		stream []byte
+		errMsg string
+	}{
+		{"prelude crc", corrupt(9), "prelude crc"},
+		{"message crc", corrupt(len(valid) - 10), "message crc"},
+		{"truncated prelude", valid[:6], "truncated prelude"},
+		{"truncated message", valid[:len(valid)-3], "truncated message"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newEventStreamDecoder(bytes.NewReader(tt.stream)).Next()
+			if !errors.Is(err, ErrInvalidEventStream) || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("expected %q error, got %v", tt.errMsg, err)
+			}
+		})
+	}
+}
+
+func TestBedrockModel(t *testing.T) {
+	tests := map[string]string{
+		"/model/anthropic.claude-3-haiku-20240307-v1:0/invoke-with-response-stream":                    "anthropic.claude-3-haiku-20240307-v1:0",
+		"/model/arn:aws:bedrock:us-east-1:123:inference-profile%2Fus.anthropic.claude/converse-stream": "arn:aws:bedrock:us-east-1:123:inference-profile/us.anthropic.claude",
+		"/model/":  "",
+		"/v1/chat": "",
+	}
+	for path, want := range tests {
+		if got := bedrockModel(path); got != want {
+			t.Errorf("bedrockModel(%s): expected %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestServer_BedrockModelScope(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(bedrockChunk(`{"type":"message_stop"}`))
+	})
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.Routes[0].Path = "/model/"
+		cfg.Routes[0].Kind = "eventstream"
+		cfg.RateLimit.Burst = 10
+		cfg.Auth.Keys = []APIKeyConfig{{ID: "haiku", Key: "sk-haiku", TenantID: "acme", Models: []string{"anthropic.claude-3-haiku*"}}}
+	})
+
+	// Bedrock 请求体中没有 model，模型只能从路径中取
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/model/anthropic.claude-3-haiku-20240307-v1:0/invoke-with-response-stream", http.StatusOK},
+		{"/model/anthropic.claude-3-opus-20240229-v1:0/invoke-with-response-stream", http.StatusForbidden},
+		{"/model/", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if rec := doRequest(s, http.MethodPost, tt.path, "sk-haiku", `{"messages":[]}`); rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d: %s", tt.path, tt.status, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestServer_BedrockEventStream(t *testing.T) {
+	var upstreamAccept string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAccept = r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(bedrockChunk(`{"type":"message_start","message":{"usage":{"input_tokens":9,"output_tokens":1}}}`))
+		w.Write(bedrockChunk("{\n  \"type\": \"content_block_delta\",\n  \"delta\": {\"text\": \"Hi\"}\n}"))
+		w.Write(bedrockChunk(`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":9,"outputTokenCount":4}}`))
+	})
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.Routes[0].Kind = "eventstream"
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1/chat", "sk-test", `{}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstreamAccept != "application/vnd.amazon.eventstream" {
+		t.Errorf("expected event-stream Accept header upstream, got %q", upstreamAccept)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream, got %q", ct)
+	}
+
+	expected := `data: {"type":"message_start","message":{"usage":{"input_tokens":9,"output_tokens":1}}}` + "\n\n" +
+		`data: {"type":"content_block_delta","delta":{"text":"Hi"}}` + "\n\n" +
+		`data: {"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":9,"outputTokenCount":4}}` + "\n\n"
+	if rec.Body.String() != expected {
+		t.Errorf("unexpected SSE body:\n%s", rec.Body.String())
+	}
+}
+
+func TestProxy_ForwardEventStream(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(bedrockChunk(`{"type":"message_start","message":{"usage":{"input_tokens":9,"output_tokens":1}}}`))
+	stream.Write(encodeEventStream(map[string]string{":message-type": "event", ":event-type": "metadata"}, []byte(`{}`)))
+	stream.Write(bedrockChunk(`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":9,"outputTokenCount":4}}`))
+
+	p := &Proxy{}
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.forwardEventStream(httptest.NewRecorder(), &stream, ctx); err != nil {
+		t.Fatalf("forwardEventStream: %v", err)
+	}
+	if ctx.TTFTMs == nil {
+		t.Error("expected TTFT to be recorded")
+	}
+	if ctx.ChunksCount != 2 {
+		t.Errorf("expected 2 chunks (non-chunk events skipped), got %d", ctx.ChunksCount)
+	}
+	usage := extractUsage(ctx.ResponseChunks)
+	if usage == nil || usage.InputTokens != 9 || usage.OutputTokens != 4 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+
+	// Exceptions become an SSE error event and end the stream
+	stream.Reset()
+	stream.Write(bedrockChunk(`{"type":"message_start"}`))
+	stream.Write(encodeEventStream(map[string]string{
+		":message-type":   "exception",
+		":exception-type": "throttlingException",
+	}, []byte(`{"message":"Too many requests"}`)))
+	stream.Write(bedrockChunk(`{"type":"never_sent"}`))
+
+	rec := httptest.NewRecorder()
+	ctx = NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.forwardEventStream(rec, &stream, ctx); err == nil {
+		t.Fatal("expected error for exception event")
+	}
+	if ctx.ErrorType != "upstream_error" || !strings.Contains(ctx.ErrorMessage, "throttlingException") {
+		t.Errorf("unexpected error recorded: %s %s", ctx.ErrorType, ctx.ErrorMessage)
+	}
+	if !strings.Contains(rec.Body.String(), "event: error\ndata: {\"message\":\"Too many requests\",\"type\":\"throttlingException\"}\n\n") ||
+		strings.Contains(rec.Body.String(), "never_sent") {
+		t.Errorf("unexpected body:\n%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// ProviderGemini Google Gemini（generativelanguage / Vertex AI 的 streamGenerateContent）
+	ProviderGemini = "gemini"
+	// ResponseFormatOpenAI 把上游的流转换为 OpenAI chat.completion.chunk
+	ResponseFormatOpenAI = "openai"
+)
+
+// geminiStreamMethod Gemini 的流式方法，需要 alt=sse 才返回 SSE（否则是一个 JSON 数组）
+const geminiStreamMethod = ":streamGenerateContent"
+
+// geminiUpstreamQuery 流式请求补上 alt=sse
+func geminiUpstreamQuery(path string, query url.Values) url.Values {
+	if strings.HasSuffix(path, geminiStreamMethod) && query.Get("alt") == "" {
+		query.Set("alt", "sse")
+	}
+	return query
+}
+
+// geminiModel 从路径中解析模型，例如 /v1beta/models/gemini-1.5-flash:streamGenerateContent -> gemini-1.5-flash
+func geminiModel(path string) string {
+	_, rest, ok := strings.Cut(path, "/models/")
+	if !ok {
+		return ""
+	}
+	model, _, _ := strings.Cut(rest, ":")
+	model, _, _ = strings.Cut(model, "/")
+	return model
+}
+
+// geminiChunk Gemini 流中的一个 JSON 事件
+type geminiChunk struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text    string `json:"text"`
+				Thought bool   `json:"thought"`
+			} `json:"parts"`
+		} `json:"content"`
+		FinishReason string `json:"finishReason"`
+		Index        int    `json:"index"`
+	} `json:"candidates"`
+	UsageMetadata *geminiUsage `json:"usageMetadata"`
+	ModelVersion  string       `json:"modelVersion"`
+}
+
+// geminiUsage usageMetadata，每个事件都带累计值
+type geminiUsage struct {
+	PromptTokenCount     int64 `json:"promptTokenCount"`
+	CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+	ThoughtsTokenCount   int64 `json:"thoughtsTokenCount"`
+}
+
+// outputTokens 输出 token 包含思考 token（同样计费）
+func (u *geminiUsage) outputTokens() int64 {
+	return u.CandidatesTokenCount + u.ThoughtsTokenCount
+}
+
+// geminiHasText 是否是带候选文本的事件（用于 TTFT，只有 role 或安全评级的事件不算）
+func geminiHasText(line string) bool {
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok || !strings.Contains(data, `"text"`) {
+		return false
+	}
+	var chunk geminiChunk
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+		return false
+	}
+	for _, candidate := range chunk.Candidates {
+		for _, part := range candidate.Content.Parts {
+			if part.Text != "" && !part.Thought {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// geminiFinishReasons Gemini finishReason 到 OpenAI finish_reason 的映射，其余视为 stop
+var geminiFinishReasons = map[string]string{
+	"STOP":               "stop",
+	"MAX_TOKENS":         "length",
+	"SAFETY":             "content_filter",
+	"RECITATION":         "content_filter",
+	"BLOCKLIST":          "content_filter",
+	"SPII":               "content_filter",
+	"PROHIBITED_CONTENT": "content_filter",
+}
+
+// openAIChunk OpenAI chat.completion.chunk
+type openAIChunk struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+type openAIChoice struct {
+	Index        int         `json:"index"`
+	Delta        openAIDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"`
+}
+
+type openAIDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+}
+
+// geminiTranslator 把 Gemini 的 SSE 流逐行转换为 OpenAI 格式
+// 第一个 delta 带 role，带 finishReason 的事件附上 usage，流结束后补 data: [DONE]
+type geminiTranslator struct {
+	id       string
+	model    string
+	created  int64
+	sentRole map[int]bool
+}
+
+// newGeminiTranslator 创建转换器，id 使用请求 ID
+func newGeminiTranslator(ctx *RequestContext) *geminiTranslator {
+	return &geminiTranslator{
+		id:       "chatcmpl-" + ctx.RequestID,
+		model:    ctx.Model,
+		created:  time.Now().Unix(),
+		sentRole: make(map[int]bool),
+	}
+}
+
+// translate 转换一行：data 行转换为 OpenAI chunk，空行（事件分隔）保留，其他行丢弃
+func (t *geminiTranslator) translate(line string) []string {
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok {
+		if line == "" {
+			return []string{line}
+		}
+		return nil
+	}
+
+	var chunk geminiChunk
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk); err != nil {
+		// 不是 Gemini 事件（例如错误信息），原样转发
+		return []string{line}
+	}
+	if t.model == "" {
+		t.model = chunk.ModelVersion
+	}
+
+	out := openAIChunk{ID: t.id, Object: "chat.completion.chunk", Created: t.created, Model: t.model, Choices: []openAIChoice{}}
+	finished := false
+	for _, candidate := range chunk.Candidates {
+		choice := openAIChoice{Index: candidate.Index}
+		if !t.sentRole[candidate.Index] {
+			choice.Delta.Role = "assistant"
+			t.sentRole[candidate.Index] = true
+		}
+		for _, part := range candidate.Content.Parts {
+			if !part.Thought {
+				choice.Delta.Content += part.Text
+			}
+		}
+		if candidate.FinishReason != "" {
+			reason, ok := geminiFinishReasons[candidate.FinishReason]
+			if !ok {
+				reason = "stop"
+			}
+			choice.FinishReason = &reason
+			finished = true
+		}
+		out.Choices = append(out.Choices, choice)
+	}
+	if finished && chunk.UsageMetadata != nil {
+		u := chunk.UsageMetadata
+		out.Usage = &openAIUsage{
+			PromptTokens:     u.PromptTokenCount,
+			CompletionTokens: u.outputTokens(),
+			TotalTokens:      u.PromptTokenCount + u.outputTokens(),
+		}
+	}
+
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		return []string{line}
+	}
+	return []string{"data: " + string(encoded)}
+}
+
+// done 流结束时补上 OpenAI 的结束标记
+func (t *geminiTranslator) done() []string {
+	return []string{"data: [DONE]", ""}
+}
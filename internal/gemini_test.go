@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// geminiStream Gemini alt=sse 响应，事件之间用 \r\n\r\n 分隔
+var geminiStream = []string{
+	`data: {"candidates":[{"content":{"parts":[{"text":"Hel"}],"role":"model"},"index":0}],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":1},"modelVersion":"gemini-1.5-flash-002"}`,
+	`data: {"candidates":[{"content":{"parts":[{"text":"lo"}],"role":"model"},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":2,"totalTokenCount":10},"modelVersion":"gemini-1.5-flash-002"}`,
+}
+
+// geminiUpstream 返回固定 Gemini 流的测试上游，记录收到的查询参数
+func geminiUpstream(query *url.Values) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*query = r.URL.Query()
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range geminiStream {
+			w.Write([]byte(event + "\r\n\r\n"))
+		}
+	})
+}
+
+func TestGeminiModel(t *testing.T) {
+	tests := map[string]string{
+		"/v1beta/models/gemini-1.5-flash:streamGenerateContent":                                                "gemini-1.5-flash",
+		"/v1/projects/p/locations/us-central1/publishers/google/models/gemini-2.0-flash:streamGenerateContent": "gemini-2.0-flash",
+		"/v1beta/models": "",
+		"/v1/chat":       "",
+	}
+	for path, want := range tests {
+		if got := geminiModel(path); got != want {
+			t.Errorf("geminiModel(%s): expected %q, got %q", path, want, got)
+		}
+	}
+}
+
+func TestGeminiHasText(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{geminiStream[0], true},
+		{`data: {"candidates":[{"content":{"role":"model"}}]}`, false},
+		{`data: {"candidates":[{"content":{"parts":[{"text":"thinking...","thought":true}]}}]}`, false},
+		{`data: {"promptFeedback":{"blockReason":"SAFETY"}}`, false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := geminiHasText(tt.line); got != tt.want {
+			t.Errorf("geminiHasText(%s): expected %v", tt.line, tt.want)
+		}
+	}
+}
+
+func TestServer_GeminiStream(t *testing.T) {
+	var query url.Values
+	t.Setenv("TEST_RELAY_GEMINI_KEY", "gm-secret")
+	s := newTestServer(t, geminiUpstream(&query), func(cfg *Config) {
+		cfg.Routes[0].Path = "/v1beta/models/"
+		cfg.Routes[0].Provider = ProviderGemini
+		cfg.Routes[0].AuthEnv = "TEST_RELAY_GEMINI_KEY"
+		cfg.RateLimit.Burst = 10
+		cfg.Auth.Keys = []APIKeyConfig{{ID: "flash", Key: "sk-flash", TenantID: "acme", Models: []string{"gemini-1.5-flash*"}}}
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1beta/models/gemini-1.5-flash:streamGenerateContent", "sk-flash", `{"contents":[]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if query.Get("alt") != "sse" || query.Get("key") != "gm-secret" {
+		t.Errorf("expected alt=sse and key query params, got %v", query)
+	}
+	if !strings.Contains(rec.Body.String(), `"text":"Hel"`) {
+		t.Errorf("expected Gemini chunks to pass through unchanged: %s", rec.Body.String())
+	}
+
+	// The model comes from the path for key restrictions
+	rec = doRequest(s, http.MethodPost, "/v1beta/models/gemini-1.5-pro:streamGenerateContent", "sk-flash", `{"contents":[]}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for disallowed model, got %d", rec.Code)
+	}
+	// 路径中没有模型时，限定模型的 Key 不能访问
+	rec = doRequest(s, http.MethodPost, "/v1beta/models/", "sk-flash", `{"contents":[]}`)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 when the path names no model, got %d", rec.Code)
+	}
+}
+
+func TestServer_GeminiOpenAIFormat(t *testing.T) {
+	var query url.Values
+	s := newTestServer(t, geminiUpstream(&query), func(cfg *Config) {
+		cfg.Routes[0].Path = "/v1beta/models/"
+		cfg.Routes[0].Provider = ProviderGemini
+		cfg.Routes[0].ResponseFormat = ResponseFormatOpenAI
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1beta/models/gemini-1.5-flash:streamGenerateContent?alt=sse", "sk-test", `{"contents":[]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var chunks []openAIChunk
+	var done bool
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatalf("invalid chunk %s: %v", data, err)
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) != 2 || !done {
+		t.Fatalf("expected 2 chunks and [DONE], got %d %v:\n%s", len(chunks), done, rec.Body.String())
+	}
+	first, last := chunks[0], chunks[1]
+	if first.Object != "chat.completion.chunk" || first.Model != "gemini-1.5-flash" || !strings.HasPrefix(first.ID, "chatcmpl-") {
+		t.Errorf("unexpected chunk envelope: %+v", first)
+	}
+	if first.Choices[0].Delta.Role != "assistant" || first.Choices[0].Delta.Content != "Hel" || first.Choices[0].FinishReason != nil {
+		t.Errorf("unexpected first choice: %+v", first.Choices[0])
+	}
+	if last.Choices[0].Delta.Role != "" || last.Choices[0].Delta.Content != "lo" {
+		t.Errorf("unexpected last delta: %+v", last.Choices[0].Delta)
+	}
+	if last.Choices[0].FinishReason == nil || *last.Choices[0].FinishReason != "stop" {
+		t.Errorf("expected finish_reason stop, got %v", last.Choices[0].FinishReason)
+	}
+	if last.Usage == nil || last.Usage.PromptTokens != 8 || last.Usage.CompletionTokens != 2 || last.Usage.TotalTokens != 10 {
+		t.Errorf("unexpected usage: %+v", last.Usage)
+	}
+	if first.Usage != nil {
+		t.Error("expected usage only on the final chunk")
+	}
+}
+
+func TestServer_GeminiOpenAIFormatUpstreamError(t *testing.T) {
+	errorBody := "{\n  \"error\": {\n    \"code\": 400,\n    \"message\": \"API key not valid.\",\n    \"status\": \"INVALID_ARGUMENT\"\n  }\n}\n"
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(errorBody))
+	}), func(cfg *Config) {
+		cfg.Routes[0].Path = "/v1beta/models/"
+		cfg.Routes[0].Provider = ProviderGemini
+		cfg.Routes[0].ResponseFormat = ResponseFormatOpenAI
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1beta/models/gemini-1.5-flash:streamGenerateContent?alt=sse", "sk-test", `{"contents":[]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec.Body.String() != errorBody {
+		t.Errorf("expected error body unchanged, got:\n%s", rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected upstream content type, got %q", ct)
+	}
+}
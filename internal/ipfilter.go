@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseCIDRs 解析 CIDR 列表，单个 IP 视为 /32（IPv6 为 /128）
+func parseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip %q: %w", v, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", v, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// mustParseCIDRs 解析已经校验过的 CIDR 列表，忽略错误
+func mustParseCIDRs(values []string) []netip.Prefix {
+	prefixes, _ := parseCIDRs(values)
+	return prefixes
+}
+
+// containsIP IP 是否在任一网段内，网段为空表示不限制
+func containsIP(prefixes []netip.Prefix, ip netip.Addr) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIPResolver 解析客户端真实 IP
+// 从直连地址开始，只要当前地址是可信代理（在 trusted_proxies 中，或仍在 trusted_hops 跳数内）
+// 就取 X-Forwarded-For 中它左边的一项，客户端自己伪造的 X-Forwarded-For 不会被采信
+type clientIPResolver struct {
+	proxies []netip.Prefix
+	hops    int
+}
+
+// newClientIPResolver 创建解析器
+func newClientIPResolver(config *ClientIPConfig) *clientIPResolver {
+	return &clientIPResolver{
+		proxies: mustParseCIDRs(config.TrustedProxies),
+		hops:    config.TrustedHops,
+	}
+}
+
+// resolve 返回客户端 IP，解析失败时返回无效地址
+func (r *clientIPResolver) resolve(req *http.Request) netip.Addr {
+	ip := parseHostIP(req.RemoteAddr)
+	if !ip.IsValid() || (len(r.proxies) == 0 && r.hops == 0) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		for _, part := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(part))
+		}
+	}
+
+	for hop := 0; len(forwarded) > 0; hop++ {
+		trusted := hop < r.hops || (len(r.proxies) > 0 && containsIP(r.proxies, ip))
+		if !trusted {
+			break
+		}
+		next, err := netip.ParseAddr(forwarded[len(forwarded)-1])
+		if err != nil {
+			break
+		}
+		ip = next.Unmap()
+		forwarded = forwarded[:len(forwarded)-1]
+	}
+	return ip
+}
+
+// parseHostIP 解析 host:port 中的 IP
+func parseHostIP(hostport string) netip.Addr {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := parseCIDRs([]string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32", "10.1.2.3/16"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.7/32", "2001:db8::/32", "10.1.0.0/16"}
+	for i, p := range prefixes {
+		if p.String() != want[i] {
+			t.Errorf("prefix %d: expected %s, got %s", i, want[i], p)
+		}
+	}
+
+	for _, bad := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0/8"} {
+		if _, err := parseCIDRs([]string{bad}); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestContainsIP(t *testing.T) {
+	prefixes := mustParseCIDRs([]string{"10.0.0.0/8", "2001:db8::/32"})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tt := range tests {
+		if got := containsIP(prefixes, netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("containsIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	if !containsIP(nil, netip.MustParseAddr("1.2.3.4")) {
+		t.Error("expected empty allowlist to allow everything")
+	}
+}
+
+func TestClientIPResolver(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     ClientIPConfig
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{
+			name:       "no trusted proxies ignores header",
+			remoteAddr: "203.0.113.9:4321",
+			forwarded:  []string{"1.2.3.4"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "trusted proxy",
+			config:     ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+			remoteAddr: "10.0.0.5:4321",
+			forwarded:  []string{"198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "untrusted peer cannot spoof",
+			config:     ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+			remoteAddr: "203.0.113.9:4321",
+			forwarded:  []string{"10.0.0.1"},
+			want:       "203.0.113.9",
+		},
+		{
+			name:       "client-supplied entries left of the first untrusted hop are ignored",
+			config:     ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}},
+			remoteAddr: "10.0.0.5:4321",
+			forwarded:  []string{"1.1.1.1, 198.51.100.7", "10.0.0.6"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "trusted hops",
+			config:     ClientIPConfig{TrustedHops: 1},
+			remoteAddr: "172.16.0.1:4321",
+			forwarded:  []string{"1.1.1.1, 198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "malformed entry stops the walk",
+			config:     ClientIPConfig{TrustedHops: 2},
+			remoteAddr: "172.16.0.1:4321",
+			forwarded:  []string{"198.51.100.7, garbage"},
+			want:       "172.16.0.1",
+		},
+		{
+			name:       "ipv4-mapped ipv6",
+			remoteAddr: "[::ffff:203.0.113.9]:4321",
+			want:       "203.0.113.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := newClientIPResolver(&tt.config).resolve(req); got.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
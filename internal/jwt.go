@@ -0,0 +1,422 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJWKSCacheTTL 未配置 cache_ttl 时 JWKS 的缓存时间
+	defaultJWKSCacheTTL = 10 * time.Minute
+	// jwksMinRefreshInterval 两次加载 JWKS 的最小间隔（无论成功与否），防止伪造的 kid 或宕机的 IdP 导致每个请求都去拉取
+	jwksMinRefreshInterval = 30 * time.Second
+	// maxJWKSSize JWKS 响应的最大字节数
+	maxJWKSSize = 1 << 20
+)
+
+// ErrInvalidToken JWT 校验失败
+var ErrInvalidToken = errors.New("invalid token")
+
+// isJWT 是否是 JWT（header.payload.signature）
+func isJWT(token string) bool {
+	return strings.HasPrefix(token, "eyJ") && strings.Count(token, ".") == 2
+}
+
+// JWTVerifier 校验 IdP 签发的 JWT 并映射为调用方身份
+// 签名公钥来自 JWKS 文件或 URL，按 cache_ttl 缓存，遇到未知 kid 时提前刷新
+// 加载在锁外进行，同一时刻只有一个请求加载，其余请求等待它的结果
+type JWTVerifier struct {
+	config *JWTConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time     // 最近一次加载成功的时间
+	attemptedAt time.Time     // 最近一次开始加载的时间
+	loadErr     error         // 最近一次加载的错误
+	loading     chan struct{} // 正在加载时不为 nil，加载结束后关闭
+}
+
+// NewJWTVerifier 创建 JWT 校验器，JWKS 在第一次校验时加载
+func NewJWTVerifier(config *JWTConfig, client *http.Client) *JWTVerifier {
+	return &JWTVerifier{
+		config: config,
+		client: client,
+	}
+}
+
+// jwtHeader JWT 头
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify 校验签名、issuer、audience 和有效期，返回 claims 映射出的身份
+func (v *JWTVerifier) Verify(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidToken, err)
+	}
+
+	key, err := v.key(header.Kid, now)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidToken, err)
+	}
+	if err := v.validateClaims(claims, now); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return v.principal(claims)
+}
+
+// validateClaims 校验 iss / aud / exp / nbf
+func (v *JWTVerifier) validateClaims(claims map[string]any, now time.Time) error {
+	if iss, _ := claims["iss"].(string); iss != v.config.Issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !slices.Contains(claimStrings(claims["aud"]), v.config.Audience) {
+		return errors.New("audience mismatch")
+	}
+
+	leeway := v.config.Leeway
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("missing exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token not yet valid")
+	}
+	return nil
+}
+
+// principal 按 claims 配置映射出租户、档位和访问范围
+// scope 中 "route:<name>" 限制路由，"model:<pattern>" 限制模型，其他值忽略
+func (v *JWTVerifier) principal(claims map[string]any) (*Principal, error) {
+	mapping := v.config.Claims
+	tenant, _ := claims[mapping.tenantClaim()].(string)
+	if tenant == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, mapping.tenantClaim())
+	}
+	subject, _ := claims["sub"].(string)
+	tier, _ := claims[mapping.tierClaim()].(string)
+
+	p := &Principal{
+		KeyID:    "jwt:" + subject,
+		Name:     subject,
+		TenantID: tenant,
+		Tier:     tier,
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		p.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	for _, scope := range claimStrings(claims[mapping.scopesClaim()]) {
+		if route, ok := strings.CutPrefix(scope, "route:"); ok {
+			p.Routes = append(p.Routes, route)
+		} else if model, ok := strings.CutPrefix(scope, "model:"); ok {
+			p.Models = append(p.Models, model)
+		}
+	}
+	return p, nil
+}
+
+// claimStrings 把字符串（空格分隔）或字符串数组形式的 claim 转换为切片
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// decodeSegment 解码 base64url 编码的 JSON
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// verifySignature 按 alg 校验签名，alg 必须与公钥类型匹配，不接受 none 和 HS*
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	switch alg {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type mismatch for %s", alg)
+		}
+		hash := shaForAlg(alg)
+		digest := hashBytes(hash, signed)
+		if strings.HasPrefix(alg, "PS") {
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+	case "ES256", "ES384", "ES512":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type mismatch for %s", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, hashBytes(shaForAlg(alg), signed), r, s) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case "EdDSA":
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("key type mismatch for %s", alg)
+		}
+		if !ed25519.Verify(pub, signed, signature) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported alg %q", alg)
+	}
+}
+
+// shaForAlg alg 对应的哈希算法
+func shaForAlg(alg string) crypto.Hash {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
+
+// hashBytes 计算摘要
+func hashBytes(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// key 返回 kid 对应的公钥，缓存过期或 kid 未知时重新加载 JWKS
+// 刷新失败时继续使用旧的公钥
+func (v *JWTVerifier) key(kid string, now time.Time) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	for v.loading != nil || v.needsLoad(kid, now) {
+		if v.loading == nil {
+			v.load(now)
+			break
+		}
+		// 其他请求正在加载，等它结束后按新的结果重新判断
+		loading := v.loading
+		v.mu.Unlock()
+		<-loading
+		v.mu.Lock()
+	}
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.keys == nil {
+		return nil, v.loadErr
+	}
+	return nil, fmt.Errorf("unknown kid %q", kid)
+}
+
+// needsLoad 是否需要加载 JWKS：还没有公钥、缓存过期或 kid 未知，且距上次加载超过最小间隔（调用方持有锁）
+func (v *JWTVerifier) needsLoad(kid string, now time.Time) bool {
+	if !v.attemptedAt.IsZero() && now.Sub(v.attemptedAt) < jwksMinRefreshInterval {
+		return false
+	}
+	ttl := v.config.CacheTTL
+	if ttl <= 0 {
+		ttl = defaultJWKSCacheTTL
+	}
+	_, ok := v.keys[kid]
+	return v.keys == nil || !ok || now.Sub(v.fetchedAt) > ttl
+}
+
+// load 在锁外加载 JWKS（调用方持有锁，返回时仍持有锁）
+func (v *JWTVerifier) load(now time.Time) {
+	loading := make(chan struct{})
+	v.loading = loading
+	v.attemptedAt = now
+	v.mu.Unlock()
+
+	keys, err := v.loadJWKS()
+
+	v.mu.Lock()
+	v.loadErr = err
+	if err == nil {
+		v.keys = keys
+		v.fetchedAt = now
+	}
+	v.loading = nil
+	close(loading)
+}
+
+// loadJWKS 从文件或 URL 加载 JWKS
+func (v *JWTVerifier) loadJWKS() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if v.config.JWKSFile != "" {
+		data, err = os.ReadFile(v.config.JWKSFile)
+	} else {
+		data, err = v.fetchJWKS()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load jwks: %w", err)
+	}
+	return parseJWKS(data)
+}
+
+// fetchJWKS 请求 JWKS URL
+func (v *JWTVerifier) fetchJWKS() ([]byte, error) {
+	resp, err := v.client.Get(v.config.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+// jwk JWKS 中的一个公钥
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS 解析 JWKS，跳过不支持或用于加密的公钥
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks contains no usable keys")
+	}
+	return keys, nil
+}
+
+// publicKey 转换为公钥
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+	}
+}
+
+// decodeBigInt 解码 base64url 编码的大整数
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid jwk integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
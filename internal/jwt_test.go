@@ -0,0 +1,299 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testIdP 测试用 IdP：签发 JWT 并通过 JWKS 暴露公钥
+type testIdP struct {
+	rsaKey  *rsa.PrivateKey
+	ecKey   *ecdsa.PrivateKey
+	jwks    atomic.Value // []byte
+	fetches atomic.Int32
+	server  *httptest.Server
+}
+
+// newTestIdP 创建测试 IdP
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &testIdP{rsaKey: rsaKey, ecKey: ecKey}
+	idp.setKeys(map[string]crypto.PublicKey{"rsa-1": &rsaKey.PublicKey, "ec-1": &ecKey.PublicKey})
+	idp.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.fetches.Add(1)
+		w.Write(idp.jwks.Load().([]byte))
+	}))
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// setKeys 替换 JWKS 中的公钥
+func (idp *testIdP) setKeys(keys map[string]crypto.PublicKey) {
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	enc := base64.RawURLEncoding
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "RSA", "kid": kid, "use": "sig",
+				"n": enc.EncodeToString(k.N.Bytes()),
+				"e": enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, map[string]string{
+				"kty": "EC", "kid": kid, "crv": "P-256",
+				"x": enc.EncodeToString(k.X.FillBytes(make([]byte, 32))),
+				"y": enc.EncodeToString(k.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+	data, _ := json.Marshal(set)
+	idp.jwks.Store(data)
+}
+
+// sign 签发 JWT
+func (idp *testIdP) sign(t *testing.T, alg, kid string, claims map[string]any) string {
+	t.Helper()
+
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	digest := hashBytes(crypto.SHA256, []byte(signed))
+
+	var sig []byte
+	var err error
+	switch alg {
+	case "RS256":
+		sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKey, crypto.SHA256, digest)
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, idp.ecKey, digest)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + enc.EncodeToString(sig)
+}
+
+// testClaims 合法的 claims
+func testClaims() map[string]any {
+	return map[string]any{
+		"iss":       "https://idp.example.com",
+		"aud":       []string{"stream-relay"},
+		"sub":       "svc-billing",
+		"exp":       time.Now().Add(time.Hour).Unix(),
+		"tenant_id": "acme",
+		"tier":      "pro",
+		"scope":     "openid route:chat model:gpt-4o*",
+	}
+}
+
+// newTestVerifier 创建指向测试 IdP 的校验器
+func newTestVerifier(idp *testIdP) *JWTVerifier {
+	return NewJWTVerifier(&JWTConfig{
+		Enabled:  true,
+		JWKSURL:  idp.server.URL,
+		Issuer:   "https://idp.example.com",
+		Audience: "stream-relay",
+	}, idp.server.Client())
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(idp)
+
+	for _, tc := range []struct{ alg, kid string }{{"RS256", "rsa-1"}, {"ES256", "ec-1"}} {
+		t.Run(tc.alg, func(t *testing.T) {
+			p, err := v.Verify(idp.sign(t, tc.alg, tc.kid, testClaims()), time.Now())
+			if err != nil {
+				t.Fatalf("verify failed: %v", err)
+			}
+			if p.TenantID != "acme" || p.Tier != "pro" || p.KeyID != "jwt:svc-billing" {
+				t.Errorf("unexpected principal: %+v", p)
+			}
+			if len(p.Routes) != 1 || p.Routes[0] != "chat" || len(p.Models) != 1 || p.Models[0] != "gpt-4o*" {
+				t.Errorf("unexpected scopes: routes=%v models=%v", p.Routes, p.Models)
+			}
+		})
+	}
+
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("expected JWKS to be cached, fetched %d times", n)
+	}
+}
+
+func TestJWTVerifier_Rejects(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(idp)
+
+	tests := []struct {
+		name   string
+		mutate func(map[string]any)
+	}{
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "other" }},
+		{"expired", func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{"missing exp", func(c map[string]any) { delete(c, "exp") }},
+		{"not yet valid", func(c map[string]any) { c["nbf"] = time.Now().Add(time.Hour).Unix() }},
+		{"missing tenant", func(c map[string]any) { delete(c, "tenant_id") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := testClaims()
+			tt.mutate(claims)
+			if _, err := v.Verify(idp.sign(t, "RS256", "rsa-1", claims), time.Now()); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+
+	token := idp.sign(t, "RS256", "rsa-1", testClaims())
+	parts := strings.Split(token, ".")
+
+	t.Run("tampered payload", func(t *testing.T) {
+		claims := testClaims()
+		claims["tenant_id"] = "other"
+		payload, _ := json.Marshal(claims)
+		forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		if _, err := v.Verify(forged, time.Now()); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("alg none", func(t *testing.T) {
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa-1"}`))
+		if _, err := v.Verify(header+"."+parts[1]+".", time.Now()); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+
+	t.Run("alg key mismatch", func(t *testing.T) {
+		if _, err := v.Verify(idp.sign(t, "ES256", "rsa-1", testClaims()), time.Now()); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+}
+
+func TestJWTVerifier_KeyRotation(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(idp)
+	now := time.Now()
+
+	if _, err := v.Verify(idp.sign(t, "RS256", "rsa-1", testClaims()), now); err != nil {
+		t.Fatalf("verify failed: %v", err)
+	}
+
+	// IdP publishes a new kid - picked up once the min refresh interval has passed
+	idp.setKeys(map[string]crypto.PublicKey{"rsa-2": &idp.rsaKey.PublicKey})
+	token := idp.sign(t, "RS256", "rsa-2", testClaims())
+	if _, err := v.Verify(token, now); err == nil {
+		t.Error("expected unknown kid to be rejected inside the refresh interval")
+	}
+	if _, err := v.Verify(token, now.Add(jwksMinRefreshInterval+time.Second)); err != nil {
+		t.Errorf("expected unknown kid to trigger a JWKS refresh: %v", err)
+	}
+}
+
+func TestJWTVerifier_SingleFetch(t *testing.T) {
+	idp := newTestIdP(t)
+	v := newTestVerifier(idp)
+	token := idp.sign(t, "RS256", "rsa-1", testClaims())
+
+	// 并发的第一次校验只加载一次 JWKS
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := v.Verify(token, time.Now()); err != nil {
+				t.Errorf("verify failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := idp.fetches.Load(); n != 1 {
+		t.Errorf("expected a single JWKS fetch, got %d", n)
+	}
+
+	// 未知 kid 在最小间隔内不重复拉取
+	now := time.Now().Add(jwksMinRefreshInterval + time.Second)
+	unknown := idp.sign(t, "RS256", "forged", testClaims())
+	for i := 0; i < 5; i++ {
+		v.Verify(unknown, now)
+	}
+	if n := idp.fetches.Load(); n != 2 {
+		t.Errorf("expected one refetch for unknown kids, got %d fetches", n)
+	}
+}
+
+func TestJWTVerifier_FailedFetchThrottled(t *testing.T) {
+	var fetches atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+	idp := newTestIdP(t)
+	v := NewJWTVerifier(&JWTConfig{Enabled: true, JWKSURL: down.URL, Issuer: "https://idp.example.com", Audience: "stream-relay"}, down.Client())
+
+	token := idp.sign(t, "RS256", "rsa-1", testClaims())
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := v.Verify(token, now); err == nil {
+			t.Fatal("expected verification to fail without a JWKS")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected failed loads to be throttled, got %d fetches", n)
+	}
+	v.Verify(token, now.Add(jwksMinRefreshInterval+time.Second))
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("expected a retry after the interval, got %d fetches", n)
+	}
+}
+
+func TestJWTVerifier_JWKSFile(t *testing.T) {
+	idp := newTestIdP(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	os.WriteFile(path, idp.jwks.Load().([]byte), 0o600)
+
+	v := NewJWTVerifier(&JWTConfig{
+		Enabled:  true,
+		JWKSFile: path,
+		Issuer:   "https://idp.example.com",
+		Audience: "stream-relay",
+	}, nil)
+	if _, err := v.Verify(idp.sign(t, "ES256", "ec-1", testClaims()), time.Now()); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
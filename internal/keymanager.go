@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// keyRefreshInterval 定期从存储全量刷新 Key 的间隔（pub/sub 通知丢失时兜底）
+	keyRefreshInterval = 30 * time.Second
+	// defaultRotateOverlap 轮换时旧 Key 默认继续有效的时长
+	defaultRotateOverlap = 24 * time.Hour
+)
+
+var (
+	// ErrKeyExists Key ID 已存在
+	ErrKeyExists = errors.New("api key already exists")
+	// ErrKeyReadOnly 配置文件中的 Key 不能通过管理 API 修改
+	ErrKeyReadOnly = errors.New("api key is managed in the config file")
+	// ErrInvalidKeyRequest 创建 Key 的参数不合法
+	ErrInvalidKeyRequest = errors.New("invalid api key request")
+)
+
+// KeyManager 管理 API Key 的生命周期 - 创建、轮换、吊销
+// 运行时 Key 保存在 KeyStore 中，鉴权只查内存中的 KeyRegistry，收到变更通知或定期从存储刷新
+type KeyManager struct {
+	registry *KeyRegistry
+	store    KeyStore
+	config   *Config
+	admin    []*keyHash
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+}
+
+// NewKeyManager 创建 Key 管理器并开始监听存储的变更通知
+func NewKeyManager(config *Config, store KeyStore) *KeyManager {
+	m := &KeyManager{
+		registry: NewKeyRegistry(&config.Auth),
+		store:    store,
+		config:   config,
+	}
+	for _, encoded := range config.Auth.Admin.KeyHashes {
+		if hash, err := parseKeyHash(encoded); err == nil {
+			m.admin = append(m.admin, hash)
+		}
+	}
+
+	// 先订阅再返回，之后的变更都不会错过
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go m.sync(ctx, store.Watch(ctx))
+	return m
+}
+
+// Stop 停止监听
+func (m *KeyManager) Stop() {
+	m.stopOnce.Do(m.cancel)
+}
+
+// Lookup 校验 Key 并返回对应的身份
+func (m *KeyManager) Lookup(token string, now time.Time) (*Principal, error) {
+	return m.registry.Lookup(token, now)
+}
+
+// Active Key ID 是否存在且未过期
+func (m *KeyManager) ActiveKey(id string, now time.Time) *APIKeyConfig {
+	return m.registry.ActiveKey(id, now)
+}
+
+// CORSPolicies 返回所有 Key 级跨域策略
+func (m *KeyManager) CORSPolicies() []*CORSPolicy {
+	return m.registry.CORSPolicies()
+}
+
+// IsAdmin 校验管理员 Token
+func (m *KeyManager) IsAdmin(token string) bool {
+	if token == "" {
+		return false
+	}
+	// 逐个比较，不提前返回，耗时与匹配的位置无关
+	ok := false
+	for _, hash := range m.admin {
+		if hash.verify(token) {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// Refresh 从存储加载全部运行时 Key
+func (m *KeyManager) Refresh(ctx context.Context) error {
+	keys, err := m.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	m.registry.Update(keys)
+	return nil
+}
+
+// sync 收到变更通知或定时从存储刷新
+func (m *KeyManager) sync(ctx context.Context, changes <-chan struct{}) {
+	ticker := time.NewTicker(keyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				// 订阅失败，下次定时刷新时重新订阅
+				changes = nil
+			}
+		case <-ticker.C:
+			if changes == nil {
+				changes = m.store.Watch(ctx)
+			}
+		}
+
+		if err := m.Refresh(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to refresh api keys", "error", err)
+		}
+	}
+}
+
+// Create 创建 Key，返回原始 Key（只在此时可见）
+// key.ID 为空时自动生成，key.Key / key.KeyHash 会被忽略
+func (m *KeyManager) Create(ctx context.Context, key APIKeyConfig) (string, *StoredKey, error) {
+	if key.ID == "" {
+		key.ID = "key-" + uuid.NewString()[:8]
+	}
+	if err := m.config.ValidateKey(&key); err != nil {
+		return "", nil, fmt.Errorf("%w: %w", ErrInvalidKeyRequest, err)
+	}
+	if m.configKey(key.ID) != nil {
+		return "", nil, ErrKeyExists
+	}
+
+	raw, hash, err := newKeySecret(key.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	key.Key = ""
+	key.KeyHash = hash
+
+	now := time.Now().UTC()
+	stored := &StoredKey{APIKeyConfig: key, CreatedAt: now, RotatedAt: now}
+	if err := m.store.Create(ctx, stored); err != nil {
+		return "", nil, err
+	}
+	return raw, stored, m.Refresh(ctx)
+}
+
+// List 返回全部 Key，配置文件中的在前
+func (m *KeyManager) List(ctx context.Context) ([]StoredKey, error) {
+	stored, err := m.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID < stored[j].ID })
+
+	keys := make([]StoredKey, 0, len(m.config.Auth.Keys)+len(stored))
+	for _, key := range m.config.Auth.Keys {
+		keys = append(keys, StoredKey{APIKeyConfig: key})
+	}
+	for _, key := range stored {
+		if m.configKey(key.ID) == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// Rotate 为 Key 生成新的密钥，旧密钥在 overlap 内继续有效，overlap 为 0 时立即失效
+func (m *KeyManager) Rotate(ctx context.Context, id string, overlap time.Duration) (string, *StoredKey, error) {
+	if m.configKey(id) != nil {
+		return "", nil, ErrKeyReadOnly
+	}
+
+	raw, hash, err := newKeySecret(id)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 新哈希和旧哈希的过期时间在一次原子写入中生效，并发的轮换或吊销不会被覆盖
+	stored, err := m.store.Update(ctx, id, func(stored *StoredKey) error {
+		now := time.Now().UTC()
+		previous := stored.Previous[:0]
+		for _, p := range stored.Previous {
+			if now.Before(p.ExpiresAt) {
+				previous = append(previous, p)
+			}
+		}
+		if overlap > 0 {
+			previous = append(previous, PreviousKey{KeyHash: stored.KeyHash, ExpiresAt: now.Add(overlap)})
+		}
+
+		stored.Previous = previous
+		stored.KeyHash = hash
+		stored.RotatedAt = now
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return raw, stored, m.Refresh(ctx)
+}
+
+// Revoke 吊销 Key，立即失效（包括轮换重叠期内的旧密钥）
+func (m *KeyManager) Revoke(ctx context.Context, id string) error {
+	if m.configKey(id) != nil {
+		return ErrKeyReadOnly
+	}
+	if err := m.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	return m.Refresh(ctx)
+}
+
+// configKey 返回配置文件中的 Key
+func (m *KeyManager) configKey(id string) *APIKeyConfig {
+	for i := range m.config.Auth.Keys {
+		if m.config.Auth.Keys[i].ID == id {
+			return &m.config.Auth.Keys[i]
+		}
+	}
+	return nil
+}
+
+// newKeySecret 生成原始 Key 和对应的哈希
+func newKeySecret(id string) (string, string, error) {
+	raw, err := GenerateAPIKey(id)
+	if err != nil {
+		return "", "", err
+	}
+	hash, err := HashAPIKey(raw, HashSHA256)
+	if err != nil {
+		return "", "", fmt.Errorf("hash key: %w", err)
+	}
+	return raw, hash, nil
+}
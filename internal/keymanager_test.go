@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestKeyManager 创建使用内存存储的 KeyManager
+func newTestKeyManager(t *testing.T, store KeyStore) *KeyManager {
+	t.Helper()
+
+	cfg := &Config{
+		Routes: []RouteConfig{{Name: "chat"}},
+		Auth: AuthConfig{
+			Keys: []APIKeyConfig{{ID: "static", Key: "sk-static", TenantID: "ops"}},
+		},
+	}
+	m := NewKeyManager(cfg, store)
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func TestKeyManager_Create(t *testing.T) {
+	m := newTestKeyManager(t, newMemoryKeyStore())
+	ctx := context.Background()
+
+	raw, key, err := m.Create(ctx, APIKeyConfig{ID: "acme-1", TenantID: "acme", Routes: []string{"chat"}})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if key.KeyHash == "" || key.KeyHash == raw {
+		t.Error("expected only the hash to be stored")
+	}
+
+	p, err := m.Lookup(raw, time.Now())
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if p.TenantID != "acme" || p.KeyID != "acme-1" {
+		t.Errorf("unexpected principal: %+v", p)
+	}
+
+	if _, _, err := m.Create(ctx, APIKeyConfig{ID: "acme-1", TenantID: "acme"}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("expected ErrKeyExists, got %v", err)
+	}
+	if _, _, err := m.Create(ctx, APIKeyConfig{ID: "static", TenantID: "acme"}); !errors.Is(err, ErrKeyExists) {
+		t.Errorf("expected ErrKeyExists for config key id, got %v", err)
+	}
+	if _, _, err := m.Create(ctx, APIKeyConfig{TenantID: "acme", Routes: []string{"missing"}}); !errors.Is(err, ErrInvalidKeyRequest) {
+		t.Errorf("expected ErrInvalidKeyRequest, got %v", err)
+	}
+
+	_, generated, err := m.Create(ctx, APIKeyConfig{TenantID: "acme"})
+	if err != nil || generated.ID == "" {
+		t.Errorf("expected generated id, got %q (%v)", generated.ID, err)
+	}
+}
+
+func TestKeyManager_CreateConcurrent(t *testing.T) {
+	m := newTestKeyManager(t, newMemoryKeyStore())
+	ctx := context.Background()
+
+	// 并发创建同一个 ID 时只有一个成功，成功返回的 Key 可以使用
+	var wg sync.WaitGroup
+	raws := make([]string, 8)
+	errs := make([]error, len(raws))
+	for i := range raws {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			raws[i], _, errs[i] = m.Create(ctx, APIKeyConfig{ID: "race", TenantID: "acme"})
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+			if _, err := m.Lookup(raws[i], time.Now()); err != nil {
+				t.Errorf("expected the created key to work: %v", err)
+			}
+		case !errors.Is(err, ErrKeyExists):
+			t.Errorf("expected ErrKeyExists, got %v", err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("expected exactly one create to succeed, got %d", created)
+	}
+}
+
+func TestKeyManager_Rotate(t *testing.T) {
+	m := newTestKeyManager(t, newMemoryKeyStore())
+	ctx := context.Background()
+
+	oldKey, _, _ := m.Create(ctx, APIKeyConfig{ID: "k1", TenantID: "acme"})
+	newKey, _, err := m.Rotate(ctx, "k1", time.Hour)
+	if err != nil {
+		t.Fatalf("rotate failed: %v", err)
+	}
+
+	now := time.Now()
+	if _, err := m.Lookup(newKey, now); err != nil {
+		t.Errorf("new key rejected: %v", err)
+	}
+	if _, err := m.Lookup(oldKey, now); err != nil {
+		t.Errorf("old key should stay valid during overlap: %v", err)
+	}
+	if _, err := m.Lookup(oldKey, now.Add(2*time.Hour)); err == nil {
+		t.Error("old key should expire after overlap")
+	}
+
+	// Rotating without overlap invalidates the current key immediately
+	newest, _, _ := m.Rotate(ctx, "k1", 0)
+	if _, err := m.Lookup(newKey, now); err == nil {
+		t.Error("expected key rotated without overlap to be rejected")
+	}
+	if _, err := m.Lookup(oldKey, now); err != nil {
+		t.Errorf("earlier overlap should still apply: %v", err)
+	}
+	if _, err := m.Lookup(newest, now); err != nil {
+		t.Errorf("newest key rejected: %v", err)
+	}
+
+	if _, _, err := m.Rotate(ctx, "static", time.Hour); !errors.Is(err, ErrKeyReadOnly) {
+		t.Errorf("expected ErrKeyReadOnly, got %v", err)
+	}
+	if _, _, err := m.Rotate(ctx, "missing", time.Hour); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestKeyManager_ConcurrentRotate(t *testing.T) {
+	m := newTestKeyManager(t, newMemoryKeyStore())
+	ctx := context.Background()
+
+	first, _, _ := m.Create(ctx, APIKeyConfig{ID: "k1", TenantID: "acme"})
+	keys := make([]string, 8)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keys[i], _, _ = m.Rotate(ctx, "k1", time.Hour)
+		}()
+	}
+	wg.Wait()
+
+	// 轮换是原子的读改写，每次轮换前的哈希都保留在重叠期内
+	for _, key := range append(keys, first) {
+		if _, err := m.Lookup(key, time.Now()); err != nil {
+			t.Errorf("expected every rotated key to stay valid during overlap: %v", err)
+		}
+	}
+}
+
+func TestKeyManager_Revoke(t *testing.T) {
+	m := newTestKeyManager(t, newMemoryKeyStore())
+	ctx := context.Background()
+
+	oldKey, _, _ := m.Create(ctx, APIKeyConfig{ID: "k1", TenantID: "acme"})
+	newKey, _, _ := m.Rotate(ctx, "k1", time.Hour)
+
+	if err := m.Revoke(ctx, "k1"); err != nil {
+		t.Fatalf("revoke failed: %v", err)
+	}
+	for _, key := range []string{oldKey, newKey} {
+		if _, err := m.Lookup(key, time.Now()); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("expected revoked key to be rejected, got %v", err)
+		}
+	}
+
+	if err := m.Revoke(ctx, "k1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := m.Revoke(ctx, "static"); !errors.Is(err, ErrKeyReadOnly) {
+		t.Errorf("expected ErrKeyReadOnly, got %v", err)
+	}
+}
+
+func TestKeyManager_SyncsAcrossInstances(t *testing.T) {
+	store := newMemoryKeyStore()
+	a := newTestKeyManager(t, store)
+	b := newTestKeyManager(t, store)
+
+	raw, _, err := a.Create(context.Background(), APIKeyConfig{ID: "k1", TenantID: "acme"})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := b.Lookup(raw, time.Now()); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("second instance did not pick up the new key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKeyManager_IsAdmin(t *testing.T) {
+	hash, _ := HashAPIKey("admin-secret", HashSHA256)
+	m := NewKeyManager(&Config{Auth: AuthConfig{Admin: AdminConfig{Enabled: true, KeyHashes: []string{hash}}}}, newMemoryKeyStore())
+	defer m.Stop()
+
+	if !m.IsAdmin("admin-secret") {
+		t.Error("expected admin token to be accepted")
+	}
+	if m.IsAdmin("wrong") || m.IsAdmin("") {
+		t.Error("expected invalid admin token to be rejected")
+	}
+}
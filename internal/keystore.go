@@ -0,0 +1,373 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisKeysHash 保存运行时 Key 的 Redis hash，field 为 Key ID，value 为 StoredKey 的 JSON
+	redisKeysHash = "relay:api_keys"
+	// redisKeysChannel Key 变更通知的 pub/sub 频道
+	redisKeysChannel = "relay:api_keys:changed"
+	// maxKeyUpdateRetries 并发修改同一个 Key 时乐观事务的重试次数
+	maxKeyUpdateRetries = 10
+)
+
+// ErrKeyNotFound Key 不存在
+var ErrKeyNotFound = errors.New("api key not found")
+
+// StoredKey 管理 API 创建的 Key，只保存哈希
+type StoredKey struct {
+	APIKeyConfig
+	CreatedAt time.Time     `json:"created_at"`
+	RotatedAt time.Time     `json:"rotated_at"`
+	Previous  []PreviousKey `json:"previous,omitempty"` // 轮换前的哈希，重叠期内仍然有效
+}
+
+// PreviousKey 轮换前的 Key 哈希
+type PreviousKey struct {
+	KeyHash   string    `json:"key_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// KeyStore 运行时 Key 的持久化存储
+// Create、Update 和 Delete 成功后通知所有实例（包括自己）的 Watch
+type KeyStore interface {
+	// List 返回全部 Key，无法解码的条目跳过并记录日志
+	List(ctx context.Context) ([]StoredKey, error)
+	Get(ctx context.Context, id string) (*StoredKey, error)
+	// Create 保存新 Key，ID 已存在时返回 ErrKeyExists，并发创建同一个 ID 时只有一个成功
+	Create(ctx context.Context, key *StoredKey) error
+	// Update 原子地读取、修改并保存 Key，期间 Key 被并发修改或删除时不会覆盖对方的结果
+	Update(ctx context.Context, id string, fn func(*StoredKey) error) (*StoredKey, error)
+	Delete(ctx context.Context, id string) error
+	// Watch 返回变更通知；ctx 结束或订阅失败时关闭，调用方之后可以重新订阅
+	Watch(ctx context.Context) <-chan struct{}
+}
+
+// NewKeyStore 有 Redis 时使用 Redis（多实例共享），否则退化为只在本实例生效的内存存储
+func NewKeyStore(storage *Storage) KeyStore {
+	if storage != nil && storage.redis != nil {
+		return &redisKeyStore{client: storage.redis}
+	}
+	return newMemoryKeyStore()
+}
+
+// redisKeyStore 基于 Redis hash + pub/sub 的 KeyStore
+type redisKeyStore struct {
+	client *redis.Client
+}
+
+// List 返回全部 Key
+func (s *redisKeyStore) List(ctx context.Context) ([]StoredKey, error) {
+	values, err := s.client.HGetAll(ctx, redisKeysHash).Result()
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+
+	keys := make([]StoredKey, 0, len(values))
+	for id, value := range values {
+		var key StoredKey
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			// 一条坏数据不能让所有运行时 Key 都无法刷新
+			slog.Warn("Skipping undecodable api key", "id", id, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Get 返回单个 Key
+func (s *redisKeyStore) Get(ctx context.Context, id string) (*StoredKey, error) {
+	value, err := s.client.HGet(ctx, redisKeysHash, id).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get key %s: %w", id, err)
+	}
+
+	var key StoredKey
+	if err := json.Unmarshal([]byte(value), &key); err != nil {
+		return nil, fmt.Errorf("decode key %s: %w", id, err)
+	}
+	return &key, nil
+}
+
+// Create 用 WATCH + MULTI 保存新 Key 并通知其他实例：ID 已存在时不写入，读取后 hash 被修改时重试
+func (s *redisKeyStore) Create(ctx context.Context, key *StoredKey) error {
+	value, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("encode key %s: %w", key.ID, err)
+	}
+	txf := func(tx *redis.Tx) error {
+		exists, err := tx.HExists(ctx, redisKeysHash, key.ID).Result()
+		if err != nil {
+			return fmt.Errorf("get key %s: %w", key.ID, err)
+		}
+		if exists {
+			return ErrKeyExists
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisKeysHash, key.ID, value)
+			pipe.Publish(ctx, redisKeysChannel, key.ID)
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < maxKeyUpdateRetries; i++ {
+		err := s.client.Watch(ctx, txf, redisKeysHash)
+		if !errors.Is(err, redis.TxFailedErr) {
+			if err != nil && !errors.Is(err, ErrKeyExists) {
+				return fmt.Errorf("save key %s: %w", key.ID, err)
+			}
+			return err
+		}
+	}
+	return fmt.Errorf("save key %s: too many concurrent modifications", key.ID)
+}
+
+// Update 用 WATCH + MULTI 实现的乐观事务：读取后 hash 被修改时重试
+// 新哈希和旧哈希的过期时间在同一次写入中生效，不会出现新 Key 已写入、旧 Key 还没设置过期的中间状态
+func (s *redisKeyStore) Update(ctx context.Context, id string, fn func(*StoredKey) error) (*StoredKey, error) {
+	var updated *StoredKey
+	txf := func(tx *redis.Tx) error {
+		value, err := tx.HGet(ctx, redisKeysHash, id).Result()
+		if errors.Is(err, redis.Nil) {
+			return ErrKeyNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("get key %s: %w", id, err)
+		}
+		var key StoredKey
+		if err := json.Unmarshal([]byte(value), &key); err != nil {
+			return fmt.Errorf("decode key %s: %w", id, err)
+		}
+		if err := fn(&key); err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(&key)
+		if err != nil {
+			return fmt.Errorf("encode key %s: %w", id, err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redisKeysHash, id, encoded)
+			pipe.Publish(ctx, redisKeysChannel, id)
+			return nil
+		})
+		updated = &key
+		return err
+	}
+
+	for i := 0; i < maxKeyUpdateRetries; i++ {
+		err := s.client.Watch(ctx, txf, redisKeysHash)
+		if !errors.Is(err, redis.TxFailedErr) {
+			if err != nil {
+				return nil, err
+			}
+			return updated, nil
+		}
+	}
+	return nil, fmt.Errorf("update key %s: too many concurrent modifications", id)
+}
+
+// Delete 删除 Key 并通知其他实例
+func (s *redisKeyStore) Delete(ctx context.Context, id string) error {
+	n, err := s.client.HDel(ctx, redisKeysHash, id).Result()
+	if err != nil {
+		return fmt.Errorf("delete key %s: %w", id, err)
+	}
+	if n == 0 {
+		return ErrKeyNotFound
+	}
+	return s.publish(ctx, id)
+}
+
+// publish 发送变更通知
+func (s *redisKeyStore) publish(ctx context.Context, id string) error {
+	if err := s.client.Publish(ctx, redisKeysChannel, id).Err(); err != nil {
+		return fmt.Errorf("publish key change: %w", err)
+	}
+	return nil
+}
+
+// Watch 订阅变更通知，连续的多条通知会合并为一次
+func (s *redisKeyStore) Watch(ctx context.Context) <-chan struct{} {
+	return watchRedis(ctx, s.client, redisKeysChannel)
+}
+
+// watchRedis 订阅 pub/sub 频道，连续的多条通知会合并为一次
+// 等到 Redis 确认订阅后才返回，之后发布的通知都不会错过；订阅失败时返回已关闭的 channel
+// 连接断开后 go-redis 会自动重连并重新订阅，但断开期间的通知会丢失，由调用方的定期全量刷新兜底
+func watchRedis(ctx context.Context, client *redis.Client, channel string) <-chan struct{} {
+	sub := client.Subscribe(ctx, channel)
+	changes := make(chan struct{}, 1)
+	if _, err := sub.Receive(ctx); err != nil {
+		if ctx.Err() == nil {
+			slog.Warn("Failed to subscribe to redis channel", "channel", channel, "error", err)
+		}
+		sub.Close()
+		close(changes)
+		return changes
+	}
+
+	go func() {
+		defer close(changes)
+		defer sub.Close()
+
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-messages:
+				if !ok {
+					return
+				}
+				notify(changes)
+			}
+		}
+	}()
+	return changes
+}
+
+// memoryKeyStore 没有 Redis 时使用的内存存储，重启后丢失
+type memoryKeyStore struct {
+	mu       sync.Mutex
+	keys     map[string]StoredKey
+	watchers memoryWatchers
+}
+
+// newMemoryKeyStore 创建内存存储
+func newMemoryKeyStore() *memoryKeyStore {
+	return &memoryKeyStore{keys: make(map[string]StoredKey)}
+}
+
+// List 返回全部 Key
+func (s *memoryKeyStore) List(ctx context.Context) ([]StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]StoredKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// Get 返回单个 Key
+func (s *memoryKeyStore) Get(ctx context.Context, id string) (*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return &key, nil
+}
+
+// Create 保存新 Key，ID 已存在时返回 ErrKeyExists
+func (s *memoryKeyStore) Create(ctx context.Context, key *StoredKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[key.ID]; ok {
+		return ErrKeyExists
+	}
+	s.keys[key.ID] = *key
+	s.watchers.notify()
+	return nil
+}
+
+// Update 在锁内读取、修改并保存 Key
+func (s *memoryKeyStore) Update(ctx context.Context, id string, fn func(*StoredKey) error) (*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	key.Previous = append([]PreviousKey(nil), key.Previous...)
+	if err := fn(&key); err != nil {
+		return nil, err
+	}
+	s.keys[id] = key
+	s.watchers.notify()
+	return &key, nil
+}
+
+// Delete 删除 Key
+func (s *memoryKeyStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.keys[id]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, id)
+	s.watchers.notify()
+	return nil
+}
+
+// Watch 返回变更通知
+func (s *memoryKeyStore) Watch(ctx context.Context) <-chan struct{} {
+	return s.watchers.add(ctx)
+}
+
+// memoryWatchers 内存存储的变更通知订阅者
+type memoryWatchers struct {
+	mu    sync.Mutex
+	chans []chan struct{}
+}
+
+// add 添加订阅者，ctx 结束后移除并关闭
+func (w *memoryWatchers) add(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	w.chans = append(w.chans, changes)
+	w.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for i, ch := range w.chans {
+			if ch == changes {
+				w.chans = append(w.chans[:i], w.chans[i+1:]...)
+				break
+			}
+		}
+		close(changes)
+	}()
+	return changes
+}
+
+// notify 通知所有订阅者
+func (w *memoryWatchers) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, ch := range w.chans {
+		notify(ch)
+	}
+}
+
+// notify 非阻塞发送，已有未处理的通知时直接丢弃
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -1,73 +1,592 @@
 package internal
 
 import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
+const (
+	// defaultQueueTimeout 未配置 queue_timeout 时的排队超时
+	defaultQueueTimeout = 30 * time.Second
+	// defaultIdleTimeout 未配置 idle_timeout 时的空闲淘汰时间
+	defaultIdleTimeout = 1 * time.Hour
+	// defaultMaxTenants 未配置 max_tenants 时最多保留的 limiter 数
+	defaultMaxTenants = 10000
+	// janitorInterval 空闲淘汰的检查间隔
+	janitorInterval = 1 * time.Minute
+)
+
 // RateLimiter 简单的限流器 - 基于 token bucket
+// 每个租户两个桶：请求数（RPM）和 token 数（TPM），外加并发流限制，额度按 RateLimitConfig.Resolve 取最具体的规则
+// limiter 按 LRU 保存，数量超过 max_tenants 时淘汰最久未使用的，后台 janitor 定期淘汰空闲超过 idle_timeout 的
+// 有进行中或排队中的流、TPM 透支未还清或正在获取并发名额的 limiter 不会被淘汰
 type RateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.RWMutex
+	limiters map[string]*tenantLimiter
+	lru      *list.List                // 最近使用的在前，元素为 limiter key
+	routes   map[string]*streamLimiter // 路由级并发限制（所有租户共享）
+	caps     map[string]int            // 热更新后的路由并发上限，未热更新过时使用路由配置
+	mu       sync.Mutex
 	config   *RateLimitConfig
+	metrics  *Metrics
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// RateLimitStatus 限流状态快照，用于生成 X-RateLimit / RateLimit 响应头
+type RateLimitStatus struct {
+	Limit      int           // 每分钟请求数
+	Remaining  int           // 桶中剩余请求数
+	Reset      time.Duration // 请求桶恢复满所需时间
+	RetryAfter time.Duration // 下一个请求可用前的等待时间，有余额时为 0
+
+	TokenLimit     int           // 每分钟 token 数，0 表示未限制
+	TokenRemaining int64         // 剩余 token 数（透支时为 0）
+	TokenReset     time.Duration // token 桶恢复满所需时间
 }
 
-// NewRateLimiter 创建限流器
-func NewRateLimiter(config *RateLimitConfig) *RateLimiter {
-	return &RateLimiter{
-		limiters: make(map[string]*rate.Limiter),
+// tenantLimiter 单个租户（或租户 + 路由）的限流状态
+type tenantLimiter struct {
+	scope    LimitScope
+	requests *rate.Limiter
+	tokens   *tokenBucket
+	streams  *streamLimiter
+	elem     *list.Element
+	lastSeen time.Time
+	pins     int // 正在获取并发名额的请求数，期间不能淘汰（受 RateLimiter.mu 保护）
+}
+
+// NewRateLimiter 创建限流器并启动 janitor，metrics 可以为 nil
+func NewRateLimiter(config *RateLimitConfig, metrics *Metrics) *RateLimiter {
+	rl := &RateLimiter{
+		limiters: make(map[string]*tenantLimiter),
+		lru:      list.New(),
+		routes:   make(map[string]*streamLimiter),
 		config:   config,
+		metrics:  metrics,
+		done:     make(chan struct{}),
 	}
+	go rl.janitor()
+	return rl
+}
+
+// Stop 停止 janitor
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() {
+		close(rl.done)
+	})
 }
 
 // Allow 检查是否允许请求
-func (rl *RateLimiter) Allow(tenantID string) bool {
-	if !rl.config.Enabled {
+func (rl *RateLimiter) Allow(scope LimitScope) bool {
+	limiter := rl.getLimiter(scope)
+	if limiter == nil {
 		return true
 	}
+	return limiter.requests.Allow()
+}
 
-	limiter := rl.getLimiter(tenantID)
-	return limiter.Allow()
+// Status 返回当前限流状态，限流关闭时返回 nil
+func (rl *RateLimiter) Status(scope LimitScope) *RateLimitStatus {
+	limiter := rl.getLimiter(scope)
+	if limiter == nil {
+		return nil
+	}
+
+	status := &RateLimitStatus{}
+	limiter.requestStatus(status)
+	limiter.tokens.status(status)
+	return status
 }
 
-// getLimiter 获取或创建 limiter
-func (rl *RateLimiter) getLimiter(tenantID string) *rate.Limiter {
-	rl.mu.RLock()
-	limiter, exists := rl.limiters[tenantID]
-	rl.mu.RUnlock()
+// ReserveTokens 按估算值预扣 TPM 配额，返回实际预扣的数量
+func (rl *RateLimiter) ReserveTokens(scope LimitScope, estimated int64) (int64, bool) {
+	limiter := rl.getLimiter(scope)
+	if limiter == nil {
+		return 0, true
+	}
+	return limiter.tokens.reserve(estimated)
+}
 
-	if exists {
-		return limiter
+// TokensRetryAfter 估算的 token 数可以预扣前需要等待的时间
+func (rl *RateLimiter) TokensRetryAfter(scope LimitScope, estimated int64) time.Duration {
+	limiter := rl.getLimiter(scope)
+	if limiter == nil {
+		return 0
+	}
+	return limiter.tokens.waitFor(estimated)
+}
+
+// ReconcileTokens 流结束后按实际用量对账：多用的补扣（可透支），少用的退还
+func (rl *RateLimiter) ReconcileTokens(scope LimitScope, reserved, actual int64) {
+	limiter := rl.getLimiter(scope)
+	if limiter == nil {
+		return
 	}
+	limiter.tokens.adjust(actual - reserved)
+}
 
+// Scopes 把 scope 展开到每个路由（voice 路由及其引用的路由），解析到同一个 limiter 的只保留第一个
+func (rl *RateLimiter) Scopes(scope LimitScope, routes ...string) []LimitScope {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	// 双重检查
-	if l, exists := rl.limiters[tenantID]; exists {
+	seen := make(map[string]bool, len(routes))
+	scopes := make([]LimitScope, 0, len(routes))
+	for _, route := range routes {
+		s := scope
+		s.Route = route
+		if _, resolved := rl.config.Resolve(s); !seen[resolved.key()] {
+			seen[resolved.key()] = true
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes
+}
+
+// AcquireStream 依次为每个路由获取并发流名额：先占租户名额，再占路由名额
+// 多个路由解析到同一个 limiter 时只占一个租户名额（voice 路由和它引用的路由）
+// 超过上限时在有界 FIFO 队列中等待，最长 queue_timeout；返回的 release 必须在流结束后调用
+func (rl *RateLimiter) AcquireStream(ctx context.Context, scope LimitScope, routes ...*RouteConfig) (func(), error) {
+	rl.mu.Lock()
+	timeout := rl.config.QueueTimeout
+	rl.mu.Unlock()
+	if timeout <= 0 {
+		timeout = defaultQueueTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var held []*streamLimiter
+	release := func() {
+		for _, l := range held {
+			l.release()
+		}
+	}
+
+	acquired := make(map[*tenantLimiter]bool, len(routes))
+	for _, route := range routes {
+		// 获取名额期间固定住 limiter，避免在拿到 limiter 和占用名额之间被淘汰，导致名额计在已删除的 limiter 上
+		routeScope := scope
+		routeScope.Route = route.Name
+		if limiter, unpin := rl.pinLimiter(routeScope); limiter != nil {
+			var err error
+			if !acquired[limiter] {
+				acquired[limiter] = true
+				if err = rl.acquire(ctx, limiter.streams, route.Name, "tenant"); err == nil {
+					held = append(held, limiter.streams)
+				}
+			}
+			unpin()
+			if err != nil {
+				release()
+				return nil, err
+			}
+		}
+
+		if routeStreams := rl.getRouteStreams(route); routeStreams != nil {
+			if err := rl.acquire(ctx, routeStreams, route.Name, "route"); err != nil {
+				release()
+				return nil, err
+			}
+			held = append(held, routeStreams)
+		}
+	}
+
+	return release, nil
+}
+
+// acquire 获取名额并记录排队指标
+func (rl *RateLimiter) acquire(ctx context.Context, l *streamLimiter, route, scope string) error {
+	start := time.Now()
+	queued, err := l.acquire(ctx, func() {
+		if rl.metrics != nil {
+			rl.metrics.RecordQueued(route, scope)
+		}
+	})
+
+	if rl.metrics != nil {
+		if queued {
+			rl.metrics.RecordDequeued(route, scope, time.Since(start))
+		}
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			rl.metrics.RecordError(route, "queue_full")
+		case errors.Is(err, ErrQueueTimeout):
+			rl.metrics.RecordError(route, "queue_timeout")
+		}
+	}
+
+	return err
+}
+
+// getRouteStreams 获取路由级并发限制，未配置时返回 nil
+// 已经创建过的始终返回（上限被热更新为 0 时不限制，但仍计数），保证进行中的流被正确计入
+func (rl *RateLimiter) getRouteStreams(route *RouteConfig) *streamLimiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if l, exists := rl.routes[route.Name]; exists {
 		return l
 	}
+	limit := route.MaxConcurrentStreams
+	if reloaded, ok := rl.caps[route.Name]; ok {
+		limit = reloaded
+	}
+	if limit <= 0 {
+		return nil
+	}
+	l := newStreamLimiter(limit, rl.config.QueueSize)
+	rl.routes[route.Name] = l
+	return l
+}
+
+// Reload 热更新限流配置和路由的并发上限
+// 已有的 limiter 原地调整速率，保留当前余额和进行中的流
+// 作用域发生变化的（例如删除了路由级规则）改挂到新的 key 下；新 key 已有 limiter 时合并进去，进行中的流和透支的 token 一并转移
+func (rl *RateLimiter) Reload(config *RateLimitConfig, routes []RouteConfig) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.config = config
+	keys := make([]string, 0, len(rl.limiters))
+	for key := range rl.limiters {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		limiter := rl.limiters[key]
+		if !config.Enabled {
+			rl.removeLocked(key, limiter)
+			continue
+		}
+		rule, scope := config.Resolve(limiter.scope)
+		newKey := scope.key()
+		if newKey == key {
+			limiter.apply(rule, config.QueueSize)
+			continue
+		}
+
+		rl.removeLocked(key, limiter)
+		if dst, exists := rl.limiters[newKey]; exists {
+			limiter.streams.migrateTo(dst.streams)
+			dst.tokens.adjust(limiter.tokens.debt())
+			continue
+		}
+		limiter.scope = scope
+		limiter.elem = rl.lru.PushFront(newKey)
+		rl.limiters[newKey] = limiter
+		limiter.apply(rule, config.QueueSize)
+	}
+
+	rl.caps = make(map[string]int, len(routes))
+	for _, route := range routes {
+		rl.caps[route.Name] = route.MaxConcurrentStreams
+	}
+	for name, l := range rl.routes {
+		l.setLimit(rl.caps[name], config.QueueSize)
+	}
+	rl.evictForCapacityLocked()
+	rl.recordTenantsLocked()
+}
+
+// getLimiter 获取或创建 limiter，限流关闭时返回 nil
+func (rl *RateLimiter) getLimiter(scope LimitScope) *tenantLimiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.getLimiterLocked(scope)
+}
+
+// pinLimiter 获取 limiter 并在 unpin 之前禁止淘汰，限流关闭时返回 nil
+func (rl *RateLimiter) pinLimiter(scope LimitScope) (*tenantLimiter, func()) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	limiter := rl.getLimiterLocked(scope)
+	if limiter == nil {
+		return nil, nil
+	}
+	limiter.pins++
+	return limiter, func() {
+		rl.mu.Lock()
+		defer rl.mu.Unlock()
+		limiter.pins--
+	}
+}
+
+// getLimiterLocked 获取或创建 limiter（调用方持有锁）
+func (rl *RateLimiter) getLimiterLocked(scope LimitScope) *tenantLimiter {
+	if !rl.config.Enabled {
+		return nil
+	}
+
+	rule, scope := rl.config.Resolve(scope)
+	key := scope.key()
+	if limiter, exists := rl.limiters[key]; exists {
+		limiter.lastSeen = time.Now()
+		rl.lru.MoveToFront(limiter.elem)
+		return limiter
+	}
 
-	// 创建新的 limiter
-	// rate.Limit 表示每秒的请求数
-	r := rate.Limit(float64(rl.config.Default) / 60.0) // 转换为每秒
-	limiter = rate.NewLimiter(r, rl.config.Burst)
-	rl.limiters[tenantID] = limiter
+	// 先腾出位置，保证 map 大小有上限
+	rl.evictForCapacityLocked()
 
-	// 定期清理（可选）
-	go rl.cleanup(tenantID)
+	limiter := &tenantLimiter{
+		scope:    scope,
+		requests: rate.NewLimiter(perSecond(rule.Default), rule.Burst),
+		tokens:   newTokenBucket(rule.TokensPerMinute),
+		streams:  newStreamLimiter(rule.MaxConcurrentStreams, rl.config.QueueSize),
+		elem:     rl.lru.PushFront(key),
+		lastSeen: time.Now(),
+	}
+	rl.limiters[key] = limiter
+	rl.recordTenantsLocked()
 
 	return limiter
 }
 
-// cleanup 清理不活跃的 limiter（避免内存泄漏）
-func (rl *RateLimiter) cleanup(tenantID string) {
-	time.Sleep(1 * time.Hour)
+// janitor 定期淘汰空闲的 limiter（整个 RateLimiter 只有一个 goroutine）
+func (rl *RateLimiter) janitor() {
+	ticker := time.NewTicker(janitorInterval)
+	defer ticker.Stop()
 
+	for {
+		select {
+		case <-rl.done:
+			return
+		case now := <-ticker.C:
+			rl.evictIdle(now)
+		}
+	}
+}
+
+// evictIdle 淘汰空闲超过 idle_timeout 的 limiter
+// TPM 透支未还清的不淘汰，否则空闲一段时间即可清掉欠账；请求桶的恢复时间通常远短于 idle_timeout
+func (rl *RateLimiter) evictIdle(now time.Time) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	delete(rl.limiters, tenantID)
+	idleTimeout := rl.config.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+
+	// 从最久未使用的一端开始，遇到未超时的即可停止
+	for elem := rl.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		key := elem.Value.(string)
+		limiter := rl.limiters[key]
+		if now.Sub(limiter.lastSeen) < idleTimeout {
+			break
+		}
+		if limiter.busy() {
+			// 长连接仍在进行或 TPM 仍在透支，视为活跃
+			limiter.lastSeen = now
+			rl.lru.MoveToFront(elem)
+		} else {
+			rl.removeLocked(key, limiter)
+			rl.recordEvictionLocked("idle")
+		}
+		elem = prev
+	}
+	rl.recordTenantsLocked()
+}
+
+// evictForCapacityLocked 数量达到 max_tenants 时淘汰最久未使用且空闲的 limiter（调用方持有锁）
+// 全部都在使用中（见 busy）时不淘汰，此时数量可能暂时超过 max_tenants
+func (rl *RateLimiter) evictForCapacityLocked() {
+	maxTenants := rl.config.MaxTenants
+	if maxTenants <= 0 {
+		maxTenants = defaultMaxTenants
+	}
+
+	for elem := rl.lru.Back(); elem != nil && len(rl.limiters) >= maxTenants; {
+		prev := elem.Prev()
+		key := elem.Value.(string)
+		if limiter := rl.limiters[key]; !limiter.busy() {
+			rl.removeLocked(key, limiter)
+			rl.recordEvictionLocked("capacity")
+		}
+		elem = prev
+	}
+}
+
+// removeLocked 删除 limiter（调用方持有锁）
+func (rl *RateLimiter) removeLocked(key string, limiter *tenantLimiter) {
+	rl.lru.Remove(limiter.elem)
+	delete(rl.limiters, key)
+}
+
+// recordEvictionLocked 记录淘汰（调用方持有锁）
+func (rl *RateLimiter) recordEvictionLocked(reason string) {
+	if rl.metrics != nil {
+		rl.metrics.RecordLimiterEviction(reason)
+	}
+}
+
+// recordTenantsLocked 记录当前 limiter 数（调用方持有锁）
+func (rl *RateLimiter) recordTenantsLocked() {
+	if rl.metrics != nil {
+		rl.metrics.SetLimiterTenants(len(rl.limiters))
+	}
+}
+
+// busy 是否不能淘汰：有进行中或排队中的流、TPM 透支未还清，或正在获取并发名额（调用方持有 RateLimiter.mu）
+func (l *tenantLimiter) busy() bool {
+	return l.pins > 0 || l.streams.inUse() || l.tokens.debt() > 0
+}
+
+// apply 原地更新速率、容量和并发上限
+func (l *tenantLimiter) apply(rule RateLimitRule, queueSize int) {
+	l.requests.SetLimit(perSecond(rule.Default))
+	l.requests.SetBurst(rule.Burst)
+	l.tokens.setLimit(rule.TokensPerMinute)
+	l.streams.setLimit(rule.MaxConcurrentStreams, queueSize)
+}
+
+// requestStatus 从请求桶计算剩余量和恢复时间
+func (l *tenantLimiter) requestStatus(status *RateLimitStatus) {
+	limit := l.requests.Limit()
+	burst := float64(l.requests.Burst())
+	tokens := math.Max(0, l.requests.Tokens())
+
+	status.Limit = int(math.Round(float64(limit) * 60))
+	status.Remaining = int(tokens)
+	if limit > 0 {
+		status.Reset = secondsDuration((burst - tokens) / float64(limit))
+		if tokens < 1 {
+			status.RetryAfter = secondsDuration((1 - tokens) / float64(limit))
+		}
+	}
+}
+
+// secondsDuration 秒数转换为 time.Duration
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(math.Max(0, seconds) * float64(time.Second))
+}
+
+// perSecond 每分钟配额转换为 rate.Limit（每秒）
+func perSecond(perMinute int) rate.Limit {
+	return rate.Limit(float64(perMinute) / 60.0)
+}
+
+// tokenBucket 允许透支的令牌桶
+// rate.Limiter 不支持退还，TPM 需要先预扣估算值、流结束后再按实际用量修正
+// capacity 为 0 表示不限制
+type tokenBucket struct {
+	mu       sync.Mutex
+	capacity float64
+	perSec   float64
+	tokens   float64
+	last     time.Time
+}
+
+// newTokenBucket 创建令牌桶，容量为一分钟的配额，初始为满
+func newTokenBucket(perMinute int) *tokenBucket {
+	b := &tokenBucket{last: time.Now()}
+	b.setLimit(perMinute)
+	return b
+}
+
+// setLimit 调整每分钟配额：从不限制变为限制时桶为满，否则余额不超过新容量
+func (b *tokenBucket) setLimit(perMinute int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	unlimited := b.capacity <= 0
+	b.capacity = float64(max(perMinute, 0))
+	b.perSec = b.capacity / 60.0
+	if unlimited {
+		b.tokens = b.capacity
+	} else {
+		b.tokens = math.Min(b.tokens, b.capacity)
+	}
+}
+
+// refill 按流逝时间补充令牌（调用方持有锁）
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.perSec)
+	}
+	b.last = now
+}
+
+// reserve 预扣 n 个令牌，返回实际预扣数量
+// 超过桶容量时按容量预扣，避免大请求永远无法通过；透支状态下拒绝
+func (b *tokenBucket) reserve(n int64) (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.capacity <= 0 {
+		return 0, true
+	}
+
+	b.refill(time.Now())
+	n = min(n, int64(b.capacity))
+	if b.tokens < float64(n) {
+		return 0, false
+	}
+	b.tokens -= float64(n)
+	return n, true
+}
+
+// status 填充 token 桶的剩余量和恢复时间，未限制时不填充
+func (b *tokenBucket) status(status *RateLimitStatus) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.capacity <= 0 {
+		return
+	}
+
+	b.refill(time.Now())
+	status.TokenLimit = int(b.capacity)
+	status.TokenRemaining = int64(math.Max(0, b.tokens))
+	status.TokenReset = secondsDuration((b.capacity - b.tokens) / b.perSec)
+}
+
+// debt 透支的 token 数，没有透支时为 0
+func (b *tokenBucket) debt() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.capacity <= 0 {
+		return 0
+	}
+
+	b.refill(time.Now())
+	return int64(math.Max(0, -b.tokens))
+}
+
+// waitFor 余额达到 n（按容量截断）所需的时间
+func (b *tokenBucket) waitFor(n int64) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.capacity <= 0 {
+		return 0
+	}
+
+	b.refill(time.Now())
+	need := math.Min(float64(n), b.capacity) - b.tokens
+	return secondsDuration(need / b.perSec)
+}
+
+// adjust 按差额修正余额：正数补扣（可透支为负数），负数退还（不超过容量）
+func (b *tokenBucket) adjust(delta int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.capacity <= 0 {
+		return
+	}
+
+	b.refill(time.Now())
+	b.tokens = math.Min(b.capacity, b.tokens-float64(delta))
 }
@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"context"
+	"fmt"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewRateLimiter(t *testing.T) {
@@ -12,7 +16,7 @@ func TestNewRateLimiter(t *testing.T) {
 		Burst:   10,
 	}
 
-	rl := NewRateLimiter(config)
+	rl := NewRateLimiter(config, nil)
 	if rl == nil {
 		t.Fatal("NewRateLimiter returned nil")
 	}
@@ -31,11 +35,11 @@ func TestRateLimiter_Allow_Disabled(t *testing.T) {
 		Burst:   1,
 	}
 
-	rl := NewRateLimiter(config)
+	rl := NewRateLimiter(config, nil)
 
 	// Should always allow when disabled
 	for i := 0; i < 100; i++ {
-		if !rl.Allow("tenant1") {
+		if !rl.Allow(LimitScope{TenantID: "tenant1"}) {
 			t.Error("should always allow when rate limiting is disabled")
 		}
 	}
@@ -48,12 +52,12 @@ func TestRateLimiter_Allow_Enabled(t *testing.T) {
 		Burst:   5,
 	}
 
-	rl := NewRateLimiter(config)
+	rl := NewRateLimiter(config, nil)
 
 	// First burst should be allowed
 	allowedCount := 0
 	for i := 0; i < 10; i++ {
-		if rl.Allow("tenant1") {
+		if rl.Allow(LimitScope{TenantID: "tenant1"}) {
 			allowedCount++
 		}
 	}
@@ -71,18 +75,18 @@ func TestRateLimiter_Allow_PerTenant(t *testing.T) {
 		Burst:   3,
 	}
 
-	rl := NewRateLimiter(config)
+	rl := NewRateLimiter(config, nil)
 
 	// Each tenant should have independent limits
 	// Exhaust tenant1's burst
 	for i := 0; i < 5; i++ {
-		rl.Allow("tenant1")
+		rl.Allow(LimitScope{TenantID: "tenant1"})
 	}
 
 	// tenant2 should still have full burst available
 	allowedCount := 0
 	for i := 0; i < 5; i++ {
-		if rl.Allow("tenant2") {
+		if rl.Allow(LimitScope{TenantID: "tenant2"}) {
 			allowedCount++
 		}
 	}
@@ -99,7 +103,7 @@ func TestRateLimiter_Concurrent(t *testing.T) {
 		Burst:   100,
 	}
 
-	rl := NewRateLimiter(config)
+	rl := NewRateLimiter(config, nil)
 
 	var wg sync.WaitGroup
 	var allowedCount int
@@ -111,7 +115,7 @@ func TestRateLimiter_Concurrent(t *testing.T) {
 		go func(tenantID string) {
 			defer wg.Done()
 			for j := 0; j < 20; j++ {
-				if rl.Allow(tenantID) {
+				if rl.Allow(LimitScope{TenantID: tenantID}) {
 					mu.Lock()
 					allowedCount++
 					mu.Unlock()
@@ -135,14 +139,454 @@ func TestRateLimiter_getLimiter_DoubleCheck(t *testing.T) {
 		Burst:   10,
 	}
 
-	rl := NewRateLimiter(config)
+	rl := NewRateLimiter(config, nil)
 
 	// Get limiter twice for same tenant
-	l1 := rl.getLimiter("tenant1")
-	l2 := rl.getLimiter("tenant1")
+	l1 := rl.getLimiter(LimitScope{TenantID: "tenant1"})
+	l2 := rl.getLimiter(LimitScope{TenantID: "tenant1"})
 
 	// Should return the same limiter instance
 	if l1 != l2 {
 		t.Error("getLimiter should return same instance for same tenant")
 	}
 }
+
+func TestRateLimiter_ReserveTokens_Disabled(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled: true,
+		Default: 60,
+		Burst:   10,
+	}
+
+	rl := NewRateLimiter(config, nil)
+
+	// TPM not configured - always allowed, nothing reserved
+	reserved, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 1_000_000)
+	if !ok {
+		t.Error("should allow when tokens_per_minute is not configured")
+	}
+	if reserved != 0 {
+		t.Errorf("expected 0 reserved, got %d", reserved)
+	}
+}
+
+func TestRateLimiter_ReserveTokens(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled:         true,
+		Default:         60,
+		Burst:           10,
+		TokensPerMinute: 1000,
+	}
+
+	rl := NewRateLimiter(config, nil)
+
+	if _, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 600); !ok {
+		t.Fatal("first reservation should be allowed")
+	}
+	if _, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 600); ok {
+		t.Error("second reservation should exceed TPM budget")
+	}
+
+	// Oversized estimates are clamped to the bucket capacity
+	reserved, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant2"}, 5000)
+	if !ok {
+		t.Fatal("oversized reservation on a full bucket should be allowed")
+	}
+	if reserved != 1000 {
+		t.Errorf("expected reservation clamped to 1000, got %d", reserved)
+	}
+}
+
+func TestRateLimiter_ReconcileTokens_Refund(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled:         true,
+		Default:         60,
+		Burst:           10,
+		TokensPerMinute: 1000,
+	}
+
+	rl := NewRateLimiter(config, nil)
+
+	reserved, _ := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 800)
+	rl.ReconcileTokens(LimitScope{TenantID: "tenant1"}, reserved, 100)
+
+	// 700 tokens were refunded, so another 800 fits
+	if _, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 800); !ok {
+		t.Error("unused tokens should be refunded after reconcile")
+	}
+}
+
+func TestRateLimiter_ReconcileTokens_Debt(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled:         true,
+		Default:         60,
+		Burst:           10,
+		TokensPerMinute: 1000,
+	}
+
+	rl := NewRateLimiter(config, nil)
+
+	reserved, _ := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 500)
+	rl.ReconcileTokens(LimitScope{TenantID: "tenant1"}, reserved, 1500)
+
+	// Actual usage exceeded the budget - tenant is in debt
+	if _, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 1); ok {
+		t.Error("tenant in debt should be throttled")
+	}
+
+	// Other tenants are unaffected
+	if _, ok := rl.ReserveTokens(LimitScope{TenantID: "tenant2"}, 500); !ok {
+		t.Error("tenant2 should have independent TPM budget")
+	}
+}
+
+func TestRateLimiter_TenantOverride(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled: true,
+		Default: 60,
+		Burst:   2,
+		Tenants: map[string]TenantRateLimit{
+			"batch": {RateLimitRule: RateLimitRule{Burst: 20}},
+		},
+	}
+
+	rl := NewRateLimiter(config, nil)
+
+	allowed := 0
+	for i := 0; i < 30; i++ {
+		if rl.Allow(LimitScope{TenantID: "batch"}) {
+			allowed++
+		}
+	}
+	if allowed < 19 || allowed > 21 {
+		t.Errorf("expected ~20 allowed for batch tenant, got %d", allowed)
+	}
+
+	allowed = 0
+	for i := 0; i < 30; i++ {
+		if rl.Allow(LimitScope{TenantID: "other"}) {
+			allowed++
+		}
+	}
+	if allowed < 1 || allowed > 3 {
+		t.Errorf("expected ~2 allowed for default tenant, got %d", allowed)
+	}
+}
+
+func TestRateLimiter_TenantSharedAcrossTiers(t *testing.T) {
+	config := &RateLimitConfig{
+		Enabled: true,
+		Default: 60,
+		Burst:   100,
+		Tiers: map[string]RateLimitRule{
+			"free": {Burst: 1},
+			"pro":  {Burst: 50},
+		},
+		Tenants: map[string]TenantRateLimit{
+			"acme": {RateLimitRule: RateLimitRule{Burst: 2}},
+		},
+	}
+
+	rl := NewRateLimiter(config, nil)
+	defer rl.Stop()
+
+	// 同一租户两个不同档位的 Key 共用租户的桶
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		tier := "free"
+		if i%2 == 1 {
+			tier = "pro"
+		}
+		if rl.Allow(LimitScope{TenantID: "acme", Tier: tier}) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("expected the tenant burst of 2 to be shared across tiers, got %d", allowed)
+	}
+	if rl.getLimiter(LimitScope{TenantID: "acme", Tier: "pro"}) != rl.getLimiter(LimitScope{TenantID: "acme", Tier: "free"}) {
+		t.Error("expected both tiers to resolve to one limiter")
+	}
+}
+
+func TestRateLimiter_Reload(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled: true,
+		Default: 60,
+		Burst:   5,
+		Routes: map[string]RateLimitRule{
+			"chat": {Default: 30},
+		},
+	}, nil)
+
+	before := rl.getLimiter(LimitScope{TenantID: "tenant1", Route: "other"})
+	rl.Allow(LimitScope{TenantID: "tenant2", Route: "chat"})
+
+	rl.Reload(&RateLimitConfig{
+		Enabled: true,
+		Default: 120,
+		Burst:   50,
+		Tenants: map[string]TenantRateLimit{
+			"tenant1": {RateLimitRule: RateLimitRule{Burst: 7}},
+		},
+	}, nil)
+
+	// Tenant-wide limiter keeps its identity and picks up the new rule
+	after := rl.getLimiter(LimitScope{TenantID: "tenant1", Route: "other"})
+	if after != before {
+		t.Error("reload should update existing limiter in place")
+	}
+	if after.requests.Burst() != 7 {
+		t.Errorf("expected burst 7 after reload, got %d", after.requests.Burst())
+	}
+	if after.requests.Limit() != perSecond(120) {
+		t.Errorf("expected limit %v after reload, got %v", perSecond(120), after.requests.Limit())
+	}
+
+	// The chat route rule was removed: its route-scoped limiter is dropped, not leaked
+	rl.mu.Lock()
+	_, stale := rl.limiters["tenant2@chat"]
+	rl.mu.Unlock()
+	if stale {
+		t.Error("limiter whose scope changed should be removed on reload")
+	}
+
+	// Disabling clears everything
+	rl.Reload(&RateLimitConfig{Enabled: false}, nil)
+	rl.mu.Lock()
+	remaining := len(rl.limiters)
+	rl.mu.Unlock()
+	if remaining != 0 {
+		t.Errorf("expected no limiters after disabling, got %d", remaining)
+	}
+	if !rl.Allow(LimitScope{TenantID: "tenant1", Route: "chat"}) {
+		t.Error("should allow when disabled by reload")
+	}
+}
+
+func TestRateLimiter_Status(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:         true,
+		Default:         60, // 1 per second
+		Burst:           2,
+		TokensPerMinute: 600,
+	}, nil)
+
+	rl.Allow(LimitScope{TenantID: "tenant1"})
+	rl.ReserveTokens(LimitScope{TenantID: "tenant1"}, 300)
+
+	status := rl.Status(LimitScope{TenantID: "tenant1"})
+	if status == nil {
+		t.Fatal("expected status when rate limiting is enabled")
+	}
+	if status.Limit != 60 {
+		t.Errorf("expected limit 60, got %d", status.Limit)
+	}
+	if status.Remaining != 1 {
+		t.Errorf("expected 1 remaining, got %d", status.Remaining)
+	}
+	if status.Reset <= 0 || status.Reset > time.Second {
+		t.Errorf("expected reset within 1s, got %v", status.Reset)
+	}
+	if status.TokenLimit != 600 {
+		t.Errorf("expected token limit 600, got %d", status.TokenLimit)
+	}
+	if status.TokenRemaining < 299 || status.TokenRemaining > 301 {
+		t.Errorf("expected ~300 tokens remaining, got %d", status.TokenRemaining)
+	}
+
+	rl.Allow(LimitScope{TenantID: "tenant1"})
+	status = rl.Status(LimitScope{TenantID: "tenant1"})
+	if status.Remaining != 0 {
+		t.Errorf("expected 0 remaining, got %d", status.Remaining)
+	}
+	if status.RetryAfter <= 0 {
+		t.Error("expected retry-after once the bucket is empty")
+	}
+
+	disabled := NewRateLimiter(&RateLimitConfig{Enabled: false}, nil)
+	if disabled.Status(LimitScope{TenantID: "tenant1"}) != nil {
+		t.Error("expected nil status when rate limiting is disabled")
+	}
+}
+
+func TestRateLimiter_MaxTenants(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:    true,
+		Default:    60,
+		Burst:      10,
+		MaxTenants: 100,
+	}, getTestMetrics())
+	defer rl.Stop()
+
+	// Spoofed tenant IDs must not grow memory without bound
+	for i := 0; i < 10000; i++ {
+		rl.Allow(LimitScope{TenantID: fmt.Sprintf("tenant-%d", i)})
+	}
+
+	rl.mu.Lock()
+	size, lruSize := len(rl.limiters), rl.lru.Len()
+	_, newest := rl.limiters["tenant-9999"]
+	_, oldest := rl.limiters["tenant-0"]
+	rl.mu.Unlock()
+
+	if size > 100 {
+		t.Errorf("expected at most 100 limiters, got %d", size)
+	}
+	if lruSize != size {
+		t.Errorf("lru list (%d) out of sync with map (%d)", lruSize, size)
+	}
+	if !newest || oldest {
+		t.Error("expected least recently used tenants to be evicted first")
+	}
+}
+
+func TestRateLimiter_MaxTenants_KeepsActiveStreams(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:    true,
+		Default:    60,
+		Burst:      10,
+		MaxTenants: 2,
+	}, nil)
+	defer rl.Stop()
+
+	release, err := rl.AcquireStream(context.Background(), LimitScope{TenantID: "streaming", Route: "chat"}, &RouteConfig{Name: "chat"})
+	if err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+	defer release()
+
+	for i := 0; i < 10; i++ {
+		rl.Allow(LimitScope{TenantID: fmt.Sprintf("tenant-%d", i)})
+	}
+
+	rl.mu.Lock()
+	_, kept := rl.limiters["streaming"]
+	rl.mu.Unlock()
+	if !kept {
+		t.Error("limiter with an active stream must not be evicted")
+	}
+}
+
+func TestRateLimiter_EvictIdle(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:     true,
+		Default:     60,
+		Burst:       10,
+		IdleTimeout: time.Minute,
+	}, nil)
+	defer rl.Stop()
+
+	rl.Allow(LimitScope{TenantID: "idle"})
+	rl.Allow(LimitScope{TenantID: "active"})
+	release, _ := rl.AcquireStream(context.Background(), LimitScope{TenantID: "streaming", Route: "chat"}, &RouteConfig{Name: "chat"})
+	defer release()
+
+	// Nothing is idle yet
+	rl.evictIdle(time.Now())
+	rl.mu.Lock()
+	size := len(rl.limiters)
+	rl.mu.Unlock()
+	if size != 3 {
+		t.Fatalf("expected 3 limiters before idle timeout, got %d", size)
+	}
+
+	rl.Allow(LimitScope{TenantID: "active"}) // touched, but still older than the timeout below
+	rl.evictIdle(time.Now().Add(2 * time.Minute))
+
+	rl.mu.Lock()
+	_, idle := rl.limiters["idle"]
+	_, streaming := rl.limiters["streaming"]
+	size = len(rl.limiters)
+	rl.mu.Unlock()
+
+	if idle {
+		t.Error("idle limiter should be evicted")
+	}
+	if !streaming {
+		t.Error("limiter with an active stream should survive idle eviction")
+	}
+	if size != 1 {
+		t.Errorf("expected only the streaming limiter to remain, got %d", size)
+	}
+}
+
+func TestRateLimiter_EvictIdle_KeepsTokenDebt(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:         true,
+		Default:         60,
+		Burst:           10,
+		TokensPerMinute: 1000,
+		IdleTimeout:     time.Minute,
+		MaxTenants:      1,
+	}, nil)
+	defer rl.Stop()
+
+	scope := LimitScope{TenantID: "overdrawn"}
+	reserved, _ := rl.ReserveTokens(scope, 100)
+	rl.ReconcileTokens(scope, reserved, 100000) // 远超桶容量，2 分钟内还不清
+
+	rl.evictIdle(time.Now().Add(2 * time.Minute))
+	rl.Allow(LimitScope{TenantID: "other"})
+
+	rl.mu.Lock()
+	_, kept := rl.limiters["overdrawn"]
+	rl.mu.Unlock()
+	if !kept {
+		t.Fatal("limiter with outstanding TPM debt must not be evicted")
+	}
+	if _, ok := rl.ReserveTokens(scope, 1); ok {
+		t.Error("expected the debt to still block reservations")
+	}
+}
+
+func TestRateLimiter_PinnedLimiterNotEvicted(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled:     true,
+		Default:     60,
+		Burst:       10,
+		IdleTimeout: time.Minute,
+		MaxTenants:  1,
+	}, nil)
+	defer rl.Stop()
+
+	// 模拟拿到 limiter 之后、占用名额之前的窗口
+	limiter, unpin := rl.pinLimiter(LimitScope{TenantID: "acquiring"})
+	rl.evictIdle(time.Now().Add(2 * time.Minute))
+	rl.Allow(LimitScope{TenantID: "other"})
+
+	rl.mu.Lock()
+	current := rl.limiters["acquiring"]
+	rl.mu.Unlock()
+	if current != limiter {
+		t.Fatal("pinned limiter must not be evicted")
+	}
+
+	unpin()
+	rl.evictIdle(time.Now().Add(4 * time.Minute))
+	rl.mu.Lock()
+	_, kept := rl.limiters["acquiring"]
+	rl.mu.Unlock()
+	if kept {
+		t.Error("expected the limiter to be evictable once unpinned")
+	}
+}
+
+func TestRateLimiter_NoGoroutinePerTenant(t *testing.T) {
+	rl := NewRateLimiter(&RateLimitConfig{
+		Enabled: true,
+		Default: 60,
+		Burst:   10,
+	}, nil)
+	defer rl.Stop()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 1000; i++ {
+		rl.Allow(LimitScope{TenantID: fmt.Sprintf("tenant-%d", i)})
+	}
+	after := runtime.NumGoroutine()
+
+	if after-before > 5 {
+		t.Errorf("expected no goroutine per tenant, got %d new goroutines", after-before)
+	}
+}
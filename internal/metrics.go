@@ -1,17 +1,30 @@
 package internal
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
-// Metrics Prometheus 指标 - 只保留核心的 5 个
+// Metrics Prometheus 指标 - 核心的 5 个 + 并发排队 + 限流器 + 访问控制 + 音频 + TTS 缓存
 type Metrics struct {
 	requestsTotal     *prometheus.CounterVec
 	durationMs        *prometheus.HistogramVec
 	errorsTotal       *prometheus.CounterVec
 	activeConnections *prometheus.GaugeVec
 	storageWriteMs    prometheus.Histogram
+	queueDepth        *prometheus.GaugeVec
+	queueWaitMs       *prometheus.HistogramVec
+	limiterTenants    prometheus.Gauge
+	limiterEvictions  *prometheus.CounterVec
+	ipRejections      *prometheus.CounterVec
+	audioSeconds      *prometheus.HistogramVec
+	realtimeFactor    *prometheus.HistogramVec
+	inputChars        *prometheus.HistogramVec
+	ttfaMs            *prometheus.HistogramVec
+	ttsCacheRequests  *prometheus.CounterVec
+	ttsCacheBytes     prometheus.Gauge
 }
 
 // NewMetrics 创建指标
@@ -62,6 +75,108 @@ func NewMetrics() *Metrics {
 				Buckets: []float64{1, 5, 10, 50, 100, 500, 1000},
 			},
 		),
+
+		// 6. 排队中的请求数（scope: tenant | route）
+		queueDepth: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "relay_queue_depth",
+				Help: "Number of requests waiting for a stream slot",
+			},
+			[]string{"route", "scope"},
+		),
+
+		// 7. 排队等待时间
+		queueWaitMs: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "relay_queue_wait_ms",
+				Help:    "Time spent waiting for a stream slot in milliseconds",
+				Buckets: []float64{10, 50, 100, 500, 1000, 5000, 10000, 30000},
+			},
+			[]string{"route", "scope"},
+		),
+
+		// 8. 内存中的 limiter 数
+		limiterTenants: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "relay_limiter_tenants",
+				Help: "Number of rate limiter entries held in memory",
+			},
+		),
+
+		// 9. limiter 淘汰次数（reason: idle | capacity）
+		limiterEvictions: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "relay_limiter_evictions_total",
+				Help: "Total number of rate limiter entries evicted",
+			},
+			[]string{"reason"},
+		),
+
+		// 10. 来源 IP 不在允许网段内被拒绝的请求
+		ipRejections: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "relay_ip_rejections_total",
+				Help: "Total number of requests rejected by IP allowlists",
+			},
+			[]string{"tenant"},
+		),
+
+		// 11. 音频时长（STT 为上传的音频，TTS 为输出的音频）
+		audioSeconds: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "relay_audio_duration_seconds",
+				Help:    "Audio duration in seconds",
+				Buckets: []float64{1, 5, 10, 30, 60, 300, 600, 1800, 3600},
+			},
+			[]string{"route"},
+		),
+
+		// 12. TTS 实时率（生成耗时 / 音频时长，小于 1 表示比实时快）
+		realtimeFactor: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "relay_tts_realtime_factor",
+				Help:    "TTS generation time divided by audio duration",
+				Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 5},
+			},
+			[]string{"route"},
+		),
+
+		// 13. TTS 输入字符数
+		inputChars: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "relay_tts_input_chars",
+				Help:    "Number of input characters per TTS request",
+				Buckets: []float64{10, 50, 100, 200, 500, 1000, 2000, 5000, 10000},
+			},
+			[]string{"route"},
+		),
+
+		// 14. 首个音频字节的延迟（voice 路由为从收到请求到第一段音频的端到端延迟）
+		ttfaMs: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "relay_ttfa_ms",
+				Help:    "Time to first audio byte in milliseconds",
+				Buckets: []float64{50, 100, 200, 300, 500, 750, 1000, 2000, 5000},
+			},
+			[]string{"route"},
+		),
+
+		// 15. TTS 缓存查询（result: hit | miss）
+		ttsCacheRequests: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "relay_tts_cache_requests_total",
+				Help: "Total number of TTS cache lookups",
+			},
+			[]string{"route", "result"},
+		),
+
+		// 16. TTS 缓存占用的磁盘空间
+		ttsCacheBytes: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "relay_tts_cache_bytes",
+				Help: "Size of the TTS audio cache on disk in bytes",
+			},
+		),
 	}
 }
 
@@ -81,9 +196,71 @@ func (m *Metrics) RecordRequest(ctx *RequestContext) {
 	if ctx.ErrorType != "" {
 		m.errorsTotal.WithLabelValues(route, ctx.ErrorType).Inc()
 	}
+
+	if ctx.AudioDuration != nil {
+		m.audioSeconds.WithLabelValues(route).Observe(float64(*ctx.AudioDuration) / 1000)
+	}
+	if ctx.RTF != nil {
+		m.realtimeFactor.WithLabelValues(route).Observe(*ctx.RTF)
+	}
+	if ctx.InputChars != nil {
+		m.inputChars.WithLabelValues(route).Observe(float64(*ctx.InputChars))
+	}
+	if ctx.TTFAMs != nil {
+		m.ttfaMs.WithLabelValues(route).Observe(float64(*ctx.TTFAMs))
+	}
+	if ctx.TTSCache != "" {
+		m.ttsCacheRequests.WithLabelValues(route, ctx.TTSCache).Inc()
+	}
 }
 
 // RecordStorageError 记录存储错误
 func (m *Metrics) RecordStorageError() {
 	m.errorsTotal.WithLabelValues("storage", "write_failed").Inc()
 }
+
+// RecordError 记录错误
+func (m *Metrics) RecordError(route, errorType string) {
+	m.errorsTotal.WithLabelValues(route, errorType).Inc()
+}
+
+// ConnectionOpened 活跃连接 +1
+func (m *Metrics) ConnectionOpened(route string) {
+	m.activeConnections.WithLabelValues(route).Inc()
+}
+
+// ConnectionClosed 活跃连接 -1
+func (m *Metrics) ConnectionClosed(route string) {
+	m.activeConnections.WithLabelValues(route).Dec()
+}
+
+// RecordQueued 请求进入等待队列
+func (m *Metrics) RecordQueued(route, scope string) {
+	m.queueDepth.WithLabelValues(route, scope).Inc()
+}
+
+// RecordDequeued 请求离开等待队列（获得名额或超时）
+func (m *Metrics) RecordDequeued(route, scope string, wait time.Duration) {
+	m.queueDepth.WithLabelValues(route, scope).Dec()
+	m.queueWaitMs.WithLabelValues(route, scope).Observe(float64(wait.Milliseconds()))
+}
+
+// SetLimiterTenants 更新内存中的 limiter 数
+func (m *Metrics) SetLimiterTenants(n int) {
+	m.limiterTenants.Set(float64(n))
+}
+
+// RecordLimiterEviction 记录 limiter 淘汰
+func (m *Metrics) RecordLimiterEviction(reason string) {
+	m.limiterEvictions.WithLabelValues(reason).Inc()
+}
+
+// RecordIPRejected 记录被 IP 白名单拒绝的请求
+func (m *Metrics) RecordIPRejected(tenant string) {
+	m.ipRejections.WithLabelValues(tenant).Inc()
+}
+
+// SetTTSCacheBytes 更新 TTS 缓存占用的磁盘空间
+func (m *Metrics) SetTTSCacheBytes(n int64) {
+	m.ttsCacheBytes.Set(float64(n))
+}
@@ -1,19 +1,28 @@
 package internal
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 // StreamLog 流式请求日志 - 存储到 ClickHouse 的完整记录
 type StreamLog struct {
 	// 基础信息
 	RequestID string    `json:"request_id"`
 	TenantID  string    `json:"tenant_id"`
+	APIKeyID  string    `json:"api_key_id"`
 	CreatedAt time.Time `json:"created_at"`
 
 	// 路由信息
 	Route    string `json:"route"`
 	Provider string `json:"provider"`
 	Model    string `json:"model"`
-	Kind     string `json:"kind"` // sse | raw
+	Kind     string `json:"kind"` // sse | raw | eventstream | multipart | voice
+
+	CredentialSource string `json:"credential_source"` // tenant | route | none
 
 	// 请求（压缩存储）
 	RequestBody string `json:"request_body"` // JSON string
@@ -30,6 +39,14 @@ type StreamLog struct {
 	BytesOut    int64  `json:"bytes_out"`
 	ChunksCount int    `json:"chunks_count"`
 
+	// 音频（multipart 为上传的音频和转写文本长度，raw 为 TTS 输出的音频和输入字符数，均按字符计）
+	AudioFormat     string   `json:"audio_format,omitempty"`
+	AudioDurationMs *int64   `json:"audio_duration_ms,omitempty"`
+	TextLength      *int64   `json:"text_length,omitempty"`
+	InputChars      *int64   `json:"input_chars,omitempty"`
+	RTF             *float64 `json:"rtf,omitempty"`       // Real-Time Factor：生成耗时 / 音频时长
+	TTSCache        string   `json:"tts_cache,omitempty"` // hit | miss，路由开启 tts.cache 时记录
+
 	// Token（从响应提取，失败则为 null）
 	TokensIn  *int64 `json:"tokens_in,omitempty"`
 	TokensOut *int64 `json:"tokens_out,omitempty"`
@@ -43,52 +60,87 @@ type StreamLog struct {
 type RequestContext struct {
 	RequestID string
 	TenantID  string
+	APIKeyID  string
+	Model     string
 	Route     *RouteConfig
 	StartTime time.Time
 
+	principal   *Principal // 转发过程中才能确定模型时（multipart）用于检查模型
+	ttsCacheKey string     // 开启 tts.cache 且未命中时，成功的响应写入该缓存键
+
+	CredentialSource string // 上游凭证来源：tenant | route | none
+
 	// 收集的数据
 	BytesIn        int64
 	BytesOut       int64
 	ChunksCount    int
 	TTFTMs         *int64
 	TTFAMs         *int64
+	AudioFormat    string
+	AudioDuration  *int64 // 毫秒
+	TextLength     *int64
+	InputChars     *int64
+	RTF            *float64
+	TTSCache       string // hit | miss
 	ResponseChunks []string
+	Usage          *Usage // 从响应提取的实际用量，失败则为 nil
 	StatusCode     int
 	ErrorType      string
 	ErrorMessage   string
 }
 
+// NewRequestContext 创建请求上下文，租户和 Key 来自鉴权结果
+func NewRequestContext(principal *Principal) *RequestContext {
+	return &RequestContext{
+		RequestID: uuid.New().String(),
+		TenantID:  principal.TenantID,
+		APIKeyID:  principal.KeyID,
+		StartTime: time.Now(),
+		principal: principal,
+	}
+}
+
+// allowsModel Key 是否允许使用该模型
+func (ctx *RequestContext) allowsModel(model string) bool {
+	return ctx.principal == nil || ctx.principal.AllowsModel(model)
+}
+
 // ToStreamLog 转换为 StreamLog
 func (ctx *RequestContext) ToStreamLog(requestBody string) *StreamLog {
 	duration := time.Since(ctx.StartTime).Milliseconds()
 
 	log := &StreamLog{
-		RequestID:      ctx.RequestID,
-		TenantID:       ctx.TenantID,
-		CreatedAt:      ctx.StartTime,
-		Route:          ctx.Route.Name,
-		Provider:       extractProvider(ctx.Route.Upstream),
-		Kind:           ctx.Route.Kind,
-		RequestBody:    requestBody,
-		StatusCode:     ctx.StatusCode,
-		ResponseChunks: ctx.ResponseChunks,
-		DurationMs:     duration,
-		TTFTMs:         ctx.TTFTMs,
-		TTFAMs:         ctx.TTFAMs,
-		BytesIn:        ctx.BytesIn,
-		BytesOut:       ctx.BytesOut,
-		ChunksCount:    ctx.ChunksCount,
-		ErrorType:      ctx.ErrorType,
-		ErrorMessage:   ctx.ErrorMessage,
-	}
-
-	// 尝试从最后一个 chunk 提取 token
-	if ctx.Route.Kind == "sse" && len(ctx.ResponseChunks) > 0 {
-		usage := extractUsage(ctx.ResponseChunks)
-		if usage != nil {
-			log.TokensIn = &usage.InputTokens
-			log.TokensOut = &usage.OutputTokens
-		}
+		RequestID:        ctx.RequestID,
+		TenantID:         ctx.TenantID,
+		APIKeyID:         ctx.APIKeyID,
+		CreatedAt:        ctx.StartTime,
+		Route:            ctx.Route.Name,
+		Provider:         extractProvider(ctx.Route.Upstream),
+		Model:            ctx.Model,
+		Kind:             ctx.Route.Kind,
+		CredentialSource: ctx.CredentialSource,
+		RequestBody:      requestBody,
+		StatusCode:       ctx.StatusCode,
+		ResponseChunks:   ctx.ResponseChunks,
+		DurationMs:       duration,
+		TTFTMs:           ctx.TTFTMs,
+		TTFAMs:           ctx.TTFAMs,
+		BytesIn:          ctx.BytesIn,
+		BytesOut:         ctx.BytesOut,
+		ChunksCount:      ctx.ChunksCount,
+		AudioFormat:      ctx.AudioFormat,
+		AudioDurationMs:  ctx.AudioDuration,
+		TextLength:       ctx.TextLength,
+		InputChars:       ctx.InputChars,
+		RTF:              ctx.RTF,
+		TTSCache:         ctx.TTSCache,
+		ErrorType:        ctx.ErrorType,
+		ErrorMessage:     ctx.ErrorMessage,
+	}
+
+	if ctx.Usage != nil {
+		log.TokensIn = &ctx.Usage.InputTokens
+		log.TokensOut = &ctx.Usage.OutputTokens
 	}
 
 	return log
@@ -109,11 +161,244 @@ func extractProvider(_ string) string {
 	return "unknown"
 }
 
-// extractUsage 从响应中提取 usage（简化版）
+// usagePayload SSE 事件中与用量相关的字段
+type usagePayload struct {
+	Usage   *usageFields `json:"usage"`
+	Message *struct {
+		Usage *usageFields `json:"usage"`
+	} `json:"message"` // Anthropic message_start
+	InvocationMetrics *struct {
+		InputTokenCount  int64 `json:"inputTokenCount"`
+		OutputTokenCount int64 `json:"outputTokenCount"`
+	} `json:"amazon-bedrock-invocationMetrics"` // Bedrock 最后一个 chunk
+	UsageMetadata *geminiUsage `json:"usageMetadata"` // Gemini
+	Response      *struct {
+		Usage *usageFields `json:"usage"`
+	} `json:"response"` // OpenAI Responses 的 response.completed / response.incomplete
+}
+
+type usageFields struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	InputTokens      int64 `json:"input_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+}
+
+// extractUsage 从 SSE 响应中提取 usage
+// OpenAI: { "usage": { "prompt_tokens": 10, "completion_tokens": 20 } }
+// Anthropic: message_start 携带 input_tokens，message_delta 携带累计的 output_tokens
+// Bedrock: 最后一个 chunk 的 amazon-bedrock-invocationMetrics 携带 inputTokenCount / outputTokenCount
+// Gemini: 每个事件的 usageMetadata 携带累计的 promptTokenCount / candidatesTokenCount
+// OpenAI Responses: response.completed 的 response.usage 携带 input_tokens / output_tokens
 func extractUsage(chunks []string) *Usage {
-	// TODO: 实现 JSON 解析逻辑
-	// 从最后几个 chunk 中查找包含 "usage" 的部分
-	// OpenAI: { "usage": { "prompt_tokens": 10, "completion_tokens": 20 } }
-	// Anthropic: { "usage": { "input_tokens": 10, "output_tokens": 20 } }
-	return nil
+	var usage Usage
+	found := false
+
+	for _, chunk := range chunks {
+		data, ok := strings.CutPrefix(chunk, "data:")
+		if !ok || !hasUsageField(data) {
+			continue
+		}
+
+		var payload usagePayload
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &payload); err != nil {
+			continue
+		}
+		fields := payload.Usage
+		if fields == nil && payload.Message != nil {
+			fields = payload.Message.Usage
+		}
+		if fields == nil && payload.Response != nil {
+			fields = payload.Response.Usage
+		}
+		if fields == nil && payload.InvocationMetrics != nil {
+			fields = &usageFields{
+				InputTokens:  payload.InvocationMetrics.InputTokenCount,
+				OutputTokens: payload.InvocationMetrics.OutputTokenCount,
+			}
+		}
+		if fields == nil && payload.UsageMetadata != nil {
+			fields = &usageFields{
+				InputTokens:  payload.UsageMetadata.PromptTokenCount,
+				OutputTokens: payload.UsageMetadata.outputTokens(),
+			}
+		}
+		if fields == nil {
+			continue
+		}
+
+		// 后出现的非零值覆盖前面的值（流中的 usage 是累计值）
+		found = true
+		if v := max(fields.PromptTokens, fields.InputTokens); v > 0 {
+			usage.InputTokens = v
+		}
+		if v := max(fields.CompletionTokens, fields.OutputTokens); v > 0 {
+			usage.OutputTokens = v
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	return &usage
+}
+
+// sseEvent 带类型的 SSE 事件（OpenAI Responses、Anthropic）中用到的字段
+type sseEvent struct {
+	Type  string `json:"type"`
+	Error *struct {
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Response *struct {
+		Error *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	} `json:"response"`
+}
+
+// sseEventType 返回 data 中 JSON 的 type 字段，没有时为空
+func sseEventType(data string) string {
+	if !strings.Contains(data, `"type"`) {
+		return ""
+	}
+	var event sseEvent
+	if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+		return ""
+	}
+	return event.Type
+}
+
+// anthropicLifecycleEvents Anthropic 中不携带输出内容的事件
+var anthropicLifecycleEvents = map[string]bool{
+	"message_start":       true,
+	"content_block_start": true,
+	"content_block_stop":  true,
+	"message_delta":       true,
+	"message_stop":        true,
+	"ping":                true,
+	"error":               true,
+}
+
+// sseHasToken 是否是携带输出内容的 data 行（用于 TTFT）
+// 带类型的事件只认内容增量：OpenAI Responses 的 response.*.delta、Anthropic 的 content_block_delta；
+// 没有 type 的事件（Chat Completions chunk）沿用第一个 data 行
+func sseHasToken(line string) bool {
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok || strings.Contains(data, "[DONE]") {
+		return false
+	}
+	eventType := sseEventType(data)
+	switch {
+	case eventType == "":
+		return true
+	case strings.HasPrefix(eventType, "response."):
+		return strings.HasSuffix(eventType, ".delta")
+	default:
+		return !anthropicLifecycleEvents[eventType]
+	}
+}
+
+// extractStreamError 从 SSE 响应中提取流内的错误事件
+// OpenAI Responses: response.failed 的 response.error、error 事件；Anthropic: error 事件
+func extractStreamError(chunks []string) (string, bool) {
+	for _, chunk := range chunks {
+		data, ok := strings.CutPrefix(chunk, "data:")
+		if !ok || !strings.Contains(data, `"error"`) {
+			continue
+		}
+		var event sseEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err != nil {
+			continue
+		}
+		switch {
+		case event.Type == "response.failed" && event.Response != nil && event.Response.Error != nil:
+			return event.Response.Error.Code + ": " + event.Response.Error.Message, true
+		case event.Type == "error" && event.Error != nil:
+			code := event.Error.Code
+			if code == "" {
+				code = event.Error.Type
+			}
+			return code + ": " + event.Error.Message, true
+		}
+	}
+	return "", false
+}
+
+// hasUsageField 是否可能带有用量字段，避免每个事件都解析 JSON
+func hasUsageField(data string) bool {
+	return strings.Contains(data, `"usage"`) ||
+		strings.Contains(data, `"amazon-bedrock-invocationMetrics"`) ||
+		strings.Contains(data, `"usageMetadata"`)
+}
+
+// charsPerToken 估算用的平均每 token 字符数
+const charsPerToken = 4
+
+// estimateTokens 根据请求体估算本次请求的 token 消耗：prompt 文本长度 / 4 + 最大输出 token
+// 估算值只用于预扣 TPM 配额，流结束后会按实际用量对账
+func estimateTokens(body []byte) int64 {
+	var req map[string]any
+	if err := json.Unmarshal(body, &req); err != nil {
+		return int64(len(body) / charsPerToken)
+	}
+
+	chars := 0
+	for key, value := range req {
+		if key == "model" {
+			continue
+		}
+		chars += textLength(value)
+	}
+	estimate := int64(chars / charsPerToken)
+
+	// Gemini 的输出上限在 generationConfig.maxOutputTokens 中
+	limits := req
+	if config, ok := req["generationConfig"].(map[string]any); ok {
+		limits = config
+	}
+	for _, key := range []string{"max_tokens", "max_completion_tokens", "max_output_tokens", "maxOutputTokens"} {
+		if v, ok := limits[key].(float64); ok && v > 0 {
+			estimate += int64(v)
+			break
+		}
+	}
+
+	return estimate
+}
+
+// requestModel 从 JSON 请求体中取出 model 字段，非 JSON 或没有该字段时返回空
+func requestModel(body []byte) string {
+	var req struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return req.Model
+}
+
+// textLength 递归统计 JSON 值中所有字符串的长度
+func textLength(value any) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []any:
+		n := 0
+		for _, item := range v {
+			n += textLength(item)
+		}
+		return n
+	case map[string]any:
+		n := 0
+		for _, item := range v {
+			n += textLength(item)
+		}
+		return n
+	default:
+		return 0
+	}
 }
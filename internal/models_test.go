@@ -0,0 +1,200 @@
+package internal
+
+import "testing"
+
+func TestExtractUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		wantNil bool
+		wantIn  int64
+		wantOut int64
+	}{
+		{
+			name: "openai final chunk",
+			chunks: []string{
+				`data: {"choices":[{"delta":{"content":"Hi"}}]}`,
+				`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":34,"total_tokens":46}}`,
+				`data: [DONE]`,
+			},
+			wantIn:  12,
+			wantOut: 34,
+		},
+		{
+			name: "anthropic message_start and message_delta",
+			chunks: []string{
+				`event: message_start`,
+				`data: {"type":"message_start","message":{"usage":{"input_tokens":25,"output_tokens":1}}}`,
+				`event: content_block_delta`,
+				`data: {"type":"content_block_delta","delta":{"text":"Hello"}}`,
+				`event: message_delta`,
+				`data: {"type":"message_delta","usage":{"output_tokens":15}}`,
+			},
+			wantIn:  25,
+			wantOut: 15,
+		},
+		{
+			name: "bedrock invocation metrics",
+			chunks: []string{
+				`data: {"type":"message_start","message":{"usage":{"input_tokens":25,"output_tokens":1}}}`,
+				`data: {"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":25,"outputTokenCount":17,"invocationLatency":812}}`,
+			},
+			wantIn:  25,
+			wantOut: 17,
+		},
+		{
+			name: "gemini usageMetadata",
+			chunks: []string{
+				`data: {"candidates":[{"content":{"parts":[{"text":"Hi"}]}}],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":1}}`,
+				`data: {"candidates":[{"content":{"parts":[{"text":"!"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":8,"candidatesTokenCount":5,"thoughtsTokenCount":3,"totalTokenCount":16}}`,
+			},
+			wantIn:  8,
+			wantOut: 8,
+		},
+		{
+			name: "openai responses completed",
+			chunks: []string{
+				`event: response.created`,
+				`data: {"type":"response.created","response":{"id":"resp_1","status":"in_progress","usage":null}}`,
+				`event: response.output_text.delta`,
+				`data: {"type":"response.output_text.delta","delta":"Hi"}`,
+				`event: response.completed`,
+				`data: {"type":"response.completed","response":{"id":"resp_1","status":"completed","usage":{"input_tokens":11,"output_tokens":7,"total_tokens":18}}}`,
+			},
+			wantIn:  11,
+			wantOut: 7,
+		},
+		{
+			name: "no usage",
+			chunks: []string{
+				`data: {"choices":[{"delta":{"content":"Hi"}}]}`,
+				`data: [DONE]`,
+			},
+			wantNil: true,
+		},
+		{
+			name:    "invalid json",
+			chunks:  []string{`data: {"usage": broken`},
+			wantNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := extractUsage(tt.chunks)
+			if tt.wantNil {
+				if usage != nil {
+					t.Errorf("expected nil usage, got %+v", usage)
+				}
+				return
+			}
+			if usage == nil {
+				t.Fatal("expected usage, got nil")
+			}
+			if usage.InputTokens != tt.wantIn || usage.OutputTokens != tt.wantOut {
+				t.Errorf("expected %d/%d tokens, got %d/%d", tt.wantIn, tt.wantOut, usage.InputTokens, usage.OutputTokens)
+			}
+			if usage.TotalTokens != tt.wantIn+tt.wantOut {
+				t.Errorf("expected total %d, got %d", tt.wantIn+tt.wantOut, usage.TotalTokens)
+			}
+		})
+	}
+}
+
+func TestSSEHasToken(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{`data: {"choices":[{"delta":{"role":"assistant"}}]}`, true},
+		{`data: [DONE]`, false},
+		{`event: response.output_text.delta`, false},
+		{`data: {"type":"response.created","response":{"id":"resp_1"}}`, false},
+		{`data: {"type":"response.in_progress","response":{"id":"resp_1"}}`, false},
+		{`data: {"type":"response.output_item.added","item":{"type":"message"}}`, false},
+		{`data: {"type":"response.output_text.delta","delta":"Hi"}`, true},
+		{`data: {"type":"response.function_call_arguments.delta","delta":"{"}`, true},
+		{`data: {"type":"message_start","message":{"usage":{"input_tokens":25}}}`, false},
+		{`data: {"type":"ping"}`, false},
+		{`data: {"type":"content_block_delta","delta":{"text":"Hello"}}`, true},
+	}
+	for _, tt := range tests {
+		if got := sseHasToken(tt.line); got != tt.want {
+			t.Errorf("sseHasToken(%s): expected %v", tt.line, tt.want)
+		}
+	}
+}
+
+func TestExtractStreamError(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   string
+	}{
+		{
+			name: "responses failed",
+			chunks: []string{
+				`data: {"type":"response.created","response":{"id":"resp_1","error":null}}`,
+				`data: {"type":"response.failed","response":{"id":"resp_1","status":"failed","error":{"code":"server_error","message":"The model failed"}}}`,
+			},
+			want: "server_error: The model failed",
+		},
+		{
+			name:   "anthropic error event",
+			chunks: []string{`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`},
+			want:   "overloaded_error: Overloaded",
+		},
+		{
+			name: "no error",
+			chunks: []string{
+				`data: {"type":"response.completed","response":{"id":"resp_1","error":null}}`,
+				`data: {"choices":[{"delta":{"content":"error"}}]}`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := extractStreamError(tt.chunks)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("expected %q, got %q %v", tt.want, got, ok)
+			}
+		})
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int64
+	}{
+		{
+			name: "messages with max_tokens",
+			body: `{"model":"gpt-4o","messages":[{"role":"user","content":"0123456789abcdef"}],"max_tokens":100}`,
+			want: (4+16)/4 + 100,
+		},
+		{
+			name: "anthropic system prompt",
+			body: `{"model":"claude","system":"12345678","messages":[],"max_tokens":10}`,
+			want: 8/4 + 10,
+		},
+		{
+			name: "gemini generationConfig",
+			body: `{"contents":[{"role":"user","parts":[{"text":"0123456789abcdef"}]}],"generationConfig":{"maxOutputTokens":50}}`,
+			want: (4+16)/4 + 50,
+		},
+		{
+			name: "non-json body",
+			body: "plain text body!",
+			want: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateTokens([]byte(tt.body)); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -4,29 +4,45 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
+	"unicode/utf8"
 )
 
 // Proxy 核心转发器 - 只做一件事：转发流并收集元数据
 type Proxy struct {
-	config  *Config
-	storage *Storage
-	metrics *Metrics
-	client  *http.Client
+	config      *Config
+	storage     *Storage
+	metrics     *Metrics
+	credentials *CredentialVault // 未开启租户自带凭证时为 nil
+	secrets     *SecretManager   // 为 nil 时只使用租户凭证
+	ttsCache    *TTSCache        // 未开启 tts_cache 时为 nil
+	client      *http.Client
+	auth        map[string]upstreamAuthenticator // 路由名 -> 上游鉴权方式，不需要凭证的路由不在其中
 }
 
 // NewProxy 创建代理
-func NewProxy(config *Config, storage *Storage, metrics *Metrics) *Proxy {
+func NewProxy(config *Config, storage *Storage, metrics *Metrics, credentials *CredentialVault, secrets *SecretManager, ttsCache *TTSCache) *Proxy {
+	tokens := newTokenCache(&http.Client{Timeout: 10 * time.Second})
+	auth := make(map[string]upstreamAuthenticator)
+	for i := range config.Routes {
+		if a := newUpstreamAuthenticator(&config.Routes[i], tokens); a != nil {
+			auth[config.Routes[i].Name] = a
+		}
+	}
+
 	return &Proxy{
-		config:  config,
-		storage: storage,
-		metrics: metrics,
+		config:      config,
+		storage:     storage,
+		metrics:     metrics,
+		credentials: credentials,
+		secrets:     secrets,
+		ttsCache:    ttsCache,
 		client: &http.Client{
 			Timeout: config.Server.Timeout,
 			Transport: &http.Transport{
@@ -35,42 +51,128 @@ func NewProxy(config *Config, storage *Storage, metrics *Metrics) *Proxy {
 				IdleConnTimeout:     90 * time.Second,
 			},
 		},
+		auth: auth,
 	}
 }
 
-// Handle 处理请求 - 核心逻辑
-func (p *Proxy) Handle(w http.ResponseWriter, r *http.Request) error {
-	// 1. 创建请求上下文
-	ctx := &RequestContext{
-		RequestID: uuid.New().String(),
-		TenantID:  r.Header.Get("X-Tenant-ID"),
-		StartTime: time.Now(),
-	}
-
-	// 2. 路由匹配
+// Handle 处理请求 - 核心逻辑，ctx 由调用方创建，处理过程中填充元数据
+func (p *Proxy) Handle(w http.ResponseWriter, r *http.Request, ctx *RequestContext) error {
+	// 1. 路由匹配
 	route := p.config.GetRouteByPath(r.URL.Path)
 	if route == nil {
 		return fmt.Errorf("route not found for path: %s", r.URL.Path)
 	}
 	ctx.Route = route
 
-	// 3. 读取请求体（需要重放给上游）
-	requestBody, err := io.ReadAll(r.Body)
-	if err != nil {
-		return fmt.Errorf("read request body: %w", err)
+	p.metrics.ConnectionOpened(route.Name)
+	defer p.metrics.ConnectionClosed(route.Name)
+
+	// 2. 读取请求体（需要重放给上游）；multipart 上传边转发边解析，不缓存
+	var requestBody []byte
+	var upload *uploadStream
+	var fileBeforeModel bool // 限定了模型的 Key 在允许的 model 字段之前上传文件，只在 upload.wait 之后读取
+	if route.Kind == "multipart" {
+		boundary, err := multipartBoundary(r.Header.Get("Content-Type"))
+		if err != nil {
+			return err
+		}
+		// 表单中的模型不允许时立即中断上传；Key 限定了模型时，文件开始前必须已经出现允许的 model 字段
+		reqCtx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		r = r.WithContext(reqCtx)
+		modelSeen := false
+		upload = newUploadStream(reqCtx, r.Body, boundary, func(name, value string) {
+			if name != "model" {
+				return
+			}
+			modelSeen = true
+			if !ctx.allowsModel(value) {
+				cancel()
+			}
+		}, func() {
+			if !modelSeen && !ctx.allowsModel("") {
+				fileBeforeModel = true
+				cancel()
+			}
+		})
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return fmt.Errorf("read request body: %w", err)
+		}
+		requestBody = body
+		ctx.BytesIn = int64(len(requestBody))
+		r.Body.Close()
+	}
+
+	// Azure TTS 的 JSON 请求转换为 SSML
+	if route.TTS.Enabled {
+		body, err := prepareTTSBody(&route.TTS, r.Header, requestBody)
+		if err != nil {
+			ctx.StatusCode = http.StatusBadRequest
+			ctx.ErrorType = "invalid_request"
+			ctx.ErrorMessage = err.Error()
+			p.saveLog(ctx, string(requestBody))
+			return err
+		}
+		requestBody = body
+	}
+
+	// TTS 缓存命中时直接返回，不请求上游
+	if route.TTS.Cache && p.ttsCache != nil {
+		if served, err := p.serveTTSCache(w, r, route, requestBody, ctx); served {
+			return err
+		}
 	}
-	ctx.BytesIn = int64(len(requestBody))
-	r.Body.Close()
 
-	// 4. 构造上游请求
-	upstreamReq, err := p.buildUpstreamRequest(r, route, requestBody)
+	// 语音管线：LLM 和 TTS 由 voice 配置中的路由转发
+	if route.Kind == "voice" {
+		return p.forwardVoice(w, r, route, requestBody, ctx)
+	}
+
+	// TTS 按句分段合成（无法切分、只有一句或超过 max_segments 时按普通请求转发）
+	if route.TTS.Segment {
+		segments := splitTTSInput(requestBody, r.Header.Get(azureOutputFormatHeader), route.TTS.maxSegmentChars())
+		if len(segments) > 1 && len(segments) <= route.TTS.maxSegments() {
+			return p.forwardTTSSegments(w, r, route, segments, requestBody, ctx)
+		}
+	}
+
+	// 3. 构造上游请求
+	upstreamReq, err := p.buildUpstreamRequest(r, route, requestBody, ctx)
 	if err != nil {
+		if ctx.ErrorType != "" {
+			p.saveLog(ctx, string(requestBody))
+		}
 		return fmt.Errorf("build upstream request: %w", err)
 	}
+	if upload != nil {
+		upstreamReq.Body = upload
+		upstreamReq.GetBody = nil
+		upstreamReq.ContentLength = r.ContentLength
+	}
 
-	// 5. 发起请求
+	// 4. 发起请求
 	upstreamResp, err := p.client.Do(upstreamReq)
+	if upload != nil {
+		requestBody = []byte(p.recordUpload(ctx, upload.wait(), upload.bytesRead))
+		if fileBeforeModel || !ctx.allowsModel(ctx.Model) {
+			if err == nil {
+				upstreamResp.Body.Close()
+			}
+			err = fmt.Errorf("%w %s", ErrModelNotAllowed, ctx.Model)
+			if fileBeforeModel {
+				err = fmt.Errorf("%w: the model field must come before the file", ErrModelNotAllowed)
+			}
+			ctx.StatusCode = http.StatusForbidden
+			ctx.ErrorType = "model_not_allowed"
+			ctx.ErrorMessage = err.Error()
+			p.saveLog(ctx, string(requestBody))
+			return err
+		}
+	}
 	if err != nil {
+		err = redactUpstreamError(err, p.auth[route.Name])
 		ctx.ErrorType = "upstream_error"
 		ctx.ErrorMessage = err.Error()
 		p.saveLog(ctx, string(requestBody))
@@ -80,20 +182,58 @@ func (p *Proxy) Handle(w http.ResponseWriter, r *http.Request) error {
 
 	ctx.StatusCode = upstreamResp.StatusCode
 
-	// 6. 复制响应头
-	for k, v := range upstreamResp.Header {
-		w.Header()[k] = v
+	// 5. 复制响应头
+	p.copyUpstreamHeaders(w, upstreamResp.Header)
+	// event-stream 成功时转换为 SSE；上游报错时返回的是普通 JSON，原样转发
+	eventStream := route.Kind == "eventstream" && upstreamResp.StatusCode == http.StatusOK
+	if eventStream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Del("Content-Length")
 	}
 	w.Header().Set("X-Request-ID", ctx.RequestID)
 	w.WriteHeader(upstreamResp.StatusCode)
 
-	// 7. 流式转发（根据 kind）
-	if route.Kind == "sse" {
+	// 6. 流式转发（根据 kind），流式转写（stream=true）返回的是 SSE
+	sse := route.Kind == "sse" ||
+		(route.Kind == "multipart" && strings.HasPrefix(upstreamResp.Header.Get("Content-Type"), "text/event-stream"))
+	switch {
+	case sse:
 		err = p.forwardSSE(w, upstreamResp.Body, ctx)
-	} else {
+	case eventStream:
+		err = p.forwardEventStream(w, upstreamResp.Body, ctx)
+	case route.Kind == "multipart":
+		err = p.forwardTranscript(w, upstreamResp.Body, ctx)
+	case route.TTS.Enabled && upstreamResp.StatusCode == http.StatusOK:
+		// 边转发边计量输出音频（TTS），开启缓存时同时写入缓存
+		meter := newTTSAudioMeter(upstreamResp.Header.Get("Content-Type"), r.Header.Get(azureOutputFormatHeader))
+		cache := p.ttsCache.begin(ctx.ttsCacheKey, upstreamResp.Header.Get("Content-Type"))
+		err = p.forwardRaw(w, io.TeeReader(upstreamResp.Body, io.MultiWriter(meter, cache)), ctx)
+		cache.finish(err == nil)
+		recordTTSAudio(ctx, meter, requestBody)
+	default:
 		err = p.forwardRaw(w, upstreamResp.Body, ctx)
 	}
 
+	// 7. 提取实际用量（供日志和 TPM 对账使用）
+	if sse || eventStream {
+		ctx.Usage = extractUsage(ctx.ResponseChunks)
+	}
+	if route.Kind == "multipart" && upstreamResp.StatusCode == http.StatusOK {
+		text, usage := parseTranscription(ctx.ResponseChunks, sse)
+		length := int64(utf8.RuneCountInString(text))
+		ctx.TextLength = &length
+		if usage != nil {
+			ctx.Usage = usage
+		}
+	}
+	// 流内的错误事件（HTTP 状态码仍是 200）同样记录到日志
+	if sse && ctx.ErrorType == "" {
+		if message, ok := extractStreamError(ctx.ResponseChunks); ok {
+			ctx.ErrorType = "upstream_error"
+			ctx.ErrorMessage = message
+		}
+	}
+
 	// 8. 存储日志（同步）
 	p.saveLog(ctx, string(requestBody))
 
@@ -111,16 +251,18 @@ func (p *Proxy) forwardSSE(w http.ResponseWriter, body io.Reader, ctx *RequestCo
 	firstToken := true
 	chunks := []string{}
 
-	for scanner.Scan() {
-		line := scanner.Text()
-
-		// 记录 TTFT
-		if firstToken && strings.HasPrefix(line, "data:") && !strings.Contains(line, "[DONE]") {
-			ttft := time.Since(ctx.StartTime).Milliseconds()
-			ctx.TTFTMs = &ttft
-			firstToken = false
+	// TTFT 以第一个携带输出内容的事件计算，Gemini 成功时按需转换为 OpenAI 格式
+	// 上游报错时返回的是普通 JSON（不是 SSE），原样转发
+	hasToken := sseHasToken
+	var translator *geminiTranslator
+	if ctx.Route.Provider == ProviderGemini {
+		hasToken = geminiHasText
+		if ctx.Route.ResponseFormat == ResponseFormatOpenAI && ctx.StatusCode == http.StatusOK {
+			translator = newGeminiTranslator(ctx)
 		}
+	}
 
+	write := func(line string) {
 		// 收集 chunks（完整存储）
 		chunks = append(chunks, line)
 
@@ -132,6 +274,31 @@ func (p *Proxy) forwardSSE(w http.ResponseWriter, body io.Reader, ctx *RequestCo
 		ctx.ChunksCount++
 	}
 
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// 记录 TTFT
+		if firstToken && hasToken(line) {
+			ttft := time.Since(ctx.StartTime).Milliseconds()
+			ctx.TTFTMs = &ttft
+			firstToken = false
+		}
+
+		if translator == nil {
+			write(line)
+			continue
+		}
+		for _, out := range translator.translate(line) {
+			write(out)
+		}
+	}
+
+	if translator != nil && scanner.Err() == nil {
+		for _, out := range translator.done() {
+			write(out)
+		}
+	}
+
 	ctx.ResponseChunks = chunks
 
 	if err := scanner.Err(); err != nil {
@@ -143,6 +310,92 @@ func (p *Proxy) forwardSSE(w http.ResponseWriter, body io.Reader, ctx *RequestCo
 	return nil
 }
 
+// forwardEventStream 解码 AWS event-stream（Bedrock InvokeModelWithResponseStream），逐条转换为 SSE 事件
+func (p *Proxy) forwardEventStream(w http.ResponseWriter, body io.Reader, ctx *RequestContext) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("response writer does not support flushing")
+	}
+
+	decoder := newEventStreamDecoder(body)
+	chunks := []string{}
+	defer func() { ctx.ResponseChunks = chunks }()
+
+	for {
+		msg, err := decoder.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			ctx.ErrorType = "stream_error"
+			ctx.ErrorMessage = err.Error()
+			return err
+		}
+
+		data, upstreamErr := bedrockSSEData(msg)
+		if data == nil {
+			continue
+		}
+
+		line := "data: " + string(data)
+		if upstreamErr != nil {
+			line = "event: error\n" + line
+		} else if ctx.TTFTMs == nil {
+			// 记录 TTFT
+			ttft := time.Since(ctx.StartTime).Milliseconds()
+			ctx.TTFTMs = &ttft
+		}
+
+		chunks = append(chunks, line)
+		fmt.Fprintf(w, "%s\n\n", line)
+		flusher.Flush()
+
+		ctx.BytesOut += int64(len(line) + 2)
+		ctx.ChunksCount++
+
+		if upstreamErr != nil {
+			ctx.ErrorType = "upstream_error"
+			ctx.ErrorMessage = upstreamErr.Error()
+			return upstreamErr
+		}
+	}
+}
+
+// forwardTranscript 转发转写结果（JSON 或纯文本），同时保留一份用于统计文本长度
+func (p *Proxy) forwardTranscript(w http.ResponseWriter, body io.Reader, ctx *RequestContext) error {
+	transcript, err := io.ReadAll(io.LimitReader(body, maxTranscriptSize))
+	if err == nil {
+		//nolint:errcheck // write errors are handled by connection close
+		w.Write(transcript)
+		var rest int64
+		rest, err = io.Copy(w, body)
+		ctx.BytesOut = int64(len(transcript)) + rest
+		ctx.ChunksCount = 1
+	}
+	ctx.ResponseChunks = []string{string(transcript)}
+
+	if err != nil {
+		ctx.ErrorType = "stream_error"
+		ctx.ErrorMessage = err.Error()
+		return err
+	}
+	return nil
+}
+
+// recordUpload 把上传的元数据写入上下文，返回记录到日志的请求体
+func (p *Proxy) recordUpload(ctx *RequestContext, upload *multipartUpload, bytesRead int64) string {
+	ctx.BytesIn = bytesRead
+	ctx.Model = upload.Fields["model"]
+	if upload.Audio != nil {
+		ctx.AudioFormat = upload.Audio.Format()
+		if d, ok := upload.Audio.Duration(); ok {
+			ms := d.Milliseconds()
+			ctx.AudioDuration = &ms
+		}
+	}
+	return upload.logBody()
+}
+
 // forwardRaw 转发原始二进制流
 func (p *Proxy) forwardRaw(w http.ResponseWriter, body io.Reader, ctx *RequestContext) error {
 	flusher, ok := w.(http.Flusher)
@@ -187,12 +440,45 @@ func (p *Proxy) forwardRaw(w http.ResponseWriter, body io.Reader, ctx *RequestCo
 	return nil
 }
 
+// copyUpstreamHeaders 复制上游响应头
+// relay 已写入自己的限流头时，上游的限流头（描述的是 relay 共用的上游账号）不透传，避免覆盖；relay 未开启限流时原样透传
+// relay 开启跨域时跨域头由 relay 自己决定，未开启时原样透传；Vary 与 relay 已写入的值合并
+func (p *Proxy) copyUpstreamHeaders(w http.ResponseWriter, header http.Header) {
+	relayLimits := w.Header().Get("RateLimit-Policy") != ""
+	relayCORS := p.config.Server.CORS.Enabled
+	for k, v := range header {
+		switch {
+		case relayLimits && isRateLimitHeader(k), relayCORS && isCORSHeader(k):
+			continue
+		case k == "Vary":
+			w.Header()[k] = append(w.Header()[k], v...)
+		default:
+			w.Header()[k] = v
+		}
+	}
+}
+
+// isRateLimitHeader 是否为限流相关的响应头（X-RateLimit-* / RateLimit-*）
+func isRateLimitHeader(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "x-ratelimit-") || strings.HasPrefix(key, "ratelimit")
+}
+
+// isCORSHeader 是否为跨域响应头（Access-Control-*）
+func isCORSHeader(key string) bool {
+	return strings.HasPrefix(strings.ToLower(key), "access-control-")
+}
+
 // buildUpstreamRequest 构造上游请求
-func (p *Proxy) buildUpstreamRequest(r *http.Request, route *RouteConfig, body []byte) (*http.Request, error) {
+func (p *Proxy) buildUpstreamRequest(r *http.Request, route *RouteConfig, body []byte, ctx *RequestContext) (*http.Request, error) {
 	// 构造完整 URL
+	rawQuery := r.URL.RawQuery
+	if route.Provider == ProviderGemini {
+		rawQuery = geminiUpstreamQuery(r.URL.Path, r.URL.Query()).Encode()
+	}
 	upstreamURL := route.Upstream + r.URL.Path
-	if r.URL.RawQuery != "" {
-		upstreamURL += "?" + r.URL.RawQuery
+	if rawQuery != "" {
+		upstreamURL += "?" + rawQuery
 	}
 
 	// 创建请求
@@ -208,21 +494,68 @@ func (p *Proxy) buildUpstreamRequest(r *http.Request, route *RouteConfig, body [
 		}
 	}
 
-	// 注入上游认证
-	if route.AuthHeader != "" && route.AuthEnv != "" {
-		authValue := route.GetAuthValue()
-		if authValue != "" {
-			// 自动添加 Bearer 前缀（如果是 Authorization header 且还没有前缀）
-			if route.AuthHeader == "Authorization" && !strings.HasPrefix(authValue, "Bearer ") {
-				authValue = "Bearer " + authValue
+	// SigV4 会签名所有 x-amz-* 请求头，客户端发送的不能混入签名（例如伪造 X-Amz-Security-Token）
+	if route.UpstreamAuth.Type == UpstreamAuthSigV4 {
+		for k := range req.Header {
+			if strings.HasPrefix(strings.ToLower(k), "x-amz-") {
+				req.Header.Del(k)
 			}
-			req.Header.Set(route.AuthHeader, authValue)
+		}
+	}
+
+	// Bedrock 按 Accept 决定响应格式，客户端期望的是转换后的 SSE
+	if route.Kind == "eventstream" {
+		req.Header.Set("Accept", "application/vnd.amazon.eventstream")
+	}
+
+	// 注入上游认证：租户自带的凭证优先，其次是路由配置的凭证
+	auth := p.auth[route.Name]
+	secret, source, err := p.upstreamCredential(route, ctx.TenantID)
+	ctx.CredentialSource = source
+	if err != nil {
+		ctx.ErrorType = "upstream_auth_error"
+		ctx.ErrorMessage = err.Error()
+		return nil, err
+	}
+	if auth != nil && secret != "" {
+		if err := auth.apply(r.Context(), req, secret, body); err != nil {
+			ctx.ErrorType = "upstream_auth_error"
+			ctx.ErrorMessage = err.Error()
+			return nil, fmt.Errorf("upstream auth: %w", err)
 		}
 	}
 
 	return req, nil
 }
 
+// upstreamCredential 返回上游凭证及其来源
+// 租户配置了凭证但无法解密，或路由要求租户凭证而租户没有配置时返回错误，不回退到路由凭证
+func (p *Proxy) upstreamCredential(route *RouteConfig, tenant string) (string, string, error) {
+	if p.auth[route.Name] == nil {
+		return "", CredentialSourceNone, nil
+	}
+	if p.credentials != nil {
+		secret, err := p.credentials.Lookup(tenant, route.Name)
+		switch {
+		case err == nil:
+			return secret, CredentialSourceTenant, nil
+		case !errors.Is(err, ErrCredentialNotFound):
+			slog.Error("Tenant credential unavailable", "tenant", tenant, "route", route.Name, "error", err)
+			return "", CredentialSourceTenant, err
+		}
+	}
+	if route.RequireTenantCredential {
+		return "", CredentialSourceNone, fmt.Errorf("%w for route %s", ErrTenantCredentialRequired, route.Name)
+	}
+	if p.secrets == nil {
+		return "", CredentialSourceNone, nil
+	}
+	if value, ok := p.secrets.Get(route.Name); ok {
+		return value, CredentialSourceRoute, nil
+	}
+	return "", CredentialSourceNone, nil
+}
+
 // saveLog 保存日志（同步）
 func (p *Proxy) saveLog(ctx *RequestContext, requestBody string) {
 	log := ctx.ToStreamLog(requestBody)
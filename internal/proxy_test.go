@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newRouteProxy 创建只有一个路由的 Proxy，路由的 upstream 指向 upstream
+func newRouteProxy(t *testing.T, upstream http.Handler, route RouteConfig) *Proxy {
+	t.Helper()
+
+	up := httptest.NewServer(upstream)
+	t.Cleanup(up.Close)
+
+	route.Upstream = up.URL
+	cfg := &Config{
+		Server: ServerConfig{Timeout: 5 * time.Second},
+		Routes: []RouteConfig{route},
+	}
+	return NewProxy(cfg, nil, getTestMetrics(), nil, NewSecretManager(cfg), nil)
+}
+
+// responsesRoute Responses API 的 SSE 路由
+var responsesRoute = RouteConfig{Name: "responses", Path: "/v1/responses", Kind: "sse"}
+
+func TestProxy_ResponsesStream(t *testing.T) {
+	// response.created arrives well before any text; TTFT must wait for the first delta
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"usage\":null}}\n\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Hi\"}\n\n"))
+		w.Write([]byte("event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"usage\":{\"input_tokens\":11,\"output_tokens\":7,\"total_tokens\":18}}}\n\n"))
+	})
+	p := newRouteProxy(t, upstream, responsesRoute)
+
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(`{"model":"gpt-4o","input":"Hi"}`)), ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if ctx.TTFTMs == nil || *ctx.TTFTMs < 50 {
+		t.Errorf("expected TTFT at the first text delta, got %v", ctx.TTFTMs)
+	}
+	if ctx.Usage == nil || ctx.Usage.InputTokens != 11 || ctx.Usage.OutputTokens != 7 {
+		t.Errorf("unexpected usage: %+v", ctx.Usage)
+	}
+	if ctx.ErrorType != "" {
+		t.Errorf("expected no error, got %s: %s", ctx.ErrorType, ctx.ErrorMessage)
+	}
+	if !strings.Contains(rec.Body.String(), "event: response.output_text.delta\n") {
+		t.Errorf("expected typed events to pass through: %s", rec.Body.String())
+	}
+}
+
+func TestProxy_ResponsesStreamFailed(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"error\":null}}\n\n"))
+		w.Write([]byte("event: response.failed\ndata: {\"type\":\"response.failed\",\"response\":{\"id\":\"resp_1\",\"error\":{\"code\":\"server_error\",\"message\":\"The model failed\"}}}\n\n"))
+	})
+	p := newRouteProxy(t, upstream, responsesRoute)
+
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/responses", strings.NewReader(`{}`)), ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if ctx.TTFTMs != nil {
+		t.Error("expected no TTFT without any output")
+	}
+	if ctx.StatusCode != http.StatusOK || ctx.ErrorType != "upstream_error" || ctx.ErrorMessage != "server_error: The model failed" {
+		t.Errorf("expected failed response to be logged, got %d %s %q", ctx.StatusCode, ctx.ErrorType, ctx.ErrorMessage)
+	}
+}
+
+func TestProxy_NilSecretManager(t *testing.T) {
+	var upstreamAuth string
+	p := newRouteProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamAuth = r.Header.Get("Authorization")
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	}), RouteConfig{Name: "chat", Path: "/v1/chat/completions", Kind: "sse", AuthHeader: "Authorization"})
+	p.secrets = nil
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, req, &RequestContext{}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if rec.Code != http.StatusOK || upstreamAuth != "" {
+		t.Errorf("expected request without route credential, got %d %q", rec.Code, upstreamAuth)
+	}
+}
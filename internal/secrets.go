@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultSecretRefreshInterval 未配置 refresh_interval 时重新读取密钥的间隔
+	defaultSecretRefreshInterval = 30 * time.Second
+	// defaultVaultField 引用中没有 #field 时读取的字段
+	defaultVaultField = "value"
+	// maxVaultResponseSize Vault 响应的最大字节数
+	maxVaultResponseSize = 1 << 20
+)
+
+// ErrSecretNotFound 密钥不存在或为空
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider 上游凭证的来源，ref 是去掉 "<scheme>:" 前缀后的引用
+type SecretProvider interface {
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// parseSecretRef 解析 "env:NAME" / "file:/path" / "vault:path#field" 形式的引用
+func parseSecretRef(ref string) (string, string, error) {
+	scheme, value, ok := strings.Cut(ref, ":")
+	if !ok || value == "" {
+		return "", "", fmt.Errorf("invalid secret reference %q (want <scheme>:<ref>)", ref)
+	}
+	switch scheme {
+	case "env", "file", "vault":
+		return scheme, value, nil
+	default:
+		return "", "", fmt.Errorf("unknown secret provider %q (must be env, file or vault)", scheme)
+	}
+}
+
+// envSecretProvider 从环境变量读取
+type envSecretProvider struct{}
+
+func (envSecretProvider) Get(_ context.Context, name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", fmt.Errorf("%w: environment variable %s is not set", ErrSecretNotFound, name)
+	}
+	return value, nil
+}
+
+// fileSecretProvider 从文件读取（Docker / Kubernetes 挂载的 secret），去掉首尾空白
+// 不监听文件变化，而是每次刷新（refresh_interval，默认 30 秒）重新读取，挂载的文件被轮换后下一次刷新生效
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Get(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s does not exist", ErrSecretNotFound, path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", path, err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("%w: %s is empty", ErrSecretNotFound, path)
+	}
+	return value, nil
+}
+
+// vaultSecretProvider 通过 HTTP API 读取 Vault 兼容的 KV 存储
+// ref 为 "<path>#<field>"，例如 "secret/data/openai#api_key"，同时支持 KV v1 和 v2 的响应格式
+type vaultSecretProvider struct {
+	config *VaultConfig
+	client *http.Client
+}
+
+func (p *vaultSecretProvider) Get(ctx context.Context, ref string) (string, error) {
+	path, field, _ := strings.Cut(ref, "#")
+	if field == "" {
+		field = defaultVaultField
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", p.config.Token)
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", fmt.Errorf("%w: vault path %s", ErrSecretNotFound, path)
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault returned %d for %s", resp.StatusCode, path)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxVaultResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("decode vault response: %w", err)
+	}
+	// KV v2 把字段放在 data.data 中
+	data := body.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, isMetadata := data["metadata"]; isMetadata {
+			data = nested
+		}
+	}
+	value, _ := data[field].(string)
+	if value == "" {
+		return "", fmt.Errorf("%w: vault path %s has no field %s", ErrSecretNotFound, path, field)
+	}
+	return value, nil
+}
+
+// SecretManager 解析并缓存各路由的上游凭证
+// 启动时 Load 一次性解析全部引用，缺失时返回错误；之后按 refresh_interval 定期重新读取，
+// 读取失败时保留旧值，转发时只查内存
+type SecretManager struct {
+	routes    []RouteConfig
+	providers map[string]SecretProvider
+	interval  time.Duration
+
+	values   atomic.Pointer[map[string]string]
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+}
+
+// NewSecretManager 创建密钥管理器，需要调用 Load 加载
+func NewSecretManager(config *Config) *SecretManager {
+	interval := config.Secrets.RefreshInterval
+	if interval <= 0 {
+		interval = defaultSecretRefreshInterval
+	}
+	m := &SecretManager{
+		routes:   config.Routes,
+		interval: interval,
+		providers: map[string]SecretProvider{
+			"env":  envSecretProvider{},
+			"file": fileSecretProvider{},
+		},
+	}
+	if config.Secrets.Vault.Addr != "" {
+		m.providers["vault"] = &vaultSecretProvider{
+			config: &config.Secrets.Vault,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	m.values.Store(&map[string]string{})
+	return m
+}
+
+// Get 返回路由的上游凭证
+func (m *SecretManager) Get(route string) (string, bool) {
+	value, ok := (*m.values.Load())[route]
+	return value, ok
+}
+
+// Load 读取全部路由的凭证，返回所有读取失败的路由（合并为一个错误），成功的部分仍然生效
+func (m *SecretManager) Load(ctx context.Context) error {
+	current := *m.values.Load()
+	values := make(map[string]string, len(m.routes))
+	var errs []error
+
+	for _, route := range m.routes {
+		ref := route.SecretRef()
+		if ref == "" {
+			continue
+		}
+		value, err := m.resolve(ctx, ref)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("route %s: %w", route.Name, err))
+			// 刷新失败时保留旧值，避免临时故障导致请求不带凭证
+			if old, ok := current[route.Name]; ok {
+				values[route.Name] = old
+			}
+			continue
+		}
+		if old, ok := current[route.Name]; ok && old != value {
+			slog.Info("Upstream secret rotated", "route", route.Name)
+		}
+		values[route.Name] = value
+	}
+
+	m.values.Store(&values)
+	return errors.Join(errs...)
+}
+
+// resolve 按引用的 scheme 选择 provider 读取
+func (m *SecretManager) resolve(ctx context.Context, ref string) (string, error) {
+	scheme, value, err := parseSecretRef(ref)
+	if err != nil {
+		return "", err
+	}
+	provider, ok := m.providers[scheme]
+	if !ok {
+		return "", fmt.Errorf("secret provider %s is not configured", scheme)
+	}
+	return provider.Get(ctx, value)
+}
+
+// Start 开始定期刷新
+func (m *SecretManager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	go m.refresh(ctx)
+}
+
+// Stop 停止刷新
+func (m *SecretManager) Stop() {
+	m.stopOnce.Do(func() {
+		if m.cancel != nil {
+			m.cancel()
+		}
+	})
+}
+
+// refresh 定时重新读取凭证
+func (m *SecretManager) refresh(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Load(ctx); err != nil && ctx.Err() == nil {
+				slog.Warn("Failed to refresh upstream secrets, keeping current", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestSecretManager 创建只有给定路由的密钥管理器
+func newTestSecretManager(routes []RouteConfig, secrets SecretsConfig) *SecretManager {
+	return NewSecretManager(&Config{Routes: routes, Secrets: secrets})
+}
+
+func TestSecretManager_EnvAndFile(t *testing.T) {
+	t.Setenv("TEST_SECRET_OPENAI", "sk-env")
+	path := filepath.Join(t.TempDir(), "anthropic")
+	if err := os.WriteFile(path, []byte("sk-file-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestSecretManager([]RouteConfig{
+		{Name: "openai", AuthEnv: "TEST_SECRET_OPENAI"},
+		{Name: "anthropic", AuthSecret: "file:" + path},
+		{Name: "public"},
+	}, SecretsConfig{})
+	if err := m.Load(context.Background()); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if value, ok := m.Get("openai"); !ok || value != "sk-env" {
+		t.Errorf("expected env secret, got %q %v", value, ok)
+	}
+	if value, ok := m.Get("anthropic"); !ok || value != "sk-file-1" {
+		t.Errorf("expected trimmed file secret, got %q %v", value, ok)
+	}
+	if _, ok := m.Get("public"); ok {
+		t.Error("expected no secret for route without reference")
+	}
+
+	// Rotated file is picked up on the next load
+	if err := os.WriteFile(path, []byte("sk-file-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load(context.Background()); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if value, _ := m.Get("anthropic"); value != "sk-file-2" {
+		t.Errorf("expected rotated secret, got %q", value)
+	}
+}
+
+func TestSecretManager_Missing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "openai")
+	if err := os.WriteFile(path, []byte("sk-file"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newTestSecretManager([]RouteConfig{
+		{Name: "openai", AuthSecret: "file:" + path},
+		{Name: "typo", AuthEnv: "TEST_SECRET_DOES_NOT_EXIST"},
+	}, SecretsConfig{})
+
+	err := m.Load(context.Background())
+	if !errors.Is(err, ErrSecretNotFound) || !strings.Contains(err.Error(), "route typo") {
+		t.Fatalf("expected missing secret error naming the route, got %v", err)
+	}
+	if _, ok := m.Get("openai"); !ok {
+		t.Error("expected resolved secrets to be usable despite other failures")
+	}
+
+	// A failed refresh keeps the previous value
+	os.Remove(path)
+	if err := m.Load(context.Background()); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("expected ErrSecretNotFound, got %v", err)
+	}
+	if value, _ := m.Get("openai"); value != "sk-file" {
+		t.Errorf("expected previous secret to be kept, got %q", value)
+	}
+}
+
+func TestSecretManager_Vault(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/openai": // KV v2
+			w.Write([]byte(`{"data":{"data":{"api_key":"sk-vault-v2"},"metadata":{"version":3}}}`))
+		case "/v1/kv/anthropic": // KV v1
+			w.Write([]byte(`{"data":{"value":"sk-vault-v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+
+	m := newTestSecretManager([]RouteConfig{
+		{Name: "openai", AuthSecret: "vault:secret/data/openai#api_key"},
+		{Name: "anthropic", AuthSecret: "vault:kv/anthropic"},
+	}, SecretsConfig{Vault: VaultConfig{Addr: vault.URL, Token: "root-token"}})
+	if err := m.Load(context.Background()); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if value, _ := m.Get("openai"); value != "sk-vault-v2" {
+		t.Errorf("expected KV v2 secret, got %q", value)
+	}
+	if value, _ := m.Get("anthropic"); value != "sk-vault-v1" {
+		t.Errorf("expected KV v1 secret, got %q", value)
+	}
+
+	missing := newTestSecretManager([]RouteConfig{
+		{Name: "openai", AuthSecret: "vault:secret/data/openai#nope"},
+		{Name: "gone", AuthSecret: "vault:secret/data/gone"},
+	}, SecretsConfig{Vault: VaultConfig{Addr: vault.URL, Token: "root-token"}})
+	if err := missing.Load(context.Background()); !errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected ErrSecretNotFound, got %v", err)
+	}
+
+	forbidden := newTestSecretManager([]RouteConfig{
+		{Name: "openai", AuthSecret: "vault:secret/data/openai#api_key"},
+	}, SecretsConfig{Vault: VaultConfig{Addr: vault.URL, Token: "wrong"}})
+	if err := forbidden.Load(context.Background()); err == nil || errors.Is(err, ErrSecretNotFound) {
+		t.Errorf("expected vault status error, got %v", err)
+	}
+}
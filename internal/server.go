@@ -1,26 +1,49 @@
 package internal
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// statusClientClosedRequest 客户端在响应之前断开（沿用 nginx 的 499），只出现在访问日志中
+const statusClientClosedRequest = 499
+
 // Server HTTP 服务器
 type Server struct {
 	config  *Config
 	proxy   *Proxy
 	limiter *RateLimiter
+	keys    *KeyManager
+	jwt     *JWTVerifier       // 未开启 JWT 鉴权时为 nil
+	tokens  *ClientTokenIssuer // 未开启客户端 Token 时为 nil
+	cors    *corsPolicies      // 未开启跨域时为 nil
 	engine  *gin.Engine
+	http    *http.Server
+
+	clientIP     *clientIPResolver
+	tenantCIDRs  map[string][]netip.Prefix // 租户级允许网段
+	authFailures *authFailures             // 按客户端 IP 限制 Key 校验失败的频率
+
+	done     chan struct{} // 关闭时停止证书热更新
+	stopOnce sync.Once
 }
 
 // NewServer 创建服务器
-func NewServer(config *Config, proxy *Proxy, limiter *RateLimiter) *Server {
+func NewServer(config *Config, proxy *Proxy, limiter *RateLimiter, keys *KeyManager) *Server {
 	// 设置 Gin 模式
 	if config.Observability.Logging.Level != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -33,7 +56,29 @@ func NewServer(config *Config, proxy *Proxy, limiter *RateLimiter) *Server {
 		config:  config,
 		proxy:   proxy,
 		limiter: limiter,
+		keys:    keys,
 		engine:  engine,
+		http: &http.Server{
+			Addr:    fmt.Sprintf(":%d", config.Server.Port),
+			Handler: engine,
+		},
+		done:         make(chan struct{}),
+		clientIP:     newClientIPResolver(&config.Server.ClientIP),
+		tenantCIDRs:  make(map[string][]netip.Prefix),
+		authFailures: newAuthFailures(),
+	}
+	for tenant, t := range config.Auth.Tenants {
+		s.tenantCIDRs[tenant] = mustParseCIDRs(t.AllowedCIDRs)
+	}
+	if config.Auth.JWT.Enabled {
+		s.jwt = NewJWTVerifier(&config.Auth.JWT, &http.Client{Timeout: 10 * time.Second})
+	}
+	if config.Auth.ClientTokens.Enabled {
+		s.tokens = NewClientTokenIssuer(&config.Auth.ClientTokens, keys.ActiveKey)
+	}
+	if config.Server.CORS.Enabled {
+		s.cors = newCORSPolicies(config, keys)
+		engine.Use(s.handleCORS)
 	}
 
 	s.setupRoutes()
@@ -51,59 +96,414 @@ func (s *Server) setupRoutes() {
 	s.engine.GET("/healthz", s.handleHealth)
 	s.engine.GET("/readyz", s.handleReady)
 
+	// 管理 API
+	if s.config.Auth.Admin.Enabled {
+		s.setupAdminRoutes()
+	}
+
+	// 签发客户端 Token
+	if s.tokens != nil {
+		s.engine.POST("/auth/tokens", s.handleIssueToken)
+	}
+
 	// 代理路由 - 使用 NoRoute 处理所有未匹配的请求
 	s.engine.NoRoute(s.handleProxy)
 }
 
 // handleProxy 处理代理请求
 func (s *Server) handleProxy(c *gin.Context) {
-	// 1. 鉴权
-	if !s.authenticate(c) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "unauthorized",
+	// 1. 鉴权：租户来自 Key，不信任 X-Tenant-ID
+	principal, err := s.authenticate(c)
+	if err != nil {
+		c.JSON(authErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !s.checkClientIP(c, principal) || !s.checkOrigin(c, principal) {
+		return
+	}
+
+	// 2. 路由匹配与访问范围检查
+	route := s.config.GetRouteByPath(c.Request.URL.Path)
+	if route == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "route not found",
+		})
+		return
+	}
+	if !principal.AllowsRoute(route.Name) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "api key is not allowed to access this route",
+		})
+		return
+	}
+	// voice 路由同时检查它引用的 LLM 和 TTS 路由：访问范围、限流、TPM 和并发上限与直接请求这些路由一致
+	routes := []*RouteConfig{route}
+	if route.Kind == "voice" {
+		routes = append(routes, s.config.GetRouteByName(route.Voice.LLMRoute), s.config.GetRouteByName(route.Voice.TTSRoute))
+	}
+	names := make([]string, len(routes))
+	for i, r := range routes {
+		if !principal.AllowsRoute(r.Name) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "api key is not allowed to access route " + r.Name + " used by this route",
+			})
+			return
+		}
+		names[i] = r.Name
+	}
+
+	// 3. 限流
+	scope := LimitScope{TenantID: principal.TenantID, Tier: principal.Tier, Route: route.Name}
+	scopes := s.limiter.Scopes(scope, names...)
+	for _, sc := range scopes {
+		if !s.limiter.Allow(sc) {
+			// 两次调用之间热更新可能关闭了限流，此时 status 为 nil
+			retryAfter := time.Second
+			status := s.limiter.Status(sc)
+			if status != nil {
+				retryAfter = status.RetryAfter
+			}
+			s.rejectRateLimited(c, status, retryAfter, "rate limit exceeded")
+			return
+		}
+	}
+
+	// 4. 读取请求体：检查模型，按估算 token 预扣 TPM 配额
+	// multipart 上传（语音转写）不缓存请求体，模型由 proxy 在转发时从表单字段中检查
+	var body []byte
+	if route.Kind == "multipart" {
+		if _, err := multipartBoundary(c.Request.Header.Get("Content-Type")); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	} else {
+		body, err = io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "read request body failed",
+			})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	// voice 路由的请求体是补全请求，proxy 需要改写其中的 stream 字段
+	if route.Kind == "voice" && !isJSONObject(body) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "JSON request body required",
 		})
 		return
 	}
 
-	// 2. 限流
-	tenantID := c.GetHeader("X-Tenant-ID")
-	if tenantID == "" {
-		tenantID = "default"
+	model := requestModel(body)
+	switch {
+	case route.Provider == ProviderGemini:
+		// Gemini 的模型在路径中
+		model = geminiModel(c.Request.URL.Path)
+	case route.bedrock():
+		// Bedrock 的模型在路径中：/model/{id}/invoke...
+		model = bedrockModel(c.Request.URL.EscapedPath())
+	}
+	// multipart 的模型在表单字段中，由 proxy 在转发时检查
+	if route.Kind != "multipart" && !principal.AllowsModel(model) {
+		message := "api key is not allowed to use model " + model
+		if model == "" {
+			message = "api key is restricted to specific models, but the request does not name one"
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": message,
+		})
+		return
 	}
 
-	if !s.limiter.Allow(tenantID) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
-			"error": "rate limit exceeded",
+	estimated := estimateTokens(body)
+	if err := s.reserveBudget(principal, estimated); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
+	reserved := make([]int64, 0, len(scopes))
+	for _, sc := range scopes {
+		n, ok := s.limiter.ReserveTokens(sc, estimated)
+		if !ok {
+			s.reconcileTokens(scopes, reserved, 0)
+			s.reconcileBudget(principal, estimated, 0)
+			retryAfter := s.limiter.TokensRetryAfter(sc, estimated)
+			s.rejectRateLimited(c, s.limiter.Status(sc), retryAfter, "token rate limit exceeded")
+			return
+		}
+		reserved = append(reserved, n)
+	}
+
+	// 5. 并发流限制（超过上限时排队等待）
+	release, err := s.limiter.AcquireStream(c.Request.Context(), scope, routes...)
+	if err != nil {
+		s.reconcileBudget(principal, estimated, 0)
+		s.reconcileTokens(scopes, reserved, 0)
+		// 客户端在排队期间断开不算限流拒绝
+		if errors.Is(err, context.Canceled) {
+			c.AbortWithStatus(statusClientClosedRequest)
+			return
+		}
+		s.rejectRateLimited(c, s.limiter.Status(scope), time.Second, err.Error())
+		return
+	}
+	defer release()
+
+	// 限流头先写入，proxy 复制上游响应头时不会覆盖
+	setRateLimitHeaders(c.Writer.Header(), s.limiter.Status(scope))
 
-	// 3. 转发
-	if err := s.proxy.Handle(c.Writer, c.Request); err != nil {
+	// 6. 转发
+	ctx := NewRequestContext(principal)
+	ctx.Model = model
+	if err := s.proxy.Handle(c.Writer, c.Request, ctx); err != nil {
 		// 错误已经在 proxy.Handle 中记录
 		if !c.Writer.Written() {
-			c.JSON(http.StatusBadGateway, gin.H{
+			status := http.StatusBadGateway
+			switch {
+			case errors.Is(err, ErrModelNotAllowed), errors.Is(err, ErrTenantCredentialRequired):
+				status = http.StatusForbidden
+			case errors.Is(err, ErrInvalidTTSRequest):
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{
 				"error": err.Error(),
 			})
 		}
 	}
+
+	// 7. 按实际用量对账：上游未成功则全额退还，用量提取失败则保留预扣值
+	switch {
+	case ctx.StatusCode < 200 || ctx.StatusCode >= 300:
+		s.reconcileTokens(scopes, reserved, 0)
+		s.reconcileBudget(principal, estimated, 0)
+	case ctx.Usage != nil:
+		s.reconcileTokens(scopes, reserved, ctx.Usage.TotalTokens)
+		s.reconcileBudget(principal, estimated, ctx.Usage.TotalTokens)
+	}
+}
+
+// reconcileTokens 按实际用量对账各限流范围的 TPM 预扣，reserved 与 scopes 按顺序对应
+func (s *Server) reconcileTokens(scopes []LimitScope, reserved []int64, actual int64) {
+	for i, n := range reserved {
+		s.limiter.ReconcileTokens(scopes[i], n, actual)
+	}
+}
+
+// reserveBudget 从客户端 Token 的预算中预扣
+func (s *Server) reserveBudget(principal *Principal, estimated int64) error {
+	if s.tokens == nil {
+		return nil
+	}
+	return s.tokens.Reserve(principal, estimated, time.Now())
+}
+
+// reconcileBudget 按实际用量修正客户端 Token 的预算
+func (s *Server) reconcileBudget(principal *Principal, reserved, actual int64) {
+	if s.tokens != nil {
+		s.tokens.Reconcile(principal, reserved, actual)
+	}
+}
+
+// handleIssueToken 用真实 Key（或 JWT）换取短期客户端 Token
+func (s *Server) handleIssueToken(c *gin.Context) {
+	principal, err := s.authenticate(c)
+	if err != nil {
+		c.JSON(authErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if !s.checkClientIP(c, principal) || !s.checkOrigin(c, principal) {
+		return
+	}
+
+	var req ClientTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	token, expiresAt, err := s.tokens.Issue(principal, &req, time.Now())
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrClientTokenScope) {
+			status = http.StatusForbidden
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":      token,
+		"expires_at": expiresAt.UTC(),
+	})
+}
+
+// rejectRateLimited 返回 429，带上限流头和 Retry-After
+func (s *Server) rejectRateLimited(c *gin.Context, status *RateLimitStatus, retryAfter time.Duration, message string) {
+	setRateLimitHeaders(c.Writer.Header(), status)
+	c.Header("Retry-After", formatSeconds(max(retryAfter, time.Second)))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": message,
+	})
+}
+
+// setRateLimitHeaders 写入限流响应头，status 为 nil（限流关闭）时不写
+// 同时输出三套命名：
+//   - OpenAI 风格：x-ratelimit-{limit,remaining,reset}-{requests,tokens}，reset 为 "6m0s" 形式的时长
+//   - X-RateLimit-{Limit,Remaining,Reset}，reset 为秒数
+//   - IETF 草案：RateLimit-{Limit,Remaining,Reset} + RateLimit-Policy
+func setRateLimitHeaders(h http.Header, status *RateLimitStatus) {
+	if status == nil {
+		return
+	}
+
+	limit := strconv.Itoa(status.Limit)
+	remaining := strconv.Itoa(status.Remaining)
+	reset := formatSeconds(status.Reset)
+
+	h.Set("x-ratelimit-limit-requests", limit)
+	h.Set("x-ratelimit-remaining-requests", remaining)
+	h.Set("x-ratelimit-reset-requests", formatDuration(status.Reset))
+	if status.TokenLimit > 0 {
+		h.Set("x-ratelimit-limit-tokens", strconv.Itoa(status.TokenLimit))
+		h.Set("x-ratelimit-remaining-tokens", strconv.FormatInt(status.TokenRemaining, 10))
+		h.Set("x-ratelimit-reset-tokens", formatDuration(status.TokenReset))
+	}
+
+	h.Set("X-RateLimit-Limit", limit)
+	h.Set("X-RateLimit-Remaining", remaining)
+	h.Set("X-RateLimit-Reset", reset)
+
+	h.Set("RateLimit-Limit", limit)
+	h.Set("RateLimit-Remaining", remaining)
+	h.Set("RateLimit-Reset", reset)
+	h.Set("RateLimit-Policy", limit+";w=60")
+}
+
+// formatSeconds 向上取整的秒数
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// formatDuration OpenAI 风格的时长（精确到毫秒），例如 "1s"、"6m0s"、"250ms"
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// authenticate 鉴权，返回 Key、JWT 或客户端证书对应的身份
+// 带 Authorization 时以 Token 为准，没有时尝试已验证的客户端证书
+func (s *Server) authenticate(c *gin.Context) (*Principal, error) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" && c.Request.TLS != nil {
+		return certPrincipal(s.config.Server.TLS.Identities, c.Request.TLS)
+	}
+	if s.jwt != nil && isJWT(token) {
+		return s.jwt.Verify(token, time.Now())
+	}
+	if s.tokens != nil && isClientToken(token) {
+		return s.tokens.Verify(token, time.Now())
+	}
+
+	// 错误的 Key 可能触发 argon2id 计算，按客户端 IP 限制失败次数
+	now := time.Now()
+	client := s.clientIP.resolve(c.Request).String()
+	if s.authFailures.blocked(client, now) {
+		return nil, ErrTooManyAuthFailures
+	}
+	principal, err := s.keys.Lookup(token, now)
+	if errors.Is(err, ErrInvalidKey) {
+		s.authFailures.record(client, now)
+	}
+	return principal, err
+}
+
+// authErrorStatus 鉴权失败的状态码：失败次数过多返回 429，其余返回 401
+func authErrorStatus(err error) int {
+	if errors.Is(err, ErrTooManyAuthFailures) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusUnauthorized
+}
+
+// checkClientIP 检查来源 IP 是否在 Key 和租户允许的网段内，不在时返回 403 并记录日志和指标
+func (s *Server) checkClientIP(c *gin.Context, principal *Principal) bool {
+	tenantCIDRs := s.tenantCIDRs[principal.TenantID]
+	if len(principal.AllowedCIDRs) == 0 && len(tenantCIDRs) == 0 {
+		return true
+	}
+
+	ip := s.clientIP.resolve(c.Request)
+	if ip.IsValid() && containsIP(principal.AllowedCIDRs, ip) && containsIP(tenantCIDRs, ip) {
+		return true
+	}
+
+	slog.Warn("Request rejected by IP allowlist",
+		"key_id", principal.KeyID,
+		"tenant", principal.TenantID,
+		"ip", ip.String(),
+	)
+	s.proxy.metrics.RecordIPRejected(principal.TenantID)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "client ip is not allowed",
+	})
+	return false
 }
 
-// authenticate 鉴权
-func (s *Server) authenticate(c *gin.Context) bool {
-	auth := c.GetHeader("Authorization")
-	if auth == "" {
-		return false
+// handleCORS 跨域中间件：在鉴权之前应答预检请求，并让鉴权失败等错误响应对允许的来源可见
+func (s *Server) handleCORS(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		c.Next()
+		return
 	}
+	c.Writer.Header().Add("Vary", "Origin")
 
-	// Bearer token
-	token := strings.TrimPrefix(auth, "Bearer ")
-	for _, key := range s.config.Auth.APIKeys {
-		if token == key {
-			return true
+	policy := s.cors.preflight(origin)
+	if method := c.GetHeader("Access-Control-Request-Method"); c.Request.Method == http.MethodOptions && method != "" {
+		if policy == nil || !policy.allowsMethod(method) ||
+			!policy.allowsHeaders(c.GetHeader("Access-Control-Request-Headers")) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
 		}
+		policy.writePreflight(c.Writer.Header(), origin, int(s.config.Server.CORS.MaxAge.Seconds()))
+		c.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	if policy != nil {
+		writeCORSHeaders(c.Writer.Header(), origin)
+	}
+	c.Next()
+}
+
+// checkOrigin 鉴权后按 Key、租户或全局策略检查来源和方法，不允许时返回 403
+func (s *Server) checkOrigin(c *gin.Context, principal *Principal) bool {
+	origin := c.GetHeader("Origin")
+	if s.cors == nil || origin == "" {
+		return true
+	}
+
+	policy := s.cors.resolve(principal)
+	if policy.allowsOrigin(origin) && policy.allowsMethod(c.Request.Method) {
+		writeCORSHeaders(c.Writer.Header(), origin)
+		return true
 	}
 
+	c.Writer.Header().Del("Access-Control-Allow-Origin")
+	c.Writer.Header().Del("Access-Control-Expose-Headers")
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "origin is not allowed for this api key",
+	})
 	return false
 }
 
@@ -124,16 +524,34 @@ func (s *Server) handleReady(c *gin.Context) {
 	})
 }
 
-// Start 启动服务器
+// Start 启动服务器，开启 TLS 时同时启动证书热更新
 func (s *Server) Start() error {
-	addr := fmt.Sprintf(":%d", s.config.Server.Port)
-	fmt.Printf("Starting server on %s\n", addr)
-	return s.engine.Run(addr)
+	var err error
+	if tlsConfig := &s.config.Server.TLS; tlsConfig.Enabled {
+		certs, loadErr := newCertReloader(tlsConfig)
+		if loadErr != nil {
+			return loadErr
+		}
+		go certs.watch(s.done)
+
+		s.http.TLSConfig = certs.tlsConfig()
+		fmt.Printf("Starting server on %s (TLS, client_auth=%s)\n", s.http.Addr, tlsConfig.ClientAuth)
+		err = s.http.ListenAndServeTLS("", "")
+	} else {
+		fmt.Printf("Starting server on %s\n", s.http.Addr)
+		err = s.http.ListenAndServe()
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
 }
 
-// Shutdown 优雅关闭
+// Shutdown 优雅关闭：停止接受新连接，等待进行中的请求结束
 func (s *Server) Shutdown(ctx context.Context) error {
-	// Gin 没有内置 Shutdown，需要用标准库
-	// 这里简化处理
-	return nil
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+	return s.http.Shutdown(ctx)
 }
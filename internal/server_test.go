@@ -0,0 +1,536 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer 创建指向测试上游的 Server
+func newTestServer(t *testing.T, upstream http.Handler, mutate func(*Config)) *Server {
+	t.Helper()
+
+	up := httptest.NewServer(upstream)
+	t.Cleanup(up.Close)
+
+	cfg := &Config{
+		Server: ServerConfig{Port: 8080, Timeout: 5 * time.Second},
+		Routes: []RouteConfig{
+			{Name: "chat", Path: "/v1/chat", Upstream: up.URL, Kind: "sse"},
+		},
+		RateLimit: RateLimitConfig{Enabled: true, Default: 60, Burst: 2},
+		Auth:      AuthConfig{APIKeys: []string{"sk-test"}},
+	}
+	if mutate != nil {
+		mutate(cfg)
+	}
+
+	metrics := getTestMetrics()
+	keys := NewKeyManager(cfg, newMemoryKeyStore())
+	t.Cleanup(keys.Stop)
+	var credentials *CredentialVault
+	if cfg.Credentials.Enabled {
+		var err error
+		credentials, err = NewCredentialVault(cfg, newMemoryCredentialStore())
+		if err != nil {
+			t.Fatalf("NewCredentialVault: %v", err)
+		}
+		t.Cleanup(credentials.Stop)
+	}
+	secrets := NewSecretManager(cfg)
+	if err := secrets.Load(context.Background()); err != nil {
+		t.Fatalf("SecretManager.Load: %v", err)
+	}
+	return NewServer(cfg, NewProxy(cfg, nil, metrics, credentials, secrets, nil), NewRateLimiter(&cfg.RateLimit, metrics), keys)
+}
+
+// doRequest 发送请求并返回响应
+func doRequest(s *Server, method, path, apiKey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	return rec
+}
+
+// sseUpstream 返回固定 SSE 流的测试上游
+func sseUpstream(events ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Ratelimit-Remaining-Requests", "9999")
+		for _, event := range events {
+			io.WriteString(w, event+"\n\n")
+		}
+	})
+}
+
+func TestServer_RateLimitHeaders(t *testing.T) {
+	s := newTestServer(t, sseUpstream(`data: {"choices":[]}`, "data: [DONE]"), nil)
+
+	rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{"model":"m"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	h := rec.Header()
+	if got := h.Get("x-ratelimit-limit-requests"); got != "60" {
+		t.Errorf("expected x-ratelimit-limit-requests 60, got %q", got)
+	}
+	if got := h.Get("x-ratelimit-remaining-requests"); got != "1" {
+		t.Errorf("expected relay remaining 1 (not upstream's), got %q", got)
+	}
+	if got := h.Get("RateLimit-Policy"); got != "60;w=60" {
+		t.Errorf("expected RateLimit-Policy 60;w=60, got %q", got)
+	}
+	for _, name := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"} {
+		if h.Get(name) == "" {
+			t.Errorf("expected %s header", name)
+		}
+	}
+	if h.Get("Retry-After") != "" {
+		t.Error("Retry-After should only be sent on 429")
+	}
+}
+
+func TestServer_UpstreamRateLimitHeadersWithoutRelayLimits(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.RateLimit.Enabled = false
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`)
+	if got := rec.Header().Get("x-ratelimit-remaining-requests"); got != "9999" {
+		t.Errorf("expected upstream rate limit headers to pass through when relay limits are off, got %q", got)
+	}
+}
+
+func TestServer_RateLimitExceeded(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), nil)
+
+	doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`)
+	doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`)
+	rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`)
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("expected RateLimit-Remaining 0, got %q", got)
+	}
+}
+
+func TestServer_TokenRateLimitExceeded(t *testing.T) {
+	s := newTestServer(t, sseUpstream(`data: {"usage":{"prompt_tokens":10,"completion_tokens":990}}`), func(cfg *Config) {
+		cfg.RateLimit.Burst = 10
+		cfg.RateLimit.TokensPerMinute = 600
+	})
+
+	// Actual usage (1000) exceeds the budget - tenant goes into debt
+	rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{"max_tokens":10}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	rec = doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{"max_tokens":10}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	retryAfter := rec.Header().Get("Retry-After")
+	if retryAfter == "" || retryAfter == "1" {
+		t.Errorf("expected Retry-After based on token debt, got %q", retryAfter)
+	}
+	if got := rec.Header().Get("x-ratelimit-remaining-tokens"); got != "0" {
+		t.Errorf("expected 0 tokens remaining, got %q", got)
+	}
+}
+
+func TestServer_QueueClientDisconnect(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.RateLimit.Burst = 10
+		cfg.RateLimit.TokensPerMinute = 600
+		cfg.RateLimit.MaxConcurrentStreams = 1
+		cfg.RateLimit.QueueSize = 1
+		cfg.RateLimit.QueueTimeout = 5 * time.Second
+	})
+
+	// 占住唯一的并发名额，下一个请求进入队列
+	release, err := s.limiter.AcquireStream(context.Background(), LimitScope{TenantID: defaultTenantID, Route: "chat"}, &s.config.Routes[0])
+	if err != nil {
+		t.Fatalf("AcquireStream: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"max_tokens":500}`)).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer sk-test")
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("expected a disconnect not to be reported as rate limited, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 断开的请求预扣的 token 已退还
+	release()
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{"max_tokens":500}`); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 after the disconnect, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestServer_TenantFromKey(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.Auth.Keys = []APIKeyConfig{
+			{ID: "k1", Key: "sk-acme", TenantID: "acme"},
+		}
+	})
+
+	// Spoofed tenant header must not give the key a fresh bucket
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer sk-acme")
+		req.Header.Set("X-Tenant-ID", "other-"+strconv.Itoa(i))
+		rec := httptest.NewRecorder()
+		s.engine.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-acme", `{}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 for tenant acme, got %d", rec.Code)
+	}
+
+	// Legacy keys share the default tenant, separate from acme
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for default tenant, got %d", rec.Code)
+	}
+}
+
+func TestServer_AuthFailureLimit(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+	})
+
+	for i := 0; i < authFailureBurst; i++ {
+		if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-relay-k1.guess", `{}`); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected 401, got %d", i, rec.Code)
+		}
+	}
+	// 失败次数用完后直接拒绝，不再查找 Key
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-relay-k1.guess", `{}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 after repeated failures, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the client to stay blocked, got %d", rec.Code)
+	}
+
+	// 其他客户端不受影响
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+	req.RemoteAddr = "198.51.100.7:1234"
+	req.Header.Set("Authorization", "Bearer sk-test")
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected another client to authenticate, got %d", rec.Code)
+	}
+}
+
+func TestServer_KeyScope(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Routes = append(cfg.Routes, RouteConfig{Name: "tts", Path: "/v1/tts", Upstream: cfg.Routes[0].Upstream, Kind: "sse"})
+		cfg.Auth.Keys = []APIKeyConfig{
+			{ID: "k1", Key: "sk-scoped", TenantID: "acme", Routes: []string{"chat"}, Models: []string{"gpt-4o*"}},
+			{ID: "k2", Key: "sk-expired", TenantID: "acme", ExpiresAt: time.Now().Add(-time.Minute)},
+		}
+	})
+
+	tests := []struct {
+		name   string
+		key    string
+		path   string
+		body   string
+		status int
+	}{
+		{"allowed", "sk-scoped", "/v1/chat/completions", `{"model":"gpt-4o-mini"}`, http.StatusOK},
+		{"route not allowed", "sk-scoped", "/v1/tts", `{}`, http.StatusForbidden},
+		{"model not allowed", "sk-scoped", "/v1/chat/completions", `{"model":"claude-3"}`, http.StatusForbidden},
+		{"expired key", "sk-expired", "/v1/chat/completions", `{}`, http.StatusUnauthorized},
+		{"unknown key", "sk-nope", "/v1/chat/completions", `{}`, http.StatusUnauthorized},
+		{"unknown route", "sk-scoped", "/v2/other", `{}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := doRequest(s, http.MethodPost, tt.path, tt.key, tt.body)
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_JWTAuth(t *testing.T) {
+	idp := newTestIdP(t)
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.Auth.JWT = JWTConfig{
+			Enabled:  true,
+			JWKSURL:  idp.server.URL,
+			Issuer:   "https://idp.example.com",
+			Audience: "stream-relay",
+		}
+	})
+
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", idp.sign(t, "RS256", "rsa-1", testClaims()), `{"model":"gpt-4o"}`); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for valid JWT, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Scope from the token is enforced
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", idp.sign(t, "RS256", "rsa-1", testClaims()), `{"model":"claude-3"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for model outside scope, got %d", rec.Code)
+	}
+
+	claims := testClaims()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", idp.sign(t, "RS256", "rsa-1", claims), `{}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for expired JWT, got %d", rec.Code)
+	}
+
+	// Static API keys keep working
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 for API key, got %d", rec.Code)
+	}
+}
+
+func TestServer_ClientTokens(t *testing.T) {
+	s := newTestServer(t, sseUpstream(`data: {"usage":{"prompt_tokens":40,"completion_tokens":60}}`), func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Auth.ClientTokens = ClientTokenConfig{Enabled: true, Secret: strings.Repeat("s", 32)}
+	})
+
+	if rec := doRequest(s, http.MethodPost, "/auth/tokens", "", `{}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a key, got %d", rec.Code)
+	}
+
+	rec := doRequest(s, http.MethodPost, "/auth/tokens", "sk-test", `{"routes":["chat"],"max_tokens":150,"ttl":"5m"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	// First request uses 100 of the 150 token budget
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", resp.Token, `{"max_tokens":50}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", resp.Token, `{"max_tokens":100}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 once the budget is exhausted, got %d", rec.Code)
+	}
+
+	if rec := doRequest(s, http.MethodPost, "/auth/tokens", resp.Token, `{}`); rec.Code != http.StatusForbidden {
+		t.Errorf("expected client token to be unable to mint tokens, got %d", rec.Code)
+	}
+}
+
+func TestServer_IPAllowlist(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Server.ClientIP = ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}}
+		cfg.Auth.Keys = []APIKeyConfig{
+			{ID: "office", Key: "sk-office", TenantID: "acme", AllowedCIDRs: []string{"198.51.100.0/24"}},
+			{ID: "open", Key: "sk-open", TenantID: "acme"},
+			{ID: "other", Key: "sk-other", TenantID: "globex"},
+		}
+		cfg.Auth.Tenants = map[string]TenantAuthConfig{
+			"acme": {AllowedCIDRs: []string{"198.51.100.0/24", "203.0.113.0/24"}},
+		}
+	})
+
+	tests := []struct {
+		name       string
+		key        string
+		remoteAddr string
+		forwarded  string
+		status     int
+	}{
+		{"key allowlist", "sk-office", "198.51.100.7:1234", "", http.StatusOK},
+		{"outside key allowlist", "sk-office", "203.0.113.7:1234", "", http.StatusForbidden},
+		{"tenant allowlist", "sk-open", "203.0.113.7:1234", "", http.StatusOK},
+		{"outside tenant allowlist", "sk-open", "192.0.2.1:1234", "", http.StatusForbidden},
+		{"via trusted proxy", "sk-office", "10.0.0.5:1234", "198.51.100.7", http.StatusOK},
+		{"spoofed forwarded-for", "sk-office", "192.0.2.1:1234", "198.51.100.7", http.StatusForbidden},
+		{"unrestricted tenant", "sk-other", "192.0.2.1:1234", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{}`))
+			req.Header.Set("Authorization", "Bearer "+tt.key)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rec := httptest.NewRecorder()
+			s.engine.ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_CORS(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	})
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Server.CORS = CORSConfig{Enabled: true, MaxAge: 10 * time.Minute}
+		cfg.Auth.Keys = []APIKeyConfig{
+			{ID: "web", Key: "sk-web", TenantID: "acme", CORS: CORSPolicy{AllowedOrigins: []string{"https://app.acme.io"}}},
+			{ID: "backend", Key: "sk-backend", TenantID: "acme"},
+		}
+	})
+
+	request := func(method, key, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/chat/completions", strings.NewReader(`{}`))
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		req.Header.Set("Origin", origin)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		s.engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Preflight is answered without credentials
+	rec := request(http.MethodOptions, "", "https://app.acme.io", map[string]string{
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "authorization, content-type",
+	})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for preflight, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.acme.io" {
+		t.Errorf("expected allowed origin, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Errorf("expected max age 600, got %q", got)
+	}
+
+	// 方法不区分大小写
+	rec = request(http.MethodOptions, "", "https://app.acme.io", map[string]string{"Access-Control-Request-Method": "post"})
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected lower-case method to be allowed, got %d", rec.Code)
+	}
+
+	rec = request(http.MethodOptions, "", "https://evil.example.com", map[string]string{"Access-Control-Request-Method": "POST"})
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected preflight from unknown origin to be rejected, got %d", rec.Code)
+	}
+
+	// Actual request exposes the request ID and rate limit headers; upstream CORS headers are dropped
+	rec = request(http.MethodPost, "sk-web", "https://app.acme.io", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://app.acme.io" {
+		t.Errorf("expected allowed origin, got %q", got)
+	}
+	exposed := rec.Header().Get("Access-Control-Expose-Headers")
+	if !strings.Contains(exposed, "X-Request-ID") || !strings.Contains(exposed, "x-ratelimit-remaining-requests") {
+		t.Errorf("expected request ID and rate limit headers to be exposed, got %q", exposed)
+	}
+
+	// Key without a browser policy cannot be used from that origin
+	rec = request(http.MethodPost, "sk-backend", "https://app.acme.io", nil)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected 403 without CORS headers, got %d", rec.Code)
+	}
+
+	// Auth errors stay readable by allowed origins
+	rec = request(http.MethodPost, "sk-nope", "https://app.acme.io", nil)
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Access-Control-Allow-Origin") == "" {
+		t.Errorf("expected readable 401, got %d", rec.Code)
+	}
+
+	// Requests without Origin are unaffected
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-backend", `{}`); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 without Origin, got %d", rec.Code)
+	}
+}
+
+func TestServer_ClientTokenCORS(t *testing.T) {
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.RateLimit.Burst = 100
+		cfg.Server.CORS = CORSConfig{Enabled: true}
+		cfg.Auth.ClientTokens = ClientTokenConfig{Enabled: true, Secret: strings.Repeat("s", 32)}
+		cfg.Auth.Keys = []APIKeyConfig{
+			{ID: "web", Key: "sk-web", TenantID: "acme", CORS: CORSPolicy{AllowedOrigins: []string{"https://app.acme.io"}}},
+		}
+	})
+
+	rec := doRequest(s, http.MethodPost, "/auth/tokens", "sk-web", `{"ttl":"5m"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+
+	request := func(method, token, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/v1/chat/completions", strings.NewReader(`{}`))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			req.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		req.Header.Set("Origin", origin)
+		rec := httptest.NewRecorder()
+		s.engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := request(http.MethodOptions, "", "https://app.acme.io"); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for preflight, got %d", rec.Code)
+	}
+	// Token 沿用签发 Key 的跨域策略
+	rec = request(http.MethodPost, resp.Token, "https://app.acme.io")
+	if rec.Code != http.StatusOK || rec.Header().Get("Access-Control-Allow-Origin") != "https://app.acme.io" {
+		t.Errorf("expected the token to be usable from the key's origin, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := request(http.MethodPost, resp.Token, "https://evil.example.com"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 from another origin, got %d", rec.Code)
+	}
+}
+
+func TestServer_UpstreamCORSWithoutRelayCORS(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	})
+	s := newTestServer(t, upstream, nil)
+
+	// relay 未开启跨域时不接管跨域头，上游的原样透传
+	rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-test", `{}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("expected upstream CORS header to pass through, got %q", got)
+	}
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidTTSRequest TTS 请求无效（JSON 字段、voice 或格式不合法，或 SSML 无法解析）
+var ErrInvalidTTSRequest = errors.New("invalid tts request")
+
+// defaultAzureOutputFormat JSON 请求和配置都没有指定输出格式时使用
+const defaultAzureOutputFormat = "audio-24khz-48kbitrate-mono-mp3"
+
+var (
+	// ssmlRatePattern 语速：相对值（+10%）、倍数（1.2）或预设值
+	ssmlRatePattern = regexp.MustCompile(`^([+-]?\d+(\.\d+)?%|\d+(\.\d+)?|x-slow|slow|medium|fast|x-fast|default)$`)
+	// ssmlPitchPattern 音调：相对值（+10%、-2st、+50Hz）或预设值
+	ssmlPitchPattern = regexp.MustCompile(`^([+-]?\d+(\.\d+)?(%|Hz|st)|x-low|low|medium|high|x-high|default)$`)
+	// ssmlStylePattern 说话风格，例如 cheerful、narration-professional
+	ssmlStylePattern = regexp.MustCompile(`^[a-z][a-z-]*$`)
+	// azureFormatPattern Azure 输出格式名，例如 riff-24khz-16bit-mono-pcm
+	azureFormatPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)+$`)
+)
+
+// ssmlRequest 生成 SSML 的 JSON 请求，只有 text 必填，voice 默认为配置中的第一个
+type ssmlRequest struct {
+	Text   string `json:"text"`
+	Voice  string `json:"voice"`
+	Rate   string `json:"rate"`
+	Pitch  string `json:"pitch"`
+	Style  string `json:"style"`
+	Format string `json:"format"`
+}
+
+// prepareTTSBody 配置了 voices 的路由接受 JSON 请求：校验后生成 SSML，
+// 并设置 Content-Type 和 X-Microsoft-OutputFormat；SSML 请求校验其中的 voice 后原样返回
+func prepareTTSBody(t *TTSConfig, header http.Header, body []byte) ([]byte, error) {
+	if len(t.Voices) == 0 {
+		return body, nil
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		if err := checkSSMLVoices(t, body); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidTTSRequest, err)
+		}
+		return body, nil
+	}
+
+	var req ssmlRequest
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTTSRequest, err)
+	}
+	ssml, err := renderSSML(t, &req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTTSRequest, err)
+	}
+
+	format := req.Format
+	if format == "" {
+		format = header.Get(azureOutputFormatHeader)
+	}
+	if format == "" {
+		format = t.defaultFormat()
+	}
+	header.Set("Content-Type", "application/ssml+xml")
+	header.Set(azureOutputFormatHeader, format)
+	return ssml, nil
+}
+
+// defaultFormat JSON 请求未指定 format 时的输出格式
+func (t *TTSConfig) defaultFormat() string {
+	if t.DefaultFormat != "" {
+		return t.DefaultFormat
+	}
+	return defaultAzureOutputFormat
+}
+
+// allowedVoice 按不区分大小写的方式在 voices 中查找，返回配置中的写法
+func (t *TTSConfig) allowedVoice(name string) (string, bool) {
+	for _, v := range t.Voices {
+		if strings.EqualFold(v, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// checkSSMLVoices 解析 SSML，要求每个 <voice> 都带 name 且在 voices 中
+func checkSSMLVoices(t *TTSConfig, body []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid ssml: %v", err)
+		}
+		elem, ok := token.(xml.StartElement)
+		if !ok || elem.Name.Local != "voice" {
+			continue
+		}
+		name := ""
+		for _, attr := range elem.Attr {
+			if attr.Name.Local == "name" {
+				name = attr.Value
+			}
+		}
+		if _, ok := t.allowedVoice(name); !ok {
+			return fmt.Errorf("voice %q is not allowed", name)
+		}
+	}
+}
+
+// renderSSML 校验 JSON 请求并生成 SSML，文本和属性值均做 XML 转义
+func renderSSML(t *TTSConfig, req *ssmlRequest) ([]byte, error) {
+	if strings.TrimSpace(req.Text) == "" {
+		return nil, fmt.Errorf("text is required")
+	}
+	voice := t.Voices[0]
+	if req.Voice != "" {
+		var ok bool
+		if voice, ok = t.allowedVoice(req.Voice); !ok {
+			return nil, fmt.Errorf("voice %q is not allowed", req.Voice)
+		}
+	}
+	switch {
+	case req.Rate != "" && !ssmlRatePattern.MatchString(req.Rate):
+		return nil, fmt.Errorf("invalid rate %q", req.Rate)
+	case req.Pitch != "" && !ssmlPitchPattern.MatchString(req.Pitch):
+		return nil, fmt.Errorf("invalid pitch %q", req.Pitch)
+	case req.Style != "" && !ssmlStylePattern.MatchString(req.Style):
+		return nil, fmt.Errorf("invalid style %q", req.Style)
+	case req.Format != "" && !azureFormatPattern.MatchString(req.Format):
+		return nil, fmt.Errorf("invalid format %q", req.Format)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(`<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xmlns:mstts="https://www.w3.org/2001/mstts" xml:lang="`)
+	writeXMLText(&b, voiceLocale(voice))
+	b.WriteString(`"><voice name="`)
+	writeXMLText(&b, voice)
+	b.WriteString(`">`)
+	if req.Style != "" {
+		b.WriteString(`<mstts:express-as style="` + req.Style + `">`)
+	}
+	if req.Rate != "" || req.Pitch != "" {
+		b.WriteString("<prosody")
+		if req.Rate != "" {
+			b.WriteString(` rate="` + req.Rate + `"`)
+		}
+		if req.Pitch != "" {
+			b.WriteString(` pitch="` + req.Pitch + `"`)
+		}
+		b.WriteString(">")
+	}
+	writeXMLText(&b, req.Text)
+	if req.Rate != "" || req.Pitch != "" {
+		b.WriteString("</prosody>")
+	}
+	if req.Style != "" {
+		b.WriteString("</mstts:express-as>")
+	}
+	b.WriteString("</voice></speak>")
+	return b.Bytes(), nil
+}
+
+// writeXMLText 写入转义后的文本，同样适用于双引号包围的属性值
+func writeXMLText(b *bytes.Buffer, s string) {
+	xml.EscapeText(b, []byte(s)) //nolint:errcheck // bytes.Buffer 写入不会失败
+}
+
+// voiceLocale 从 voice 名称中取语言区域，例如 zh-CN-XiaoxiaoNeural -> zh-CN
+func voiceLocale(voice string) string {
+	parts := strings.SplitN(voice, "-", 3)
+	if len(parts) < 3 {
+		return "en-US"
+	}
+	return parts[0] + "-" + parts[1]
+}
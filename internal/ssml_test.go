@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRenderSSML(t *testing.T) {
+	cfg := &TTSConfig{Voices: []string{"en-US-JennyNeural", "zh-CN-XiaoxiaoNeural"}}
+
+	ssml, err := renderSSML(cfg, &ssmlRequest{Text: `Tom & "Jerry" <3`, Voice: "zh-cn-xiaoxiaoneural", Rate: "+10%", Pitch: "-2st", Style: "cheerful"})
+	if err != nil {
+		t.Fatalf("renderSSML: %v", err)
+	}
+	want := `<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xmlns:mstts="https://www.w3.org/2001/mstts" xml:lang="zh-CN">` +
+		`<voice name="zh-CN-XiaoxiaoNeural"><mstts:express-as style="cheerful"><prosody rate="+10%" pitch="-2st">` +
+		`Tom &amp; &#34;Jerry&#34; &lt;3</prosody></mstts:express-as></voice></speak>`
+	if string(ssml) != want {
+		t.Errorf("unexpected SSML:\n%s", ssml)
+	}
+	if n, ok := ssmlTextChars(ssml); !ok || n != int64(len(`Tom & "Jerry" <3`)) {
+		t.Errorf("expected well-formed SSML, got %d %v", n, ok)
+	}
+
+	ssml, err = renderSSML(cfg, &ssmlRequest{Text: "Hi"})
+	if err != nil || !strings.Contains(string(ssml), `<voice name="en-US-JennyNeural">Hi</voice>`) {
+		t.Errorf("expected the first voice without prosody, got %s (%v)", ssml, err)
+	}
+}
+
+func TestPrepareTTSBody(t *testing.T) {
+	cfg := &TTSConfig{Voices: []string{"en-US-JennyNeural"}, DefaultFormat: "riff-24khz-16bit-mono-pcm"}
+
+	header := http.Header{}
+	body, err := prepareTTSBody(cfg, header, []byte(`{"text":"Hello"}`))
+	if err != nil || !strings.HasPrefix(string(body), "<speak") {
+		t.Fatalf("expected SSML, got %s (%v)", body, err)
+	}
+	if header.Get("Content-Type") != "application/ssml+xml" || header.Get(azureOutputFormatHeader) != "riff-24khz-16bit-mono-pcm" {
+		t.Errorf("unexpected headers: %v", header)
+	}
+
+	// 请求头中已有的格式优先于默认值，JSON 中的 format 优先于请求头
+	header = http.Header{}
+	header.Set(azureOutputFormatHeader, "raw-16khz-16bit-mono-pcm")
+	prepareTTSBody(cfg, header, []byte(`{"text":"Hello"}`))
+	if header.Get(azureOutputFormatHeader) != "raw-16khz-16bit-mono-pcm" {
+		t.Errorf("expected the client format to be kept, got %q", header.Get(azureOutputFormatHeader))
+	}
+	prepareTTSBody(cfg, header, []byte(`{"text":"Hello","format":"audio-16khz-32kbitrate-mono-mp3"}`))
+	if header.Get(azureOutputFormatHeader) != "audio-16khz-32kbitrate-mono-mp3" {
+		t.Errorf("expected the JSON format to win, got %q", header.Get(azureOutputFormatHeader))
+	}
+
+	for _, body := range []string{
+		`{"text":""}`,
+		`{"text":"Hi","voice":"en-US-GuyNeural"}`,
+		`{"text":"Hi","voise":"en-US-JennyNeural"}`,
+		`{"text":"Hi","rate":"fast\" pitch=\"high"}`,
+		`{"text":"Hi","pitch":"2 semitones"}`,
+		`{"text":"Hi","style":"<cheerful>"}`,
+		`{"text":"Hi","format":"mp3"}`,
+		`{"text":`,
+	} {
+		if _, err := prepareTTSBody(cfg, http.Header{}, []byte(body)); !errors.Is(err, ErrInvalidTTSRequest) {
+			t.Errorf("expected %s to be rejected, got %v", body, err)
+		}
+	}
+
+	ssml := []byte(`<speak><voice name="en-us-jennyneural">Hi</voice></speak>`)
+	if body, err := prepareTTSBody(cfg, http.Header{}, ssml); err != nil || string(body) != string(ssml) {
+		t.Errorf("expected SSML to pass through, got %s (%v)", body, err)
+	}
+	// SSML 中每个 voice 都要在 voices 中，无法解析的请求体同样拒绝
+	for _, body := range []string{
+		`<speak><voice name="en-US-GuyNeural">Hi</voice></speak>`,
+		`<speak><voice name="en-US-JennyNeural">Hi</voice><voice name="en-US-GuyNeural">Bye</voice></speak>`,
+		`<speak><voice>Hi</voice></speak>`,
+		`<speak><voice name="en-US-JennyNeural">Hi</speak>`,
+	} {
+		if _, err := prepareTTSBody(cfg, http.Header{}, []byte(body)); !errors.Is(err, ErrInvalidTTSRequest) {
+			t.Errorf("expected %s to be rejected, got %v", body, err)
+		}
+	}
+	if body, err := prepareTTSBody(&TTSConfig{}, http.Header{}, []byte(`<speak><voice name="x">Hi</voice></speak>`)); err != nil || string(body) != `<speak><voice name="x">Hi</voice></speak>` {
+		t.Errorf("expected SSML to pass through without voices, got %s (%v)", body, err)
+	}
+	if body, err := prepareTTSBody(&TTSConfig{}, http.Header{}, []byte(`{"text":"Hi"}`)); err != nil || string(body) != `{"text":"Hi"}` {
+		t.Errorf("expected JSON to pass through without voices, got %s (%v)", body, err)
+	}
+}
+
+func TestServer_TTSJSONRequest(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/ssml+xml" || r.Header.Get(azureOutputFormatHeader) != defaultAzureOutputFormat {
+			t.Errorf("unexpected upstream headers: %v", r.Header)
+		}
+		if err := xml.Unmarshal(body, new(struct{})); err != nil {
+			t.Errorf("expected well-formed SSML, got %s", body)
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(testMP3(10, false))
+	})
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.Routes[0].Path = "/cognitiveservices/v1"
+		cfg.Routes[0].Kind = "raw"
+		cfg.Routes[0].TTS.Enabled = true
+		cfg.Routes[0].TTS.Voices = []string{"en-US-JennyNeural"}
+		cfg.RateLimit.Burst = 10
+	})
+
+	rec := doRequest(s, http.MethodPost, "/cognitiveservices/v1", "sk-test", `{"text":"Fish & chips","style":"cheerful"}`)
+	if rec.Code != http.StatusOK || rec.Body.Len() != len(testMP3(10, false)) {
+		t.Errorf("expected audio, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(s, http.MethodPost, "/cognitiveservices/v1", "sk-test", `{"text":"Hi","voice":"en-US-JenyNeural"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "en-US-JenyNeural") {
+		t.Errorf("expected 400 for an unknown voice, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -47,12 +47,14 @@ func (s *Storage) ensureTables(ctx context.Context) error {
 	CREATE TABLE IF NOT EXISTS stream_logs (
 		request_id String,
 		tenant_id String,
+		api_key_id String,
 		created_at DateTime64(3),
 
 		route String,
 		provider String,
 		model String,
 		kind String,
+		credential_source String,
 
 		request_body String,
 
@@ -66,6 +68,13 @@ func (s *Storage) ensureTables(ctx context.Context) error {
 		bytes_out Int64,
 		chunks_count Int32,
 
+		audio_format String,
+		audio_duration_ms Nullable(Int64),
+		text_length Nullable(Int64),
+		input_chars Nullable(Int64),
+		rtf Nullable(Float64),
+		tts_cache String,
+
 		tokens_in Nullable(Int64),
 		tokens_out Nullable(Int64),
 
@@ -78,6 +87,7 @@ func (s *Storage) ensureTables(ctx context.Context) error {
 	`
 
 	// TODO: implement when ClickHouse support is enabled
+	// 已有的表不会被 CREATE TABLE IF NOT EXISTS 修改，接入时需要为后来增加的列补上 ALTER TABLE ... ADD COLUMN IF NOT EXISTS
 	_ = createTableSQL
 	return nil
 }
@@ -85,8 +95,8 @@ func (s *Storage) ensureTables(ctx context.Context) error {
 // SaveLog 保存日志（暂时只输出到日志，不写 ClickHouse）
 func (s *Storage) SaveLog(ctx context.Context, log *StreamLog) error {
 	// 暂时只打印摘要日志，不写数据库
-	fmt.Printf("SESSION: request_id=%s route=%s status=%d duration=%dms bytes_out=%d\n",
-		log.RequestID, log.Route, log.StatusCode, log.DurationMs, log.BytesOut)
+	fmt.Printf("SESSION: request_id=%s tenant=%s key=%s route=%s model=%s credential=%s status=%d duration=%dms bytes_out=%d\n",
+		log.RequestID, log.TenantID, log.APIKeyID, log.Route, log.Model, log.CredentialSource, log.StatusCode, log.DurationMs, log.BytesOut)
 
 	// TODO: 当 ClickHouse 可用时，写入数据库
 	return nil
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// certReloadInterval 检查证书文件是否变化的间隔
+const certReloadInterval = 10 * time.Second
+
+// ErrUnknownClientCert 客户端证书校验通过但没有对应的身份映射
+var ErrUnknownClientCert = errors.New("client certificate is not mapped to a tenant")
+
+// certReloader 证书热更新 - 定期检查证书、私钥和 CA 文件的修改时间，变化后重新加载
+// 新连接使用新证书，已建立的连接不受影响；加载失败时继续使用旧证书
+type certReloader struct {
+	config *TLSConfig
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// newCertReloader 加载证书，失败时返回错误
+func newCertReloader(config *TLSConfig) (*certReloader, error) {
+	r := &certReloader{config: config}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// files 需要监听的文件
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if r.config.ClientCAFile != "" {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+// load 读取证书文件
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return fmt.Errorf("stat %s: %w", file, err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return fmt.Errorf("load certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.config.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("read client ca: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in %s", r.config.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+// changed 文件修改时间是否变化
+func (r *certReloader) changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			// 证书轮换工具可能先删后写，下次再检查
+			return false
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch 定期检查文件变化，直到 done 关闭
+func (r *certReloader) watch(done <-chan struct{}) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.load(); err != nil {
+				slog.Error("Failed to reload TLS certificate, keeping current", "error", err)
+				continue
+			}
+			slog.Info("TLS certificate reloaded", "cert", r.config.CertFile)
+		}
+	}
+}
+
+// tlsConfig 返回服务端 TLS 配置，每个新连接读取当前的证书和 CA
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+				ClientCAs:    r.clientCAs,
+				ClientAuth:   r.config.clientAuthType(),
+				NextProtos:   []string{"h2", "http/1.1"},
+			}, nil
+		},
+	}
+}
+
+// clientAuthType 转换为 tls.ClientAuthType
+func (c *TLSConfig) clientAuthType() tls.ClientAuthType {
+	switch c.ClientAuth {
+	case "request":
+		return tls.VerifyClientCertIfGiven
+	case "require":
+		return tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert
+	}
+}
+
+// certPrincipal 把已验证的客户端证书映射为调用方身份
+// 按顺序匹配 client_identities，URI SAN（如 SPIFFE ID）、DNS SAN 或 Subject CN 任一相等即命中
+func certPrincipal(identities []ClientCertIdentity, state *tls.ConnectionState) (*Principal, error) {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil, ErrInvalidKey
+	}
+	leaf := state.VerifiedChains[0][0]
+
+	for i := range identities {
+		id := &identities[i]
+		if id.matches(leaf) {
+			return &Principal{
+				KeyID:    "cert:" + id.ID,
+				Name:     leaf.Subject.CommonName,
+				TenantID: id.TenantID,
+				Tier:     id.Tier,
+				Routes:   id.Routes,
+				Models:   id.Models,
+
+				ExpiresAt: leaf.NotAfter,
+			}, nil
+		}
+	}
+	return nil, ErrUnknownClientCert
+}
+
+// matches 证书是否匹配该身份
+func (id *ClientCertIdentity) matches(cert *x509.Certificate) bool {
+	if id.CommonName != "" && cert.Subject.CommonName == id.CommonName {
+		return true
+	}
+	if id.DNSName != "" && slices.Contains(cert.DNSNames, id.DNSName) {
+		return true
+	}
+	if id.URI != "" {
+		for _, uri := range cert.URIs {
+			if uri.String() == id.URI {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 测试用 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCA 创建自签名 CA
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue 签发叶子证书
+func (ca *testCA) issue(t *testing.T, tmpl *x509.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writePEM 把证书和私钥写入文件
+func writePEM(t *testing.T, dir, name string, cert tls.Certificate) (string, string) {
+	t.Helper()
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	keyDER, _ := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+// writeTLSFiles 生成 CA 和服务端证书，返回 TLS 配置
+func writeTLSFiles(t *testing.T, ca *testCA, clientAuth string) TLSConfig {
+	t.Helper()
+
+	dir := t.TempDir()
+	serverCert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "relay"},
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	certFile, keyFile := writePEM(t, dir, "server", serverCert)
+	caFile := filepath.Join(dir, "ca.crt")
+	os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600)
+
+	return TLSConfig{
+		Enabled:      true,
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   clientAuth,
+	}
+}
+
+func TestCertReloader_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	cfg := writeTLSFiles(t, ca, "none")
+
+	r, err := newCertReloader(&cfg)
+	if err != nil {
+		t.Fatalf("load failed: %v", err)
+	}
+	if r.changed() {
+		t.Error("expected no change right after load")
+	}
+
+	// Replace the certificate on disk
+	next := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "relay-2"}})
+	writePEM(t, filepath.Dir(cfg.CertFile), "server", next)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(cfg.CertFile, future, future)
+
+	if !r.changed() {
+		t.Fatal("expected change to be detected")
+	}
+	if err := r.load(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+
+	conf, _ := r.tlsConfig().GetConfigForClient(nil)
+	leaf, _ := x509.ParseCertificate(conf.Certificates[0].Certificate[0])
+	if leaf.Subject.CommonName != "relay-2" {
+		t.Errorf("expected reloaded certificate, got %s", leaf.Subject.CommonName)
+	}
+
+	// A broken file keeps the current certificate
+	os.WriteFile(cfg.KeyFile, []byte("garbage"), 0o600)
+	if err := r.load(); err == nil {
+		t.Error("expected error for broken key")
+	}
+	conf, _ = r.tlsConfig().GetConfigForClient(nil)
+	if leaf, _ := x509.ParseCertificate(conf.Certificates[0].Certificate[0]); leaf.Subject.CommonName != "relay-2" {
+		t.Error("expected current certificate to be kept after failed reload")
+	}
+}
+
+func TestServer_ClientCertAuth(t *testing.T) {
+	ca := newTestCA(t)
+	tlsConfig := writeTLSFiles(t, ca, "request")
+	tlsConfig.Identities = []ClientCertIdentity{
+		{ID: "billing", URI: "spiffe://acme/billing", TenantID: "acme", Routes: []string{"chat"}},
+	}
+
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.Server.TLS = tlsConfig
+	})
+	certs, err := newCertReloader(&s.config.Server.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: s.engine, TLSConfig: certs.tlsConfig()}
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	client := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certs}}}
+	}
+	post := func(c *http.Client, apiKey string) int {
+		req, _ := http.NewRequest(http.MethodPost, "https://"+ln.Addr().String()+"/v1/chat/completions", strings.NewReader(`{}`))
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	spiffe, _ := url.Parse("spiffe://acme/billing")
+	mapped := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "billing"}, URIs: []*url.URL{spiffe}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	unmapped := ca.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+
+	if code := post(client(mapped), ""); code != http.StatusOK {
+		t.Errorf("expected 200 for mapped client cert, got %d", code)
+	}
+	if code := post(client(unmapped), ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for unmapped client cert, got %d", code)
+	}
+	if code := post(client(), ""); code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without credentials, got %d", code)
+	}
+	// API keys still work over TLS
+	if code := post(client(), "sk-test"); code != http.StatusOK {
+		t.Errorf("expected 200 for API key over TLS, got %d", code)
+	}
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+)
+
+// maxMultipartFieldSize 普通表单字段（model、language 等）的读取上限，超出部分丢弃
+const maxMultipartFieldSize = 4 * 1024
+
+// maxTranscriptSize 转写响应的记录上限，超出部分照常转发但不记录
+const maxTranscriptSize = 1 << 20
+
+// multipartBoundary 从 Content-Type 中取出 multipart/form-data 的 boundary
+func multipartBoundary(contentType string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return "", fmt.Errorf("multipart/form-data request body required")
+	}
+	return params["boundary"], nil
+}
+
+// multipartUpload 从 multipart 上传中解析出的元数据，文件内容不缓存
+type multipartUpload struct {
+	Fields      map[string]string
+	FileName    string
+	ContentType string
+	FileSize    int64
+	Audio       *audioMeter // 第一个文件字段的音频计量
+}
+
+// inspectMultipart 流式解析 multipart 表单直到结束，onField 在每个普通字段读完时调用，onFile 在每个文件字段开始时调用
+func inspectMultipart(r io.Reader, boundary string, onField func(name, value string), onFile func()) (*multipartUpload, error) {
+	upload := &multipartUpload{Fields: make(map[string]string)}
+	reader := multipart.NewReader(r, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return upload, nil
+		}
+		if err != nil {
+			return upload, err
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxMultipartFieldSize))
+			if err != nil {
+				return upload, err
+			}
+			upload.Fields[part.FormName()] = string(value)
+			if onField != nil {
+				onField(part.FormName(), string(value))
+			}
+			continue
+		}
+
+		if onFile != nil {
+			onFile()
+		}
+		// 只计量第一个文件，其余文件由 NextPart 跳过
+		if upload.Audio != nil {
+			continue
+		}
+		upload.FileName = part.FileName()
+		upload.ContentType = part.Header.Get("Content-Type")
+		upload.Audio = newAudioMeter()
+		upload.FileSize, err = io.Copy(upload.Audio, part)
+		if err != nil {
+			return upload, err
+		}
+	}
+}
+
+// logBody 记录到日志的请求体：表单字段和文件元数据，不含音频
+func (u *multipartUpload) logBody() string {
+	entry := map[string]any{"fields": u.Fields}
+	if u.Audio != nil {
+		file := map[string]any{
+			"name":         u.FileName,
+			"content_type": u.ContentType,
+			"size":         u.FileSize,
+			"format":       u.Audio.Format(),
+		}
+		if d, ok := u.Audio.Duration(); ok {
+			file["duration_ms"] = d.Milliseconds()
+		}
+		entry["file"] = file
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// uploadStream 转发给上游的 multipart 请求体，读到的字节同时经管道交给解析协程
+type uploadStream struct {
+	ctx  context.Context // 取消后不再读取请求体，中断上传
+	body io.ReadCloser
+	pw   *io.PipeWriter
+	done chan struct{}
+
+	// 以下字段在 done 关闭后才能读取
+	upload    *multipartUpload
+	bytesRead int64
+}
+
+// newUploadStream 创建上传流并启动解析协程，回调在解析协程中依次调用
+func newUploadStream(ctx context.Context, body io.ReadCloser, boundary string, onField func(name, value string), onFile func()) *uploadStream {
+	pr, pw := io.Pipe()
+	s := &uploadStream{ctx: ctx, body: body, pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		counter := &countingReader{r: pr}
+		// 格式错误只影响计量，上游会自己拒绝
+		s.upload, _ = inspectMultipart(counter, boundary, onField, onFile)
+		// 解析结束后继续读完管道，避免阻塞转发
+		//nolint:errcheck // drain only
+		io.Copy(io.Discard, counter)
+		s.bytesRead = counter.n
+	}()
+	return s
+}
+
+func (s *uploadStream) Read(p []byte) (int, error) {
+	if err := s.ctx.Err(); err != nil {
+		s.pw.CloseWithError(err)
+		return 0, err
+	}
+	n, err := s.body.Read(p)
+	if n > 0 {
+		//nolint:errcheck // 解析协程始终读完管道，写入只会在关闭后失败
+		s.pw.Write(p[:n])
+	}
+	switch {
+	case err == io.EOF:
+		s.pw.Close()
+	case err != nil:
+		s.pw.CloseWithError(err)
+	}
+	return n, err
+}
+
+func (s *uploadStream) Close() error {
+	s.pw.CloseWithError(io.ErrUnexpectedEOF)
+	return s.body.Close()
+}
+
+// wait 等待解析结束；上游提前返回时请求体可能没有读完，按截断处理
+func (s *uploadStream) wait() *multipartUpload {
+	s.pw.CloseWithError(io.ErrUnexpectedEOF)
+	<-s.done
+	return s.upload
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// transcription 转写响应（json / verbose_json）以及流式转写的 transcript.text.done 事件
+type transcription struct {
+	Type  string       `json:"type"`
+	Text  string       `json:"text"`
+	Usage *usageFields `json:"usage"`
+}
+
+// parseTranscription 从转写响应中提取文本和用量
+// json / verbose_json 取 text 与 usage 字段（whisper-1 的 usage 按秒计，不算 token），
+// 流式转写取 transcript.text.done 事件（用量由 extractUsage 提取），text / srt / vtt 整体视为文本
+func parseTranscription(chunks []string, sse bool) (string, *Usage) {
+	if sse {
+		for _, chunk := range chunks {
+			data, ok := strings.CutPrefix(chunk, "data:")
+			if !ok || !strings.Contains(data, "transcript.text.done") {
+				continue
+			}
+			var event transcription
+			if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &event); err == nil && event.Type == "transcript.text.done" {
+				return event.Text, nil
+			}
+		}
+		return "", nil
+	}
+
+	body := strings.Join(chunks, "")
+	var result transcription
+	if !strings.HasPrefix(strings.TrimSpace(body), "{") || json.Unmarshal([]byte(body), &result) != nil {
+		return body, nil
+	}
+	var usage *Usage
+	if u := result.Usage; u != nil && u.InputTokens+u.OutputTokens > 0 {
+		usage = &Usage{InputTokens: u.InputTokens, OutputTokens: u.OutputTokens, TotalTokens: u.InputTokens + u.OutputTokens}
+	}
+	return result.Text, usage
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// transcriptionForm 构造转写上传表单，fields 按顺序写入，file 为 nil 时不带文件
+func transcriptionForm(t *testing.T, fields [][2]string, file []byte) (string, []byte) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, field := range fields {
+		if field[0] == "file" {
+			part, err := w.CreateFormFile("file", "speech.wav")
+			if err != nil {
+				t.Fatalf("CreateFormFile: %v", err)
+			}
+			part.Write(file)
+			continue
+		}
+		w.WriteField(field[0], field[1])
+	}
+	w.Close()
+	return w.FormDataContentType(), body.Bytes()
+}
+
+// transcriptionUpstream 读完整个上传并校验文件内容后返回 response
+func transcriptionUpstream(t *testing.T, file []byte, contentType, response string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			t.Errorf("upstream ParseMultipartForm: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("upstream FormFile: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if got, _ := io.ReadAll(f); !bytes.Equal(got, file) {
+			t.Errorf("upstream received %d bytes, expected %d", len(got), len(file))
+		}
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, response)
+	})
+}
+
+// transcriptionRoute 语音转写的 multipart 路由
+var transcriptionRoute = RouteConfig{Name: "stt", Path: "/v1/audio/transcriptions", Kind: "multipart"}
+
+func TestProxy_Transcription(t *testing.T) {
+	audio := testWAV(2, 64000)
+	contentType, body := transcriptionForm(t, [][2]string{{"file", ""}, {"model", "whisper-1"}, {"language", "zh"}}, audio)
+	p := newRouteProxy(t, transcriptionUpstream(t, audio, "application/json", `{"text":"你好，世界"}`), transcriptionRoute)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"text":"你好，世界"}` {
+		t.Errorf("unexpected response %d: %s", rec.Code, rec.Body.String())
+	}
+	if ctx.Model != "whisper-1" || ctx.BytesIn != int64(len(body)) {
+		t.Errorf("expected model and bytes in from the form, got %q %d", ctx.Model, ctx.BytesIn)
+	}
+	if ctx.AudioFormat != AudioFormatWAV || ctx.AudioDuration == nil || *ctx.AudioDuration != 2000 {
+		t.Errorf("expected 2s wav, got %q %v", ctx.AudioFormat, ctx.AudioDuration)
+	}
+	if ctx.TextLength == nil || *ctx.TextLength != 5 {
+		t.Errorf("expected text length 5, got %v", ctx.TextLength)
+	}
+
+	log := ctx.ToStreamLog("")
+	if log.AudioDurationMs == nil || *log.AudioDurationMs != 2000 || log.TextLength == nil {
+		t.Errorf("expected audio fields in the log: %+v", log)
+	}
+}
+
+func TestProxy_TranscriptionStream(t *testing.T) {
+	audio := testMP3(50, false)
+	contentType, body := transcriptionForm(t, [][2]string{{"model", "gpt-4o-transcribe"}, {"stream", "true"}, {"file", ""}}, audio)
+	p := newRouteProxy(t, transcriptionUpstream(t, audio, "text/event-stream",
+		"data: {\"type\":\"transcript.text.delta\",\"delta\":\"Hello\"}\n\n"+
+			"data: {\"type\":\"transcript.text.delta\",\"delta\":\" there\"}\n\n"+
+			"data: {\"type\":\"transcript.text.done\",\"text\":\"Hello there\",\"usage\":{\"type\":\"tokens\",\"input_tokens\":14,\"output_tokens\":3,\"total_tokens\":17}}\n\n"), transcriptionRoute)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.Handle(httptest.NewRecorder(), req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if ctx.TTFTMs == nil || ctx.AudioFormat != AudioFormatMP3 || ctx.AudioDuration == nil || *ctx.AudioDuration != 1306 {
+		t.Errorf("unexpected metadata: ttft=%v format=%q duration=%v", ctx.TTFTMs, ctx.AudioFormat, ctx.AudioDuration)
+	}
+	if ctx.TextLength == nil || *ctx.TextLength != int64(len("Hello there")) {
+		t.Errorf("expected text length from transcript.text.done, got %v", ctx.TextLength)
+	}
+	if ctx.Usage == nil || ctx.Usage.InputTokens != 14 || ctx.Usage.OutputTokens != 3 {
+		t.Errorf("unexpected usage: %+v", ctx.Usage)
+	}
+}
+
+func TestParseTranscription(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		text   string
+		tokens int64
+	}{
+		{"json", []string{`{"text":"hello"}`}, "hello", 0},
+		{"json with usage", []string{`{"text":"hello","usage":{"type":"tokens","input_tokens":20,"output_tokens":2}}`}, "hello", 22},
+		{"whisper duration usage", []string{`{"text":"hello","usage":{"type":"duration","seconds":3}}`}, "hello", 0},
+		{"plain text", []string{"hello\n"}, "hello\n", 0},
+		{"srt", []string{"1\n00:00:00,000 --> 00:00:01,000\nhello\n"}, "1\n00:00:00,000 --> 00:00:01,000\nhello\n", 0},
+	}
+	for _, tt := range tests {
+		text, usage := parseTranscription(tt.chunks, false)
+		if text != tt.text {
+			t.Errorf("%s: expected text %q, got %q", tt.name, tt.text, text)
+		}
+		var tokens int64
+		if usage != nil {
+			tokens = usage.TotalTokens
+		}
+		if tokens != tt.tokens {
+			t.Errorf("%s: expected %d tokens, got %d", tt.name, tt.tokens, tokens)
+		}
+	}
+}
+
+func TestServer_TranscriptionModelCheck(t *testing.T) {
+	audio := testWAV(30, 32000)
+	// 上传可能被中途取消，上游不校验内容，只记录收到的字节数
+	var received atomic.Int64
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		received.Store(n)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"text":"ok"}`)
+	})
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.Routes[0].Path = "/v1/audio/transcriptions"
+		cfg.Routes[0].Kind = "multipart"
+		cfg.RateLimit.Burst = 10
+		cfg.Auth.Keys = []APIKeyConfig{{ID: "stt", Key: "sk-stt", TenantID: "acme", Models: []string{"whisper-1"}}}
+	})
+
+	var size int
+	upload := func(fields [][2]string) *httptest.ResponseRecorder {
+		received.Store(0)
+		contentType, body := transcriptionForm(t, fields, audio)
+		size = len(body)
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-stt")
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		s.engine.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := upload([][2]string{{"model", "whisper-1"}, {"file", ""}}); rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := upload([][2]string{{"model", "gpt-4o-transcribe"}, {"file", ""}}); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "gpt-4o-transcribe") {
+		t.Errorf("expected 403 for disallowed model, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// 限定了模型的 Key 必须先发 model 字段：文件开始时立即中断上传，省略 model 同样不能绕过
+	for _, fields := range [][][2]string{
+		{{"file", ""}, {"model", "gpt-4o-transcribe"}},
+		{{"file", ""}, {"model", "whisper-1"}},
+		{{"file", ""}},
+	} {
+		rec := upload(fields)
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "before the file") {
+			t.Errorf("expected 403 for a file before the model, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if n := received.Load(); n >= int64(size) {
+			t.Errorf("expected the upload to be aborted, upstream received %d of %d bytes", n, size)
+		}
+	}
+
+	rec := doRequest(s, http.MethodPost, "/v1/audio/transcriptions", "sk-stt", `{"model":"whisper-1"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for non-multipart body, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"mime"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// azureOutputFormatHeader Azure TTS 指定输出格式的请求头
+const azureOutputFormatHeader = "X-Microsoft-OutputFormat"
+
+// azureRawFormat Azure 无容器的 PCM 输出格式，例如 raw-16khz-16bit-mono-pcm、raw-8khz-8bit-mono-mulaw
+var azureRawFormat = regexp.MustCompile(`^raw-(\d+)(khz|hz)-(\d+)bit-mono-(pcm|mulaw|alaw)$`)
+
+// pcmByteRate 无容器 PCM 的字节率，无法确定时返回 0
+// 先看响应的 Content-Type（audio/L16;rate=..;channels=..），再看请求的 X-Microsoft-OutputFormat
+func pcmByteRate(contentType, outputFormat string) int64 {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == "audio/l16" || mediaType == "audio/pcm") {
+		rate, _ := strconv.ParseInt(params["rate"], 10, 64)
+		channels, err := strconv.ParseInt(params["channels"], 10, 64)
+		if err != nil || channels <= 0 {
+			channels = 1
+		}
+		if rate > 0 {
+			return rate * channels * 2
+		}
+	}
+
+	m := azureRawFormat.FindStringSubmatch(strings.ToLower(outputFormat))
+	if m == nil {
+		return 0
+	}
+	rate, _ := strconv.ParseInt(m[1], 10, 64)
+	if m[2] == "khz" {
+		rate *= 1000
+	}
+	bits, _ := strconv.ParseInt(m[3], 10, 64)
+	return rate * bits / 8
+}
+
+// newTTSAudioMeter 为 TTS 输出创建音频计量：无容器 PCM 按字节率计算，其余按魔数识别容器
+func newTTSAudioMeter(contentType, outputFormat string) *audioMeter {
+	if byteRate := pcmByteRate(contentType, outputFormat); byteRate > 0 {
+		return newPCMMeter(byteRate)
+	}
+	return newAudioMeter()
+}
+
+// ttsInputChars TTS 输入的字符数：SSML 只计文本内容（不含标签和标签之间的空白），
+// JSON 请求取 input（OpenAI）或 text 字段，其余按纯文本计
+func ttsInputChars(body []byte) int64 {
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var req struct {
+			Input string `json:"input"`
+			Text  string `json:"text"`
+		}
+		if err := json.Unmarshal(trimmed, &req); err == nil {
+			return int64(utf8.RuneCountInString(req.Input + req.Text))
+		}
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		if n, ok := ssmlTextChars(trimmed); ok {
+			return n
+		}
+	}
+	return int64(utf8.RuneCount(trimmed))
+}
+
+// ssmlTextChars 统计 SSML 中文本节点的字符数，解析失败时返回 false
+func ssmlTextChars(ssml []byte) (int64, bool) {
+	decoder := xml.NewDecoder(bytes.NewReader(ssml))
+	var n int64
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return n, true
+		}
+		if err != nil {
+			return 0, false
+		}
+		if text, ok := token.(xml.CharData); ok && len(bytes.TrimSpace(text)) > 0 {
+			n += int64(utf8.RuneCount(text))
+		}
+	}
+}
+
+// recordTTSAudio 记录 TTS 输出音频的格式、时长、实时率（生成耗时 / 音频时长）和输入字符数
+func recordTTSAudio(ctx *RequestContext, meter *audioMeter, requestBody []byte) {
+	chars := ttsInputChars(requestBody)
+	ctx.InputChars = &chars
+	ctx.AudioFormat = meter.Format()
+
+	d, ok := meter.Duration()
+	if !ok {
+		return
+	}
+	ms := d.Milliseconds()
+	ctx.AudioDuration = &ms
+	rtf := float64(time.Since(ctx.StartTime)) / float64(d)
+	ctx.RTF = &rtf
+}
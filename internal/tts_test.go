@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPCMByteRate(t *testing.T) {
+	tests := []struct {
+		contentType  string
+		outputFormat string
+		want         int64
+	}{
+		{"audio/L16; rate=24000; channels=1", "", 48000},
+		{"audio/L16; rate=16000; channels=2", "", 64000},
+		{"audio/pcm;rate=8000", "", 16000},
+		{"audio/x-wav", "raw-16khz-16bit-mono-pcm", 32000},
+		{"", "raw-8khz-8bit-mono-mulaw", 8000},
+		{"", "Raw-22050Hz-16bit-Mono-PCM", 44100},
+		{"audio/mpeg", "audio-24khz-48kbitrate-mono-mp3", 0},
+		{"audio/x-wav", "riff-24khz-16bit-mono-pcm", 0},
+	}
+	for _, tt := range tests {
+		if got := pcmByteRate(tt.contentType, tt.outputFormat); got != tt.want {
+			t.Errorf("pcmByteRate(%q, %q): expected %d, got %d", tt.contentType, tt.outputFormat, tt.want, got)
+		}
+	}
+}
+
+func TestTTSInputChars(t *testing.T) {
+	ssml := `<speak version="1.0" xmlns="http://www.w3.org/2001/10/synthesis" xml:lang="en-US">
+  <voice name="en-US-JennyNeural">
+    Hello &amp; <break time="100ms"/>welcome
+  </voice>
+</speak>`
+	tests := []struct {
+		name string
+		body string
+		want int64
+	}{
+		{"ssml", ssml, int64(len("\n    Hello & ") + len("welcome\n  "))},
+		{"openai json", `{"model":"tts-1","input":"你好世界","voice":"alloy"}`, 4},
+		{"plain text", "  Hello there  ", 11},
+		{"broken ssml", "<speak>Hello", int64(len("<speak>Hello"))},
+	}
+	for _, tt := range tests {
+		if got := ttsInputChars([]byte(tt.body)); got != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+// azureTTSRoute Azure TTS 的 raw 路由
+var azureTTSRoute = RouteConfig{Name: "tts", Path: "/cognitiveservices/v1", Kind: "raw", TTS: TTSConfig{Enabled: true}}
+
+func TestProxy_TTSAudioMetrics(t *testing.T) {
+	mp3 := testMP3(200, true) // 200 * 1152 / 44100 ≈ 5.22s
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		// 分块输出，帧头跨越写入边界
+		for data := mp3; len(data) > 0; {
+			n := min(1000, len(data))
+			w.Write(data[:n])
+			w.(http.Flusher).Flush()
+			data = data[n:]
+		}
+	})
+	p := newRouteProxy(t, upstream, azureTTSRoute)
+
+	req := httptest.NewRequest(http.MethodPost, "/cognitiveservices/v1", strings.NewReader(`<speak><voice name="en-US-JennyNeural">Hello world</voice></speak>`))
+	req.Header.Set(azureOutputFormatHeader, "audio-24khz-48kbitrate-mono-mp3")
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if rec.Body.Len() != len(mp3) {
+		t.Errorf("expected audio to pass through unchanged, got %d bytes", rec.Body.Len())
+	}
+	if ctx.AudioFormat != AudioFormatMP3 || ctx.AudioDuration == nil || *ctx.AudioDuration != 5224 {
+		t.Errorf("unexpected audio: %q %v", ctx.AudioFormat, ctx.AudioDuration)
+	}
+	if ctx.InputChars == nil || *ctx.InputChars != 11 {
+		t.Errorf("expected 11 input chars, got %v", ctx.InputChars)
+	}
+	if ctx.RTF == nil || *ctx.RTF <= 0 || *ctx.RTF >= 1 {
+		t.Errorf("expected RTF between 0 and 1, got %v", ctx.RTF)
+	}
+
+	log := ctx.ToStreamLog("")
+	if log.RTF == nil || log.InputChars == nil || log.AudioDurationMs == nil {
+		t.Errorf("expected audio fields in the log: %+v", log)
+	}
+}
+
+func TestProxy_TTSRawPCM(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/x-wav")
+		w.Write(make([]byte, 48000)) // 1.5s @ 16kHz 16bit
+	})
+	p := newRouteProxy(t, upstream, azureTTSRoute)
+
+	req := httptest.NewRequest(http.MethodPost, "/cognitiveservices/v1", strings.NewReader(`<speak>Hi</speak>`))
+	req.Header.Set(azureOutputFormatHeader, "raw-16khz-16bit-mono-pcm")
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.Handle(httptest.NewRecorder(), req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if ctx.AudioFormat != AudioFormatPCM || ctx.AudioDuration == nil || *ctx.AudioDuration != 1500 {
+		t.Errorf("unexpected audio: %q %v", ctx.AudioFormat, ctx.AudioDuration)
+	}
+}
+
+func TestProxy_RawWithoutTTS(t *testing.T) {
+	// 没有开启 tts.enabled 的 raw 路由（例如文件下载）不做音频计量
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(testMP3(10, false))
+	})
+	p := newRouteProxy(t, upstream, RouteConfig{Name: "files", Path: "/v1/files", Kind: "raw"})
+
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/files/f1/content", nil), ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if ctx.AudioFormat != "" || ctx.AudioDuration != nil || ctx.InputChars != nil || ctx.RTF != nil {
+		t.Errorf("expected no audio metering, got %q %v %v %v", ctx.AudioFormat, ctx.AudioDuration, ctx.InputChars, ctx.RTF)
+	}
+}
+
+func TestProxy_TTSError(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad ssml", http.StatusBadRequest)
+	})
+	p := newRouteProxy(t, upstream, azureTTSRoute)
+
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	if err := p.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/cognitiveservices/v1", strings.NewReader("<speak")), ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if ctx.AudioDuration != nil || ctx.RTF != nil || ctx.InputChars != nil {
+		t.Error("expected no audio metrics for an error response")
+	}
+}
@@ -0,0 +1,354 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTTSCacheMaxSize 缓存目录的默认容量上限
+	defaultTTSCacheMaxSize = 1 << 30 // 1GB
+
+	ttsCacheExt = ".tts"
+	ttsCacheTmp = ".tmp"
+
+	// ttsCacheHeader 响应头，开启 expose_header 时告知客户端是否命中缓存（hit | miss）
+	ttsCacheHeader = "X-TTS-Cache"
+)
+
+// TTSCache TTS 音频的磁盘缓存，按内容寻址（租户、路由、规范化的输入、输出格式），超过容量时淘汰最久未使用的条目
+// 每个条目一个文件：第一行是 JSON 元数据，之后是原样的音频；修改时间记录最近一次使用，重启后据此恢复 LRU 顺序
+type TTSCache struct {
+	dir          string
+	maxSize      int64
+	exposeHeader bool
+	metrics      *Metrics
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // 队首为最近使用
+	size    int64
+}
+
+// ttsCacheEntry LRU 中的一个条目
+type ttsCacheEntry struct {
+	key  string
+	size int64
+}
+
+// ttsCacheMeta 缓存文件的元数据行
+type ttsCacheMeta struct {
+	ContentType string `json:"content_type"`
+}
+
+// NewTTSCache 创建磁盘缓存并加载目录中已有的条目
+func NewTTSCache(cfg *TTSCacheConfig, metrics *Metrics) (*TTSCache, error) {
+	c := &TTSCache{
+		dir:          cfg.Dir,
+		maxSize:      cfg.MaxSize,
+		exposeHeader: cfg.ExposeHeader,
+		metrics:      metrics,
+		entries:      make(map[string]*list.Element),
+		lru:          list.New(),
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = defaultTTSCacheMaxSize
+	}
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create tts cache dir: %w", err)
+	}
+	if err := c.load(); err != nil {
+		return nil, fmt.Errorf("load tts cache: %w", err)
+	}
+	return c, nil
+}
+
+// load 按修改时间从旧到新加入 LRU，清理上次退出时残留的临时文件
+func (c *TTSCache) load() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range dirEntries {
+		name := e.Name()
+		if strings.HasSuffix(name, ttsCacheTmp) {
+			os.Remove(filepath.Join(c.dir, name)) //nolint:errcheck // 残留的临时文件，删除失败不影响使用
+			continue
+		}
+		key, ok := strings.CutSuffix(name, ttsCacheExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{key: key, size: info.Size(), modTime: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		c.entries[f.key] = c.lru.PushFront(&ttsCacheEntry{key: f.key, size: f.size})
+		c.size += f.size
+	}
+	c.evictLocked()
+	return nil
+}
+
+// path 条目的文件路径
+func (c *TTSCache) path(key string) string {
+	return filepath.Join(c.dir, key+ttsCacheExt)
+}
+
+// ttsCacheHit 命中的缓存条目，读取的是元数据行之后的音频
+type ttsCacheHit struct {
+	*bufio.Reader
+	file        *os.File
+	contentType string
+	size        int64 // 音频的字节数
+}
+
+func (h *ttsCacheHit) Close() error {
+	return h.file.Close()
+}
+
+// open 查找缓存，未命中时返回 nil；命中的条目移到 LRU 队首
+func (c *TTSCache) open(key string) *ttsCacheHit {
+	if c == nil || key == "" {
+		return nil
+	}
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	// 文件打开之后即使被淘汰删除，也可以继续读完
+	file, err := os.Open(c.path(key))
+	if err != nil {
+		c.removeLocked(elem)
+		c.mu.Unlock()
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	c.mu.Unlock()
+
+	now := time.Now()
+	os.Chtimes(file.Name(), now, now) //nolint:errcheck // 只影响重启后的淘汰顺序
+
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	var meta ttsCacheMeta
+	info, statErr := file.Stat()
+	if err != nil || statErr != nil || json.Unmarshal(line, &meta) != nil {
+		file.Close()
+		c.remove(key)
+		return nil
+	}
+	return &ttsCacheHit{Reader: reader, file: file, contentType: meta.ContentType, size: info.Size() - int64(len(line))}
+}
+
+// ttsCacheWriter 把一次成功的合成写入临时文件，完整结束后再加入缓存
+type ttsCacheWriter struct {
+	cache  *TTSCache
+	key    string
+	file   *os.File
+	size   int64
+	failed bool
+}
+
+// begin 开始写入一个条目，未开启缓存或响应不是音频时返回 nil
+func (c *TTSCache) begin(key, contentType string) *ttsCacheWriter {
+	if c == nil || key == "" || !cacheableAudio(contentType) {
+		return nil
+	}
+	file, err := os.CreateTemp(c.dir, key+".*"+ttsCacheTmp)
+	if err != nil {
+		slog.Warn("Failed to create tts cache file", "error", err)
+		return nil
+	}
+	w := &ttsCacheWriter{cache: c, key: key, file: file}
+	meta, _ := json.Marshal(ttsCacheMeta{ContentType: contentType})
+	w.Write(append(meta, '\n')) //nolint:errcheck // 写入失败记录在 failed 中
+	return w
+}
+
+// Write 实现 io.Writer；写入失败或超过容量上限时放弃缓存，但不影响转发（nil 时直接丢弃）
+func (w *ttsCacheWriter) Write(p []byte) (int, error) {
+	if w == nil || w.failed {
+		return len(p), nil
+	}
+	w.size += int64(len(p))
+	if w.size > w.cache.maxSize {
+		w.failed = true
+		return len(p), nil
+	}
+	if _, err := w.file.Write(p); err != nil {
+		slog.Warn("Failed to write tts cache file", "error", err)
+		w.failed = true
+	}
+	return len(p), nil
+}
+
+// finish 结束写入：complete 为 true 且没有出错时加入缓存，否则删除临时文件
+func (w *ttsCacheWriter) finish(complete bool) {
+	if w == nil {
+		return
+	}
+	err := w.file.Close()
+	if !complete || w.failed || err != nil {
+		os.Remove(w.file.Name()) //nolint:errcheck // 临时文件，下次启动时也会清理
+		return
+	}
+	if err := os.Rename(w.file.Name(), w.cache.path(w.key)); err != nil {
+		slog.Warn("Failed to commit tts cache file", "error", err)
+		os.Remove(w.file.Name()) //nolint:errcheck // 同上
+		return
+	}
+	w.cache.add(w.key, w.size)
+}
+
+// add 加入（或替换）一个条目，超过容量时淘汰最久未使用的条目
+func (c *TTSCache) add(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		// 同一内容并发未命中时各自写入，后完成的覆盖先完成的
+		entry := elem.Value.(*ttsCacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.lru.PushFront(&ttsCacheEntry{key: key, size: size})
+		c.size += size
+	}
+	c.evictLocked()
+}
+
+// remove 删除一个条目
+func (c *TTSCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.removeLocked(elem)
+		c.updateMetricsLocked()
+	}
+}
+
+// evictLocked 淘汰最久未使用的条目直到不超过容量
+func (c *TTSCache) evictLocked() {
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back())
+	}
+	c.updateMetricsLocked()
+}
+
+func (c *TTSCache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*ttsCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	os.Remove(c.path(entry.key)) //nolint:errcheck // 文件可能已被外部删除
+}
+
+func (c *TTSCache) updateMetricsLocked() {
+	if c.metrics != nil {
+		c.metrics.SetTTSCacheBytes(c.size)
+	}
+}
+
+// serveTTSCache 查找缓存，命中时从磁盘返回音频并记录日志；未命中时记下缓存键，由转发成功后写入
+func (p *Proxy) serveTTSCache(w http.ResponseWriter, r *http.Request, route *RouteConfig, requestBody []byte, ctx *RequestContext) (bool, error) {
+	outputFormat := r.Header.Get(azureOutputFormatHeader)
+	ctx.ttsCacheKey = ttsCacheKey(ctx.TenantID, route.Name, requestBody, outputFormat)
+	hit := p.ttsCache.open(ctx.ttsCacheKey)
+	if hit == nil {
+		ctx.TTSCache = "miss"
+		if p.ttsCache.exposeHeader {
+			w.Header().Set(ttsCacheHeader, "miss")
+		}
+		return false, nil
+	}
+	defer hit.Close()
+
+	ctx.TTSCache = "hit"
+	ctx.StatusCode = http.StatusOK
+	w.Header().Set("Content-Type", hit.contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(hit.size, 10))
+	w.Header().Set("X-Request-ID", ctx.RequestID)
+	if p.ttsCache.exposeHeader {
+		w.Header().Set(ttsCacheHeader, "hit")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	meter := newTTSAudioMeter(hit.contentType, outputFormat)
+	err := p.forwardRaw(w, io.TeeReader(hit, meter), ctx)
+	recordTTSAudio(ctx, meter, requestBody)
+	ctx.RTF = nil // 没有合成，实时率无意义
+	p.saveLog(ctx, string(requestBody))
+	return true, err
+}
+
+// cacheableAudio 只缓存音频响应，SSE（stream_format: sse）和 JSON 不缓存
+func cacheableAudio(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (strings.HasPrefix(mediaType, "audio/") || mediaType == "application/octet-stream")
+}
+
+// ttsCacheKey 缓存键：租户、路由、输出格式和规范化请求体的 SHA-256
+// 租户之间不共享条目，使用租户自己的上游凭证（BYOK）时合成的音频也不会返回给其他租户
+func ttsCacheKey(tenant, route string, body []byte, outputFormat string) string {
+	h := sha256.New()
+	io.WriteString(h, tenant+"\x00"+route)                         //nolint:errcheck // hash 写入不会失败
+	io.WriteString(h, "\x00"+strings.ToLower(outputFormat)+"\x00") //nolint:errcheck // 同上
+	h.Write(normalizeTTSInput(body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeTTSInput 规范化请求体，使只有空白或字段顺序不同的请求命中同一条目
+// JSON 按字段名排序并折叠 input / text 中的空白；SSML 和纯文本整体折叠空白
+func normalizeTTSInput(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var req map[string]any
+		if json.Unmarshal(trimmed, &req) == nil && req != nil {
+			for _, field := range []string{"input", "text"} {
+				if s, ok := req[field].(string); ok {
+					req[field] = collapseSpace(s)
+				}
+			}
+			if normalized, err := json.Marshal(req); err == nil {
+				return normalized
+			}
+		}
+	}
+	return []byte(collapseSpace(string(trimmed)))
+}
+
+// collapseSpace 去掉首尾空白，连续空白折叠为一个空格
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTSCacheKey(t *testing.T) {
+	format := "audio-24khz-48kbitrate-mono-mp3"
+	key := ttsCacheKey("acme", "tts", []byte(`{"model":"tts-1","voice":"alloy","input":"Hello  world"}`), "")
+
+	// 字段顺序和输入中的空白不影响缓存键
+	if got := ttsCacheKey("acme", "tts", []byte(`{"input":" Hello\nworld ","voice":"alloy","model":"tts-1"}`), ""); got != key {
+		t.Error("expected reordered JSON with different whitespace to share a key")
+	}
+	ssml := ttsCacheKey("acme", "tts", []byte("<speak>\n  <voice name=\"a\">Hi</voice>\n</speak>"), format)
+	if got := ttsCacheKey("acme", "tts", []byte(`<speak> <voice name="a">Hi</voice> </speak>`), strings.ToUpper(format)); got != ssml {
+		t.Error("expected SSML differing only in whitespace and format case to share a key")
+	}
+
+	for name, other := range map[string]string{
+		"voice":  ttsCacheKey("acme", "tts", []byte(`{"model":"tts-1","voice":"nova","input":"Hello world"}`), ""),
+		"text":   ttsCacheKey("acme", "tts", []byte(`{"model":"tts-1","voice":"alloy","input":"Hello world!"}`), ""),
+		"format": ttsCacheKey("acme", "tts", []byte("<speak>\n  <voice name=\"a\">Hi</voice>\n</speak>"), "riff-24khz-16bit-mono-pcm"),
+		"route":  ttsCacheKey("acme", "tts-hd", []byte(`{"model":"tts-1","voice":"alloy","input":"Hello world"}`), ""),
+		"tenant": ttsCacheKey("globex", "tts", []byte(`{"model":"tts-1","voice":"alloy","input":"Hello world"}`), ""),
+	} {
+		if other == key || other == ssml {
+			t.Errorf("expected a different %s to change the key", name)
+		}
+	}
+}
+
+// putTTSCache 写入一个条目
+func putTTSCache(c *TTSCache, key string, audio []byte) {
+	w := c.begin(key, "audio/mpeg")
+	w.Write(audio)
+	w.finish(true)
+}
+
+func TestTTSCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "stale.123"+ttsCacheTmp), []byte("partial"), 0o644)
+
+	// 每个条目 = 元数据行 + 100 字节音频，容量放得下两个
+	entrySize := int64(len(`{"content_type":"audio/mpeg"}`)+1) + 100
+	c, err := NewTTSCache(&TTSCacheConfig{Enabled: true, Dir: dir, MaxSize: 2*entrySize + 10}, getTestMetrics())
+	if err != nil {
+		t.Fatalf("NewTTSCache: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stale.123"+ttsCacheTmp)); !os.IsNotExist(err) {
+		t.Error("expected leftover temp files to be removed")
+	}
+
+	putTTSCache(c, "a", bytes.Repeat([]byte("a"), 100))
+	putTTSCache(c, "b", bytes.Repeat([]byte("b"), 100))
+	hit := c.open("a") // a 成为最近使用，b 被淘汰
+	if hit == nil || hit.contentType != "audio/mpeg" || hit.size != 100 {
+		t.Fatalf("expected a hit for a, got %+v", hit)
+	}
+	hit.Close()
+	putTTSCache(c, "c", bytes.Repeat([]byte("c"), 100))
+
+	if c.open("b") != nil {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, err := os.Stat(c.path("b")); !os.IsNotExist(err) {
+		t.Error("expected the evicted file to be removed")
+	}
+
+	// 未完成或超过容量的写入不加入缓存
+	w := c.begin("d", "audio/mpeg")
+	w.Write([]byte("partial"))
+	w.finish(false)
+	putTTSCache(c, "e", bytes.Repeat([]byte("e"), int(3*entrySize)))
+	if c.open("d") != nil || c.open("e") != nil {
+		t.Error("expected incomplete and oversized responses to be skipped")
+	}
+	if c.begin("f", "application/json") != nil {
+		t.Error("expected non-audio responses to be skipped")
+	}
+
+	// 重启后从目录恢复
+	reloaded, err := NewTTSCache(&TTSCacheConfig{Enabled: true, Dir: dir, MaxSize: 2*entrySize + 10}, getTestMetrics())
+	if err != nil {
+		t.Fatalf("NewTTSCache: %v", err)
+	}
+	if reloaded.size != 2*entrySize || len(reloaded.entries) != 2 {
+		t.Errorf("expected 2 entries after reload, got %d (%d bytes)", len(reloaded.entries), reloaded.size)
+	}
+	hit = reloaded.open("c")
+	if hit == nil {
+		t.Fatal("expected c to survive a reload")
+	}
+	defer hit.Close()
+	if audio, _ := io.ReadAll(hit); string(audio) != strings.Repeat("c", 100) {
+		t.Errorf("unexpected audio: %q", audio)
+	}
+}
+
+func TestProxy_TTSCache(t *testing.T) {
+	mp3 := testMP3(50, true)
+	var calls atomic.Int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 2 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(mp3)
+	})
+	up := httptest.NewServer(upstream)
+	t.Cleanup(up.Close)
+
+	cfg := &Config{
+		Server:   ServerConfig{Port: 8080, Timeout: 5 * time.Second},
+		Routes:   []RouteConfig{{Name: "tts", Path: "/v1/audio/speech", Upstream: up.URL, Kind: "raw", TTS: TTSConfig{Enabled: true, Cache: true}}},
+		TTSCache: TTSCacheConfig{Enabled: true, Dir: t.TempDir(), ExposeHeader: true},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	cache, err := NewTTSCache(&cfg.TTSCache, getTestMetrics())
+	if err != nil {
+		t.Fatalf("NewTTSCache: %v", err)
+	}
+	p := NewProxy(cfg, nil, getTestMetrics(), nil, NewSecretManager(cfg), cache)
+
+	speakAs := func(tenant, body string) (*httptest.ResponseRecorder, *RequestContext) {
+		t.Helper()
+		ctx := NewRequestContext(&Principal{TenantID: tenant})
+		rec := httptest.NewRecorder()
+		if err := p.Handle(rec, httptest.NewRequest(http.MethodPost, "/v1/audio/speech", strings.NewReader(body)), ctx); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+		return rec, ctx
+	}
+	speak := func(body string) (*httptest.ResponseRecorder, *RequestContext) {
+		t.Helper()
+		return speakAs("acme", body)
+	}
+
+	rec, ctx := speak(`{"model":"tts-1","voice":"alloy","input":"Hello world"}`)
+	if rec.Header().Get(ttsCacheHeader) != "miss" || ctx.TTSCache != "miss" || !bytes.Equal(rec.Body.Bytes(), mp3) {
+		t.Fatalf("expected a miss with upstream audio, got %q %d bytes", rec.Header().Get(ttsCacheHeader), rec.Body.Len())
+	}
+
+	rec, ctx = speak(`{"voice":"alloy","input":"Hello   world","model":"tts-1"}`)
+	if rec.Header().Get(ttsCacheHeader) != "hit" || ctx.TTSCache != "hit" || calls.Load() != 1 {
+		t.Fatalf("expected a hit without calling upstream, got %q after %d calls", rec.Header().Get(ttsCacheHeader), calls.Load())
+	}
+	if !bytes.Equal(rec.Body.Bytes(), mp3) || rec.Header().Get("Content-Type") != "audio/mpeg" {
+		t.Errorf("expected the cached audio, got %d bytes of %q", rec.Body.Len(), rec.Header().Get("Content-Type"))
+	}
+	if ctx.TTFAMs == nil || ctx.AudioDuration == nil || ctx.RTF != nil || ctx.StatusCode != http.StatusOK {
+		t.Errorf("unexpected metadata for a hit: ttfa=%v duration=%v rtf=%v", ctx.TTFAMs, ctx.AudioDuration, ctx.RTF)
+	}
+	if log := ctx.ToStreamLog(""); log.TTSCache != "hit" {
+		t.Errorf("expected the cache result in the log, got %q", log.TTSCache)
+	}
+
+	// 上游报错时不缓存
+	speak(`{"model":"tts-1","voice":"alloy","input":"Goodbye"}`)
+	rec, _ = speak(`{"model":"tts-1","voice":"alloy","input":"Goodbye"}`)
+	if rec.Header().Get(ttsCacheHeader) != "miss" || calls.Load() != 3 {
+		t.Errorf("expected the error response not to be cached, got %q after %d calls", rec.Header().Get(ttsCacheHeader), calls.Load())
+	}
+
+	// 其他租户不共享缓存条目
+	if _, ctx = speakAs("globex", `{"model":"tts-1","voice":"alloy","input":"Hello world"}`); ctx.TTSCache != "miss" || calls.Load() != 4 {
+		t.Errorf("expected another tenant to miss, got %q after %d calls", ctx.TTSCache, calls.Load())
+	}
+
+	// 默认不返回 X-TTS-Cache，结果只写入日志
+	cache.exposeHeader = false
+	if rec, ctx = speak(`{"model":"tts-1","voice":"alloy","input":"Hello world"}`); rec.Header().Get(ttsCacheHeader) != "" || ctx.TTSCache != "hit" {
+		t.Errorf("expected a hit without the header, got %q (%q)", rec.Header().Get(ttsCacheHeader), ctx.TTSCache)
+	}
+}
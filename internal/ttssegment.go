@@ -0,0 +1,607 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultTTSMaxParallel     = 3
+	defaultTTSMaxSegmentChars = 300
+	defaultTTSMaxSegments     = 20
+	// segmentBufferLimit 每段在内存中缓冲的字节数上限，达到后合成协程暂停读取上游，等转发追上
+	segmentBufferLimit = 1 << 20
+)
+
+// maxParallel 同时合成的段数
+func (t *TTSConfig) maxParallel() int {
+	if t.MaxParallel > 0 {
+		return t.MaxParallel
+	}
+	return defaultTTSMaxParallel
+}
+
+// maxSegments 每个请求最多分成的段数
+func (t *TTSConfig) maxSegments() int {
+	if t.MaxSegments > 0 {
+		return t.MaxSegments
+	}
+	return defaultTTSMaxSegments
+}
+
+// maxSegmentChars 第一段之后每段的字符数上限
+func (t *TTSConfig) maxSegmentChars() int {
+	if t.MaxSegmentChars > 0 {
+		return t.MaxSegmentChars
+	}
+	return defaultTTSMaxSegmentChars
+}
+
+// sentenceTerminators 中文句末标点和换行之后直接断句
+const sentenceTerminators = "。！？；…\n"
+
+// sentenceClosers 句末标点之后仍属于这一句的字符（连续的标点、右引号和括号）
+const sentenceClosers = "。！？；….!?\"'”’」』）)"
+
+// splitSentences 按句末标点切分，句子带上其后的空白，拼接后与原文相同
+// 英文的 . ! ? 后面需要跟空白才断句，避免切开小数和网址
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	ended := false
+	for i, r := range text {
+		if ended {
+			if unicode.IsSpace(r) || strings.ContainsRune(sentenceClosers, r) {
+				continue
+			}
+			sentences = append(sentences, text[start:i])
+			start = i
+			ended = false
+		}
+		switch {
+		case strings.ContainsRune(sentenceTerminators, r):
+			ended = true
+		case strings.ContainsRune(".!?", r):
+			next, _ := utf8.DecodeRuneInString(text[i+1:])
+			ended = unicode.IsSpace(next)
+		}
+	}
+	if start < len(text) {
+		sentences = append(sentences, text[start:])
+	}
+	return sentences
+}
+
+// endsSentence 文本是否以句末结束，即后面接上其他文本时会断句
+func endsSentence(s string) bool {
+	sentences := splitSentences(s + " x")
+	return len(sentences) > 1 && sentences[len(sentences)-1] == "x"
+}
+
+// groupSentences 把句子合并为分段，返回每段的 [起始, 结束) 下标
+// 第一句单独成段以便尽快开始合成，之后每段在不超过 maxChars 的前提下尽量多放句子
+func groupSentences(lengths []int, maxChars int) [][2]int {
+	if len(lengths) == 0 {
+		return nil
+	}
+	groups := [][2]int{{0, 1}}
+	chars := 0
+	for i := 1; i < len(lengths); i++ {
+		last := &groups[len(groups)-1]
+		if last[0] > 0 && chars+lengths[i] <= maxChars {
+			last[1] = i + 1
+			chars += lengths[i]
+			continue
+		}
+		groups = append(groups, [2]int{i, i + 1})
+		chars = lengths[i]
+	}
+	return groups
+}
+
+// textChars 计入分段长度的字符数（不含首尾空白）
+func textChars(s string) int {
+	return utf8.RuneCountInString(strings.TrimSpace(s))
+}
+
+// splitTTSInput 把 TTS 请求体按句切分为多个请求体，无法切分或只有一段时返回 nil
+// SSML（Azure）按 X-Microsoft-OutputFormat 判断输出能否拼接；JSON（OpenAI）切分 input 字段
+func splitTTSInput(body []byte, outputFormat string, maxChars int) [][]byte {
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		return splitTTSJSON(trimmed, maxChars)
+	case bytes.HasPrefix(trimmed, []byte("<")) && splicableOutputFormat(outputFormat):
+		return splitSSML(trimmed, maxChars)
+	}
+	return nil
+}
+
+// splicableOutputFormat Azure 输出格式能否直接拼接：MP3、WAV（riff-*）和无容器 PCM；Ogg / WebM 等不支持
+func splicableOutputFormat(outputFormat string) bool {
+	format := strings.ToLower(outputFormat)
+	return strings.HasSuffix(format, "-mp3") || strings.HasPrefix(format, "riff-") || azureRawFormat.MatchString(format)
+}
+
+// splicableResponseFormats OpenAI response_format 中能直接拼接的格式，空表示默认的 mp3
+var splicableResponseFormats = map[string]bool{"": true, "mp3": true, "wav": true, "pcm": true}
+
+// splitTTSJSON 切分 OpenAI 风格请求的 input 字段，其他字段原样保留
+func splitTTSJSON(body []byte, maxChars int) [][]byte {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+	var input, responseFormat, streamFormat string
+	if json.Unmarshal(req["input"], &input) != nil {
+		return nil
+	}
+	if raw, ok := req["response_format"]; ok && json.Unmarshal(raw, &responseFormat) != nil {
+		return nil
+	}
+	if raw, ok := req["stream_format"]; ok && json.Unmarshal(raw, &streamFormat) != nil {
+		return nil
+	}
+	// stream_format: sse 返回的是 SSE 事件而不是音频
+	if !splicableResponseFormats[responseFormat] || streamFormat == "sse" {
+		return nil
+	}
+
+	sentences := splitSentences(input)
+	lengths := make([]int, len(sentences))
+	for i, s := range sentences {
+		lengths[i] = textChars(s)
+	}
+	groups := groupSentences(lengths, maxChars)
+	if len(groups) < 2 {
+		return nil
+	}
+
+	segments := make([][]byte, 0, len(groups))
+	for _, g := range groups {
+		text, err := json.Marshal(strings.TrimSpace(strings.Join(sentences[g[0]:g[1]], "")))
+		if err != nil {
+			return nil
+		}
+		req["input"] = text
+		segment, err := json.Marshal(req)
+		if err != nil {
+			return nil
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// ssmlUnit SSML 中的一句，open / close 为切开时需要补上的外层标签
+type ssmlUnit struct {
+	open    string
+	content string
+	close   string
+	chars   int
+}
+
+// splitSSML 在 <voice> 内的句末切分 SSML，每段补全外层的开闭标签（speak、voice、prosody 等）
+func splitSSML(ssml []byte, maxChars int) [][]byte {
+	units, err := ssmlUnits(ssml)
+	if err != nil {
+		return nil
+	}
+	lengths := make([]int, len(units))
+	for i, u := range units {
+		lengths[i] = u.chars
+	}
+	groups := groupSentences(lengths, maxChars)
+	if len(groups) < 2 {
+		return nil
+	}
+
+	segments := make([][]byte, 0, len(groups))
+	for _, g := range groups {
+		var segment bytes.Buffer
+		segment.WriteString(units[g[0]].open)
+		for _, u := range units[g[0]:g[1]] {
+			segment.WriteString(u.content)
+		}
+		segment.WriteString(units[g[1]-1].close)
+		segments = append(segments, segment.Bytes())
+	}
+	return segments
+}
+
+// ssmlUnits 按句拆分 SSML，所有单元的 content 拼接后与原文相同
+func ssmlUnits(ssml []byte) ([]ssmlUnit, error) {
+	type element struct {
+		name string
+		raw  string
+	}
+	var stack []element
+	openTags := func() string {
+		var b strings.Builder
+		for _, e := range stack {
+			b.WriteString(e.raw)
+		}
+		return b.String()
+	}
+	closeTags := func() string {
+		var b strings.Builder
+		for i := len(stack) - 1; i >= 0; i-- {
+			b.WriteString("</" + stack[i].name + ">")
+		}
+		return b.String()
+	}
+	inVoice := func() bool {
+		for _, e := range stack {
+			if e.name == "voice" || strings.HasSuffix(e.name, ":voice") {
+				return true
+			}
+		}
+		return false
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(ssml))
+	var units []ssmlUnit
+	var cur ssmlUnit
+	var content strings.Builder
+	split := func() {
+		cur.content = content.String()
+		cur.close = closeTags()
+		units = append(units, cur)
+		content.Reset()
+		cur = ssmlUnit{open: openTags()}
+	}
+	// ended 上一段文本以句末结束且之后没有新开的元素，可以在下一段文本之前切分（例如 </prosody> 之后）
+	ended := false
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		raw := string(ssml[start:decoder.InputOffset()])
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if t.Name.Space != "" {
+				name = t.Name.Space + ":" + name
+			}
+			stack = append(stack, element{name: name, raw: raw})
+			ended = false
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected end element %s", t.Name.Local)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if !inVoice() || strings.HasPrefix(raw, "<![CDATA[") || strings.TrimSpace(raw) == "" {
+				break
+			}
+			if ended && cur.chars > 0 {
+				split()
+			}
+			sentences := splitSentences(raw)
+			for _, sentence := range sentences[:len(sentences)-1] {
+				content.WriteString(sentence)
+				cur.chars += textChars(sentence)
+				split()
+			}
+			raw = sentences[len(sentences)-1]
+			cur.chars += textChars(raw)
+			ended = endsSentence(raw)
+		}
+		content.WriteString(raw)
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("unclosed element %s", stack[len(stack)-1].name)
+	}
+	cur.content = content.String()
+	return append(units, cur), nil
+}
+
+// segmentBuffer 一段合成结果的缓冲：合成协程写入，转发时按顺序读取
+// 正在转发的段边到边读，之后的段先缓存在内存中，超过 segmentBufferLimit 时写入阻塞（背压）
+type segmentBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	resp     *http.Response // 只使用状态码和响应头，body 由合成协程读取
+	data     []byte
+	done     bool
+	err      error
+	canceled bool // 请求已结束，阻塞的写入直接返回
+}
+
+// newSegmentBuffer 创建缓冲，ctx 结束后唤醒阻塞的写入
+func newSegmentBuffer(ctx context.Context) *segmentBuffer {
+	b := &segmentBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.canceled = true
+		b.cond.Broadcast()
+	})
+	return b
+}
+
+// setResponse 收到上游响应头
+func (b *segmentBuffer) setResponse(resp *http.Response) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resp = resp
+	b.cond.Broadcast()
+}
+
+// response 等待上游响应头，请求失败时返回错误
+func (b *segmentBuffer) response() (*http.Response, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.resp == nil && !b.done {
+		b.cond.Wait()
+	}
+	if b.resp == nil {
+		return nil, b.err
+	}
+	return b.resp, nil
+}
+
+func (b *segmentBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) >= segmentBufferLimit && !b.canceled {
+		b.cond.Wait()
+	}
+	if b.canceled {
+		return 0, context.Canceled
+	}
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+// finish 合成结束，err 为 nil 表示正常读完
+func (b *segmentBuffer) finish(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.done {
+		return
+	}
+	b.done = true
+	b.err = err
+	b.cond.Broadcast()
+}
+
+func (b *segmentBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 && !b.done {
+		b.cond.Wait()
+	}
+	if len(b.data) > 0 {
+		n := copy(p, b.data)
+		b.data = b.data[n:]
+		b.cond.Broadcast()
+		return n, nil
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return 0, io.EOF
+}
+
+// audioSplicer 拼接多段音频时处理文件头，使输出仍是一个可播放的流
+// 第一段的 WAV 头改为未知长度（0xFFFFFFFF），后续段去掉 WAV 头和 ID3 标签；MP3 帧和 PCM 直接拼接
+type audioSplicer struct {
+	w     io.Writer
+	first bool
+	head  []byte // 文件头处理完之前缓冲的字节
+	ready bool
+	skip  int64
+}
+
+func (s *audioSplicer) Write(p []byte) (int, error) {
+	n := len(p)
+	if !s.ready {
+		s.head = append(s.head, p...)
+		out, ok := s.spliceHead()
+		if !ok {
+			return n, nil
+		}
+		s.ready = true
+		s.head = nil
+		p = out
+	} else if s.skip > 0 {
+		k := min(s.skip, int64(len(p)))
+		s.skip -= k
+		p = p[k:]
+	}
+	if len(p) == 0 {
+		return n, nil
+	}
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// flush 段结束时写出仍在缓冲中的字节（不足一个文件头的短段）
+func (s *audioSplicer) flush() error {
+	if s.ready || len(s.head) == 0 {
+		return nil
+	}
+	s.ready = true
+	_, err := s.w.Write(s.head)
+	return err
+}
+
+// spliceHead 处理缓冲的文件头，返回要写出的字节；还需要更多数据时返回 false
+func (s *audioSplicer) spliceHead() ([]byte, bool) {
+	head := s.head
+	if len(head) < 12 {
+		return nil, false
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WAVE")):
+		offset := 12
+		for offset+8 <= len(head) {
+			size := int(binary.LittleEndian.Uint32(head[offset+4 : offset+8]))
+			if string(head[offset:offset+4]) == "data" {
+				if !s.first {
+					return head[offset+8:], true
+				}
+				patched := bytes.Clone(head)
+				binary.LittleEndian.PutUint32(patched[4:8], 0xffffffff)
+				binary.LittleEndian.PutUint32(patched[offset+4:offset+8], 0xffffffff)
+				return patched, true
+			}
+			offset += 8 + size + size&1
+		}
+		if len(head) > audioMeterMaxHeader {
+			return head, true
+		}
+		return nil, false
+	case bytes.HasPrefix(head, []byte("ID3")) && !s.first:
+		size := id3TagSize(head)
+		if int64(len(head)) >= size {
+			return head[size:], true
+		}
+		s.skip = size - int64(len(head))
+		return nil, true
+	}
+	return head, true
+}
+
+// forwardTTSSegments 分段合成：按顺序发起请求，同时进行的不超过 max_parallel 段，
+// 第一段的响应决定状态码和响应头，各段音频严格按顺序拼接转发
+func (p *Proxy) forwardTTSSegments(w http.ResponseWriter, r *http.Request, route *RouteConfig, segments [][]byte, requestBody []byte, ctx *RequestContext) error {
+	reqCtx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(reqCtx)
+
+	// 1. 先构造所有上游请求（合成协程不访问 ctx）
+	reqs := make([]*http.Request, len(segments))
+	for i, body := range segments {
+		req, err := p.buildUpstreamRequest(r, route, body, ctx)
+		if err != nil {
+			if ctx.ErrorType != "" {
+				p.saveLog(ctx, string(requestBody))
+			}
+			return fmt.Errorf("build upstream request: %w", err)
+		}
+		reqs[i] = req
+	}
+
+	// 2. 按顺序发起合成
+	buffers := make([]*segmentBuffer, len(reqs))
+	for i := range buffers {
+		buffers[i] = newSegmentBuffer(reqCtx)
+	}
+	auth := p.auth[route.Name]
+	go func() {
+		sem := make(chan struct{}, route.TTS.maxParallel())
+		for i, req := range reqs {
+			select {
+			case sem <- struct{}{}:
+			case <-reqCtx.Done():
+				for _, b := range buffers[i:] {
+					b.finish(reqCtx.Err())
+				}
+				return
+			}
+			go func() {
+				defer func() { <-sem }()
+				p.synthesizeSegment(req, buffers[i], auth)
+			}()
+		}
+	}()
+
+	// 3. 第一段的响应决定状态码和响应头，合并后的长度未知
+	first, err := buffers[0].response()
+	if err != nil {
+		ctx.ErrorType = "upstream_error"
+		ctx.ErrorMessage = err.Error()
+		p.saveLog(ctx, string(requestBody))
+		return fmt.Errorf("upstream request: %w", err)
+	}
+	ctx.StatusCode = first.StatusCode
+	p.copyUpstreamHeaders(w, first.Header)
+	w.Header().Del("Content-Length")
+	w.Header().Set("X-Request-ID", ctx.RequestID)
+	w.WriteHeader(first.StatusCode)
+
+	if first.StatusCode != http.StatusOK {
+		// 上游报错，原样转发第一段的错误响应
+		err = p.forwardRaw(w, buffers[0], ctx)
+		p.saveLog(ctx, string(requestBody))
+		return err
+	}
+
+	// 4. 按顺序拼接，某一段失败时结束流（客户端收到截断的音频）
+	pr, pw := io.Pipe()
+	go func() {
+		for i, b := range buffers {
+			if err := spliceSegment(pw, b, i); err != nil {
+				pw.CloseWithError(fmt.Errorf("tts segment %d: %w", i, err))
+				cancel()
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	meter := newTTSAudioMeter(first.Header.Get("Content-Type"), r.Header.Get(azureOutputFormatHeader))
+	cache := p.ttsCache.begin(ctx.ttsCacheKey, first.Header.Get("Content-Type"))
+	err = p.forwardRaw(w, io.TeeReader(pr, io.MultiWriter(meter, cache)), ctx)
+	cache.finish(err == nil)
+	recordTTSAudio(ctx, meter, requestBody)
+	p.saveLog(ctx, string(requestBody))
+	return err
+}
+
+// synthesizeSegment 合成一段，结果写入缓冲
+func (p *Proxy) synthesizeSegment(req *http.Request, b *segmentBuffer, auth upstreamAuthenticator) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		b.finish(redactUpstreamError(err, auth))
+		return
+	}
+	defer resp.Body.Close()
+
+	b.setResponse(resp)
+	_, err = io.Copy(b, resp.Body)
+	b.finish(err)
+}
+
+// errSegmentStatus 后续段的上游响应不是 200
+var errSegmentStatus = errors.New("upstream returned")
+
+// spliceSegment 把第 i 段音频写入输出
+func spliceSegment(w io.Writer, b *segmentBuffer, i int) error {
+	resp, err := b.response()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(b, 512))
+		return fmt.Errorf("%w %d: %s", errSegmentStatus, resp.StatusCode, bytes.TrimSpace(message))
+	}
+	splicer := &audioSplicer{w: w, first: i == 0}
+	if _, err := io.Copy(splicer, b); err != nil {
+		return err
+	}
+	return splicer.flush()
+}
@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello world. How are you? Fine!", []string{"Hello world. ", "How are you? ", "Fine!"}},
+		{"你好。今天天气不错！走吧", []string{"你好。", "今天天气不错！", "走吧"}},
+		{"Pi is 3.14. Really?! Yes.", []string{"Pi is 3.14. ", "Really?! ", "Yes."}},
+		{"他说：“好的。”然后走了。", []string{"他说：“好的。”", "然后走了。"}},
+		{"line one\nline two", []string{"line one\n", "line two"}},
+		{"no terminator", []string{"no terminator"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := splitSentences(tt.text)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitSentences(%q): expected %q, got %q", tt.text, tt.want, got)
+		}
+		if strings.Join(got, "") != tt.text {
+			t.Errorf("splitSentences(%q): pieces do not join back to the input", tt.text)
+		}
+	}
+}
+
+func TestGroupSentences(t *testing.T) {
+	got := groupSentences([]int{10, 20, 30, 60, 5}, 60)
+	want := [][2]int{{0, 1}, {1, 3}, {3, 4}, {4, 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSplitTTSInput_JSON(t *testing.T) {
+	body := `{"model":"tts-1","voice":"alloy","input":"First sentence. Second one. Third one."}`
+	segments := splitTTSInput([]byte(body), "", 30)
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(segments))
+	}
+	var inputs []string
+	for _, s := range segments {
+		var req map[string]string
+		if err := json.Unmarshal(s, &req); err != nil {
+			t.Fatalf("segment is not JSON: %s", s)
+		}
+		if req["model"] != "tts-1" || req["voice"] != "alloy" {
+			t.Errorf("expected other fields to be kept: %s", s)
+		}
+		inputs = append(inputs, req["input"])
+	}
+	if !reflect.DeepEqual(inputs, []string{"First sentence.", "Second one. Third one."}) {
+		t.Errorf("unexpected inputs: %q", inputs)
+	}
+
+	for _, body := range []string{
+		`{"input":"One. Two.","response_format":"opus"}`,
+		`{"input":"One. Two.","stream_format":"sse"}`,
+		`{"input":"Only one sentence."}`,
+	} {
+		if segments := splitTTSInput([]byte(body), "", 30); segments != nil {
+			t.Errorf("expected %s not to be split, got %d segments", body, len(segments))
+		}
+	}
+}
+
+func TestSplitTTSInput_SSML(t *testing.T) {
+	ssml := `<speak version="1.0" xml:lang="zh-CN"><voice name="zh-CN-XiaoxiaoNeural"><prosody rate="+10%">第一句。第二句！</prosody>第三句。</voice></speak>`
+	segments := splitTTSInput([]byte(ssml), "audio-24khz-48kbitrate-mono-mp3", 3)
+	want := []string{
+		`<speak version="1.0" xml:lang="zh-CN"><voice name="zh-CN-XiaoxiaoNeural"><prosody rate="+10%">第一句。</prosody></voice></speak>`,
+		`<speak version="1.0" xml:lang="zh-CN"><voice name="zh-CN-XiaoxiaoNeural"><prosody rate="+10%">第二句！</prosody></voice></speak>`,
+		`<speak version="1.0" xml:lang="zh-CN"><voice name="zh-CN-XiaoxiaoNeural">第三句。</voice></speak>`,
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("expected %d segments, got %q", len(want), segments)
+	}
+	for i := range want {
+		if string(segments[i]) != want[i] {
+			t.Errorf("segment %d:\nexpected %s\ngot      %s", i, want[i], segments[i])
+		}
+	}
+
+	if segments := splitTTSInput([]byte(ssml), "ogg-24khz-16bit-mono-opus", 3); segments != nil {
+		t.Error("expected ogg output not to be split")
+	}
+	if segments := splitTTSInput([]byte(ssml), "", 3); segments != nil {
+		t.Error("expected a request without output format not to be split")
+	}
+	if segments := splitTTSInput([]byte(`<speak><voice>One. Two.`), "riff-16khz-16bit-mono-pcm", 3); segments != nil {
+		t.Error("expected broken SSML not to be split")
+	}
+}
+
+func TestAudioSplicer(t *testing.T) {
+	splice := func(parts [][]byte) []byte {
+		var out bytes.Buffer
+		for i, part := range parts {
+			s := &audioSplicer{w: &out, first: i == 0}
+			// 逐字节写入，文件头跨越写入边界
+			for _, b := range part {
+				s.Write([]byte{b})
+			}
+			s.flush()
+		}
+		return out.Bytes()
+	}
+
+	t.Run("wav", func(t *testing.T) {
+		wav := testWAV(1, 32000)
+		out := splice([][]byte{wav, wav, wav})
+		header := len(wav) - 32000
+		if len(out) != header+3*32000 {
+			t.Fatalf("expected a single header and 3s of audio, got %d bytes", len(out))
+		}
+		if binary.LittleEndian.Uint32(out[4:8]) != 0xffffffff || binary.LittleEndian.Uint32(out[header-4:header]) != 0xffffffff {
+			t.Error("expected the RIFF and data sizes to be patched to unknown")
+		}
+		if d, ok := meter(out, 4096).Duration(); !ok || d != 3*time.Second {
+			t.Errorf("expected 3s, got %v", d)
+		}
+	})
+
+	t.Run("mp3", func(t *testing.T) {
+		mp3 := testMP3(10, true)
+		out := splice([][]byte{mp3, mp3})
+		if len(out) != len(mp3)+len(mp3)-210 {
+			t.Fatalf("expected the second ID3 tag to be stripped, got %d bytes", len(out))
+		}
+		if !bytes.HasPrefix(out, []byte("ID3")) {
+			t.Error("expected the first ID3 tag to be kept")
+		}
+	})
+
+	t.Run("short segment", func(t *testing.T) {
+		out := splice([][]byte{[]byte("abc"), []byte("de")})
+		if string(out) != "abcde" {
+			t.Errorf("expected short segments to pass through, got %q", out)
+		}
+	})
+}
+
+// segmentRoute 开启分段合成的 raw 路由
+func segmentRoute(tts TTSConfig) RouteConfig {
+	return RouteConfig{Name: "tts", Path: "/v1/audio/speech", Kind: "raw", TTS: tts}
+}
+
+func TestProxy_TTSSegments(t *testing.T) {
+	var active, peak atomic.Int32
+	var mu sync.Mutex
+	var inputs []string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		var req struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		inputs = append(inputs, req.Input)
+		mu.Unlock()
+
+		// 第一段最慢，验证输出顺序不受完成顺序影响
+		if req.Input == "One." {
+			time.Sleep(100 * time.Millisecond)
+		} else {
+			time.Sleep(20 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		wav := testWAV(1, 32000)
+		copy(wav[len(wav)-32000:], strings.Repeat(req.Input[:1], 32000))
+		w.Write(wav)
+	})
+	p := newRouteProxy(t, upstream, segmentRoute(TTSConfig{Enabled: true, Segment: true, MaxParallel: 2, MaxSegmentChars: 4}))
+
+	body := `{"model":"tts-1","voice":"alloy","response_format":"wav","input":"One. Two. Three. Four."}`
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", strings.NewReader(body))
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/wav" {
+		t.Fatalf("unexpected response: %d %v", rec.Code, rec.Header())
+	}
+	if len(inputs) != 4 {
+		t.Fatalf("expected 4 upstream requests, got %q", inputs)
+	}
+	if peak.Load() > 2 {
+		t.Errorf("expected at most 2 parallel requests, got %d", peak.Load())
+	}
+
+	out := rec.Body.Bytes()
+	if bytes.Count(out, []byte("RIFF")) != 1 {
+		t.Error("expected a single WAV header")
+	}
+	data := out[len(out)-4*32000:]
+	for i, c := range []byte("OTTF") {
+		if data[i*32000] != c {
+			t.Errorf("segment %d out of order: got %q", i, data[i*32000])
+		}
+	}
+	if ctx.AudioDuration == nil || *ctx.AudioDuration != 4000 {
+		t.Errorf("expected 4s of audio, got %v", ctx.AudioDuration)
+	}
+	if ctx.InputChars == nil || *ctx.InputChars != 22 {
+		t.Errorf("expected input chars of the whole request, got %v", ctx.InputChars)
+	}
+}
+
+func TestProxy_TTSSegmentError(t *testing.T) {
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("Two.")) {
+			http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(testMP3(10, false))
+	})
+
+	t.Run("later segment", func(t *testing.T) {
+		p := newRouteProxy(t, upstream, segmentRoute(TTSConfig{Enabled: true, Segment: true, MaxSegmentChars: 4}))
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", strings.NewReader(`{"input":"One. Two. Three."}`))
+		ctx := NewRequestContext(&Principal{TenantID: "acme"})
+		rec := httptest.NewRecorder()
+		err := p.Handle(rec, req, ctx)
+		if err == nil || !strings.Contains(err.Error(), "tts segment 1") {
+			t.Fatalf("expected a segment error, got %v", err)
+		}
+		if rec.Code != http.StatusOK || rec.Body.Len() != len(testMP3(10, false)) {
+			t.Errorf("expected the first segment to be forwarded, got %d bytes", rec.Body.Len())
+		}
+	})
+
+	t.Run("first segment", func(t *testing.T) {
+		p := newRouteProxy(t, upstream, segmentRoute(TTSConfig{Enabled: true, Segment: true, MaxSegmentChars: 4}))
+		req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", strings.NewReader(`{"input":"Two. One."}`))
+		ctx := NewRequestContext(&Principal{TenantID: "acme"})
+		rec := httptest.NewRecorder()
+		if err := p.Handle(rec, req, ctx); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+		if rec.Code != http.StatusTooManyRequests || !strings.Contains(rec.Body.String(), "quota exceeded") {
+			t.Errorf("expected the upstream error to be forwarded, got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestProxy_TTSSegmentsLimit(t *testing.T) {
+	var calls atomic.Int32
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(testMP3(10, false))
+	})
+	p := newRouteProxy(t, upstream, segmentRoute(TTSConfig{Enabled: true, Segment: true, MaxSegmentChars: 4, MaxSegments: 2}))
+
+	// 切分出 3 段，超过 max_segments，按一个请求转发
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", strings.NewReader(`{"input":"One. Two. Three."}`))
+	if err := p.Handle(httptest.NewRecorder(), req, NewRequestContext(&Principal{TenantID: "acme"})); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected a single upstream request, got %d", calls.Load())
+	}
+}
+
+func TestSegmentBuffer_Backpressure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := newSegmentBuffer(ctx)
+
+	// 达到上限后写入阻塞，读出后继续
+	written := make(chan error, 1)
+	go func() {
+		_, err := b.Write(make([]byte, segmentBufferLimit))
+		if err == nil {
+			_, err = b.Write([]byte("more"))
+		}
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		t.Fatalf("expected the write over the limit to block, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := b.Read(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatalf("expected the write to resume, got %v", err)
+	}
+
+	// 请求结束后阻塞的写入返回错误
+	b.Write(make([]byte, 1024))
+	go func() {
+		_, err := b.Write([]byte("blocked"))
+		written <- err
+	}()
+	cancel()
+	select {
+	case err := <-written:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write still blocked after cancel")
+	}
+}
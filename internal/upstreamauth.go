@@ -0,0 +1,493 @@
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// UpstreamAuthHeader 把凭证放在 auth_header 中（默认）
+	UpstreamAuthHeader = "header"
+	// UpstreamAuthQuery 把凭证放在查询参数中（例如 Gemini 的 ?key=）
+	UpstreamAuthQuery = "query"
+	// UpstreamAuthSigV4 AWS SigV4 签名（例如 Bedrock）
+	UpstreamAuthSigV4 = "sigv4"
+	// UpstreamAuthAzureAD Azure AD client credentials 换取的 access token
+	UpstreamAuthAzureAD = "azure_ad"
+	// UpstreamAuthGoogleServiceAccount Google 服务账号 JWT 换取的 access token
+	UpstreamAuthGoogleServiceAccount = "google_service_account"
+)
+
+const (
+	// defaultAuthQueryParam query 方式默认的参数名
+	defaultAuthQueryParam = "key"
+	// defaultAzureADAuthority Azure AD 的 token 端点
+	defaultAzureADAuthority = "https://login.microsoftonline.com"
+	// defaultGoogleTokenURL 服务账号 JSON 中没有 token_uri 时使用
+	defaultGoogleTokenURL = "https://oauth2.googleapis.com/token"
+	// tokenRefreshMargin access token 过期前多久刷新
+	tokenRefreshMargin = 5 * time.Minute
+	// tokenPruneInterval 清理过期 token 的间隔，过期超过这个时间的条目被删除
+	tokenPruneInterval = 10 * time.Minute
+	// maxTokenResponseSize token 端点响应的最大字节数
+	maxTokenResponseSize = 1 << 20
+)
+
+// upstreamAuthenticator 把凭证加到上游请求上，secret 来自路由配置或租户自带凭证
+type upstreamAuthenticator interface {
+	apply(ctx context.Context, req *http.Request, secret string, body []byte) error
+}
+
+// newUpstreamAuthenticator 按路由的 upstream_auth.type 创建，路由不需要凭证时返回 nil
+func newUpstreamAuthenticator(route *RouteConfig, tokens *tokenCache) upstreamAuthenticator {
+	auth := &route.UpstreamAuth
+	switch auth.Type {
+	case UpstreamAuthQuery:
+		param := auth.Param
+		if param == "" {
+			param = defaultAuthQueryParam
+		}
+		return queryAuth{param: param}
+	case UpstreamAuthSigV4:
+		return &sigV4Auth{config: auth, now: time.Now}
+	case UpstreamAuthAzureAD:
+		return &azureADAuth{route: route.Name, config: auth, header: bearerHeader(route), tokens: tokens}
+	case UpstreamAuthGoogleServiceAccount:
+		return &googleServiceAccountAuth{route: route.Name, config: auth, header: bearerHeader(route), tokens: tokens}
+	default:
+		// Gemini 没有指定请求头时使用 ?key=
+		if route.AuthHeader == "" && route.Provider == ProviderGemini && route.UpstreamAuth.Type == "" {
+			return queryAuth{param: defaultAuthQueryParam}
+		}
+		if route.AuthHeader == "" {
+			return nil
+		}
+		return headerAuth{header: route.AuthHeader}
+	}
+}
+
+// bearerHeader access token 放在哪个请求头，默认 Authorization
+func bearerHeader(route *RouteConfig) string {
+	if route.AuthHeader == "" {
+		return "Authorization"
+	}
+	return route.AuthHeader
+}
+
+// headerAuth 把凭证放在请求头中
+type headerAuth struct {
+	header string
+}
+
+func (a headerAuth) apply(_ context.Context, req *http.Request, secret string, _ []byte) error {
+	// 自动添加 Bearer 前缀（如果是 Authorization header 且还没有前缀）
+	if a.header == "Authorization" && !strings.HasPrefix(secret, "Bearer ") {
+		secret = "Bearer " + secret
+	}
+	req.Header.Set(a.header, secret)
+	return nil
+}
+
+// queryAuth 把凭证放在查询参数中，覆盖客户端传入的同名参数
+type queryAuth struct {
+	param string
+}
+
+func (a queryAuth) apply(_ context.Context, req *http.Request, secret string, _ []byte) error {
+	query := req.URL.Query()
+	query.Set(a.param, secret)
+	req.URL.RawQuery = query.Encode()
+	return nil
+}
+
+// redactQuery 把 URL 中的凭证参数替换为 REDACTED，避免出现在错误信息和日志中
+func (a queryAuth) redactQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	if !query.Has(a.param) {
+		return rawURL
+	}
+	query.Set(a.param, "REDACTED")
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// redactUpstreamError 去掉 *url.Error 中 URL 携带的凭证
+func redactUpstreamError(err error, auth upstreamAuthenticator) error {
+	q, ok := auth.(queryAuth)
+	var urlErr *url.Error
+	if !ok || !errors.As(err, &urlErr) {
+		return err
+	}
+	urlErr.URL = q.redactQuery(urlErr.URL)
+	return err
+}
+
+// sigV4Auth AWS SigV4 签名
+// secret 为 secret access key，或 "<access_key_id>:<secret_access_key>[:<session_token>]"（租户自带凭证时使用）
+type sigV4Auth struct {
+	config *UpstreamAuthConfig
+	now    func() time.Time
+}
+
+func (a *sigV4Auth) apply(_ context.Context, req *http.Request, secret string, body []byte) error {
+	accessKeyID, secretKey, sessionToken := a.config.AccessKeyID, secret, a.config.SessionToken
+	if parts := strings.SplitN(secret, ":", 3); len(parts) > 1 {
+		accessKeyID, secretKey, sessionToken = parts[0], parts[1], ""
+		if len(parts) == 3 {
+			sessionToken = parts[2]
+		}
+	}
+	if accessKeyID == "" {
+		return errors.New("sigv4: access_key_id is required")
+	}
+
+	now := a.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	// 只签 host 和 x-amz-*，客户端透传的其他请求头不参与签名
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lower := strings.ToLower(k); strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	payloadHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		sigV4CanonicalURI(req.URL),
+		sigV4CanonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + a.config.Region + "/" + a.config.Service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, a.config.Region)
+	key = hmacSHA256(key, a.config.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+// hmacSHA256 计算 HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sigV4Escape 按 RFC 3986 编码（空格为 %20，保留 -_.~）
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// sigV4CanonicalURI 非 S3 服务的路径每段再编码一次（例如 Bedrock 模型 ID 中的 %3A 变为 %253A）
+func sigV4CanonicalURI(u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = sigV4Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// sigV4CanonicalQuery 按参数名和值排序后编码
+func sigV4CanonicalQuery(u *url.URL) string {
+	query := u.Query()
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, sigV4Escape(name)+"="+sigV4Escape(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// azureADAuth 用 client credentials 向 Azure AD 换取 access token，secret 为 client secret
+type azureADAuth struct {
+	route  string
+	config *UpstreamAuthConfig
+	header string
+	tokens *tokenCache
+}
+
+func (a *azureADAuth) apply(ctx context.Context, req *http.Request, secret string, _ []byte) error {
+	tokenURL := a.config.TokenURL
+	if tokenURL == "" {
+		tokenURL = defaultAzureADAuthority + "/" + url.PathEscape(a.config.TenantID) + "/oauth2/v2.0/token"
+	}
+	token, err := a.tokens.get(ctx, a.route, secret, func(ctx context.Context) (*accessToken, error) {
+		return a.tokens.exchange(ctx, tokenURL, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {a.config.ClientID},
+			"client_secret": {secret},
+			"scope":         {strings.Join(a.config.Scopes, " ")},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("azure ad: %w", err)
+	}
+	req.Header.Set(a.header, "Bearer "+token)
+	return nil
+}
+
+// googleServiceAccount 服务账号 JSON key 中用到的字段
+type googleServiceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// googleServiceAccountAuth 用服务账号私钥签发 JWT，向 Google 换取 access token，secret 为服务账号 JSON key
+type googleServiceAccountAuth struct {
+	route  string
+	config *UpstreamAuthConfig
+	header string
+	tokens *tokenCache
+}
+
+func (a *googleServiceAccountAuth) apply(ctx context.Context, req *http.Request, secret string, _ []byte) error {
+	token, err := a.tokens.get(ctx, a.route, secret, func(ctx context.Context) (*accessToken, error) {
+		var account googleServiceAccount
+		if err := json.Unmarshal([]byte(secret), &account); err != nil {
+			return nil, fmt.Errorf("parse service account key: %w", err)
+		}
+		tokenURL := a.config.TokenURL
+		if tokenURL == "" {
+			tokenURL = account.TokenURI
+		}
+		if tokenURL == "" {
+			tokenURL = defaultGoogleTokenURL
+		}
+		assertion, err := a.assertion(&account, tokenURL, a.tokens.now())
+		if err != nil {
+			return nil, err
+		}
+		return a.tokens.exchange(ctx, tokenURL, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("google service account: %w", err)
+	}
+	req.Header.Set(a.header, "Bearer "+token)
+	return nil
+}
+
+// assertion 签发 RS256 JWT，有效期 1 小时
+func (a *googleServiceAccountAuth) assertion(account *googleServiceAccount, audience string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return "", errors.New("private_key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("parse private_key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("private_key must be an RSA key")
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": account.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   account.ClientEmail,
+		"scope": strings.Join(a.config.Scopes, " "),
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign assertion: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// accessToken token 端点返回的 access token
+type accessToken struct {
+	Value     string
+	ExpiresAt time.Time
+}
+
+// tokenCache 按路由和凭证缓存 access token，过期前 tokenRefreshMargin（不超过有效期的一半）刷新
+// 刷新失败时只要旧 token 还没过期就继续使用；租户凭证被替换或删除后，旧 token 过期一段时间后被清理
+type tokenCache struct {
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	entries  map[string]*tokenEntry
+	prunedAt time.Time
+}
+
+// tokenEntry 单个 token，mu 保证同一凭证同时只有一个请求去换 token
+type tokenEntry struct {
+	mu        sync.Mutex
+	token     *accessToken
+	refreshAt time.Time
+
+	expiresAt time.Time // 由 tokenCache.mu 保护，用于清理；还没有 token 时为创建时间
+}
+
+// newTokenCache 创建 token 缓存
+func newTokenCache(client *http.Client) *tokenCache {
+	return &tokenCache{
+		client:  client,
+		now:     time.Now,
+		entries: make(map[string]*tokenEntry),
+	}
+}
+
+// get 返回缓存的 token，需要刷新时调用 fetch
+func (c *tokenCache) get(ctx context.Context, route, secret string, fetch func(context.Context) (*accessToken, error)) (string, error) {
+	// 以凭证的哈希区分租户自带凭证，不在内存中额外保存明文
+	sum := sha256.Sum256([]byte(secret))
+	key := route + "/" + hex.EncodeToString(sum[:])
+
+	c.mu.Lock()
+	c.pruneLocked(c.now())
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &tokenEntry{expiresAt: c.now()}
+		c.entries[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := c.now()
+	if entry.token != nil && now.Before(entry.refreshAt) {
+		return entry.token.Value, nil
+	}
+
+	token, err := fetch(ctx)
+	if err != nil {
+		if entry.token != nil && now.Before(entry.token.ExpiresAt) {
+			slog.Warn("Failed to refresh upstream access token, using cached token", "route", route, "error", err)
+			return entry.token.Value, nil
+		}
+		return "", err
+	}
+
+	margin := tokenRefreshMargin
+	if lifetime := token.ExpiresAt.Sub(now); margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+	entry.token = token
+	entry.refreshAt = token.ExpiresAt.Add(-margin)
+
+	c.mu.Lock()
+	entry.expiresAt = token.ExpiresAt
+	c.mu.Unlock()
+	return token.Value, nil
+}
+
+// pruneLocked 每隔 tokenPruneInterval 删除过期超过 tokenPruneInterval 的条目，调用方持有 c.mu
+// 被删除的条目如果还有请求在用，只是下次换 token 时重新创建
+func (c *tokenCache) pruneLocked(now time.Time) {
+	if now.Sub(c.prunedAt) < tokenPruneInterval {
+		return
+	}
+	c.prunedAt = now
+	for key, entry := range c.entries {
+		if now.Sub(entry.expiresAt) > tokenPruneInterval {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// exchange 向 OAuth token 端点提交表单，解析 access_token 和 expires_in
+func (c *tokenCache) exchange(ctx context.Context, tokenURL string, form url.Values) (*accessToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, maxTokenResponseSize)).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		if body.Error != "" {
+			return nil, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, body.Error)
+		}
+		return nil, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("decode token response: %w", decodeErr)
+	}
+	if body.AccessToken == "" || body.ExpiresIn <= 0 {
+		return nil, errors.New("token response missing access_token or expires_in")
+	}
+	return &accessToken{
+		Value:     body.AccessToken,
+		ExpiresAt: c.now().Add(time.Duration(body.ExpiresIn) * time.Second),
+	}, nil
+}
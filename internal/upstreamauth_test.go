@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// tokenEndpoint 本地替身 token 端点，check 校验表单后返回 expires_in 秒有效的 token
+func tokenEndpoint(t *testing.T, expiresIn int, check func(r *http.Request) bool) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.ParseForm() != nil || !check(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at-" + string(rune('0'+n)),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn,
+		})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestUpstreamAuth_Query(t *testing.T) {
+	var upstreamQuery, upstreamAuth string
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamQuery = r.URL.RawQuery
+		upstreamAuth = r.Header.Get("Authorization")
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	})
+	t.Setenv("TEST_RELAY_GEMINI_KEY", "gm-secret")
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.Routes[0].AuthEnv = "TEST_RELAY_GEMINI_KEY"
+		cfg.Routes[0].UpstreamAuth = UpstreamAuthConfig{Type: UpstreamAuthQuery}
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1/chat?alt=sse&key=client-supplied", "sk-test", `{}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstreamQuery != "alt=sse&key=gm-secret" {
+		t.Errorf("expected relay key to replace client key, got %q", upstreamQuery)
+	}
+	if upstreamAuth != "" {
+		t.Errorf("expected no Authorization header, got %q", upstreamAuth)
+	}
+}
+
+func TestUpstreamAuth_QueryRedactsErrors(t *testing.T) {
+	t.Setenv("TEST_RELAY_GEMINI_KEY", "gm-secret")
+	s := newTestServer(t, sseUpstream("data: [DONE]"), func(cfg *Config) {
+		cfg.Routes[0].Upstream = "http://127.0.0.1:1"
+		cfg.Routes[0].AuthEnv = "TEST_RELAY_GEMINI_KEY"
+		cfg.Routes[0].UpstreamAuth = UpstreamAuthConfig{Type: UpstreamAuthQuery}
+	})
+
+	rec := doRequest(s, http.MethodPost, "/v1/chat", "sk-test", `{}`)
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rec.Code)
+	}
+	if strings.Contains(rec.Body.String(), "gm-secret") || !strings.Contains(rec.Body.String(), "REDACTED") {
+		t.Errorf("expected key to be redacted: %s", rec.Body.String())
+	}
+}
+
+func TestUpstreamAuth_SigV4(t *testing.T) {
+	// AWS SigV4 test suite: get-vanilla
+	auth := &sigV4Auth{
+		config: &UpstreamAuthConfig{Region: "us-east-1", Service: "service", AccessKeyID: "AKIDEXAMPLE"},
+		now:    func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) },
+	}
+	req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	req.Header = http.Header{}
+	if err := auth.apply(context.Background(), req, "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", nil); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("unexpected signature:\n got  %s\n want %s", got, expected)
+	}
+	if req.Header.Get("X-Amz-Date") != "20150830T123600Z" {
+		t.Errorf("unexpected X-Amz-Date: %s", req.Header.Get("X-Amz-Date"))
+	}
+
+	// Tenant-supplied "<id>:<secret>:<token>" overrides the configured access key
+	req = httptest.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-3-haiku-20240307-v1%3A0/invoke-with-response-stream", nil)
+	req.Header = http.Header{}
+	if err := auth.apply(context.Background(), req, "AKIDTENANT:tenant-secret:session-token", []byte(`{}`)); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if got := req.Header.Get("Authorization"); !strings.Contains(got, "Credential=AKIDTENANT/") || !strings.Contains(got, "x-amz-security-token") {
+		t.Errorf("expected tenant credential and signed session token, got %s", got)
+	}
+	if req.Header.Get("X-Amz-Security-Token") != "session-token" {
+		t.Error("expected X-Amz-Security-Token header")
+	}
+}
+
+func TestSigV4CanonicalURI(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "https://bedrock-runtime.us-east-1.amazonaws.com/model/anthropic.claude-v2%3A1/invoke", nil)
+	if got := sigV4CanonicalURI(req.URL); got != "/model/anthropic.claude-v2%253A1/invoke" {
+		t.Errorf("expected double-encoded path, got %s", got)
+	}
+	req = httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/?b=2&a=x%20y&a=1", nil)
+	if got := sigV4CanonicalQuery(req.URL); got != "a=1&a=x%20y&b=2" {
+		t.Errorf("unexpected canonical query: %s", got)
+	}
+}
+
+func TestUpstreamAuth_AzureAD(t *testing.T) {
+	srv, calls := tokenEndpoint(t, 3600, func(r *http.Request) bool {
+		return r.PostForm.Get("grant_type") == "client_credentials" &&
+			r.PostForm.Get("client_id") == "app-id" &&
+			r.PostForm.Get("client_secret") == "client-secret" &&
+			r.PostForm.Get("scope") == "https://cognitiveservices.azure.com/.default"
+	})
+
+	now := time.Now()
+	tokens := newTokenCache(srv.Client())
+	tokens.now = func() time.Time { return now }
+	route := &RouteConfig{Name: "azure-openai", UpstreamAuth: UpstreamAuthConfig{
+		Type:     UpstreamAuthAzureAD,
+		ClientID: "app-id",
+		Scopes:   []string{"https://cognitiveservices.azure.com/.default"},
+		TokenURL: srv.URL,
+	}}
+	auth := newUpstreamAuthenticator(route, tokens)
+
+	apply := func(secret string) (string, error) {
+		req := httptest.NewRequest(http.MethodPost, "https://example.openai.azure.com/", nil)
+		err := auth.apply(context.Background(), req, secret, nil)
+		return req.Header.Get("Authorization"), err
+	}
+
+	for range 2 {
+		if got, err := apply("client-secret"); err != nil || got != "Bearer at-1" {
+			t.Fatalf("expected cached token, got %q %v", got, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 token request, got %d", calls.Load())
+	}
+
+	// Refreshed ahead of expiry
+	now = now.Add(56 * time.Minute)
+	if got, _ := apply("client-secret"); got != "Bearer at-2" {
+		t.Errorf("expected refreshed token, got %q", got)
+	}
+
+	if _, err := apply("wrong-secret"); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("expected token endpoint error, got %v", err)
+	}
+}
+
+func TestTokenCache_KeepsValidTokenOnRefreshFailure(t *testing.T) {
+	now := time.Now()
+	tokens := newTokenCache(http.DefaultClient)
+	tokens.now = func() time.Time { return now }
+
+	fail := false
+	fetch := func(context.Context) (*accessToken, error) {
+		if fail {
+			return nil, context.DeadlineExceeded
+		}
+		return &accessToken{Value: "at", ExpiresAt: now.Add(10 * time.Minute)}, nil
+	}
+	if _, err := tokens.get(context.Background(), "r", "s", fetch); err != nil {
+		t.Fatal(err)
+	}
+
+	fail = true
+	now = now.Add(8 * time.Minute)
+	if token, err := tokens.get(context.Background(), "r", "s", fetch); err != nil || token != "at" {
+		t.Errorf("expected cached token while still valid, got %q %v", token, err)
+	}
+	now = now.Add(3 * time.Minute)
+	if _, err := tokens.get(context.Background(), "r", "s", fetch); err == nil {
+		t.Error("expected error once the cached token has expired")
+	}
+}
+
+func TestTokenCache_PrunesExpired(t *testing.T) {
+	now := time.Now()
+	tokens := newTokenCache(http.DefaultClient)
+	tokens.now = func() time.Time { return now }
+
+	fetch := func(context.Context) (*accessToken, error) {
+		return &accessToken{Value: "at", ExpiresAt: now.Add(time.Hour)}, nil
+	}
+	for _, secret := range []string{"old", "current"} {
+		if _, err := tokens.get(context.Background(), "r", secret, fetch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// "old" 不再使用，过期超过 tokenPruneInterval 后被清理；"current" 仍在刷新
+	now = now.Add(time.Hour + tokenPruneInterval)
+	if _, err := tokens.get(context.Background(), "r", "current", fetch); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(tokenPruneInterval + time.Second)
+	if _, err := tokens.get(context.Background(), "r", "current", fetch); err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens.entries) != 1 {
+		t.Errorf("expected only the active entry to remain, got %d", len(tokens.entries))
+	}
+}
+
+func TestServer_SigV4DropsClientAmzHeaders(t *testing.T) {
+	var upstream http.Header
+	t.Setenv("TEST_RELAY_AWS_SECRET", "secret")
+	s := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Clone()
+		sseUpstream("data: [DONE]").ServeHTTP(w, r)
+	}), func(cfg *Config) {
+		cfg.Routes[0].AuthEnv = "TEST_RELAY_AWS_SECRET"
+		cfg.Routes[0].UpstreamAuth = UpstreamAuthConfig{Type: UpstreamAuthSigV4, Region: "us-east-1", Service: "execute-api", AccessKeyID: "AKIDEXAMPLE"}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer sk-test")
+	req.Header.Set("X-Amz-Security-Token", "forged")
+	req.Header.Set("X-Amz-Target", "Other.Action")
+	rec := httptest.NewRecorder()
+	s.engine.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if upstream.Get("X-Amz-Security-Token") != "" || upstream.Get("X-Amz-Target") != "" {
+		t.Errorf("client x-amz-* headers must not reach the upstream: %v", upstream)
+	}
+	if auth := upstream.Get("Authorization"); !strings.Contains(auth, "SignedHeaders=host;x-amz-date,") {
+		t.Errorf("unexpected signed headers: %s", auth)
+	}
+}
+
+func TestUpstreamAuth_GoogleServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	var tokenURL string
+	srv, calls := tokenEndpoint(t, 3600, func(r *http.Request) bool {
+		if r.PostForm.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			return false
+		}
+		parts := strings.Split(r.PostForm.Get("assertion"), ".")
+		if len(parts) != 3 {
+			return false
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			return false
+		}
+		var claims map[string]any
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		return claims["iss"] == "relay@project.iam.gserviceaccount.com" &&
+			claims["aud"] == tokenURL &&
+			claims["scope"] == "https://www.googleapis.com/auth/cloud-platform"
+	})
+	tokenURL = srv.URL
+
+	account, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "relay@project.iam.gserviceaccount.com",
+		"private_key_id": "kid-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      srv.URL,
+	})
+
+	tokens := newTokenCache(srv.Client())
+	route := &RouteConfig{Name: "vertex", UpstreamAuth: UpstreamAuthConfig{
+		Type:   UpstreamAuthGoogleServiceAccount,
+		Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"},
+	}}
+	auth := newUpstreamAuthenticator(route, tokens)
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "https://us-central1-aiplatform.googleapis.com/", nil)
+		if err := auth.apply(context.Background(), req, string(account), nil); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+		if got := req.Header.Get("Authorization"); got != "Bearer at-1" {
+			t.Errorf("expected access token, got %q", got)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 token request, got %d", calls.Load())
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "https://us-central1-aiplatform.googleapis.com/", nil)
+	if err := auth.apply(context.Background(), req, `{"client_email":"x"}`, nil); err == nil {
+		t.Error("expected error for service account without private key")
+	}
+}
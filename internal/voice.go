@@ -0,0 +1,424 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// llmDelta 流式补全事件中的文本增量
+type llmDelta struct {
+	Type    string `json:"type"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Delta json.RawMessage `json:"delta"` // Anthropic content_block_delta 为对象，OpenAI Responses 为字符串
+}
+
+// sseDeltaText 提取 SSE data 行中的回复文本
+// OpenAI Chat Completions: choices[0].delta.content；Anthropic: content_block_delta 的 delta.text；
+// OpenAI Responses: response.output_text.delta 的 delta
+func sseDeltaText(line string) string {
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok {
+		return ""
+	}
+	data = strings.TrimSpace(data)
+	if data == "" || data == "[DONE]" {
+		return ""
+	}
+	var event llmDelta
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return ""
+	}
+	switch {
+	case event.Type == "content_block_delta":
+		var delta struct {
+			Text string `json:"text"`
+		}
+		json.Unmarshal(event.Delta, &delta) //nolint:errcheck // 非文本增量（tool input）没有 text
+		return delta.Text
+	case event.Type == "response.output_text.delta":
+		var delta string
+		json.Unmarshal(event.Delta, &delta) //nolint:errcheck // 格式不符时按空文本处理
+		return delta
+	case len(event.Choices) > 0:
+		return event.Choices[0].Delta.Content
+	}
+	return ""
+}
+
+// sentenceSegmenter 把流式到达的文本切成可以朗读的句子
+type sentenceSegmenter struct {
+	pending  string
+	maxChars int
+}
+
+// push 追加一段文本，返回已经完整的句子
+// 最后一句可能还没说完，留到下一次；超过 maxChars 仍没有句末标点时整体送出
+func (s *sentenceSegmenter) push(text string) []string {
+	sentences := splitSentences(s.pending + text)
+	if len(sentences) == 0 {
+		return nil
+	}
+	s.pending = sentences[len(sentences)-1]
+	complete := sentences[:len(sentences)-1]
+	if textChars(s.pending) >= s.maxChars {
+		complete = append(complete, s.pending)
+		s.pending = ""
+	}
+	return complete
+}
+
+// flush 流结束时返回剩余的文本
+func (s *sentenceSegmenter) flush() []string {
+	if s.pending == "" {
+		return nil
+	}
+	rest := s.pending
+	s.pending = ""
+	return []string{rest}
+}
+
+// renderTTSBody 把句子填入 TTS 请求体模板：SSML 模板按 XML 转义，JSON 模板按字符串转义
+func renderTTSBody(template, text string) []byte {
+	escaped := text
+	switch trimmed := strings.TrimSpace(template); {
+	case strings.HasPrefix(trimmed, "<"):
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(text)) //nolint:errcheck // strings.Builder 写入不会失败
+		escaped = b.String()
+	case strings.HasPrefix(trimmed, "{"):
+		quoted, _ := json.Marshal(text)
+		escaped = string(quoted[1 : len(quoted)-1])
+	}
+	return []byte(strings.ReplaceAll(template, "{{text}}", escaped))
+}
+
+// ttsRequestHeader TTS 请求头：按模板类型设置 Content-Type，再应用 tts_headers
+func ttsRequestHeader(voice *VoiceConfig) http.Header {
+	header := http.Header{}
+	switch trimmed := strings.TrimSpace(voice.TTSBody); {
+	case strings.HasPrefix(trimmed, "<"):
+		header.Set("Content-Type", "application/ssml+xml")
+	case strings.HasPrefix(trimmed, "{"):
+		header.Set("Content-Type", "application/json")
+	default:
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	for k, v := range voice.TTSHeaders {
+		header.Set(k, v)
+	}
+	return header
+}
+
+// isJSONObject 请求体是否为 JSON 对象
+func isJSONObject(body []byte) bool {
+	var req map[string]json.RawMessage
+	return json.Unmarshal(body, &req) == nil && req != nil
+}
+
+// streamingBody 确保补全请求开启流式输出
+func streamingBody(body []byte) ([]byte, error) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	if req == nil {
+		return nil, fmt.Errorf("invalid request body: JSON object required")
+	}
+	req["stream"] = json.RawMessage("true")
+	return json.Marshal(req)
+}
+
+// subRequest 把组合路由的请求改写为发往另一条路由的请求，路径替换为该路由的 path
+func subRequest(r *http.Request, path string, header http.Header) *http.Request {
+	sub := r.Clone(r.Context())
+	sub.Method = http.MethodPost
+	sub.URL.Path = path
+	sub.URL.RawPath = ""
+	sub.URL.RawQuery = ""
+	sub.Header = header
+	return sub
+}
+
+// voiceOutput 语音管线的输出：原始音频流，或 SSE 中交替的 text / audio 事件
+// 文本由读取 LLM 的协程写入，音频由拼接协程写入，互斥写入同一个连接
+type voiceOutput struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+	ctx     *RequestContext
+}
+
+// event 写入一个 SSE 事件
+func (o *voiceOutput) event(name string, v any) {
+	data, _ := json.Marshal(v)
+	n, _ := fmt.Fprintf(o.w, "event: %s\ndata: %s\n\n", name, data)
+	o.flusher.Flush()
+	o.ctx.BytesOut += int64(n)
+	o.ctx.ChunksCount++
+}
+
+// text 送去合成的一句（只在 SSE 中输出）
+func (o *voiceOutput) text(index int, text string) {
+	if !o.sse {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.event("text", map[string]any{"index": index, "text": text})
+}
+
+// audio 第 index 句的音频
+func (o *voiceOutput) audio(index int, p []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// 记录端到端的 TTFA（从收到请求到第一个音频字节）
+	if o.ctx.TTFAMs == nil {
+		ttfa := time.Since(o.ctx.StartTime).Milliseconds()
+		o.ctx.TTFAMs = &ttfa
+	}
+	if o.sse {
+		o.event("audio", map[string]any{"index": index, "audio": base64.StdEncoding.EncodeToString(p)})
+		return
+	}
+	//nolint:errcheck // streaming write errors are handled by connection close
+	o.w.Write(p)
+	o.flusher.Flush()
+	o.ctx.BytesOut += int64(len(p))
+	o.ctx.ChunksCount++
+}
+
+// voiceAudioWriter 把第 index 句拼接后的音频写入输出，同时计量时长
+type voiceAudioWriter struct {
+	out   *voiceOutput
+	index int
+	meter *audioMeter
+}
+
+func (v *voiceAudioWriter) Write(p []byte) (int, error) {
+	v.meter.Write(p) //nolint:errcheck // 计量不会失败
+	v.out.audio(v.index, p)
+	return len(p), nil
+}
+
+// llmResult 读取 LLM 流的结果
+type llmResult struct {
+	chunks []string
+	spoken int64 // 送去合成的字符数
+	err    error
+}
+
+// forwardVoice 语音管线：流式请求 llm_route，边接收边按句切分，每句交给 tts_route 合成，
+// 按顺序拼接为一个音频流；客户端 Accept: text/event-stream 时以 SSE 交替输出文本和音频（base64）
+func (p *Proxy) forwardVoice(w http.ResponseWriter, r *http.Request, route *RouteConfig, requestBody []byte, ctx *RequestContext) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("response writer does not support flushing")
+	}
+	llmRoute := p.config.GetRouteByName(route.Voice.LLMRoute)
+	ttsRoute := p.config.GetRouteByName(route.Voice.TTSRoute)
+
+	reqCtx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	r = r.WithContext(reqCtx)
+
+	// 1. 请求 LLM（强制流式）
+	body, err := streamingBody(requestBody)
+	if err != nil {
+		return err
+	}
+	header := r.Header.Clone()
+	header.Del("Accept")
+	llmReq, err := p.buildUpstreamRequest(subRequest(r, llmRoute.Path, header), llmRoute, body, ctx)
+	if err != nil {
+		if ctx.ErrorType != "" {
+			p.saveLog(ctx, string(requestBody))
+		}
+		return fmt.Errorf("build upstream request: %w", err)
+	}
+	llmResp, err := p.client.Do(llmReq)
+	if err != nil {
+		err = redactUpstreamError(err, p.auth[llmRoute.Name])
+		ctx.ErrorType = "upstream_error"
+		ctx.ErrorMessage = err.Error()
+		p.saveLog(ctx, string(requestBody))
+		return fmt.Errorf("upstream request: %w", err)
+	}
+	defer llmResp.Body.Close()
+
+	if llmResp.StatusCode != http.StatusOK {
+		// LLM 报错，原样转发
+		ctx.StatusCode = llmResp.StatusCode
+		p.copyUpstreamHeaders(w, llmResp.Header)
+		w.Header().Set("X-Request-ID", ctx.RequestID)
+		w.WriteHeader(llmResp.StatusCode)
+		err = p.forwardRaw(w, llmResp.Body, ctx)
+		p.saveLog(ctx, string(requestBody))
+		return err
+	}
+
+	out := &voiceOutput{w: w, flusher: flusher, sse: strings.Contains(r.Header.Get("Accept"), "text/event-stream"), ctx: ctx}
+	if out.sse {
+		ctx.StatusCode = http.StatusOK
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Request-ID", ctx.RequestID)
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// 2. 读取 LLM 流，按句发起合成；同时进行的合成不超过 tts_route 的 max_parallel
+	ttsHeader := ttsRequestHeader(&route.Voice)
+	ttsAuth := p.auth[ttsRoute.Name]
+	queue := make(chan *segmentBuffer, ttsRoute.TTS.maxParallel())
+	results := make(chan llmResult, 1)
+	go func() {
+		var result llmResult
+		defer func() {
+			close(queue)
+			results <- result
+		}()
+
+		sem := make(chan struct{}, ttsRoute.TTS.maxParallel())
+		segmenter := &sentenceSegmenter{maxChars: ttsRoute.TTS.maxSegmentChars()}
+		index := 0
+		dispatch := func(sentences []string) bool {
+			for _, sentence := range sentences {
+				text := strings.TrimSpace(sentence)
+				if text == "" {
+					continue
+				}
+				select {
+				case sem <- struct{}{}:
+				case <-reqCtx.Done():
+					return false
+				}
+				out.text(index, text)
+				result.spoken += int64(textChars(text))
+
+				buf := newSegmentBuffer(reqCtx)
+				// 合成请求不写入 ctx（与拼接协程并发），构造失败时作为该句的错误
+				header := ttsHeader.Clone()
+				body, err := prepareTTSBody(&ttsRoute.TTS, header, renderTTSBody(route.Voice.TTSBody, text))
+				var req *http.Request
+				if err == nil {
+					req, err = p.buildUpstreamRequest(subRequest(r, ttsRoute.Path, header), ttsRoute, body, &RequestContext{TenantID: ctx.TenantID})
+				}
+				if err != nil {
+					buf.finish(err)
+					<-sem
+				} else {
+					go func() {
+						defer func() { <-sem }()
+						p.synthesizeSegment(req, buf, ttsAuth)
+					}()
+				}
+				select {
+				case queue <- buf:
+				case <-reqCtx.Done():
+					return false
+				}
+				index++
+			}
+			return true
+		}
+
+		scanner := bufio.NewScanner(llmResp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			result.chunks = append(result.chunks, line)
+			if ctx.TTFTMs == nil && sseHasToken(line) {
+				ttft := time.Since(ctx.StartTime).Milliseconds()
+				ctx.TTFTMs = &ttft
+			}
+			if text := sseDeltaText(line); text != "" && !dispatch(segmenter.push(text)) {
+				return
+			}
+		}
+		if result.err = scanner.Err(); result.err == nil {
+			dispatch(segmenter.flush())
+		}
+	}()
+
+	// 3. 按顺序拼接各句的音频；某一句失败时结束整个管线
+	var meter *audioMeter
+	index := 0
+	for buf := range queue {
+		if index == 0 {
+			first, err := buf.response()
+			if err == nil && first.StatusCode == http.StatusOK {
+				// 输出格式可能由 JSON 请求生成（tts.voices），以实际发出的请求头为准
+				meter = newTTSAudioMeter(first.Header.Get("Content-Type"), first.Request.Header.Get(azureOutputFormatHeader))
+				if !out.sse {
+					ctx.StatusCode = http.StatusOK
+					w.Header().Set("Content-Type", first.Header.Get("Content-Type"))
+					w.Header().Set("X-Request-ID", ctx.RequestID)
+					w.WriteHeader(http.StatusOK)
+				}
+			}
+		}
+		if err = spliceSegment(&voiceAudioWriter{out: out, index: index, meter: meter}, buf, index); err != nil {
+			err = fmt.Errorf("tts segment %d: %w", index, err)
+			cancel()
+			break
+		}
+		index++
+	}
+	result := <-results
+
+	// 4. 汇总：LLM 的用量和错误，输出音频的格式和时长
+	ctx.ResponseChunks = result.chunks
+	ctx.Usage = extractUsage(result.chunks)
+	ctx.InputChars = &result.spoken
+	if meter != nil {
+		ctx.AudioFormat = meter.Format()
+		if d, ok := meter.Duration(); ok {
+			ms := d.Milliseconds()
+			ctx.AudioDuration = &ms
+		}
+	}
+	switch {
+	case err != nil:
+		ctx.ErrorType = "upstream_error"
+		ctx.ErrorMessage = err.Error()
+	case result.err != nil:
+		err = result.err
+		ctx.ErrorType = "stream_error"
+		ctx.ErrorMessage = err.Error()
+	default:
+		if message, ok := extractStreamError(result.chunks); ok {
+			ctx.ErrorType = "upstream_error"
+			ctx.ErrorMessage = message
+		}
+	}
+
+	switch {
+	case out.sse && err != nil:
+		out.event("error", map[string]string{"error": err.Error()})
+	case out.sse:
+		out.event("done", map[string]any{})
+	case ctx.StatusCode == 0 && err == nil:
+		// 回复中没有可以朗读的文本
+		ctx.StatusCode = http.StatusNoContent
+		w.Header().Set("X-Request-ID", ctx.RequestID)
+		w.WriteHeader(http.StatusNoContent)
+	case ctx.StatusCode == 0:
+		// 第一句就合成失败，还没有写入响应头，由调用方返回 502
+		ctx.StatusCode = http.StatusBadGateway
+	}
+
+	p.saveLog(ctx, string(requestBody))
+	return err
+}
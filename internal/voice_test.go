@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEDeltaText(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`data: {"choices":[{"delta":{"content":"Hello"}}]}`, "Hello"},
+		{`data: {"choices":[{"delta":{"role":"assistant"}}]}`, ""},
+		{`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`, "Hi"},
+		{`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{"}}`, ""},
+		{`data: {"type":"response.output_text.delta","delta":"你好"}`, "你好"},
+		{`data: {"type":"message_start","message":{"usage":{"input_tokens":3}}}`, ""},
+		{`data: [DONE]`, ""},
+		{`event: message`, ""},
+	}
+	for _, tt := range tests {
+		if got := sseDeltaText(tt.line); got != tt.want {
+			t.Errorf("sseDeltaText(%s): expected %q, got %q", tt.line, tt.want, got)
+		}
+	}
+}
+
+func TestSentenceSegmenter(t *testing.T) {
+	s := &sentenceSegmenter{maxChars: 20}
+	var got []string
+	for _, token := range []string{"Hel", "lo there", ".", " How", " are you?", " 我很好", "。谢谢", "aaaaaaaaaaaaaaaaaaaaaaaaa", "b"} {
+		got = append(got, s.push(token)...)
+	}
+	got = append(got, s.flush()...)
+	want := []string{"Hello there. ", "How are you? ", "我很好。", "谢谢aaaaaaaaaaaaaaaaaaaaaaaaa", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRenderTTSBody(t *testing.T) {
+	ssml := renderTTSBody(`<speak><voice name="a">{{text}}</voice></speak>`, `Tom & "Jerry" <3`)
+	if string(ssml) != `<speak><voice name="a">Tom &amp; &#34;Jerry&#34; &lt;3</voice></speak>` {
+		t.Errorf("unexpected SSML: %s", ssml)
+	}
+
+	body := renderTTSBody(`{"model":"tts-1","input":"{{text}}"}`, "say \"hi\"\n")
+	var req map[string]string
+	if err := json.Unmarshal(body, &req); err != nil || req["input"] != "say \"hi\"\n" {
+		t.Errorf("unexpected JSON: %s", body)
+	}
+}
+
+// newVoiceProxy 创建 voice 路由及其引用的 LLM 和 TTS 路由
+func newVoiceProxy(t *testing.T, llm, tts http.Handler) *Proxy {
+	t.Helper()
+
+	llmServer := httptest.NewServer(llm)
+	t.Cleanup(llmServer.Close)
+	ttsServer := httptest.NewServer(tts)
+	t.Cleanup(ttsServer.Close)
+
+	cfg := &Config{
+		Server: ServerConfig{Port: 8080, Timeout: 5 * time.Second},
+		Routes: []RouteConfig{
+			{Name: "llm", Path: "/v1/chat/completions", Upstream: llmServer.URL, Kind: "sse"},
+			{Name: "tts", Path: "/v1/audio/speech", Upstream: ttsServer.URL, Kind: "raw", TTS: TTSConfig{Enabled: true, MaxParallel: 2}},
+			{Name: "voice", Path: "/v1/voice", Kind: "voice", Voice: VoiceConfig{
+				LLMRoute: "llm",
+				TTSRoute: "tts",
+				TTSBody:  `{"model":"tts-1","voice":"alloy","response_format":"wav","input":"{{text}}"}`,
+			}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	return NewProxy(cfg, nil, getTestMetrics(), nil, NewSecretManager(cfg), nil)
+}
+
+// voiceLLM 逐个 token 输出 Chat Completions 流，最后一个 chunk 带 usage
+func voiceLLM(t *testing.T, tokens ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]any
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/v1/chat/completions" || req["stream"] != true {
+			t.Errorf("expected a streaming completion request, got %s %v", r.URL.Path, req)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, token := range tokens {
+			content, _ := json.Marshal(token)
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%s}}]}\n\n", content)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":8}}\n\ndata: [DONE]\n\n")
+	}
+}
+
+// voiceTTS 每句返回 0.5 秒的 WAV，音频数据用句子的首字母填充
+func voiceTTS(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input string `json:"input"`
+		}
+		if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&req) != nil {
+			t.Errorf("unexpected TTS request: %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		wav := testWAV(1, 16000)
+		wav = wav[:len(wav)-16000]
+		copy(wav[len(wav)-16000:], strings.Repeat(req.Input[:1], 16000))
+		w.Write(wav)
+	}
+}
+
+func TestProxy_VoiceAudio(t *testing.T) {
+	p := newVoiceProxy(t, voiceLLM(t, "Hello", " there.", " How", " are you?", " Bye"), voiceTTS(t))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "audio/wav" {
+		t.Fatalf("unexpected response: %d %v", rec.Code, rec.Header())
+	}
+	out := rec.Body.Bytes()
+	if bytes.Count(out, []byte("RIFF")) != 1 {
+		t.Error("expected a single WAV header")
+	}
+	data := out[len(out)-3*16000:]
+	for i, c := range []byte("HHB") {
+		if data[i*16000] != c {
+			t.Errorf("sentence %d out of order: got %q", i, data[i*16000])
+		}
+	}
+
+	if ctx.TTFTMs == nil || ctx.TTFAMs == nil || *ctx.TTFAMs < *ctx.TTFTMs {
+		t.Errorf("expected TTFA after TTFT, got %v %v", ctx.TTFTMs, ctx.TTFAMs)
+	}
+	if ctx.AudioDuration == nil || *ctx.AudioDuration != 1500 {
+		t.Errorf("expected 1.5s of audio, got %v", ctx.AudioDuration)
+	}
+	if ctx.InputChars == nil || *ctx.InputChars != int64(len("Hello there.How are you?Bye")) {
+		t.Errorf("unexpected spoken chars: %v", ctx.InputChars)
+	}
+	if ctx.Usage == nil || ctx.Usage.TotalTokens != 20 {
+		t.Errorf("expected LLM usage, got %+v", ctx.Usage)
+	}
+}
+
+func TestProxy_VoiceSSE(t *testing.T) {
+	p := newVoiceProxy(t, voiceLLM(t, "One. ", "Two."), voiceTTS(t))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/voice", strings.NewReader(`{"model":"gpt-4o","messages":[]}`))
+	req.Header.Set("Accept", "text/event-stream")
+	ctx := NewRequestContext(&Principal{TenantID: "acme"})
+	rec := httptest.NewRecorder()
+	if err := p.Handle(rec, req, ctx); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	if rec.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected SSE, got %v", rec.Header())
+	}
+
+	var texts []string
+	var audio []byte
+	var last string
+	scanner := bufio.NewScanner(rec.Body)
+	scanner.Buffer(nil, 1<<20)
+	var event string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		last = event
+		var payload struct {
+			Index int    `json:"index"`
+			Text  string `json:"text"`
+			Audio string `json:"audio"`
+		}
+		json.Unmarshal([]byte(data), &payload)
+		switch event {
+		case "text":
+			texts = append(texts, payload.Text)
+		case "audio":
+			chunk, _ := base64.StdEncoding.DecodeString(payload.Audio)
+			audio = append(audio, chunk...)
+		}
+	}
+
+	if !reflect.DeepEqual(texts, []string{"One.", "Two."}) {
+		t.Errorf("unexpected text events: %q", texts)
+	}
+	if d, ok := meter(audio, 4096).Duration(); !ok || d != time.Second {
+		t.Errorf("expected 1s of audio, got %v", d)
+	}
+	if last != "done" {
+		t.Errorf("expected the stream to end with done, got %q", last)
+	}
+}
+
+func TestProxy_VoiceErrors(t *testing.T) {
+	t.Run("llm", func(t *testing.T) {
+		llm := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"error":"invalid api key"}`, http.StatusUnauthorized)
+		})
+		p := newVoiceProxy(t, llm, voiceTTS(t))
+		ctx := NewRequestContext(&Principal{TenantID: "acme"})
+		rec := httptest.NewRecorder()
+		if err := p.Handle(rec, httptest.NewRequest(http.MethodPost, "/v1/voice", strings.NewReader(`{}`)), ctx); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "invalid api key") {
+			t.Errorf("expected the LLM error to be forwarded, got %d %q", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("tts", func(t *testing.T) {
+		tts := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.Copy(io.Discard, r.Body)
+			http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+		})
+		p := newVoiceProxy(t, voiceLLM(t, "One. ", "Two."), tts)
+		ctx := NewRequestContext(&Principal{TenantID: "acme"})
+		rec := httptest.NewRecorder()
+		err := p.Handle(rec, httptest.NewRequest(http.MethodPost, "/v1/voice", strings.NewReader(`{}`)), ctx)
+		if err == nil || !strings.Contains(err.Error(), "tts segment 0") || !strings.Contains(err.Error(), "quota exceeded") {
+			t.Fatalf("expected a TTS error, got %v", err)
+		}
+		if ctx.StatusCode != http.StatusBadGateway || ctx.ErrorType != "upstream_error" {
+			t.Errorf("unexpected status: %d %q", ctx.StatusCode, ctx.ErrorType)
+		}
+	})
+
+	t.Run("no speech", func(t *testing.T) {
+		p := newVoiceProxy(t, voiceLLM(t, " ", "\n"), voiceTTS(t))
+		ctx := NewRequestContext(&Principal{TenantID: "acme"})
+		rec := httptest.NewRecorder()
+		if err := p.Handle(rec, httptest.NewRequest(http.MethodPost, "/v1/voice", strings.NewReader(`{}`)), ctx); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("expected 204, got %d", rec.Code)
+		}
+	})
+}
+
+func TestServer_VoiceReferencedRouteLimits(t *testing.T) {
+	llm, tts := voiceLLM(t, "Hi."), voiceTTS(t)
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/v1/audio/speech":
+			llm(w, r)
+		case r.Header.Get("Content-Type") == "application/json":
+			tts(w, r)
+		default:
+			// 客户端直接请求 TTS 路由
+			w.Write(testWAV(1, 16000))
+		}
+	})
+	s := newTestServer(t, upstream, func(cfg *Config) {
+		cfg.Routes[0].Path = "/v1/chat/completions"
+		cfg.Routes = append(cfg.Routes,
+			RouteConfig{Name: "tts", Path: "/v1/audio/speech", Upstream: cfg.Routes[0].Upstream, Kind: "raw", TTS: TTSConfig{Enabled: true}},
+			RouteConfig{Name: "voice", Path: "/v1/voice", Kind: "voice", Voice: VoiceConfig{
+				LLMRoute: "chat",
+				TTSRoute: "tts",
+				TTSBody:  `{"model":"tts-1","voice":"alloy","response_format":"wav","input":"{{text}}"}`,
+			}},
+		)
+		cfg.Auth.Keys = []APIKeyConfig{
+			{ID: "voice-only", Key: "sk-voice-only", TenantID: "acme", Routes: []string{"voice"}},
+			{ID: "full", Key: "sk-full", TenantID: "globex", Routes: []string{"voice", "chat", "tts"}},
+		}
+		// chat 路由单独限流；voice 和 tts 共用租户级 limiter，每个请求只扣一次、只占一个并发名额
+		cfg.RateLimit.Routes = map[string]RateLimitRule{"chat": {Default: 60, Burst: 1}}
+		cfg.RateLimit.MaxConcurrentStreams = 1
+	})
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+
+	if rec := doRequest(s, http.MethodPost, "/v1/voice", "sk-voice-only", body); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 without access to the referenced routes, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/voice", "sk-full", body); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/audio/speech", "sk-full", `{"input":"Hi."}`); rec.Code != http.StatusOK {
+		t.Errorf("expected the tenant bucket to be charged once per voice request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// chat 路由的额度已被 voice 请求用完
+	if rec := doRequest(s, http.MethodPost, "/v1/chat/completions", "sk-full", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the llm route limit to apply, got %d", rec.Code)
+	}
+	if rec := doRequest(s, http.MethodPost, "/v1/voice", "sk-full", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once the llm route is exhausted, got %d", rec.Code)
+	}
+}